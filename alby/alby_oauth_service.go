@@ -347,8 +347,13 @@ func (svc *albyOAuthService) LinkAccount(ctx context.Context) error {
 		connectionPubkey,
 		1_000_000,
 		nip47.BUDGET_RENEWAL_MONTHLY,
+		"",
+		0,
+		nil,
 		nil,
 		strings.Split(nip47.CAPABILITIES, " "),
+		"",
+		false,
 	)
 
 	if err != nil {
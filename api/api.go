@@ -8,6 +8,7 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 	"time"
 
@@ -18,6 +19,7 @@ import (
 	"github.com/getAlby/nostr-wallet-connect/backup"
 	"github.com/getAlby/nostr-wallet-connect/config"
 	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/fiat"
 	"github.com/getAlby/nostr-wallet-connect/lnclient"
 	"github.com/getAlby/nostr-wallet-connect/lsp"
 	"github.com/getAlby/nostr-wallet-connect/nip47"
@@ -58,13 +60,41 @@ func (api *api) CreateApp(createAppRequest *CreateAppRequest) (*CreateAppRespons
 		return nil, fmt.Errorf("won't create an app without request methods")
 	}
 
-	app, pairingSecretKey, err := api.dbSvc.CreateApp(createAppRequest.Name, createAppRequest.Pubkey, createAppRequest.MaxAmount, createAppRequest.BudgetRenewal, expiresAt, requestMethods)
+	if createAppRequest.LightningAddressUsername != "" {
+		err = validateLightningAddressUsername(createAppRequest.LightningAddressUsername)
+		if err != nil {
+			return nil, err
+		}
+		err = api.checkLightningAddressSupport()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = api.checkMaxFeeSupport(createAppRequest.MaxFeeMsat)
+	if err != nil {
+		return nil, err
+	}
+	err = api.checkIsolatedSupport(createAppRequest.Isolated)
+	if err != nil {
+		return nil, err
+	}
+
+	if createAppRequest.BudgetCurrency != "" {
+		err = fiat.ValidateCurrency(createAppRequest.BudgetCurrency)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	app, pairingSecretKey, err := api.dbSvc.CreateApp(createAppRequest.Name, createAppRequest.Pubkey, createAppRequest.MaxAmount, createAppRequest.BudgetRenewal, createAppRequest.BudgetCurrency, createAppRequest.MaxAmountFiat, createAppRequest.MaxFeeMsat, expiresAt, requestMethods, createAppRequest.LightningAddressUsername, createAppRequest.Isolated)
 
 	if err != nil {
 		return nil, err
 	}
 
 	relayUrl := api.svc.GetConfig().GetRelayUrl()
+	lightningAddress := api.lightningAddress(app.LightningAddressUsername)
 
 	responseBody := &CreateAppResponse{}
 	responseBody.Name = createAppRequest.Name
@@ -77,25 +107,71 @@ func (api *api) CreateApp(createAppRequest *CreateAppRequest) (*CreateAppRespons
 			query := returnToUrl.Query()
 			query.Add("relay", relayUrl)
 			query.Add("pubkey", api.svc.GetConfig().GetNostrPublicKey())
-			// if user.LightningAddress != "" {
-			// 	query.Add("lud16", user.LightningAddress)
-			// }
+			if lightningAddress != "" {
+				query.Add("lud16", lightningAddress)
+			}
 			returnToUrl.RawQuery = query.Encode()
 			responseBody.ReturnTo = returnToUrl.String()
 		}
 	}
 
 	var lud16 string
-	// if user.LightningAddress != "" {
-	// 	lud16 = fmt.Sprintf("&lud16=%s", user.LightningAddress)
-	// }
+	if lightningAddress != "" {
+		lud16 = fmt.Sprintf("&lud16=%s", url.QueryEscape(lightningAddress))
+	}
 	responseBody.PairingUri = fmt.Sprintf("nostr+walletconnect://%s?relay=%s&secret=%s%s", api.svc.GetConfig().GetNostrPublicKey(), relayUrl, pairingSecretKey, lud16)
 	return responseBody, nil
 }
 
+// checkMaxFeeSupport refuses fee limits the LN backend cannot enforce, every payment of the app would fail with them
+func (api *api) checkMaxFeeSupport(maxFeeMsat *uint64) error {
+	if maxFeeMsat == nil {
+		return nil
+	}
+	lnClient := api.svc.GetLNClient()
+	if lnClient == nil {
+		return errors.New("LNClient not started")
+	}
+	if !slices.Contains(lnClient.GetSupportedFeatures(), lnclient.FeatureMaxFee) {
+		return errors.New("a max fee is not supported by this lightning backend")
+	}
+	return nil
+}
+
+// checkIsolatedSupport refuses isolated apps on LN backends which do not report incoming payments,
+// the invoices of the app would never be credited to its balance
+func (api *api) checkIsolatedSupport(isolated bool) error {
+	if !isolated {
+		return nil
+	}
+	lnClient := api.svc.GetLNClient()
+	if lnClient == nil {
+		return errors.New("LNClient not started")
+	}
+	if !slices.Contains(lnClient.GetSupportedFeatures(), lnclient.FeaturePaymentReceivedEvents) {
+		return errors.New("isolated apps are not supported by this lightning backend")
+	}
+	return nil
+}
+
 func (api *api) UpdateApp(userApp *db.App, updateAppRequest *UpdateAppRequest) error {
 	maxAmount := updateAppRequest.MaxAmount
 	budgetRenewal := updateAppRequest.BudgetRenewal
+	budgetCurrency := updateAppRequest.BudgetCurrency
+	maxAmountFiat := updateAppRequest.MaxAmountFiat
+	maxFeeMsat := updateAppRequest.MaxFeeMsat
+
+	err := api.checkMaxFeeSupport(maxFeeMsat)
+	if err != nil {
+		return err
+	}
+
+	if budgetCurrency != "" {
+		err := fiat.ValidateCurrency(budgetCurrency)
+		if err != nil {
+			return err
+		}
+	}
 
 	requestMethods := updateAppRequest.RequestMethods
 	if requestMethods == "" {
@@ -108,12 +184,35 @@ func (api *api) UpdateApp(userApp *db.App, updateAppRequest *UpdateAppRequest) e
 		return fmt.Errorf("invalid expiresAt: %v", err)
 	}
 
+	var lightningAddressUsername *string
+	if updateAppRequest.LightningAddressUsername != nil && *updateAppRequest.LightningAddressUsername != "" {
+		err = validateLightningAddressUsername(*updateAppRequest.LightningAddressUsername)
+		if err != nil {
+			return err
+		}
+		err = api.checkLightningAddressSupport()
+		if err != nil {
+			return err
+		}
+		lightningAddressUsername = updateAppRequest.LightningAddressUsername
+	}
+
 	err = api.db.Transaction(func(tx *gorm.DB) error {
+		if updateAppRequest.LightningAddressUsername != nil {
+			err := tx.Model(userApp).Update("lightning_address_username", lightningAddressUsername).Error
+			if err != nil {
+				return err
+			}
+		}
+
 		// Update existing permissions with new budget and expiry
 		err := tx.Model(&db.AppPermission{}).Where("app_id", userApp.ID).Updates(map[string]interface{}{
-			"ExpiresAt":     expiresAt,
-			"MaxAmount":     maxAmount,
-			"BudgetRenewal": budgetRenewal,
+			"ExpiresAt":      expiresAt,
+			"MaxAmount":      maxAmount,
+			"BudgetRenewal":  budgetRenewal,
+			"BudgetCurrency": budgetCurrency,
+			"MaxAmountFiat":  maxAmountFiat,
+			"MaxFeeMsat":     maxFeeMsat,
 		}).Error
 		if err != nil {
 			return err
@@ -133,11 +232,14 @@ func (api *api) UpdateApp(userApp *db.App, updateAppRequest *UpdateAppRequest) e
 		for _, method := range newRequestMethods {
 			if !existingMethodMap[method] {
 				perm := db.AppPermission{
-					App:           *userApp,
-					RequestMethod: method,
-					ExpiresAt:     expiresAt,
-					MaxAmount:     maxAmount,
-					BudgetRenewal: budgetRenewal,
+					App:            *userApp,
+					RequestMethod:  method,
+					ExpiresAt:      expiresAt,
+					MaxAmount:      maxAmount,
+					BudgetRenewal:  budgetRenewal,
+					BudgetCurrency: budgetCurrency,
+					MaxAmountFiat:  maxAmountFiat,
+					MaxFeeMsat:     maxFeeMsat,
 				}
 				if err := tx.Create(&perm).Error; err != nil {
 					return err
@@ -185,10 +287,10 @@ func (api *api) GetApp(userApp *db.App) *App {
 	}
 
 	//renewsIn := ""
-	budgetUsage := int64(0)
+	budgetUsageMsat := int64(0)
 	maxAmount := paySpecificPermission.MaxAmount
-	if maxAmount > 0 {
-		budgetUsage = api.svc.GetBudgetUsage(&paySpecificPermission)
+	if maxAmount > 0 || paySpecificPermission.MaxAmountFiat > 0 {
+		budgetUsageMsat = api.svc.GetBudgetUsage(&paySpecificPermission)
 	}
 
 	response := App{
@@ -200,8 +302,25 @@ func (api *api) GetApp(userApp *db.App) *App {
 		ExpiresAt:      expiresAt,
 		MaxAmount:      maxAmount,
 		RequestMethods: requestMethods,
-		BudgetUsage:    budgetUsage,
+		BudgetUsage:    budgetUsageMsat / 1000,
 		BudgetRenewal:  paySpecificPermission.BudgetRenewal,
+		MaxFeeMsat:     paySpecificPermission.MaxFeeMsat,
+
+		MaxAmountMsat:   int64(maxAmount) * 1000,
+		BudgetUsageMsat: budgetUsageMsat,
+
+		BudgetCurrency: paySpecificPermission.BudgetCurrency,
+		MaxAmountFiat:  paySpecificPermission.MaxAmountFiat,
+
+		LightningAddressUsername: userApp.LightningAddressUsername,
+		LightningAddress:         api.lightningAddress(userApp.LightningAddressUsername),
+
+		Isolated: userApp.Isolated,
+	}
+
+	if userApp.Isolated {
+		balance := api.svc.GetIsolatedBalance(userApp)
+		response.Balance = &balance
 	}
 
 	if lastEventResult.RowsAffected > 0 {
@@ -215,7 +334,8 @@ func (api *api) GetApp(userApp *db.App) *App {
 func (api *api) ListApps() ([]App, error) {
 	// TODO: join dbApps and permissions
 	dbApps := []db.App{}
-	api.db.Find(&dbApps)
+	// the owner's payments are listed with the transactions of the node
+	api.db.Where("nostr_pubkey != ?", db.OWNER_APP_NOSTR_PUBKEY).Find(&dbApps)
 
 	permissions := []db.AppPermission{}
 	api.db.Find(&permissions)
@@ -234,6 +354,16 @@ func (api *api) ListApps() ([]App, error) {
 			CreatedAt:   userApp.CreatedAt,
 			UpdatedAt:   userApp.UpdatedAt,
 			NostrPubkey: userApp.NostrPubkey,
+
+			LightningAddressUsername: userApp.LightningAddressUsername,
+			LightningAddress:         api.lightningAddress(userApp.LightningAddressUsername),
+
+			Isolated: userApp.Isolated,
+		}
+
+		if userApp.Isolated {
+			balance := api.svc.GetIsolatedBalance(&userApp)
+			apiApp.Balance = &balance
 		}
 
 		for _, permission := range permissionsMap[userApp.ID] {
@@ -241,9 +371,14 @@ func (api *api) ListApps() ([]App, error) {
 			apiApp.ExpiresAt = permission.ExpiresAt
 			if permission.RequestMethod == nip47.PAY_INVOICE_METHOD {
 				apiApp.BudgetRenewal = permission.BudgetRenewal
+				apiApp.MaxFeeMsat = permission.MaxFeeMsat
 				apiApp.MaxAmount = permission.MaxAmount
-				if apiApp.MaxAmount > 0 {
-					apiApp.BudgetUsage = api.svc.GetBudgetUsage(&permission)
+				apiApp.MaxAmountMsat = int64(permission.MaxAmount) * 1000
+				apiApp.BudgetCurrency = permission.BudgetCurrency
+				apiApp.MaxAmountFiat = permission.MaxAmountFiat
+				if apiApp.MaxAmount > 0 || apiApp.MaxAmountFiat > 0 {
+					apiApp.BudgetUsageMsat = api.svc.GetBudgetUsage(&permission)
+					apiApp.BudgetUsage = apiApp.BudgetUsageMsat / 1000
 				}
 			}
 		}
@@ -259,11 +394,63 @@ func (api *api) ListApps() ([]App, error) {
 	return apiApps, nil
 }
 
-func (api *api) ListChannels(ctx context.Context) ([]lnclient.Channel, error) {
+func (api *api) ListAppPayments(userApp *db.App) ([]Payment, error) {
+	dbPayments := []db.Payment{}
+	err := api.db.Where("app_id = ?", userApp.ID).Order("id desc").Find(&dbPayments).Error
+	if err != nil {
+		return nil, err
+	}
+
+	payments := []Payment{}
+	for _, dbPayment := range dbPayments {
+		payments = append(payments, Payment{
+			ID:             dbPayment.ID,
+			Amount:         dbPayment.AmountMsat / 1000,
+			AmountMsat:     dbPayment.AmountMsat,
+			PaymentRequest: dbPayment.PaymentRequest,
+			PaymentHash:    dbPayment.PaymentHash,
+			Preimage:       dbPayment.Preimage,
+			State:          dbPayment.State,
+			FailureReason:  dbPayment.FailureReason,
+			CreatedAt:      dbPayment.CreatedAt,
+			SettledAt:      dbPayment.SettledAt,
+			FailedAt:       dbPayment.FailedAt,
+		})
+	}
+	return payments, nil
+}
+
+func (api *api) ListChannels(ctx context.Context) ([]Channel, error) {
 	if api.svc.GetLNClient() == nil {
 		return nil, errors.New("LNClient not started")
 	}
-	return api.svc.GetLNClient().ListChannels(ctx)
+	lnChannels, err := api.svc.GetLNClient().ListChannels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	channelIds := []string{}
+	fundingTxIds := []string{}
+	for _, lnChannel := range lnChannels {
+		channelIds = append(channelIds, lnChannel.Id)
+		fundingTxIds = append(fundingTxIds, lnChannel.FundingTxId)
+	}
+	channelLabels := api.findLabels(LabelTypeChannel, channelIds)
+	// labels of the funding transaction, e.g. imported from an on-chain wallet
+	fundingTxLabels := api.findLabels(LabelTypeTx, fundingTxIds)
+
+	channels := []Channel{}
+	for _, lnChannel := range lnChannels {
+		label, ok := channelLabels[lnChannel.Id]
+		if !ok {
+			label = fundingTxLabels[lnChannel.FundingTxId]
+		}
+		channels = append(channels, Channel{
+			Channel: lnChannel,
+			Label:   label,
+		})
+	}
+	return channels, nil
 }
 
 func (api *api) GetChannelPeerSuggestions(ctx context.Context) ([]alby.ChannelPeerSuggestion, error) {
@@ -637,6 +824,22 @@ func (api *api) SendSpontaneousPaymentProbes(ctx context.Context, sendSpontaneou
 	return &SendSpontaneousPaymentProbesResponse{Error: errMessage}, nil
 }
 
+func (api *api) EstimateFee(ctx context.Context, estimateFeeRequest *EstimateFeeRequest) (*EstimateFeeResponse, error) {
+	estimate, err := api.svc.EstimateFee(ctx, &lnclient.EstimateFeeRequest{
+		Invoice:     estimateFeeRequest.Invoice,
+		Destination: estimateFeeRequest.NodeId,
+		AmountMsat:  estimateFeeRequest.AmountMsat,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &EstimateFeeResponse{
+		FeeMsat:            estimate.FeeMsat,
+		SuccessProbability: estimate.SuccessProbability,
+	}, nil
+}
+
 func (api *api) GetNetworkGraph(nodeIds []string) (NetworkGraphResponse, error) {
 	if api.svc.GetLNClient() == nil {
 		return nil, errors.New("LNClient not started")
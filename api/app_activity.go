@@ -0,0 +1,224 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+)
+
+const (
+	defaultAppEventsLimit = 20
+	maxAppEventsLimit     = 100
+)
+
+// ListAppEvents lists the NIP-47 requests of the app with their responses, newest first
+func (api *api) ListAppEvents(userApp *db.App, listAppEventsRequest *ListAppEventsRequest) (*ListAppEventsResponse, error) {
+	limit := listAppEventsRequest.Limit
+	if limit == 0 {
+		limit = defaultAppEventsLimit
+	}
+	if limit > maxAppEventsLimit {
+		limit = maxAppEventsLimit
+	}
+
+	response := &ListAppEventsResponse{
+		Events: []AppEvent{},
+	}
+	err := api.db.Model(&db.RequestEvent{}).Where("app_id = ?", userApp.ID).Count(&response.TotalCount).Error
+	if err != nil {
+		return nil, err
+	}
+
+	requestEvents := []db.RequestEvent{}
+	err = api.db.Where("app_id = ?", userApp.ID).Order("id desc").Limit(int(limit)).Offset(int(listAppEventsRequest.Offset)).Find(&requestEvents).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(requestEvents) == 0 {
+		return response, nil
+	}
+
+	requestIds := []uint{}
+	for _, requestEvent := range requestEvents {
+		requestIds = append(requestIds, requestEvent.ID)
+	}
+
+	responseEvents := []db.ResponseEvent{}
+	err = api.db.Where("request_id IN ?", requestIds).Order("id").Find(&responseEvents).Error
+	if err != nil {
+		return nil, err
+	}
+	responsesByRequestId := map[uint][]AppEventResponse{}
+	for _, responseEvent := range responseEvents {
+		responsesByRequestId[responseEvent.RequestId] = append(responsesByRequestId[responseEvent.RequestId], AppEventResponse{
+			NostrId:   responseEvent.NostrId,
+			State:     responseEvent.State,
+			RepliedAt: responseEvent.RepliedAt,
+		})
+	}
+
+	// a multi_pay request makes several payments
+	var paymentAmounts []struct {
+		RequestEventId uint
+		AmountMsat     uint64
+	}
+	err = api.db.Model(&db.Payment{}).Select("request_event_id, SUM(amount_msat) AS amount_msat").Where("request_event_id IN ?", requestIds).Group("request_event_id").Scan(&paymentAmounts).Error
+	if err != nil {
+		return nil, err
+	}
+	amountsByRequestId := map[uint]uint64{}
+	for _, paymentAmount := range paymentAmounts {
+		amountsByRequestId[paymentAmount.RequestEventId] = paymentAmount.AmountMsat
+	}
+
+	for _, requestEvent := range requestEvents {
+		responses := responsesByRequestId[requestEvent.ID]
+		if responses == nil {
+			responses = []AppEventResponse{}
+		}
+		response.Events = append(response.Events, AppEvent{
+			ID:         requestEvent.ID,
+			NostrId:    requestEvent.NostrId,
+			Method:     requestEvent.Method,
+			State:      requestEvent.State,
+			CreatedAt:  requestEvent.CreatedAt,
+			UpdatedAt:  requestEvent.UpdatedAt,
+			AmountMsat: amountsByRequestId[requestEvent.ID],
+			Responses:  responses,
+		})
+	}
+	return response, nil
+}
+
+// GetAppStats counts the requests and payments of the app per day or week, and how many of them failed
+func (api *api) GetAppStats(userApp *db.App, getAppStatsRequest *GetAppStatsRequest) (*AppStats, error) {
+	period := getAppStatsRequest.Period
+	if period == "" {
+		period = AppStatsPeriodDay
+	}
+
+	until := time.Now()
+	if getAppStatsRequest.Until != 0 {
+		until = time.Unix(getAppStatsRequest.Until, 0)
+	}
+
+	var bucketColumn string
+	var bucketLength time.Duration
+	var defaultFrom time.Time
+	switch period {
+	case AppStatsPeriodDay:
+		bucketColumn = "date(created_at)"
+		bucketLength = 24 * time.Hour
+		defaultFrom = until.AddDate(0, 0, -29)
+	case AppStatsPeriodWeek:
+		// the monday of the week: the next sunday, or the same day if it is a sunday, minus six days
+		bucketColumn = "date(created_at, 'weekday 0', '-6 days')"
+		bucketLength = 7 * 24 * time.Hour
+		defaultFrom = until.AddDate(0, 0, -7*11)
+	default:
+		return nil, fmt.Errorf("unsupported stats period: %s", period)
+	}
+
+	from := bucketStart(defaultFrom, period)
+	if getAppStatsRequest.From != 0 {
+		from = time.Unix(getAppStatsRequest.From, 0)
+	}
+	if from.After(until) {
+		return nil, fmt.Errorf("from must not be after until")
+	}
+
+	var requestCounts []struct {
+		Bucket string
+		Count  int64
+		Failed int64
+	}
+	err := api.db.Model(&db.RequestEvent{}).
+		Select(bucketColumn+" AS bucket, COUNT(*) AS count, SUM(CASE WHEN state = ? THEN 1 ELSE 0 END) AS failed", db.REQUEST_EVENT_STATE_HANDLER_ERROR).
+		Where("app_id = ? AND created_at >= ? AND created_at <= ?", userApp.ID, from, until).
+		Group("bucket").
+		Scan(&requestCounts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var paymentCounts []struct {
+		Bucket    string
+		Count     int64
+		Failed    int64
+		SpentMsat uint64
+	}
+	err = api.db.Model(&db.Payment{}).
+		Select(bucketColumn+" AS bucket, COUNT(*) AS count, SUM(CASE WHEN state = ? THEN 1 ELSE 0 END) AS failed, SUM(CASE WHEN state = ? THEN amount_msat ELSE 0 END) AS spent_msat", db.PAYMENT_STATE_FAILED, db.PAYMENT_STATE_SUCCEEDED).
+		Where("app_id = ? AND created_at >= ? AND created_at <= ?", userApp.ID, from, until).
+		Group("bucket").
+		Scan(&paymentCounts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	// every day or week of the range is listed, including the ones without activity
+	stats := &AppStats{
+		Period:  period,
+		Buckets: []AppStatsBucket{},
+	}
+	bucketIndexes := map[string]int{}
+	for start := bucketStart(from, period); !start.After(until); start = start.Add(bucketLength) {
+		bucketIndexes[start.Format(time.DateOnly)] = len(stats.Buckets)
+		stats.Buckets = append(stats.Buckets, AppStatsBucket{Start: start})
+	}
+	if len(stats.Buckets) > 0 {
+		stats.Totals.Start = stats.Buckets[0].Start
+	}
+
+	for _, requestCount := range requestCounts {
+		index, ok := bucketIndexes[requestCount.Bucket]
+		if !ok {
+			continue
+		}
+		stats.Buckets[index].RequestCount = requestCount.Count
+		stats.Buckets[index].FailedRequestCount = requestCount.Failed
+	}
+	for _, paymentCount := range paymentCounts {
+		index, ok := bucketIndexes[paymentCount.Bucket]
+		if !ok {
+			continue
+		}
+		stats.Buckets[index].PaymentCount = paymentCount.Count
+		stats.Buckets[index].FailedPaymentCount = paymentCount.Failed
+		stats.Buckets[index].SpentMsat = paymentCount.SpentMsat
+	}
+
+	for i := range stats.Buckets {
+		bucket := &stats.Buckets[i]
+		bucket.RequestFailureRate = failureRate(bucket.FailedRequestCount, bucket.RequestCount)
+		bucket.PaymentFailureRate = failureRate(bucket.FailedPaymentCount, bucket.PaymentCount)
+
+		stats.Totals.RequestCount += bucket.RequestCount
+		stats.Totals.FailedRequestCount += bucket.FailedRequestCount
+		stats.Totals.PaymentCount += bucket.PaymentCount
+		stats.Totals.FailedPaymentCount += bucket.FailedPaymentCount
+		stats.Totals.SpentMsat += bucket.SpentMsat
+	}
+	stats.Totals.RequestFailureRate = failureRate(stats.Totals.FailedRequestCount, stats.Totals.RequestCount)
+	stats.Totals.PaymentFailureRate = failureRate(stats.Totals.FailedPaymentCount, stats.Totals.PaymentCount)
+
+	return stats, nil
+}
+
+// bucketStart returns the start of the day, or of the week starting on monday, of t in UTC
+func bucketStart(t time.Time, period string) time.Time {
+	t = t.UTC()
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if period == AppStatsPeriodWeek {
+		start = start.AddDate(0, 0, -((int(start.Weekday()) + 6) % 7))
+	}
+	return start
+}
+
+func failureRate(failed, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(failed) / float64(total)
+}
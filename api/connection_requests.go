@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	"gorm.io/gorm"
+)
+
+var ErrConnectionRequestNotFound = errors.New("connection request not found")
+var ErrConnectionRequestExpired = errors.New("connection request expired")
+
+// ListConnectionRequests lists the connections apps asked for which the owner did not approve or reject yet
+// and which did not expire
+func (api *api) ListConnectionRequests() ([]ConnectionRequest, error) {
+	dbConnectionRequests := []db.ConnectionRequest{}
+	err := api.db.Where("state = ? AND created_at >= ?", db.CONNECTION_REQUEST_STATE_PENDING, time.Now().Add(-db.CONNECTION_REQUEST_EXPIRY)).
+		Order("created_at desc").Find(&dbConnectionRequests).Error
+	if err != nil {
+		return nil, err
+	}
+
+	connectionRequests := []ConnectionRequest{}
+	for _, dbConnectionRequest := range dbConnectionRequests {
+		connectionRequests = append(connectionRequests, toApiConnectionRequest(&dbConnectionRequest))
+	}
+	return connectionRequests, nil
+}
+
+// CreateConnectionRequest adds the connection request of a nostr+walletauth deep link the app opened
+func (api *api) CreateConnectionRequest(createConnectionRequestRequest *CreateConnectionRequestRequest) (*ConnectionRequest, error) {
+	event, err := nip47.ParseConnectionRequestUri(createConnectionRequestRequest.Uri)
+	if err != nil {
+		return nil, err
+	}
+	dbConnectionRequest, err := api.svc.AddConnectionRequest(event)
+	if err != nil {
+		return nil, err
+	}
+	connectionRequest := toApiConnectionRequest(dbConnectionRequest)
+	return &connectionRequest, nil
+}
+
+// ApproveConnectionRequest creates the app with the key of the app that asked for it, with the permissions
+// it asked for unless the owner changed them, and sends the app the details of the connection
+func (api *api) ApproveConnectionRequest(ctx context.Context, id uint, approveConnectionRequestRequest *ApproveConnectionRequestRequest) (*ConnectionRequest, error) {
+	dbConnectionRequest, err := api.takePendingConnectionRequest(id, db.CONNECTION_REQUEST_STATE_APPROVED)
+	if err != nil {
+		return nil, err
+	}
+
+	createAppRequest := &CreateAppRequest{
+		Name:           dbConnectionRequest.Name,
+		Pubkey:         dbConnectionRequest.AppPubkey,
+		MaxAmount:      dbConnectionRequest.MaxAmount,
+		BudgetRenewal:  dbConnectionRequest.BudgetRenewal,
+		RequestMethods: dbConnectionRequest.RequestMethods,
+		BudgetCurrency: approveConnectionRequestRequest.BudgetCurrency,
+		MaxAmountFiat:  approveConnectionRequestRequest.MaxAmountFiat,
+		MaxFeeMsat:     approveConnectionRequestRequest.MaxFeeMsat,
+		Isolated:       approveConnectionRequestRequest.Isolated,
+	}
+	if dbConnectionRequest.ExpiresAt != nil {
+		createAppRequest.ExpiresAt = dbConnectionRequest.ExpiresAt.Format(time.RFC3339)
+	}
+	if approveConnectionRequestRequest.Name != "" {
+		createAppRequest.Name = approveConnectionRequestRequest.Name
+	}
+	if approveConnectionRequestRequest.RequestMethods != "" {
+		createAppRequest.RequestMethods = approveConnectionRequestRequest.RequestMethods
+	}
+	if approveConnectionRequestRequest.MaxAmount != nil {
+		createAppRequest.MaxAmount = *approveConnectionRequestRequest.MaxAmount
+	}
+	if approveConnectionRequestRequest.BudgetRenewal != nil {
+		createAppRequest.BudgetRenewal = *approveConnectionRequestRequest.BudgetRenewal
+	}
+	if approveConnectionRequestRequest.ExpiresAt != nil {
+		createAppRequest.ExpiresAt = *approveConnectionRequestRequest.ExpiresAt
+	}
+
+	_, err = api.CreateApp(createAppRequest)
+	if err != nil {
+		// the owner can fix the permissions and approve again
+		api.db.Model(dbConnectionRequest).Update("state", db.CONNECTION_REQUEST_STATE_PENDING)
+		return nil, err
+	}
+
+	app := db.App{}
+	err = api.db.Where("nostr_pubkey = ?", dbConnectionRequest.AppPubkey).First(&app).Error
+	if err != nil {
+		return nil, err
+	}
+	dbConnectionRequest.AppId = &app.ID
+	err = api.db.Model(dbConnectionRequest).Update("app_id", app.ID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = api.svc.PublishConnectionResponse(ctx, dbConnectionRequest)
+	if err != nil {
+		return nil, fmt.Errorf("the app was connected but could not be notified: %w", err)
+	}
+	connectionRequest := toApiConnectionRequest(dbConnectionRequest)
+	return &connectionRequest, nil
+}
+
+// RejectConnectionRequest lets the app know its request was rejected
+func (api *api) RejectConnectionRequest(ctx context.Context, id uint) error {
+	dbConnectionRequest, err := api.takePendingConnectionRequest(id, db.CONNECTION_REQUEST_STATE_REJECTED)
+	if err != nil {
+		return err
+	}
+	return api.svc.PublishConnectionResponse(ctx, dbConnectionRequest)
+}
+
+// takePendingConnectionRequest moves the request out of the pending state, so it is only approved or rejected once
+func (api *api) takePendingConnectionRequest(id uint, state string) (*db.ConnectionRequest, error) {
+	dbConnectionRequest := &db.ConnectionRequest{}
+	err := api.db.First(dbConnectionRequest, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrConnectionRequestNotFound
+		}
+		return nil, err
+	}
+
+	expiredBefore := time.Now().Add(-db.CONNECTION_REQUEST_EXPIRY)
+	if dbConnectionRequest.State == db.CONNECTION_REQUEST_STATE_PENDING && dbConnectionRequest.CreatedAt.Before(expiredBefore) {
+		return nil, ErrConnectionRequestExpired
+	}
+
+	result := api.db.Model(&db.ConnectionRequest{}).
+		Where("id = ? AND state = ? AND created_at >= ?", id, db.CONNECTION_REQUEST_STATE_PENDING, expiredBefore).
+		Update("state", state)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("the connection request was already %s", dbConnectionRequest.State)
+	}
+	dbConnectionRequest.State = state
+	return dbConnectionRequest, nil
+}
+
+func toApiConnectionRequest(dbConnectionRequest *db.ConnectionRequest) ConnectionRequest {
+	return ConnectionRequest{
+		ID:             dbConnectionRequest.ID,
+		AppPubkey:      dbConnectionRequest.AppPubkey,
+		Name:           dbConnectionRequest.Name,
+		RequestMethods: dbConnectionRequest.RequestMethods,
+		MaxAmount:      dbConnectionRequest.MaxAmount,
+		BudgetRenewal:  dbConnectionRequest.BudgetRenewal,
+		ExpiresAt:      dbConnectionRequest.ExpiresAt,
+		Relay:          dbConnectionRequest.Relay,
+		State:          dbConnectionRequest.State,
+		AppId:          dbConnectionRequest.AppId,
+		RespondedAt:    dbConnectionRequest.RespondedAt,
+		CreatedAt:      dbConnectionRequest.CreatedAt,
+	}
+}
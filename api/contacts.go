@@ -0,0 +1,150 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/lnurl"
+	"gorm.io/gorm"
+)
+
+var nodePubkeyRegex = regexp.MustCompile("^[0-9a-f]{66}$")
+
+var ErrContactNotFound = errors.New("contact not found")
+
+func (api *api) ListContacts() ([]Contact, error) {
+	dbContacts := []db.Contact{}
+	err := api.db.Order("name COLLATE NOCASE").Find(&dbContacts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	contacts := []Contact{}
+	for _, dbContact := range dbContacts {
+		contacts = append(contacts, toApiContact(&dbContact))
+	}
+	return contacts, nil
+}
+
+func (api *api) CreateContact(contactRequest *ContactRequest) (*Contact, error) {
+	dbContact := &db.Contact{}
+	err := api.setContactFields(dbContact, contactRequest)
+	if err != nil {
+		return nil, err
+	}
+	err = api.db.Create(dbContact).Error
+	if err != nil {
+		return nil, err
+	}
+	contact := toApiContact(dbContact)
+	return &contact, nil
+}
+
+// UpdateContact replaces the name and destinations of the contact
+func (api *api) UpdateContact(id uint, contactRequest *ContactRequest) (*Contact, error) {
+	dbContact := &db.Contact{}
+	err := api.db.First(dbContact, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrContactNotFound
+		}
+		return nil, err
+	}
+	err = api.setContactFields(dbContact, contactRequest)
+	if err != nil {
+		return nil, err
+	}
+	err = api.db.Save(dbContact).Error
+	if err != nil {
+		return nil, err
+	}
+	contact := toApiContact(dbContact)
+	return &contact, nil
+}
+
+func (api *api) DeleteContact(id uint) error {
+	result := api.db.Delete(&db.Contact{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrContactNotFound
+	}
+	return nil
+}
+
+// setContactFields validates the request and stores its destinations normalized,
+// so they can be matched against the destinations of payments
+func (api *api) setContactFields(dbContact *db.Contact, contactRequest *ContactRequest) error {
+	name := strings.TrimSpace(contactRequest.Name)
+	if name == "" {
+		return errors.New("contact name is required")
+	}
+	lightningAddress := lnurl.Normalize(contactRequest.LightningAddress)
+	lnurlValue := lnurl.Normalize(contactRequest.Lnurl)
+	nodePubkey := strings.ToLower(strings.TrimSpace(contactRequest.NodePubkey))
+	if lightningAddress == "" && lnurlValue == "" && nodePubkey == "" {
+		return errors.New("a lightning address, lnurl or node pubkey is required")
+	}
+	if lightningAddress != "" {
+		if !strings.Contains(lightningAddress, "@") {
+			return fmt.Errorf("invalid lightning address: %s", contactRequest.LightningAddress)
+		}
+		_, err := lnurl.ResolveUrl(lightningAddress)
+		if err != nil {
+			return err
+		}
+	}
+	if lnurlValue != "" {
+		_, err := lnurl.ResolveUrl(lnurlValue)
+		if err != nil {
+			return err
+		}
+	}
+	if nodePubkey != "" && !nodePubkeyRegex.MatchString(nodePubkey) {
+		return fmt.Errorf("invalid node pubkey: %s", contactRequest.NodePubkey)
+	}
+
+	var existingCount int64
+	err := api.db.Model(&db.Contact{}).Where("name = ? COLLATE NOCASE AND id != ?", name, dbContact.ID).Count(&existingCount).Error
+	if err != nil {
+		return err
+	}
+	if existingCount > 0 {
+		return fmt.Errorf("a contact named %s already exists", name)
+	}
+
+	dbContact.Name = name
+	dbContact.LightningAddress = lightningAddress
+	dbContact.Lnurl = lnurlValue
+	dbContact.NodePubkey = nodePubkey
+	return nil
+}
+
+// findContactByName looks up a contact ignoring the case of its name
+func (api *api) findContactByName(name string) (*db.Contact, error) {
+	dbContact := &db.Contact{}
+	err := api.db.Where("name = ? COLLATE NOCASE", strings.TrimSpace(name)).First(dbContact).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrContactNotFound
+		}
+		return nil, err
+	}
+	return dbContact, nil
+}
+
+func toApiContact(dbContact *db.Contact) Contact {
+	return Contact{
+		ID:               dbContact.ID,
+		Name:             dbContact.Name,
+		LightningAddress: dbContact.LightningAddress,
+		Lnurl:            dbContact.Lnurl,
+		NodePubkey:       dbContact.NodePubkey,
+		CreatedAt:        dbContact.CreatedAt,
+		UpdatedAt:        dbContact.UpdatedAt,
+	}
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrLabelNotFound = errors.New("label not found")
+
+// the longest line accepted when importing labels
+const maxLabelLineLength = 1024 * 1024
+
+var labelTypes = map[string]bool{
+	LabelTypeTx:      true,
+	LabelTypeAddr:    true,
+	LabelTypePubkey:  true,
+	LabelTypeInput:   true,
+	LabelTypeOutput:  true,
+	LabelTypeXpub:    true,
+	LabelTypePayment: true,
+	LabelTypeChannel: true,
+}
+
+func (api *api) ListLabels() ([]Label, error) {
+	dbLabels := []db.Label{}
+	err := api.db.Order("type, ref").Find(&dbLabels).Error
+	if err != nil {
+		return nil, err
+	}
+
+	labels := []Label{}
+	for _, dbLabel := range dbLabels {
+		labels = append(labels, toApiLabel(&dbLabel))
+	}
+	return labels, nil
+}
+
+func (api *api) GetLabel(labelType, ref string) (*Label, error) {
+	dbLabel := &db.Label{}
+	err := api.db.Where("type = ? AND ref = ?", labelType, ref).First(dbLabel).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrLabelNotFound
+		}
+		return nil, err
+	}
+	label := toApiLabel(dbLabel)
+	return &label, nil
+}
+
+// SetLabel adds the label or replaces the existing label of the same type and reference
+func (api *api) SetLabel(label *Label) error {
+	return setLabel(api.db, label)
+}
+
+func setLabel(tx *gorm.DB, label *Label) error {
+	if !labelTypes[label.Type] {
+		return fmt.Errorf("unsupported label type: %s", label.Type)
+	}
+	if label.Ref == "" {
+		return errors.New("label reference is required")
+	}
+
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "type"}, {Name: "ref"}},
+		DoUpdates: clause.AssignmentColumns([]string{"label", "origin", "spendable", "updated_at"}),
+	}).Create(&db.Label{
+		Type:      label.Type,
+		Ref:       label.Ref,
+		Label:     label.Label,
+		Origin:    label.Origin,
+		Spendable: label.Spendable,
+	}).Error
+}
+
+func (api *api) DeleteLabel(labelType, ref string) error {
+	return api.db.Where("type = ? AND ref = ?", labelType, ref).Delete(&db.Label{}).Error
+}
+
+// ImportLabels adds the labels of a BIP-329 JSONL export, replacing existing labels of the same references.
+// Lines of types this hub does not label are skipped, as BIP-329 asks of importing wallets.
+func (api *api) ImportLabels(r io.Reader) (*ImportLabelsResponse, error) {
+	response := &ImportLabelsResponse{}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLabelLineLength)
+	lineNumber := 0
+	err := api.db.Transaction(func(tx *gorm.DB) error {
+		for scanner.Scan() {
+			lineNumber++
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+
+			label := Label{}
+			err := json.Unmarshal([]byte(line), &label)
+			if err != nil {
+				return fmt.Errorf("invalid label on line %d: %w", lineNumber, err)
+			}
+			if !labelTypes[label.Type] || label.Ref == "" {
+				response.Skipped++
+				continue
+			}
+
+			err = setLabel(tx, &label)
+			if err != nil {
+				return fmt.Errorf("failed to save label on line %d: %w", lineNumber, err)
+			}
+			response.Imported++
+		}
+		return scanner.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// ExportLabels writes all labels to w as BIP-329 JSONL
+func (api *api) ExportLabels(w io.Writer) error {
+	labels, err := api.ListLabels()
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	for i := range labels {
+		err = encoder.Encode(&labels[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findLabels returns the labels of the given type by reference
+func (api *api) findLabels(labelType string, refs []string) map[string]string {
+	labels := map[string]string{}
+	if len(refs) == 0 {
+		return labels
+	}
+
+	dbLabels := []db.Label{}
+	err := api.db.Where("type = ? AND ref IN ?", labelType, refs).Find(&dbLabels).Error
+	if err != nil {
+		api.logger.WithError(err).Error("Failed to find labels")
+		return labels
+	}
+	for _, dbLabel := range dbLabels {
+		labels[dbLabel.Ref] = dbLabel.Label
+	}
+	return labels
+}
+
+func toApiLabel(dbLabel *db.Label) Label {
+	return Label{
+		Type:      dbLabel.Type,
+		Ref:       dbLabel.Ref,
+		Label:     dbLabel.Label,
+		Origin:    dbLabel.Origin,
+		Spendable: dbLabel.Spendable,
+	}
+}
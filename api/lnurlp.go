@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/lnclient"
+	"github.com/getAlby/nostr-wallet-connect/lnurl"
+)
+
+const (
+	lnurlMinSendable    = 1000            // msat
+	lnurlMaxSendable    = 100_000_000_000 // msat (1 BTC)
+	lnurlCommentAllowed = 255
+	lnurlInvoiceExpiry  = 86400 // seconds
+)
+
+var ErrLightningAddressNotFound = errors.New("lightning address not found")
+
+// LUD-16 only allows lowercase usernames
+var lightningAddressUsernameRegex = regexp.MustCompile(`^[a-z0-9\-_.]{1,64}$`)
+
+func validateLightningAddressUsername(username string) error {
+	if !lightningAddressUsernameRegex.MatchString(username) {
+		return fmt.Errorf("invalid lightning address username: %s", username)
+	}
+	return nil
+}
+
+// checkLightningAddressSupport returns an error unless the LN backend can make invoices committing to the
+// LNURL metadata, paying wallets reject any other invoice
+func (api *api) checkLightningAddressSupport() error {
+	lnClient := api.svc.GetLNClient()
+	if lnClient == nil {
+		return errors.New("LNClient not started")
+	}
+	if !slices.Contains(lnClient.GetSupportedFeatures(), lnclient.FeatureDescriptionHash) {
+		return errors.New("lightning addresses are not supported by this lightning backend")
+	}
+	return nil
+}
+
+// lightningAddress returns an empty string if no lightning address domain is configured
+func (api *api) lightningAddress(username *string) string {
+	domain := api.svc.GetConfig().GetEnv().LightningAddressDomain
+	if domain == "" || username == nil || *username == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s@%s", *username, domain)
+}
+
+func (api *api) findLightningAddressApp(username string) (*db.App, string, error) {
+	app := db.App{}
+	findResult := api.db.Where("lightning_address_username = ?", strings.ToLower(username)).Limit(1).Find(&app)
+	if findResult.Error != nil {
+		return nil, "", findResult.Error
+	}
+	lightningAddress := api.lightningAddress(app.LightningAddressUsername)
+	if findResult.RowsAffected == 0 || lightningAddress == "" {
+		return nil, "", ErrLightningAddressNotFound
+	}
+	return &app, lightningAddress, nil
+}
+
+func lnurlMetadata(lightningAddress string) (string, error) {
+	metadata, err := json.Marshal([][]string{
+		{"text/plain", fmt.Sprintf("Payment to %s", lightningAddress)},
+		{"text/identifier", lightningAddress},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(metadata), nil
+}
+
+func (api *api) GetLnurlPayParams(username string) (*lnurl.PayParams, error) {
+	err := api.checkLightningAddressSupport()
+	if err != nil {
+		return nil, err
+	}
+
+	app, lightningAddress, err := api.findLightningAddressApp(username)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := lnurlMetadata(lightningAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lnurl.PayParams{
+		Tag:            lnurl.PAY_REQUEST_TAG,
+		Callback:       fmt.Sprintf("https://%s/lnurlp/%s/callback", api.svc.GetConfig().GetEnv().LightningAddressDomain, *app.LightningAddressUsername),
+		MinSendable:    lnurlMinSendable,
+		MaxSendable:    lnurlMaxSendable,
+		Metadata:       metadata,
+		CommentAllowed: lnurlCommentAllowed,
+	}, nil
+}
+
+func (api *api) LnurlPayCallback(ctx context.Context, username string, amountMsat int64, comment string) (*lnurl.PayCallbackResponse, error) {
+	err := api.checkLightningAddressSupport()
+	if err != nil {
+		return nil, err
+	}
+
+	app, lightningAddress, err := api.findLightningAddressApp(username)
+	if err != nil {
+		return nil, err
+	}
+
+	if amountMsat < lnurlMinSendable || amountMsat > lnurlMaxSendable {
+		return nil, fmt.Errorf("amount must be between %d and %d msat", lnurlMinSendable, lnurlMaxSendable)
+	}
+	// invoices are stored in sats, the app would not be credited the extra millisats
+	if amountMsat%1000 != 0 {
+		return nil, errors.New("amount must be a whole number of sats")
+	}
+	if len(comment) > lnurlCommentAllowed {
+		return nil, fmt.Errorf("comment must not be longer than %d characters", lnurlCommentAllowed)
+	}
+
+	metadata, err := lnurlMetadata(lightningAddress)
+	if err != nil {
+		return nil, err
+	}
+	metadataHash := sha256.Sum256([]byte(metadata))
+	descriptionHash := hex.EncodeToString(metadataHash[:])
+
+	transaction, err := api.svc.GetLNClient().MakeInvoice(ctx, amountMsat, "", descriptionHash, lnurlInvoiceExpiry)
+	if err != nil {
+		return nil, err
+	}
+	// paying wallets reject invoices that do not commit to the metadata
+	if transaction.DescriptionHash != descriptionHash {
+		return nil, errors.New("lightning backend does not support description hashes")
+	}
+
+	invoice := db.Invoice{
+		AppId:          app.ID,
+		PaymentHash:    transaction.PaymentHash,
+		PaymentRequest: transaction.Invoice,
+		Amount:         uint(amountMsat / 1000),
+		Comment:        comment,
+	}
+	err = api.db.Create(&invoice).Error
+	if err != nil {
+		return nil, err
+	}
+
+	api.logger.WithFields(logrus.Fields{
+		"appId":       app.ID,
+		"paymentHash": transaction.PaymentHash,
+		"amount":      amountMsat,
+	}).Info("Created lightning address invoice")
+
+	return &lnurl.PayCallbackResponse{
+		PR:     transaction.Invoice,
+		Routes: []interface{}{},
+	}, nil
+}
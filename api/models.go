@@ -2,13 +2,16 @@ package api
 
 import (
 	"context"
+	"io"
 	"time"
 
 	"github.com/getAlby/nostr-wallet-connect/alby"
 	"github.com/getAlby/nostr-wallet-connect/backup"
 	"github.com/getAlby/nostr-wallet-connect/db"
 	"github.com/getAlby/nostr-wallet-connect/lnclient"
+	"github.com/getAlby/nostr-wallet-connect/lnurl"
 	"github.com/getAlby/nostr-wallet-connect/lsp"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
 )
 
 type API interface {
@@ -17,7 +20,10 @@ type API interface {
 	DeleteApp(userApp *db.App) error
 	GetApp(userApp *db.App) *App
 	ListApps() ([]App, error)
-	ListChannels(ctx context.Context) ([]lnclient.Channel, error)
+	ListAppPayments(userApp *db.App) ([]Payment, error)
+	ListAppEvents(userApp *db.App, listAppEventsRequest *ListAppEventsRequest) (*ListAppEventsResponse, error)
+	GetAppStats(userApp *db.App, getAppStatsRequest *GetAppStatsRequest) (*AppStats, error)
+	ListChannels(ctx context.Context) ([]Channel, error)
 	GetChannelPeerSuggestions(ctx context.Context) ([]alby.ChannelPeerSuggestion, error)
 	ResetRouter(key string) error
 	ChangeUnlockPassword(changeUnlockPasswordRequest *ChangeUnlockPasswordRequest) error
@@ -42,11 +48,33 @@ type API interface {
 	Setup(ctx context.Context, setupRequest *SetupRequest) error
 	SendPaymentProbes(ctx context.Context, sendPaymentProbesRequest *SendPaymentProbesRequest) (*SendPaymentProbesResponse, error)
 	SendSpontaneousPaymentProbes(ctx context.Context, sendSpontaneousPaymentProbesRequest *SendSpontaneousPaymentProbesRequest) (*SendSpontaneousPaymentProbesResponse, error)
+	EstimateFee(ctx context.Context, estimateFeeRequest *EstimateFeeRequest) (*EstimateFeeResponse, error)
 	GetNetworkGraph(nodeIds []string) (NetworkGraphResponse, error)
 	SyncWallet() error
 	GetLogOutput(ctx context.Context, logType string, getLogRequest *GetLogOutputRequest) (*GetLogOutputResponse, error)
 	GetLSPService() lsp.LSPService
 	GetBackupService() backup.BackupService
+	GetLnurlPayParams(username string) (*lnurl.PayParams, error)
+	LnurlPayCallback(ctx context.Context, username string, amountMsat int64, comment string) (*lnurl.PayCallbackResponse, error)
+	ExportTransactions(ctx context.Context, exportRequest *ExportTransactionsRequest, w io.Writer) error
+	ListLabels() ([]Label, error)
+	GetLabel(labelType, ref string) (*Label, error)
+	SetLabel(label *Label) error
+	DeleteLabel(labelType, ref string) error
+	ImportLabels(r io.Reader) (*ImportLabelsResponse, error)
+	ExportLabels(w io.Writer) error
+	ListContacts() ([]Contact, error)
+	CreateContact(contactRequest *ContactRequest) (*Contact, error)
+	UpdateContact(id uint, contactRequest *ContactRequest) (*Contact, error)
+	DeleteContact(id uint) error
+	ListConnectionRequests() ([]ConnectionRequest, error)
+	CreateConnectionRequest(createConnectionRequestRequest *CreateConnectionRequestRequest) (*ConnectionRequest, error)
+	ApproveConnectionRequest(ctx context.Context, id uint, approveConnectionRequestRequest *ApproveConnectionRequestRequest) (*ConnectionRequest, error)
+	RejectConnectionRequest(ctx context.Context, id uint) error
+	SendPayment(ctx context.Context, sendPaymentRequest *SendPaymentRequest) (*nip47.PayResponse, error)
+	MakeInvoice(ctx context.Context, makeInvoiceRequest *MakeInvoiceRequest) (*nip47.Transaction, error)
+	LookupInvoice(ctx context.Context, paymentHash string) (*nip47.Transaction, error)
+	ListTransactions(ctx context.Context, listTransactionsRequest *ListTransactionsRequest) ([]nip47.Transaction, error)
 }
 
 type App struct {
@@ -60,9 +88,95 @@ type App struct {
 	LastEventAt    *time.Time `json:"lastEventAt"`
 	ExpiresAt      *time.Time `json:"expiresAt"`
 	RequestMethods []string   `json:"requestMethods"`
-	MaxAmount      int        `json:"maxAmount"`
-	BudgetUsage    int64      `json:"budgetUsage"`
+	MaxAmount      int        `json:"maxAmount"`   // in sats
+	BudgetUsage    int64      `json:"budgetUsage"` // in sats, rounded down
 	BudgetRenewal  string     `json:"budgetRenewal"`
+	MaxFeeMsat     *uint64    `json:"maxFeeMsat"`
+
+	MaxAmountMsat   int64 `json:"maxAmountMsat"`
+	BudgetUsageMsat int64 `json:"budgetUsageMsat"`
+
+	BudgetCurrency string `json:"budgetCurrency"`
+	MaxAmountFiat  int64  `json:"maxAmountFiat"` // in cents of BudgetCurrency
+
+	LightningAddressUsername *string `json:"lightningAddressUsername"`
+	LightningAddress         string  `json:"lightningAddress"`
+
+	Isolated bool `json:"isolated"`
+	// in msat, only set for isolated apps
+	Balance *int64 `json:"balance"`
+}
+
+type Payment struct {
+	ID             uint       `json:"id"`
+	Amount         uint64     `json:"amount"` // in sats, rounded down
+	AmountMsat     uint64     `json:"amountMsat"`
+	PaymentRequest string     `json:"paymentRequest"`
+	PaymentHash    string     `json:"paymentHash"`
+	Preimage       *string    `json:"preimage"`
+	State          string     `json:"state"`
+	FailureReason  string     `json:"failureReason"`
+	CreatedAt      time.Time  `json:"createdAt"`
+	SettledAt      *time.Time `json:"settledAt"`
+	FailedAt       *time.Time `json:"failedAt"`
+}
+
+type ListAppEventsRequest struct {
+	Limit  uint64 `query:"limit"`
+	Offset uint64 `query:"offset"`
+}
+
+type ListAppEventsResponse struct {
+	Events     []AppEvent `json:"events"`
+	TotalCount int64      `json:"totalCount"`
+}
+
+// AppEvent is a NIP-47 request of an app and the responses published to it
+type AppEvent struct {
+	ID         uint               `json:"id"`
+	NostrId    string             `json:"nostrId"`
+	Method     string             `json:"method"`
+	State      string             `json:"state"`
+	CreatedAt  time.Time          `json:"createdAt"`
+	UpdatedAt  time.Time          `json:"updatedAt"`
+	AmountMsat uint64             `json:"amountMsat"` // of the payments made for the request
+	Responses  []AppEventResponse `json:"responses"`
+}
+
+type AppEventResponse struct {
+	NostrId   string    `json:"nostrId"`
+	State     string    `json:"state"`
+	RepliedAt time.Time `json:"repliedAt"`
+}
+
+const (
+	AppStatsPeriodDay  = "day"
+	AppStatsPeriodWeek = "week"
+)
+
+type GetAppStatsRequest struct {
+	Period string `query:"period"`
+	// unix timestamps of the reported time range, the last 30 days or 12 weeks if zero
+	From  int64 `query:"from"`
+	Until int64 `query:"until"`
+}
+
+type AppStats struct {
+	Period  string           `json:"period"`
+	Totals  AppStatsBucket   `json:"totals"`
+	Buckets []AppStatsBucket `json:"buckets"`
+}
+
+// AppStatsBucket sums up the requests and payments of an app in one day or week (UTC)
+type AppStatsBucket struct {
+	Start              time.Time `json:"start"`
+	RequestCount       int64     `json:"requestCount"`
+	FailedRequestCount int64     `json:"failedRequestCount"`
+	RequestFailureRate float64   `json:"requestFailureRate"`
+	PaymentCount       int64     `json:"paymentCount"`
+	FailedPaymentCount int64     `json:"failedPaymentCount"`
+	PaymentFailureRate float64   `json:"paymentFailureRate"`
+	SpentMsat          uint64    `json:"spentMsat"` // of the succeeded payments
 }
 
 type ListAppsResponse struct {
@@ -74,6 +188,16 @@ type UpdateAppRequest struct {
 	BudgetRenewal  string `json:"budgetRenewal"`
 	ExpiresAt      string `json:"expiresAt"`
 	RequestMethods string `json:"requestMethods"`
+
+	// a budget in a fiat currency like "EUR", in cents
+	BudgetCurrency string `json:"budgetCurrency"`
+	MaxAmountFiat  int64  `json:"maxAmountFiat"`
+
+	// routing fee limit for payments without a lower max_fee, nil removes it
+	MaxFeeMsat *uint64 `json:"maxFeeMsat"`
+
+	// nil leaves the username unchanged, an empty string removes it
+	LightningAddressUsername *string `json:"lightningAddressUsername"`
 }
 
 type CreateAppRequest struct {
@@ -84,6 +208,53 @@ type CreateAppRequest struct {
 	ExpiresAt      string `json:"expiresAt"`
 	RequestMethods string `json:"requestMethods"`
 	ReturnTo       string `json:"returnTo"`
+
+	// a budget in a fiat currency like "EUR", in cents
+	BudgetCurrency string `json:"budgetCurrency"`
+	MaxAmountFiat  int64  `json:"maxAmountFiat"`
+
+	MaxFeeMsat *uint64 `json:"maxFeeMsat"`
+
+	LightningAddressUsername string `json:"lightningAddressUsername"`
+	// the app can only spend what was paid to its own invoices
+	Isolated bool `json:"isolated"`
+}
+
+// ConnectionRequest is a connection an app asked for itself, with the permissions it asked for
+type ConnectionRequest struct {
+	ID             uint       `json:"id"`
+	AppPubkey      string     `json:"appPubkey"`
+	Name           string     `json:"name"`
+	RequestMethods string     `json:"requestMethods"`
+	MaxAmount      int        `json:"maxAmount"`
+	BudgetRenewal  string     `json:"budgetRenewal"`
+	ExpiresAt      *time.Time `json:"expiresAt"`
+	Relay          string     `json:"relay"`
+	State          string     `json:"state"`
+	AppId          *uint      `json:"appId"`
+	RespondedAt    *time.Time `json:"respondedAt"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+type CreateConnectionRequestRequest struct {
+	Uri string `json:"uri"` // nostr+walletauth://<app pubkey>?event=<signed connection request event>
+}
+
+// ApproveConnectionRequestRequest holds the owner's changes to the requested permissions.
+// Empty or nil fields keep what the app asked for.
+type ApproveConnectionRequestRequest struct {
+	Name           string  `json:"name"`
+	RequestMethods string  `json:"requestMethods"`
+	MaxAmount      *int    `json:"maxAmount"`
+	BudgetRenewal  *string `json:"budgetRenewal"`
+	ExpiresAt      *string `json:"expiresAt"`
+
+	// a budget in a fiat currency like "EUR", in cents
+	BudgetCurrency string `json:"budgetCurrency"`
+	MaxAmountFiat  int64  `json:"maxAmountFiat"`
+
+	MaxFeeMsat *uint64 `json:"maxFeeMsat"`
+	Isolated   bool    `json:"isolated"`
 }
 
 type StartRequest struct {
@@ -193,6 +364,18 @@ type SendSpontaneousPaymentProbesResponse struct {
 	Error string `json:"error"`
 }
 
+// EstimateFeeRequest is either for an invoice or for a keysend payment of AmountMsat to NodeId
+type EstimateFeeRequest struct {
+	Invoice    string `json:"invoice"`
+	NodeId     string `json:"nodeId"`
+	AmountMsat uint64 `json:"amountMsat"`
+}
+
+type EstimateFeeResponse struct {
+	FeeMsat            *uint64 `json:"feeMsat"`
+	SuccessProbability float64 `json:"successProbability"`
+}
+
 const (
 	LogTypeNode = "node"
 	LogTypeApp  = "app"
@@ -206,6 +389,105 @@ type GetLogOutputResponse struct {
 	Log string `json:"logs"`
 }
 
+// SendPaymentRequest pays either an invoice, a node by keysend, a lightning address or a contact
+type SendPaymentRequest struct {
+	Invoice          string               `json:"invoice"`
+	Pubkey           string               `json:"pubkey"`
+	LightningAddress string               `json:"lightningAddress"`
+	Contact          string               `json:"contact"` // the name of the contact, paid at its lightning address, LNURL or node pubkey
+	Amount           int64                `json:"amount"`  // in millisats, required for keysend and lightning address payments
+	Comment          string               `json:"comment"`
+	TLVRecords       []lnclient.TLVRecord `json:"tlvRecords"`
+}
+
+type MakeInvoiceRequest struct {
+	Amount      int64  `json:"amount"` // in millisats
+	Description string `json:"description"`
+	Expiry      int64  `json:"expiry"` // in seconds
+}
+
+type ListTransactionsRequest struct {
+	From   uint64 `query:"from"`
+	Until  uint64 `query:"until"`
+	Limit  uint64 `query:"limit"`
+	Offset uint64 `query:"offset"`
+	Unpaid bool   `query:"unpaid"`
+	Type   string `query:"type"`
+}
+
+const (
+	// BIP-329 label types
+	LabelTypeTx     = "tx"
+	LabelTypeAddr   = "addr"
+	LabelTypePubkey = "pubkey"
+	LabelTypeInput  = "input"
+	LabelTypeOutput = "output"
+	LabelTypeXpub   = "xpub"
+	// not part of BIP-329, lightning payments by payment hash and channels by their ID
+	LabelTypePayment = "payment"
+	LabelTypeChannel = "channel"
+)
+
+// Label is a BIP-329 label
+type Label struct {
+	Type      string `json:"type"`
+	Ref       string `json:"ref"`
+	Label     string `json:"label"`
+	Origin    string `json:"origin,omitempty"`
+	Spendable *bool  `json:"spendable,omitempty"`
+}
+
+// Contact is a saved payee, its destinations are normalized to lower case
+type Contact struct {
+	ID               uint      `json:"id"`
+	Name             string    `json:"name"`
+	LightningAddress string    `json:"lightningAddress,omitempty"`
+	Lnurl            string    `json:"lnurl,omitempty"`
+	NodePubkey       string    `json:"nodePubkey,omitempty"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+// ContactRequest creates or replaces a contact, at least one destination is required
+type ContactRequest struct {
+	Name             string `json:"name"`
+	LightningAddress string `json:"lightningAddress"`
+	Lnurl            string `json:"lnurl"`
+	NodePubkey       string `json:"nodePubkey"`
+}
+
+type ImportLabelsResponse struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+type Channel struct {
+	lnclient.Channel
+	Label string `json:"label,omitempty"`
+}
+
+const (
+	ExportFormatCSV  = "csv"
+	ExportFormatJSON = "json"
+)
+
+type ExportTransactionsRequest struct {
+	Format string `query:"format"`
+	// unix timestamps the transactions were settled between, unbounded if zero
+	From  int64 `query:"from"`
+	Until int64 `query:"until"`
+}
+
+type ExportedTransaction struct {
+	AppName     string     `json:"appName"`
+	Type        string     `json:"type"`
+	Amount      int64      `json:"amount"`   // in millisats
+	FeesPaid    int64      `json:"feesPaid"` // in millisats
+	Description string     `json:"description"`
+	PaymentHash string     `json:"paymentHash"`
+	SettledAt   *time.Time `json:"settledAt"`
+}
+
 type SignMessageRequest struct {
 	Message string `json:"message"`
 }
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+)
+
+func (api *api) SendPayment(ctx context.Context, sendPaymentRequest *SendPaymentRequest) (*nip47.PayResponse, error) {
+	var contactLnurl string
+	if sendPaymentRequest.Contact != "" {
+		contact, err := api.findContactByName(sendPaymentRequest.Contact)
+		if err != nil {
+			return nil, err
+		}
+		resolved := *sendPaymentRequest
+		resolved.Invoice = ""
+		resolved.LightningAddress = contact.LightningAddress
+		resolved.Pubkey = ""
+		if contact.LightningAddress == "" {
+			contactLnurl = contact.Lnurl
+			if contactLnurl == "" {
+				resolved.Pubkey = contact.NodePubkey
+			}
+		}
+		sendPaymentRequest = &resolved
+	}
+
+	var method string
+	var params interface{}
+	switch {
+	case contactLnurl != "":
+		method = nip47.PAY_LNURL_METHOD
+		params = &nip47.PayLnurlParams{
+			Lnurl:   contactLnurl,
+			Amount:  sendPaymentRequest.Amount,
+			Comment: sendPaymentRequest.Comment,
+		}
+	case sendPaymentRequest.Invoice != "":
+		method = nip47.PAY_INVOICE_METHOD
+		params = &nip47.PayParams{
+			Invoice: sendPaymentRequest.Invoice,
+		}
+	case sendPaymentRequest.Pubkey != "":
+		method = nip47.PAY_KEYSEND_METHOD
+		params = &nip47.KeysendParams{
+			Amount:     sendPaymentRequest.Amount,
+			Pubkey:     sendPaymentRequest.Pubkey,
+			TLVRecords: sendPaymentRequest.TLVRecords,
+		}
+	case sendPaymentRequest.LightningAddress != "":
+		method = nip47.PAY_LIGHTNING_ADDRESS_METHOD
+		params = &nip47.PayLnurlParams{
+			LightningAddress: sendPaymentRequest.LightningAddress,
+			Amount:           sendPaymentRequest.Amount,
+			Comment:          sendPaymentRequest.Comment,
+		}
+	default:
+		return nil, errors.New("an invoice, pubkey, lightning address or contact is required")
+	}
+
+	payResponse := &nip47.PayResponse{}
+	err := api.ownerRequest(ctx, method, params, payResponse)
+	if err != nil {
+		return nil, err
+	}
+	return payResponse, nil
+}
+
+func (api *api) MakeInvoice(ctx context.Context, makeInvoiceRequest *MakeInvoiceRequest) (*nip47.Transaction, error) {
+	makeInvoiceResponse := &nip47.MakeInvoiceResponse{}
+	err := api.ownerRequest(ctx, nip47.MAKE_INVOICE_METHOD, &nip47.MakeInvoiceParams{
+		Amount:      makeInvoiceRequest.Amount,
+		Description: makeInvoiceRequest.Description,
+		Expiry:      makeInvoiceRequest.Expiry,
+	}, makeInvoiceResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &makeInvoiceResponse.Transaction, nil
+}
+
+func (api *api) LookupInvoice(ctx context.Context, paymentHash string) (*nip47.Transaction, error) {
+	lookupInvoiceResponse := &nip47.LookupInvoiceResponse{}
+	err := api.ownerRequest(ctx, nip47.LOOKUP_INVOICE_METHOD, &nip47.LookupInvoiceParams{
+		PaymentHash: paymentHash,
+	}, lookupInvoiceResponse)
+	if err != nil {
+		return nil, err
+	}
+	return &lookupInvoiceResponse.Transaction, nil
+}
+
+func (api *api) ListTransactions(ctx context.Context, listTransactionsRequest *ListTransactionsRequest) ([]nip47.Transaction, error) {
+	listTransactionsResponse := &nip47.ListTransactionsResponse{}
+	err := api.ownerRequest(ctx, nip47.LIST_TRANSACTIONS_METHOD, &nip47.ListTransactionsParams{
+		From:   listTransactionsRequest.From,
+		Until:  listTransactionsRequest.Until,
+		Limit:  listTransactionsRequest.Limit,
+		Offset: listTransactionsRequest.Offset,
+		Unpaid: listTransactionsRequest.Unpaid,
+		Type:   listTransactionsRequest.Type,
+	}, listTransactionsResponse)
+	if err != nil {
+		return nil, err
+	}
+	return listTransactionsResponse.Transactions, nil
+}
+
+// ownerRequest makes a NIP-47 request as the owner of the hub and decodes its result into result
+func (api *api) ownerRequest(ctx context.Context, method string, params interface{}, result interface{}) error {
+	paramsJson, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	response, err := api.svc.HandleOwnerRequest(ctx, &nip47.Request{
+		Method: method,
+		Params: paramsJson,
+	})
+	if err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%s: %s", response.Error.Code, response.Error.Message)
+	}
+
+	// the handlers return different types for their result, so it is decoded like a response of a wallet service
+	resultJson, err := json.Marshal(response.Result)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(resultJson, result)
+}
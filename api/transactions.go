@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+)
+
+// how many transactions are loaded at once while exporting
+const exportBatchSize = 100
+
+// ExportTransactions writes the settled transactions of the LN backend to w, newest first,
+// with the name of the app which made the payment or created the invoice.
+func (api *api) ExportTransactions(ctx context.Context, exportRequest *ExportTransactionsRequest, w io.Writer) error {
+	lnClient := api.svc.GetLNClient()
+	if lnClient == nil {
+		return errors.New("LNClient not started")
+	}
+
+	var writeTransaction func(transaction *ExportedTransaction) error
+	var finish func() error
+
+	switch exportRequest.Format {
+	case ExportFormatCSV:
+		csvWriter := csv.NewWriter(w)
+		err := csvWriter.Write([]string{"app_name", "type", "amount_msat", "fees_paid_msat", "description", "payment_hash", "settled_at"})
+		if err != nil {
+			return err
+		}
+		writeTransaction = func(transaction *ExportedTransaction) error {
+			settledAt := ""
+			if transaction.SettledAt != nil {
+				settledAt = transaction.SettledAt.UTC().Format(time.RFC3339)
+			}
+			return csvWriter.Write([]string{
+				transaction.AppName,
+				transaction.Type,
+				strconv.FormatInt(transaction.Amount, 10),
+				strconv.FormatInt(transaction.FeesPaid, 10),
+				transaction.Description,
+				transaction.PaymentHash,
+				settledAt,
+			})
+		}
+		finish = func() error {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+	case ExportFormatJSON:
+		// written as a JSON array one element at a time, to not hold the whole history in memory
+		encoder := json.NewEncoder(w)
+		separator := "["
+		writeTransaction = func(transaction *ExportedTransaction) error {
+			if _, err := io.WriteString(w, separator); err != nil {
+				return err
+			}
+			separator = ","
+			return encoder.Encode(transaction)
+		}
+		finish = func() error {
+			if separator == "[" {
+				_, err := io.WriteString(w, "[]\n")
+				return err
+			}
+			_, err := io.WriteString(w, "]\n")
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported export format: %s", exportRequest.Format)
+	}
+
+	// backends filter by creation rather than settlement time and apply limit and offset differently,
+	// so every page is read and paging stops once a page has nothing new
+	seen := map[string]bool{}
+	for offset := uint64(0); ; offset += exportBatchSize {
+		transactions, err := lnClient.ListTransactions(ctx, 0, 0, exportBatchSize, offset, false, "")
+		if err != nil {
+			return err
+		}
+
+		found := false
+		settledTransactions := []nip47.Transaction{}
+		for _, transaction := range transactions {
+			key := transaction.Type + ":" + transaction.PaymentHash
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			found = true
+
+			if transaction.SettledAt == nil ||
+				(exportRequest.From != 0 && *transaction.SettledAt < exportRequest.From) ||
+				(exportRequest.Until != 0 && *transaction.SettledAt > exportRequest.Until) {
+				continue
+			}
+			settledTransactions = append(settledTransactions, transaction)
+		}
+		if !found {
+			break
+		}
+
+		appNames, err := api.transactionAppNames(settledTransactions)
+		if err != nil {
+			return err
+		}
+		for _, transaction := range settledTransactions {
+			settledAt := time.Unix(*transaction.SettledAt, 0)
+			err = writeTransaction(&ExportedTransaction{
+				AppName:     appNames[transaction.Type+":"+transaction.PaymentHash],
+				Type:        transaction.Type,
+				Amount:      transaction.Amount,
+				FeesPaid:    transaction.FeesPaid,
+				Description: transaction.Description,
+				PaymentHash: transaction.PaymentHash,
+				SettledAt:   &settledAt,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return finish()
+}
+
+// transactionAppNames returns the names of the apps which made the payments or created the invoices,
+// keyed by transaction type and payment hash
+func (api *api) transactionAppNames(transactions []nip47.Transaction) (map[string]string, error) {
+	appNames := map[string]string{}
+	paymentHashes := map[string][]string{}
+	for _, transaction := range transactions {
+		paymentHashes[transaction.Type] = append(paymentHashes[transaction.Type], transaction.PaymentHash)
+	}
+
+	if len(paymentHashes["outgoing"]) > 0 {
+		payments := []db.Payment{}
+		err := api.db.Preload("App").Where("payment_hash IN ? AND state = ?", paymentHashes["outgoing"], db.PAYMENT_STATE_SUCCEEDED).Find(&payments).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, payment := range payments {
+			appNames["outgoing:"+payment.PaymentHash] = payment.App.Name
+		}
+	}
+	if len(paymentHashes["incoming"]) > 0 {
+		invoices := []db.Invoice{}
+		err := api.db.Preload("App").Where("payment_hash IN ?", paymentHashes["incoming"]).Find(&invoices).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, invoice := range invoices {
+			appNames["incoming:"+invoice.PaymentHash] = invoice.App.Name
+		}
+	}
+	return appNames, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// anyone can send connection requests, so only this many are kept waiting for the owner
+const maxPendingConnectionRequests = 20
+
+// AddConnectionRequest stores the signed connection request of an app until the owner approves or rejects it.
+// An earlier pending request of the same app is replaced, expired requests are removed and when too many
+// requests are pending the oldest makes room, so nobody can keep new requests out by filling all the slots.
+func (svc *Service) AddConnectionRequest(event *nostr.Event) (*db.ConnectionRequest, error) {
+	request, err := nip47.ParseConnectionRequestEvent(event)
+	if err != nil {
+		return nil, err
+	}
+
+	var existingAppCount int64
+	err = svc.db.Model(&db.App{}).Where("nostr_pubkey = ?", event.PubKey).Count(&existingAppCount).Error
+	if err != nil {
+		return nil, err
+	}
+	if existingAppCount > 0 {
+		return nil, errors.New("the app is already connected")
+	}
+
+	connectionRequest := db.ConnectionRequest{
+		NostrId:        event.ID,
+		AppPubkey:      event.PubKey,
+		Name:           request.Name,
+		RequestMethods: strings.Join(request.RequestMethods, " "),
+		MaxAmount:      request.MaxAmount,
+		BudgetRenewal:  request.BudgetRenewal,
+		Relay:          request.Relay,
+		Secret:         request.Secret,
+		State:          db.CONNECTION_REQUEST_STATE_PENDING,
+	}
+	if request.ExpiresAt != 0 {
+		expiresAt := time.Unix(request.ExpiresAt, 0)
+		connectionRequest.ExpiresAt = &expiresAt
+	}
+
+	err = svc.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("state = ? AND (app_pubkey = ? OR created_at < ?)", db.CONNECTION_REQUEST_STATE_PENDING, event.PubKey, time.Now().Add(-db.CONNECTION_REQUEST_EXPIRY)).
+			Delete(&db.ConnectionRequest{}).Error
+		if err != nil {
+			return err
+		}
+		var pendingCount int64
+		err = tx.Model(&db.ConnectionRequest{}).Where("state = ?", db.CONNECTION_REQUEST_STATE_PENDING).Count(&pendingCount).Error
+		if err != nil {
+			return err
+		}
+		if pendingCount >= maxPendingConnectionRequests {
+			oldestIds := []uint{}
+			err = tx.Model(&db.ConnectionRequest{}).Where("state = ?", db.CONNECTION_REQUEST_STATE_PENDING).
+				Order("created_at, id").Limit(int(pendingCount-maxPendingConnectionRequests+1)).Pluck("id", &oldestIds).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Delete(&db.ConnectionRequest{}, oldestIds).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Create(&connectionRequest).Error
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, errors.New("the connection request was already received")
+		}
+		return nil, err
+	}
+	return &connectionRequest, nil
+}
+
+// HandleConnectionRequestEvent stores a connection request the app published on the hub's relay
+func (svc *Service) HandleConnectionRequestEvent(event *nostr.Event) {
+	connectionRequest, err := svc.AddConnectionRequest(event)
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": event.ID,
+			"appPubkey":           event.PubKey,
+		}).Infof("Failed to add connection request: %v", err)
+		return
+	}
+	svc.logger.WithFields(logrus.Fields{
+		"requestEventNostrId": event.ID,
+		"appPubkey":           event.PubKey,
+		"connectionRequestId": connectionRequest.ID,
+	}).Info("Received connection request")
+}
+
+// PublishConnectionResponse answers an approved or rejected connection request on the relay the app asked for.
+// The answer is encrypted to the app's key and tagged with it, so only the app can read it.
+func (svc *Service) PublishConnectionResponse(ctx context.Context, connectionRequest *db.ConnectionRequest) error {
+	response := nip47.ConnectionResponse{
+		Secret: connectionRequest.Secret,
+	}
+	switch connectionRequest.State {
+	case db.CONNECTION_REQUEST_STATE_APPROVED:
+		if connectionRequest.AppId == nil {
+			return errors.New("approved connection request has no app")
+		}
+		app := db.App{}
+		err := svc.db.First(&app, *connectionRequest.AppId).Error
+		if err != nil {
+			return err
+		}
+		response.Pubkey = svc.cfg.GetNostrPublicKey()
+		response.Relay = svc.cfg.GetRelayUrl()
+		response.RequestMethods = svc.GetMethods(&app)
+	case db.CONNECTION_REQUEST_STATE_REJECTED:
+		response.Error = &nip47.Error{
+			Code:    nip47.ERROR_RESTRICTED,
+			Message: "The connection request was rejected",
+		}
+	default:
+		return fmt.Errorf("connection request is still %s", connectionRequest.State)
+	}
+
+	event, err := svc.createConnectionResponse(connectionRequest, &response)
+	if err != nil {
+		return err
+	}
+	err = svc.publishToRelay(ctx, connectionRequest.Relay, *event)
+	if err != nil {
+		return fmt.Errorf("failed to publish connection response: %w", err)
+	}
+
+	now := time.Now()
+	connectionRequest.RespondedAt = &now
+	return svc.db.Model(connectionRequest).Update("responded_at", now).Error
+}
+
+func (svc *Service) createConnectionResponse(connectionRequest *db.ConnectionRequest, response *nip47.ConnectionResponse) (*nostr.Event, error) {
+	payloadBytes, err := json.Marshal(response)
+	if err != nil {
+		return nil, err
+	}
+	ss, err := nip04.ComputeSharedSecret(connectionRequest.AppPubkey, svc.cfg.GetNostrSecretKey())
+	if err != nil {
+		return nil, err
+	}
+	content, err := nip04.Encrypt(string(payloadBytes), ss)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &nostr.Event{
+		PubKey:    svc.cfg.GetNostrPublicKey(),
+		CreatedAt: nostr.Now(),
+		Kind:      nip47.CONNECTION_RESPONSE_KIND,
+		Tags: nostr.Tags{
+			[]string{"d", connectionRequest.AppPubkey},
+			[]string{"p", connectionRequest.AppPubkey},
+			[]string{"e", connectionRequest.NostrId},
+		},
+		Content: content,
+	}
+	err = event.Sign(svc.cfg.GetNostrSecretKey())
+	if err != nil {
+		return nil, err
+	}
+	return event, nil
+}
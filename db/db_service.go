@@ -24,7 +24,7 @@ func NewDBService(db *gorm.DB, logger *logrus.Logger) *dbService {
 	}
 }
 
-func (dbSvc *dbService) CreateApp(name string, pubkey string, maxAmount int, budgetRenewal string, expiresAt *time.Time, requestMethods []string) (*App, string, error) {
+func (dbSvc *dbService) CreateApp(name string, pubkey string, maxAmount int, budgetRenewal string, budgetCurrency string, maxAmountFiat int64, maxFeeMsat *uint64, expiresAt *time.Time, requestMethods []string, lightningAddressUsername string, isolated bool) (*App, string, error) {
 	var pairingPublicKey string
 	var pairingSecretKey string
 	if pubkey == "" {
@@ -40,7 +40,10 @@ func (dbSvc *dbService) CreateApp(name string, pubkey string, maxAmount int, bud
 		}
 	}
 
-	app := App{Name: name, NostrPubkey: pairingPublicKey}
+	app := App{Name: name, NostrPubkey: pairingPublicKey, Isolated: isolated}
+	if lightningAddressUsername != "" {
+		app.LightningAddressUsername = &lightningAddressUsername
+	}
 
 	err := dbSvc.db.Transaction(func(tx *gorm.DB) error {
 		err := tx.Save(&app).Error
@@ -50,7 +53,7 @@ func (dbSvc *dbService) CreateApp(name string, pubkey string, maxAmount int, bud
 
 		for _, m := range requestMethods {
 			//if we don't know this method, we return an error
-			if !strings.Contains(nip47.CAPABILITIES, m) {
+			if !strings.Contains(nip47.CAPABILITIES, m) && m != nip47.ALL_TRANSACTIONS_PERMISSION && m != nip47.KEYSEND_RECEIPTS_PERMISSION {
 				return fmt.Errorf("did not recognize request method: %s", m)
 			}
 			appPermission := AppPermission{
@@ -58,8 +61,11 @@ func (dbSvc *dbService) CreateApp(name string, pubkey string, maxAmount int, bud
 				RequestMethod: m,
 				ExpiresAt:     expiresAt,
 				//these fields are only relevant for pay_invoice
-				MaxAmount:     maxAmount,
-				BudgetRenewal: budgetRenewal,
+				MaxAmount:      maxAmount,
+				BudgetRenewal:  budgetRenewal,
+				BudgetCurrency: budgetCurrency,
+				MaxAmountFiat:  maxAmountFiat,
+				MaxFeeMsat:     maxFeeMsat,
 			}
 			err = tx.Create(&appPermission).Error
 			if err != nil {
@@ -12,12 +12,14 @@ type UserConfig struct {
 }
 
 type App struct {
-	ID          uint
-	Name        string `validate:"required"`
-	Description string
-	NostrPubkey string `validate:"required"`
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID                       uint
+	Name                     string `validate:"required"`
+	Description              string
+	NostrPubkey              string `validate:"required"`
+	LightningAddressUsername *string
+	Isolated                 bool // spends from its own ledger balance instead of the node's balance
+	CreatedAt                time.Time
+	UpdatedAt                time.Time
 }
 
 type AppPermission struct {
@@ -27,9 +29,14 @@ type AppPermission struct {
 	RequestMethod string `validate:"required"`
 	MaxAmount     int
 	BudgetRenewal string
-	ExpiresAt     *time.Time
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
+	MaxFeeMsat    *uint64 // default routing fee limit, only relevant for pay_invoice
+	// a budget of MaxAmountFiat cents of BudgetCurrency, converted at the current rate when paying.
+	// Applies in addition to MaxAmount, only relevant for pay_invoice
+	BudgetCurrency string
+	MaxAmountFiat  int64
+	ExpiresAt      *time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
 }
 
 type RequestEvent struct {
@@ -60,22 +67,161 @@ type Payment struct {
 	App            App
 	RequestEventId uint `validate:"required"`
 	RequestEvent   RequestEvent
-	Amount         uint // in sats
+	AmountMsat     uint64
 	PaymentRequest string
+	PaymentHash    string
 	Preimage       *string
+	State          string
+	FailureReason  string
+	SettledAt      *time.Time
+	FailedAt       *time.Time
+	SubscriptionId *uint
+	Destination    string // the lightning address, LNURL or node pubkey which was paid, as normalized by lnurl.Normalize
+	FiatCurrency   string
+	FiatRate       *float64 // the price of one bitcoin in FiatCurrency when the payment settled
+	FeeMsat        *uint64  // the routing fee, nil when the backend does not report it
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Invoice is an invoice created on behalf of an app, through make_invoice or its lightning address
+type Invoice struct {
+	ID             uint
+	AppId          uint `validate:"required"`
+	App            App
+	PaymentHash    string `validate:"required"`
+	PaymentRequest string
+	Amount         uint // in sats
+	Comment        string
+	Hold           bool
+	SettledAt      *time.Time // set once paid, either over the network or by another app on this hub
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// Transaction is an incoming or outgoing payment of the node, kept in sync with the LN backend
+// so that transactions can be listed the same way on every backend
+type Transaction struct {
+	ID              uint
+	AppId           *uint // the app which created the invoice or made the payment, if any
+	App             *App
+	Type            string
+	State           string
+	PaymentRequest  string
+	PaymentHash     string
+	Preimage        string
+	Description     string
+	DescriptionHash string
+	Amount          int64 // in millisats
+	FeesPaid        int64 // in millisats
+	Metadata        string
+	ExpiresAt       *time.Time
+	SettledAt       *time.Time
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// Label is a BIP-329 label of a transaction, address or output, a lightning payment or a channel
+type Label struct {
+	ID        uint
+	Type      string `validate:"required"`
+	Ref       string `validate:"required"`
+	Label     string
+	Origin    string
+	Spendable *bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Contact is a payee saved by the user, identified by any of its lightning address, LNURL and node pubkey
+type Contact struct {
+	ID               uint
+	Name             string `validate:"required"`
+	LightningAddress string
+	Lnurl            string
+	NodePubkey       string
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// Zap is a NIP-57 zap request an invoice was created for
+type Zap struct {
+	ID          uint
+	AppId       uint `validate:"required"`
+	App         App
+	PaymentHash string `validate:"required"`
+	ZapRequest  string `validate:"required"`
+	ReceiptId   *string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Subscription is a payment the hub makes on behalf of an app on a schedule
+type Subscription struct {
+	ID               uint
+	AppId            uint `validate:"required"`
+	App              App
+	RequestEventId   uint `validate:"required"` // the create_subscription request the payments are made for
+	RequestEvent     RequestEvent
+	Pubkey           string
+	LightningAddress string
+	Amount           int64 // in msat
+	Interval         string
+	Description      string
+	NextRunAt        time.Time
+	LastRunAt        *time.Time
+	CancelledAt      *time.Time
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+// ConnectionRequest is a connection an app asked for itself (Nostr Wallet Auth), waiting for the owner to approve it
+type ConnectionRequest struct {
+	ID             uint
+	NostrId        string `validate:"required"` // of the signed request event
+	AppPubkey      string `validate:"required"`
+	Name           string
+	RequestMethods string // space separated, as in the API
+	MaxAmount      int
+	BudgetRenewal  string
+	ExpiresAt      *time.Time
+	Relay          string
+	Secret         string
+	State          string
+	AppId          *uint // the app created when the request was approved
+	App            *App
+	RespondedAt    *time.Time
 	CreatedAt      time.Time
 	UpdatedAt      time.Time
 }
 
 type DBService interface {
-	CreateApp(name string, pubkey string, maxAmount int, budgetRenewal string, expiresAt *time.Time, requestMethods []string) (*App, string, error)
+	CreateApp(name string, pubkey string, maxAmount int, budgetRenewal string, budgetCurrency string, maxAmountFiat int64, maxFeeMsat *uint64, expiresAt *time.Time, requestMethods []string, lightningAddressUsername string, isolated bool) (*App, string, error)
 }
 
+// payments and invoices made through the hub's own API are recorded under the app with this pubkey.
+// It is not a valid public key, so no connection can send requests as the owner.
+const OWNER_APP_NOSTR_PUBKEY = "owner"
+
 const (
 	REQUEST_EVENT_STATE_HANDLER_EXECUTING = "executing"
 	REQUEST_EVENT_STATE_HANDLER_EXECUTED  = "executed"
 	REQUEST_EVENT_STATE_HANDLER_ERROR     = "error"
 )
+const (
+	PAYMENT_STATE_PENDING   = "pending"
+	PAYMENT_STATE_SUCCEEDED = "succeeded"
+	PAYMENT_STATE_FAILED    = "failed"
+)
+const (
+	CONNECTION_REQUEST_STATE_PENDING  = "pending"
+	CONNECTION_REQUEST_STATE_APPROVED = "approved"
+	CONNECTION_REQUEST_STATE_REJECTED = "rejected"
+)
+
+// connection requests which are not answered in time expire, so they do not pile up
+const CONNECTION_REQUEST_EXPIRY = 24 * time.Hour
+
 const (
 	RESPONSE_EVENT_STATE_PUBLISH_CONFIRMED   = "confirmed"
 	RESPONSE_EVENT_STATE_PUBLISH_FAILED      = "failed"
@@ -22,6 +22,30 @@ type PaymentReceivedEventProperties struct {
 	PaymentHash string `json:"payment_hash"`
 	Amount      uint64 `json:"amount"`
 	NodeType    string `json:"node_type"`
+	// paid by another app on this hub without a payment over the network
+	Internal bool `json:"internal,omitempty"`
+}
+
+type PaymentSucceededEventProperties struct {
+	Bolt11      string `json:"bolt11"`
+	Amount      uint64 `json:"amount"`
+	AppId       uint   `json:"app_id"`
+	PaymentHash string `json:"payment_hash"`
+}
+
+type PaymentFailedEventProperties struct {
+	Invoice     string `json:"invoice"`
+	Amount      uint64 `json:"amount"`
+	AppId       uint   `json:"app_id"`
+	PaymentHash string `json:"payment_hash"`
+	// only passed on to the app, the error may contain sensitive information
+	Reason string `json:"-"`
+}
+
+type HoldInvoiceAcceptedEventProperties struct {
+	PaymentHash string `json:"payment_hash"`
+	Amount      uint64 `json:"amount"`
+	NodeType    string `json:"node_type"`
 }
 
 type ChannelBackupEvent struct {
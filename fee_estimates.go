@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/lnclient"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+)
+
+// estimates may send probes, so they are not repeated for the same payment within this period
+const feeEstimateCacheDuration = 30 * time.Second
+
+type cachedFeeEstimate struct {
+	// mu is held while the estimate is made, so only one estimate per payment is in flight
+	mu        sync.Mutex
+	estimate  *lnclient.FeeEstimate
+	expiresAt time.Time
+	// users is the number of requests waiting for or making the estimate, guarded by feeEstimates.mu
+	users int
+}
+
+type feeEstimates struct {
+	mu        sync.Mutex
+	estimates map[string]*cachedFeeEstimate
+}
+
+func validateEstimateFeeRequest(request *lnclient.EstimateFeeRequest) error {
+	if request.Invoice == "" && (request.Destination == "" || request.AmountMsat == 0) {
+		return errors.New("either an invoice or a destination and amount are required")
+	}
+	if request.Invoice != "" && request.AmountMsat == 0 {
+		paymentRequest, err := decodepay.Decodepay(strings.ToLower(request.Invoice))
+		if err != nil {
+			return fmt.Errorf("failed to decode invoice: %w", err)
+		}
+		if paymentRequest.MSatoshi == 0 {
+			return errors.New("an amount is required to estimate the fee of an invoice without amount")
+		}
+	}
+	return nil
+}
+
+// EstimateFee estimates the routing fee of a payment, estimates are cached briefly.
+// Estimates for the same payment are made one at a time so its probes are not sent in parallel,
+// estimates for different payments do not wait for each other.
+func (svc *Service) EstimateFee(ctx context.Context, request *lnclient.EstimateFeeRequest) (*lnclient.FeeEstimate, error) {
+	if svc.lnClient == nil {
+		return nil, errors.New("LNClient not started")
+	}
+	err := validateEstimateFeeRequest(request)
+	if err != nil {
+		return nil, err
+	}
+
+	key := fmt.Sprintf("%s:%s:%d", strings.ToLower(request.Invoice), request.Destination, request.AmountMsat)
+	cached := svc.feeEstimates.acquire(key)
+	defer svc.feeEstimates.release(cached)
+
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+	if cached.estimate != nil && time.Now().Before(cached.expiresAt) {
+		return cached.estimate, nil
+	}
+
+	estimate, err := svc.lnClient.EstimateFee(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	cached.estimate = estimate
+	cached.expiresAt = time.Now().Add(feeEstimateCacheDuration)
+	return estimate, nil
+}
+
+// acquire returns the cache entry of the key and removes expired entries nobody is using
+func (feeEstimates *feeEstimates) acquire(key string) *cachedFeeEstimate {
+	feeEstimates.mu.Lock()
+	defer feeEstimates.mu.Unlock()
+	if feeEstimates.estimates == nil {
+		feeEstimates.estimates = map[string]*cachedFeeEstimate{}
+	}
+
+	now := time.Now()
+	for cachedKey, cached := range feeEstimates.estimates {
+		if cached.users == 0 && !now.Before(cached.expiresAt) {
+			delete(feeEstimates.estimates, cachedKey)
+		}
+	}
+
+	cached, ok := feeEstimates.estimates[key]
+	if !ok {
+		cached = &cachedFeeEstimate{}
+		feeEstimates.estimates[key] = cached
+	}
+	cached.users++
+	return cached
+}
+
+func (feeEstimates *feeEstimates) release(cached *cachedFeeEstimate) {
+	feeEstimates.mu.Lock()
+	defer feeEstimates.mu.Unlock()
+	cached.users--
+}
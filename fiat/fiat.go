@@ -0,0 +1,119 @@
+package fiat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const msatPerBtc = 100_000_000_000
+
+var currencyRegex = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// ValidateCurrency checks the currency is an upper case ISO 4217 code like "EUR"
+func ValidateCurrency(currency string) error {
+	if !currencyRegex.MatchString(currency) {
+		return fmt.Errorf("invalid currency: %s", currency)
+	}
+	return nil
+}
+
+// MsatToFiat converts an amount in msat to the currency of the rate, rounded to cents
+func MsatToFiat(amountMsat int64, rate *Rate) float64 {
+	return math.Round(float64(amountMsat)/msatPerBtc*rate.BtcPrice*100) / 100
+}
+
+// CentsToMsat converts an amount in cents of the currency of the rate to msat, rounded down
+func CentsToMsat(amountCents int64, rate *Rate) int64 {
+	return int64(float64(amountCents) / 100 / rate.BtcPrice * msatPerBtc)
+}
+
+type httpRateProvider struct {
+	httpClient *http.Client
+	baseUrl    string
+}
+
+// NewHttpRateProvider fetches rates from baseUrl/<currency>.json, in the format of https://getalby.com/api/rates
+func NewHttpRateProvider(httpClient *http.Client, baseUrl string) *httpRateProvider {
+	return &httpRateProvider{
+		httpClient: httpClient,
+		baseUrl:    strings.TrimSuffix(baseUrl, "/"),
+	}
+}
+
+func (provider *httpRateProvider) GetRate(ctx context.Context, currency string) (*Rate, error) {
+	err := ValidateCurrency(currency)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/%s.json", provider.baseUrl, strings.ToLower(currency)), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := provider.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("failed to fetch %s rate: %d %s", currency, res.StatusCode, string(body))
+	}
+
+	ratesResponse := ratesApiResponse{}
+	err = json.NewDecoder(res.Body).Decode(&ratesResponse)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s rate: %w", currency, err)
+	}
+	if ratesResponse.RateFloat <= 0 {
+		return nil, fmt.Errorf("invalid %s rate: %v", currency, ratesResponse.RateFloat)
+	}
+
+	return &Rate{
+		Currency:  currency,
+		BtcPrice:  ratesResponse.RateFloat,
+		FetchedAt: time.Now(),
+	}, nil
+}
+
+type cachedRateProvider struct {
+	provider      RateProvider
+	cacheDuration time.Duration
+	mu            sync.Mutex
+	rates         map[string]*Rate
+}
+
+// NewCachedRateProvider returns the rates of provider, fetching each currency at most once per cacheDuration
+func NewCachedRateProvider(provider RateProvider, cacheDuration time.Duration) *cachedRateProvider {
+	return &cachedRateProvider{
+		provider:      provider,
+		cacheDuration: cacheDuration,
+		rates:         map[string]*Rate{},
+	}
+}
+
+func (cache *cachedRateProvider) GetRate(ctx context.Context, currency string) (*Rate, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	rate, ok := cache.rates[currency]
+	if ok && time.Since(rate.FetchedAt) < cache.cacheDuration {
+		return rate, nil
+	}
+
+	rate, err := cache.provider.GetRate(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+	cache.rates[currency] = rate
+	return rate, nil
+}
@@ -0,0 +1,24 @@
+package fiat
+
+import (
+	"context"
+	"time"
+)
+
+// Rate is the price of one bitcoin in a fiat currency
+type Rate struct {
+	Currency  string
+	BtcPrice  float64
+	FetchedAt time.Time
+}
+
+type RateProvider interface {
+	// GetRate returns the current rate of the currency, given as an ISO 4217 code like "USD"
+	GetRate(ctx context.Context, currency string) (*Rate, error)
+}
+
+// the response of the rates API, e.g. https://getalby.com/api/rates/usd.json
+type ratesApiResponse struct {
+	Code      string  `json:"code"`
+	RateFloat float64 `json:"rate_float"`
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/fiat"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+)
+
+// rates are refetched at most this often, budgets are checked against rates up to this old
+const fiatRateCacheDuration = 5 * time.Minute
+
+func (svc *Service) getFiatRate(currency string) (*fiat.Rate, error) {
+	if svc.rateProvider == nil {
+		return nil, errors.New("no fiat rate provider configured")
+	}
+	return svc.rateProvider.GetRate(context.Background(), currency)
+}
+
+// fiatCurrency returns the currency amounts are shown in to the app: its budget currency or the one of the hub, if any
+func (svc *Service) fiatCurrency(app *db.App) string {
+	if app != nil {
+		var budgetCurrencies []string
+		svc.db.Model(&db.AppPermission{}).Where("app_id = ? AND request_method = ? AND budget_currency != ''", app.ID, nip47.PAY_INVOICE_METHOD).Limit(1).Pluck("budget_currency", &budgetCurrencies)
+		if len(budgetCurrencies) > 0 {
+			return budgetCurrencies[0]
+		}
+	}
+	return svc.cfg.GetEnv().FiatCurrency
+}
+
+// withFiatValues adds the amounts in currency to transactions which do not have one yet.
+// Fiat values are informational, so transactions are left as they are if the rate is unavailable.
+func (svc *Service) withFiatValues(transactions []nip47.Transaction, currency string) {
+	if currency == "" || len(transactions) == 0 {
+		return
+	}
+	rate, err := svc.getFiatRate(currency)
+	if err != nil {
+		svc.logger.WithField("currency", currency).WithError(err).Error("Failed to fetch fiat rate")
+		return
+	}
+	for i := range transactions {
+		if transactions[i].FiatAmount != nil {
+			continue
+		}
+		fiatAmount := fiat.MsatToFiat(transactions[i].Amount, rate)
+		transactions[i].FiatAmount = &fiatAmount
+		transactions[i].FiatCurrency = rate.Currency
+	}
+}
+
+// recordFiatRate sets the current rate of the app's currency on the payment, for accounting
+func (svc *Service) recordFiatRate(payment *db.Payment) {
+	currency := svc.fiatCurrency(&db.App{ID: payment.AppId})
+	if currency == "" {
+		return
+	}
+	rate, err := svc.getFiatRate(currency)
+	if err != nil {
+		svc.logger.WithField("currency", currency).WithError(err).Error("Failed to fetch fiat rate for payment")
+		return
+	}
+	btcPrice := rate.BtcPrice
+	payment.FiatCurrency = rate.Currency
+	payment.FiatRate = &btcPrice
+}
@@ -7,6 +7,8 @@ import {
   BudgetRenewalType,
   CreateAppResponse,
   PermissionType,
+  NIP_47_ALL_TRANSACTIONS_PERMISSION,
+  NIP_47_KEYSEND_RECEIPTS_PERMISSION,
   nip47PermissionDescriptions,
   validBudgetRenewals,
 } from "src/types";
@@ -51,7 +53,12 @@ const NewApp = () => {
   const parseRequestMethods = (reqParam: string): Set<PermissionType> => {
     const methods = reqParam
       ? reqParam.split(" ")
-      : Object.keys(nip47PermissionDescriptions);
+      : // apps only see their own transactions unless explicitly requested
+        Object.keys(nip47PermissionDescriptions).filter(
+          (method) =>
+            method !== NIP_47_ALL_TRANSACTIONS_PERMISSION &&
+            method !== NIP_47_KEYSEND_RECEIPTS_PERMISSION
+        );
     // Create a Set of PermissionType from the array
     const requestMethodsSet = new Set<PermissionType>(
       methods as PermissionType[]
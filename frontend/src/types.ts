@@ -1,6 +1,8 @@
 import {
   Bell,
   CirclePlus,
+  Eye,
+  Gauge,
   HandCoins,
   Info,
   LucideIcon,
@@ -8,6 +10,7 @@ import {
   PenLine,
   Search,
   WalletMinimal,
+  Zap,
 } from "lucide-react";
 
 export const NIP_47_PAY_INVOICE_METHOD = "pay_invoice";
@@ -17,8 +20,11 @@ export const NIP_47_MAKE_INVOICE_METHOD = "make_invoice";
 export const NIP_47_LOOKUP_INVOICE_METHOD = "lookup_invoice";
 export const NIP_47_LIST_TRANSACTIONS_METHOD = "list_transactions";
 export const NIP_47_SIGN_MESSAGE_METHOD = "sign_message";
+export const NIP_47_ESTIMATE_FEE_METHOD = "estimate_fee";
 
 export const NIP_47_NOTIFICATIONS_PERMISSION = "notifications";
+export const NIP_47_ALL_TRANSACTIONS_PERMISSION = "all_transactions";
+export const NIP_47_KEYSEND_RECEIPTS_PERMISSION = "keysend_receipts";
 
 export type BackendType =
   | "LND"
@@ -35,7 +41,8 @@ export type RequestMethodType =
   | "make_invoice"
   | "lookup_invoice"
   | "list_transactions"
-  | "sign_message";
+  | "sign_message"
+  | "estimate_fee";
 
 export type BudgetRenewalType =
   | "daily"
@@ -48,7 +55,9 @@ export type BudgetRenewalType =
 // TODO: move other permissions
 export type PermissionType =
   | RequestMethodType
-  | typeof NIP_47_NOTIFICATIONS_PERMISSION;
+  | typeof NIP_47_NOTIFICATIONS_PERMISSION
+  | typeof NIP_47_ALL_TRANSACTIONS_PERMISSION
+  | typeof NIP_47_KEYSEND_RECEIPTS_PERMISSION;
 
 export type IconMap = {
   [key in PermissionType]: LucideIcon;
@@ -62,7 +71,10 @@ export const iconMap: IconMap = {
   [NIP_47_MAKE_INVOICE_METHOD]: CirclePlus,
   [NIP_47_PAY_INVOICE_METHOD]: HandCoins,
   [NIP_47_SIGN_MESSAGE_METHOD]: PenLine,
+  [NIP_47_ESTIMATE_FEE_METHOD]: Gauge,
   [NIP_47_NOTIFICATIONS_PERMISSION]: Bell,
+  [NIP_47_ALL_TRANSACTIONS_PERMISSION]: Eye,
+  [NIP_47_KEYSEND_RECEIPTS_PERMISSION]: Zap,
 };
 
 export const validBudgetRenewals: BudgetRenewalType[] = [
@@ -81,12 +93,15 @@ export const nip47MethodDescriptions: Record<RequestMethodType, string> = {
   [NIP_47_MAKE_INVOICE_METHOD]: "Create invoices",
   [NIP_47_PAY_INVOICE_METHOD]: "Send payments",
   [NIP_47_SIGN_MESSAGE_METHOD]: "Sign messages",
+  [NIP_47_ESTIMATE_FEE_METHOD]: "Estimate payment fees",
 };
 
 // TODO: merge with nip47MethodDescriptions
 export const nip47PermissionDescriptions: Record<PermissionType, string> = {
   ...nip47MethodDescriptions,
   [NIP_47_NOTIFICATIONS_PERMISSION]: "Receive wallet notifications",
+  [NIP_47_ALL_TRANSACTIONS_PERMISSION]: "Read transactions of all apps",
+  [NIP_47_KEYSEND_RECEIPTS_PERMISSION]: "Read keysend payments received by your node",
 };
 
 export const expiryOptions: Record<string, number> = {
@@ -125,8 +140,93 @@ export interface App {
   maxAmount: number;
   budgetUsage: number;
   budgetRenewal: string;
+  maxAmountMsat: number;
+  budgetUsageMsat: number;
+  budgetCurrency: string;
+  maxAmountFiat: number; // in cents of budgetCurrency
 }
 
+export interface AppEvent {
+  id: number;
+  nostrId: string;
+  method: string;
+  state: string;
+  createdAt: string;
+  updatedAt: string;
+  amountMsat: number;
+  responses: {
+    nostrId: string;
+    state: string;
+    repliedAt: string;
+  }[];
+}
+
+export interface ListAppEventsResponse {
+  events: AppEvent[];
+  totalCount: number;
+}
+
+export interface AppStatsBucket {
+  start: string;
+  requestCount: number;
+  failedRequestCount: number;
+  requestFailureRate: number;
+  paymentCount: number;
+  failedPaymentCount: number;
+  paymentFailureRate: number;
+  spentMsat: number;
+}
+
+export interface AppStats {
+  period: "day" | "week";
+  totals: AppStatsBucket;
+  buckets: AppStatsBucket[];
+}
+
+export interface Contact {
+  id: number;
+  name: string;
+  lightningAddress?: string;
+  lnurl?: string;
+  nodePubkey?: string;
+  createdAt: string;
+  updatedAt: string;
+}
+
+export type ContactRequest = {
+  name: string;
+  lightningAddress?: string;
+  lnurl?: string;
+  nodePubkey?: string;
+};
+
+export interface ConnectionRequest {
+  id: number;
+  appPubkey: string;
+  name: string;
+  requestMethods: string;
+  maxAmount: number;
+  budgetRenewal: string;
+  expiresAt?: string;
+  relay: string;
+  state: "pending" | "approved" | "rejected";
+  appId?: number;
+  respondedAt?: string;
+  createdAt: string;
+}
+
+export type ApproveConnectionRequestRequest = {
+  name?: string;
+  requestMethods?: string;
+  maxAmount?: number;
+  budgetRenewal?: BudgetRenewalType;
+  expiresAt?: string;
+  budgetCurrency?: string;
+  maxAmountFiat?: number;
+  maxFeeMsat?: number;
+  isolated?: boolean;
+};
+
 export interface AppPermissions {
   // TODO: rename to permissions
   requestMethods: Set<PermissionType>;
@@ -172,6 +272,7 @@ export type Channel = {
   public: boolean;
   confirmations?: number;
   confirmationsRequired?: number;
+  label?: string;
 };
 
 export type Peer = {
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/fiat"
 	"github.com/getAlby/nostr-wallet-connect/nip47"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/sirupsen/logrus"
@@ -26,7 +27,13 @@ func (svc *Service) HandleGetBalanceEvent(ctx context.Context, nip47Request *nip
 		"appId":               app.ID,
 	}).Info("Fetching balance")
 
-	balance, err := svc.lnClient.GetBalance(ctx)
+	var balance int64
+	var err error
+	if app.Isolated {
+		balance = svc.GetIsolatedBalance(app)
+	} else {
+		balance, err = svc.lnClient.GetBalance(ctx)
+	}
 	if err != nil {
 		svc.logger.WithFields(logrus.Fields{
 			"requestEventNostrId": requestEvent.NostrId,
@@ -46,6 +53,20 @@ func (svc *Service) HandleGetBalanceEvent(ctx context.Context, nip47Request *nip
 		Balance: balance,
 	}
 
+	// the app's budget currency takes precedence, so the fiat balance and budget are in the same currency
+	var rate *fiat.Rate
+	if currency := svc.fiatCurrency(app); currency != "" {
+		rate, err = svc.getFiatRate(currency)
+		if err != nil {
+			svc.logger.WithField("currency", currency).WithError(err).Error("Failed to fetch fiat rate for balance")
+		}
+	}
+	if rate != nil {
+		fiatBalance := fiat.MsatToFiat(balance, rate)
+		responsePayload.FiatBalance = &fiatBalance
+		responsePayload.FiatCurrency = rate.Currency
+	}
+
 	appPermission := db.AppPermission{}
 	svc.db.Where("app_id = ? AND request_method = ?", app.ID, nip47.PAY_INVOICE_METHOD).First(&appPermission)
 
@@ -54,6 +75,18 @@ func (svc *Service) HandleGetBalanceEvent(ctx context.Context, nip47Request *nip
 		responsePayload.MaxAmount = maxAmount * MSAT_PER_SAT
 		responsePayload.BudgetRenewal = appPermission.BudgetRenewal
 	}
+	if appPermission.BudgetCurrency != "" && appPermission.MaxAmountFiat > 0 {
+		fiatMaxAmount := float64(appPermission.MaxAmountFiat) / 100
+		responsePayload.FiatMaxAmount = &fiatMaxAmount
+		responsePayload.BudgetRenewal = appPermission.BudgetRenewal
+		// clients unaware of fiat budgets get the lower of both budgets at the current rate
+		if rate != nil {
+			fiatMaxAmountMsat := int(fiat.CentsToMsat(appPermission.MaxAmountFiat, rate))
+			if maxAmount == 0 || fiatMaxAmountMsat < responsePayload.MaxAmount {
+				responsePayload.MaxAmount = fiatMaxAmountMsat
+			}
+		}
+	}
 
 	publishResponse(&nip47.Response{
 		ResultType: nip47Request.Method,
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sirupsen/logrus"
+)
+
+func (svc *Service) HandleCancelHoldInvoiceEvent(ctx context.Context, nip47Request *nip47.Request, requestEvent *db.RequestEvent, app *db.App, publishResponse func(*nip47.Response, nostr.Tags)) {
+
+	cancelHoldInvoiceParams := &nip47.CancelHoldInvoiceParams{}
+	resp := svc.decodeNip47Request(nip47Request, requestEvent, app, cancelHoldInvoiceParams)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	resp = svc.checkPermission(nip47Request, requestEvent.NostrId, app, 0)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	resp = svc.checkHoldInvoiceOwner(nip47Request, app, cancelHoldInvoiceParams.PaymentHash)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	svc.logger.WithFields(logrus.Fields{
+		"requestEventNostrId": requestEvent.NostrId,
+		"appId":               app.ID,
+		"paymentHash":         cancelHoldInvoiceParams.PaymentHash,
+	}).Info("Canceling hold invoice")
+
+	err := svc.lnClient.CancelHoldInvoice(ctx, cancelHoldInvoiceParams.PaymentHash)
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+			"paymentHash":         cancelHoldInvoiceParams.PaymentHash,
+		}).Infof("Failed to cancel hold invoice: %v", err)
+
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_INTERNAL,
+				Message: err.Error(),
+			},
+		}, nostr.Tags{})
+		return
+	}
+
+	publishResponse(&nip47.Response{
+		ResultType: nip47Request.Method,
+		Result:     &nip47.CancelHoldInvoiceResponse{},
+	}, nostr.Tags{})
+}
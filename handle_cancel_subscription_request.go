@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sirupsen/logrus"
+)
+
+func (svc *Service) HandleCancelSubscriptionEvent(ctx context.Context, nip47Request *nip47.Request, requestEvent *db.RequestEvent, app *db.App, publishResponse func(*nip47.Response, nostr.Tags)) {
+
+	cancelSubscriptionParams := &nip47.CancelSubscriptionParams{}
+	resp := svc.decodeNip47Request(nip47Request, requestEvent, app, cancelSubscriptionParams)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	resp = svc.checkPermission(nip47Request, requestEvent.NostrId, app, 0)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	svc.logger.WithFields(logrus.Fields{
+		"requestEventNostrId": requestEvent.NostrId,
+		"appId":               app.ID,
+		"subscriptionId":      cancelSubscriptionParams.Id,
+	}).Info("Cancelling subscription")
+
+	// apps can only cancel their own subscriptions
+	result := svc.db.Model(&db.Subscription{}).
+		Where("id = ? AND app_id = ? AND cancelled_at IS NULL", cancelSubscriptionParams.Id, app.ID).
+		Update("cancelled_at", time.Now())
+	if result.Error != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+			"subscriptionId":      cancelSubscriptionParams.Id,
+		}).WithError(result.Error).Error("Failed to cancel subscription")
+
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_INTERNAL,
+				Message: result.Error.Error(),
+			},
+		}, nostr.Tags{})
+		return
+	}
+	if result.RowsAffected == 0 {
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_BAD_REQUEST,
+				Message: "Subscription not found",
+			},
+		}, nostr.Tags{})
+		return
+	}
+
+	publishResponse(&nip47.Response{
+		ResultType: nip47Request.Method,
+		Result:     &nip47.CancelSubscriptionResponse{},
+	}, nostr.Tags{})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sirupsen/logrus"
+)
+
+func (svc *Service) HandleCreateSubscriptionEvent(ctx context.Context, nip47Request *nip47.Request, requestEvent *db.RequestEvent, app *db.App, publishResponse func(*nip47.Response, nostr.Tags)) {
+
+	createSubscriptionParams := &nip47.CreateSubscriptionParams{}
+	resp := svc.decodeNip47Request(nip47Request, requestEvent, app, createSubscriptionParams)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	// the budget is checked on every run
+	resp = svc.checkPermission(nip47Request, requestEvent.NostrId, app, 0)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	badRequest := func(message string) {
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_BAD_REQUEST,
+				Message: message,
+			},
+		}, nostr.Tags{})
+	}
+
+	if (createSubscriptionParams.Pubkey == "") == (createSubscriptionParams.LightningAddress == "") {
+		badRequest("Either pubkey or lightning_address is required")
+		return
+	}
+	if createSubscriptionParams.Amount <= 0 {
+		badRequest("Missing amount")
+		return
+	}
+	nextRunAt, err := nextSubscriptionRun(createSubscriptionParams.Interval, time.Now())
+	if err != nil {
+		badRequest(err.Error())
+		return
+	}
+
+	subscription := db.Subscription{
+		AppId:            app.ID,
+		RequestEventId:   requestEvent.ID,
+		Pubkey:           createSubscriptionParams.Pubkey,
+		LightningAddress: createSubscriptionParams.LightningAddress,
+		Amount:           createSubscriptionParams.Amount,
+		Interval:         createSubscriptionParams.Interval,
+		Description:      createSubscriptionParams.Description,
+		NextRunAt:        nextRunAt,
+	}
+	err = svc.db.Create(&subscription).Error
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+		}).WithError(err).Error("Failed to create subscription")
+
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_INTERNAL,
+				Message: err.Error(),
+			},
+		}, nostr.Tags{})
+		return
+	}
+
+	svc.logger.WithFields(logrus.Fields{
+		"requestEventNostrId": requestEvent.NostrId,
+		"appId":               app.ID,
+		"subscriptionId":      subscription.ID,
+		"interval":            subscription.Interval,
+		"nextRunAt":           subscription.NextRunAt,
+	}).Info("Created subscription")
+
+	publishResponse(&nip47.Response{
+		ResultType: nip47Request.Method,
+		Result: &nip47.CreateSubscriptionResponse{
+			Subscription: subscriptionToNip47(&subscription),
+		},
+	}, nostr.Tags{})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/lnclient"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sirupsen/logrus"
+)
+
+func (svc *Service) HandleEstimateFeeEvent(ctx context.Context, nip47Request *nip47.Request, requestEvent *db.RequestEvent, app *db.App, publishResponse func(*nip47.Response, nostr.Tags)) {
+	estimateFeeParams := &nip47.EstimateFeeParams{}
+	resp := svc.decodeNip47Request(nip47Request, requestEvent, app, estimateFeeParams)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	resp = svc.checkPermission(nip47Request, requestEvent.NostrId, app, 0)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	estimateFeeRequest := &lnclient.EstimateFeeRequest{
+		Invoice:     estimateFeeParams.Invoice,
+		Destination: estimateFeeParams.Pubkey,
+		AmountMsat:  estimateFeeParams.Amount,
+	}
+	err := validateEstimateFeeRequest(estimateFeeRequest)
+	if err != nil {
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_BAD_REQUEST,
+				Message: err.Error(),
+			},
+		}, nostr.Tags{})
+		return
+	}
+
+	svc.logger.WithFields(logrus.Fields{
+		"requestEventNostrId": requestEvent.NostrId,
+		"appId":               app.ID,
+		"invoice":             estimateFeeParams.Invoice,
+		"pubkey":              estimateFeeParams.Pubkey,
+		"amount":              estimateFeeParams.Amount,
+	}).Info("Estimating fee")
+
+	estimate, err := svc.EstimateFee(ctx, estimateFeeRequest)
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+		}).Infof("Failed to estimate fee: %v", err)
+
+		code := nip47.ERROR_INTERNAL
+		if errors.Is(err, lnclient.ErrNotImplemented) {
+			code = nip47.ERROR_NOT_IMPLEMENTED
+		}
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    code,
+				Message: err.Error(),
+			},
+		}, nostr.Tags{})
+		return
+	}
+
+	publishResponse(&nip47.Response{
+		ResultType: nip47Request.Method,
+		Result: nip47.EstimateFeeResponse{
+			Fee:                estimate.FeeMsat,
+			SuccessProbability: estimate.SuccessProbability,
+		},
+	}, nostr.Tags{})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sirupsen/logrus"
+)
+
+func (svc *Service) HandleListSubscriptionsEvent(ctx context.Context, nip47Request *nip47.Request, requestEvent *db.RequestEvent, app *db.App, publishResponse func(*nip47.Response, nostr.Tags)) {
+
+	resp := svc.checkPermission(nip47Request, requestEvent.NostrId, app, 0)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	subscriptions := []db.Subscription{}
+	err := svc.db.Where("app_id = ? AND cancelled_at IS NULL", app.ID).Order("id").Find(&subscriptions).Error
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+		}).WithError(err).Error("Failed to list subscriptions")
+
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_INTERNAL,
+				Message: err.Error(),
+			},
+		}, nostr.Tags{})
+		return
+	}
+
+	responsePayload := &nip47.ListSubscriptionsResponse{
+		Subscriptions: []nip47.Subscription{},
+	}
+	for i := range subscriptions {
+		responsePayload.Subscriptions = append(responsePayload.Subscriptions, subscriptionToNip47(&subscriptions[i]))
+	}
+
+	publishResponse(&nip47.Response{
+		ResultType: nip47Request.Method,
+		Result:     responsePayload,
+	}, nostr.Tags{})
+}
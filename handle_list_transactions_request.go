@@ -36,7 +36,16 @@ func (svc *Service) HandleListTransactionsEvent(ctx context.Context, nip47Reques
 		// make sure a sensible limit is passed
 		limit = maxLimit
 	}
-	transactions, err := svc.lnClient.ListTransactions(ctx, listParams.From, listParams.Until, limit, listParams.Offset, listParams.Unpaid, listParams.Type)
+
+	var transactions []nip47.Transaction
+	var err error
+	if svc.canSeeAllTransactions(app) {
+		// listed from the transactions table, as each LN backend filters and pages differently
+		transactions, err = svc.listLedgerTransactions(listParams, limit)
+	} else {
+		// other apps only see the invoices they created and the payments they made
+		transactions, err = svc.listAppTransactions(app, listParams, limit)
+	}
 	if err != nil {
 		svc.logger.WithFields(logrus.Fields{
 			// TODO: log request fields from listParams
@@ -53,6 +62,9 @@ func (svc *Service) HandleListTransactionsEvent(ctx context.Context, nip47Reques
 		}, nostr.Tags{})
 		return
 	}
+	svc.withLabels(transactions)
+	svc.withContacts(transactions)
+	svc.withFiatValues(transactions, svc.fiatCurrency(app))
 
 	responsePayload := &nip47.ListTransactionsResponse{
 		Transactions: transactions,
@@ -57,6 +57,71 @@ func (svc *Service) HandleLookupInvoiceEvent(ctx context.Context, nip47Request *
 		paymentHash = paymentRequest.PaymentHash
 	}
 
+	// outgoing payments are answered from their stored state. Only the app's own payments
+	// are considered, the invoice may have been paid by another app on this hub.
+	payment := db.Payment{}
+	result := svc.db.Where("app_id = ? AND payment_hash = ?", app.ID, paymentHash).Order("id desc").Limit(1).Find(&payment)
+	if result.Error != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+			"paymentHash":         paymentHash,
+		}).Errorf("Failed to lookup payment: %v", result.Error)
+
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_INTERNAL,
+				Message: result.Error.Error(),
+			},
+		}, nostr.Tags{})
+		return
+	}
+	if result.RowsAffected > 0 {
+		transactions := []nip47.Transaction{*svc.paymentToTransaction(&payment)}
+		svc.withLabels(transactions)
+		svc.withContacts(transactions)
+		svc.withFiatValues(transactions, svc.fiatCurrency(app))
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Result: &nip47.LookupInvoiceResponse{
+				Transaction: transactions[0],
+			},
+		}, nostr.Tags{})
+		return
+	}
+
+	// invoices of other apps are hidden unless the app may see every transaction of the node,
+	// keysend payments received by the node are shown to apps allowed to see them
+	invoice := db.Invoice{}
+	result = svc.db.Where("app_id = ? AND payment_hash = ?", app.ID, paymentHash).Limit(1).Find(&invoice)
+	if result.Error != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+			"paymentHash":         paymentHash,
+		}).Errorf("Failed to lookup invoice: %v", result.Error)
+
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_INTERNAL,
+				Message: result.Error.Error(),
+			},
+		}, nostr.Tags{})
+		return
+	}
+	if result.RowsAffected == 0 && !svc.canSeeAllTransactions(app) && !svc.canSeeKeysendReceipt(app, paymentHash) {
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_NOT_FOUND,
+				Message: "Invoice not found",
+			},
+		}, nostr.Tags{})
+		return
+	}
+
 	transaction, err := svc.lnClient.LookupInvoice(ctx, paymentHash)
 	if err != nil {
 		svc.logger.WithFields(logrus.Fields{
@@ -76,8 +141,13 @@ func (svc *Service) HandleLookupInvoiceEvent(ctx context.Context, nip47Request *
 		return
 	}
 
+	transactions := []nip47.Transaction{*svc.withInternalSettlement(transaction)}
+	svc.withLabels(transactions)
+	svc.withContacts(transactions)
+	svc.withFiatValues(transactions, svc.fiatCurrency(app))
+
 	responsePayload := &nip47.LookupInvoiceResponse{
-		Transaction: *transaction,
+		Transaction: transactions[0],
 	}
 
 	publishResponse(&nip47.Response{
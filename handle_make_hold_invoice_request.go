@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/lnclient"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sirupsen/logrus"
+)
+
+func (svc *Service) HandleMakeHoldInvoiceEvent(ctx context.Context, nip47Request *nip47.Request, requestEvent *db.RequestEvent, app *db.App, publishResponse func(*nip47.Response, nostr.Tags)) {
+
+	makeHoldInvoiceParams := &nip47.MakeHoldInvoiceParams{}
+	resp := svc.decodeNip47Request(nip47Request, requestEvent, app, makeHoldInvoiceParams)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	resp = svc.checkPermission(nip47Request, requestEvent.NostrId, app, 0)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	svc.logger.WithFields(logrus.Fields{
+		"requestEventNostrId": requestEvent.NostrId,
+		"appId":               app.ID,
+		"amount":              makeHoldInvoiceParams.Amount,
+		"description":         makeHoldInvoiceParams.Description,
+		"descriptionHash":     makeHoldInvoiceParams.DescriptionHash,
+		"expiry":              makeHoldInvoiceParams.Expiry,
+		"paymentHash":         makeHoldInvoiceParams.PaymentHash,
+	}).Info("Making hold invoice")
+
+	expiry := makeHoldInvoiceParams.Expiry
+	if expiry == 0 {
+		expiry = 86400
+	}
+
+	transaction, err := svc.lnClient.MakeHoldInvoice(ctx, makeHoldInvoiceParams.Amount, makeHoldInvoiceParams.Description, makeHoldInvoiceParams.DescriptionHash, expiry, makeHoldInvoiceParams.PaymentHash)
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+			"amount":              makeHoldInvoiceParams.Amount,
+			"description":         makeHoldInvoiceParams.Description,
+			"descriptionHash":     makeHoldInvoiceParams.DescriptionHash,
+			"expiry":              makeHoldInvoiceParams.Expiry,
+			"paymentHash":         makeHoldInvoiceParams.PaymentHash,
+		}).Infof("Failed to make hold invoice: %v", err)
+
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_INTERNAL,
+				Message: err.Error(),
+			},
+		}, nostr.Tags{})
+		return
+	}
+
+	// the invoice is only visible to the app that created it
+	err = svc.db.Create(&db.Invoice{
+		AppId:          app.ID,
+		PaymentHash:    transaction.PaymentHash,
+		PaymentRequest: transaction.Invoice,
+		Amount:         uint(makeHoldInvoiceParams.Amount / 1000),
+		Hold:           true,
+	}).Error
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+			"paymentHash":         transaction.PaymentHash,
+		}).WithError(err).Error("Failed to save hold invoice")
+	}
+
+	err = svc.recordTransaction(transaction, &app.ID)
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+			"paymentHash":         transaction.PaymentHash,
+		}).WithError(err).Error("Failed to record transaction")
+	}
+
+	responsePayload := &nip47.MakeHoldInvoiceResponse{
+		Transaction: *transaction,
+	}
+
+	publishResponse(&nip47.Response{
+		ResultType: nip47Request.Method,
+		Result:     responsePayload,
+	}, nostr.Tags{})
+}
+
+// checkHoldInvoiceOwner returns a NOT_FOUND response unless the app created the hold invoice,
+// so apps can only settle or cancel their own hold invoices
+func (svc *Service) checkHoldInvoiceOwner(nip47Request *nip47.Request, app *db.App, paymentHash string) *nip47.Response {
+	var count int64
+	err := svc.db.Model(&db.Invoice{}).Where("app_id = ? AND payment_hash = ? AND hold = ?", app.ID, paymentHash, true).Count(&count).Error
+	if err != nil {
+		return &nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_INTERNAL,
+				Message: err.Error(),
+			},
+		}
+	}
+	if count == 0 {
+		return &nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_NOT_FOUND,
+				Message: "hold invoice not found",
+			},
+		}
+	}
+	return nil
+}
+
+// resubscribeHoldInvoices hands the hold invoices which were not settled to the LN backend again after a restart,
+// as it only follows the hold invoices it made while running
+func (svc *Service) resubscribeHoldInvoices(ctx context.Context) {
+	if !svc.supportsFeature(lnclient.FeatureHoldInvoices) {
+		return
+	}
+
+	paymentHashes := []string{}
+	err := svc.db.Model(&db.Invoice{}).Where("hold = ? AND settled_at IS NULL", true).Pluck("payment_hash", &paymentHashes).Error
+	if err != nil {
+		svc.logger.WithError(err).Error("Failed to find hold invoices")
+		return
+	}
+	for _, paymentHash := range paymentHashes {
+		err = svc.lnClient.SubscribeHoldInvoice(ctx, paymentHash)
+		if err != nil {
+			svc.logger.WithField("paymentHash", paymentHash).WithError(err).Error("Failed to subscribe to hold invoice")
+		}
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 
 	"github.com/getAlby/nostr-wallet-connect/db"
 	"github.com/getAlby/nostr-wallet-connect/nip47"
@@ -24,6 +26,23 @@ func (svc *Service) HandleMakeInvoiceEvent(ctx context.Context, nip47Request *ni
 		return
 	}
 
+	zapRequest, err := parseZapRequest(makeInvoiceParams.Description, makeInvoiceParams.Amount)
+	if err != nil {
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_BAD_REQUEST,
+				Message: err.Error(),
+			},
+		}, nostr.Tags{})
+		return
+	}
+	if zapRequest != nil && makeInvoiceParams.DescriptionHash == "" {
+		// the invoice of a zap must commit to the zap request
+		zapRequestHash := sha256.Sum256([]byte(makeInvoiceParams.Description))
+		makeInvoiceParams.DescriptionHash = hex.EncodeToString(zapRequestHash[:])
+	}
+
 	svc.logger.WithFields(logrus.Fields{
 		"requestEventNostrId": requestEvent.NostrId,
 		"appId":               app.ID,
@@ -31,6 +50,7 @@ func (svc *Service) HandleMakeInvoiceEvent(ctx context.Context, nip47Request *ni
 		"description":         makeInvoiceParams.Description,
 		"descriptionHash":     makeInvoiceParams.DescriptionHash,
 		"expiry":              makeInvoiceParams.Expiry,
+		"zap":                 zapRequest != nil,
 	}).Info("Making invoice")
 
 	expiry := makeInvoiceParams.Expiry
@@ -59,6 +79,45 @@ func (svc *Service) HandleMakeInvoiceEvent(ctx context.Context, nip47Request *ni
 		return
 	}
 
+	// lets other apps on this hub pay the invoice without a payment over the network
+	err = svc.db.Create(&db.Invoice{
+		AppId:          app.ID,
+		PaymentHash:    transaction.PaymentHash,
+		PaymentRequest: transaction.Invoice,
+		Amount:         uint(makeInvoiceParams.Amount / 1000),
+	}).Error
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+			"paymentHash":         transaction.PaymentHash,
+		}).WithError(err).Error("Failed to save invoice")
+	}
+
+	err = svc.recordTransaction(transaction, &app.ID)
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+			"paymentHash":         transaction.PaymentHash,
+		}).WithError(err).Error("Failed to record transaction")
+	}
+
+	if zapRequest != nil {
+		err = svc.db.Create(&db.Zap{
+			AppId:       app.ID,
+			PaymentHash: transaction.PaymentHash,
+			ZapRequest:  makeInvoiceParams.Description,
+		}).Error
+		if err != nil {
+			svc.logger.WithFields(logrus.Fields{
+				"requestEventNostrId": requestEvent.NostrId,
+				"appId":               app.ID,
+				"paymentHash":         transaction.PaymentHash,
+			}).WithError(err).Error("Failed to save zap request")
+		}
+	}
+
 	responsePayload := &nip47.MakeInvoiceResponse{
 		Transaction: *transaction,
 	}
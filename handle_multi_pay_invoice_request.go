@@ -7,7 +7,6 @@ import (
 	"sync"
 
 	"github.com/getAlby/nostr-wallet-connect/db"
-	"github.com/getAlby/nostr-wallet-connect/events"
 	"github.com/getAlby/nostr-wallet-connect/nip47"
 	"github.com/nbd-wtf/go-nostr"
 	decodepay "github.com/nbd-wtf/ln-decodepay"
@@ -24,13 +23,24 @@ func (svc *Service) HandleMultiPayInvoiceEvent(ctx context.Context, nip47Request
 		return
 	}
 
-	var wg sync.WaitGroup
+	batch, resp := svc.newMultiPayBatch(nip47Request, len(multiPayParams.Invoices))
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
 	var mu sync.Mutex
 	for _, invoiceInfo := range multiPayParams.Invoices {
-		wg.Add(1)
+		invoiceInfo := invoiceInfo
 		// TODO: we should call the handle_payment_request (most of this code is duplicated)
-		go func(invoiceInfo nip47.MultiPayInvoiceElement) {
-			defer wg.Done()
+		batch.run(func() {
+			// known once the invoice is decoded
+			var amountMsat int64
+			publishResponse := func(response *nip47.Response, tags nostr.Tags) {
+				batch.record(response, tags, amountMsat)
+				publishResponse(response, tags)
+			}
+
 			bolt11 := invoiceInfo.Invoice
 			// Convert invoice to lowercase string
 			bolt11 = strings.ToLower(bolt11)
@@ -53,6 +63,7 @@ func (svc *Service) HandleMultiPayInvoiceEvent(ctx context.Context, nip47Request
 				}, nostr.Tags{dTag})
 				return
 			}
+			amountMsat = paymentRequest.MSatoshi
 
 			invoiceDTagValue := invoiceInfo.Id
 			if invoiceDTagValue == "" {
@@ -60,13 +71,35 @@ func (svc *Service) HandleMultiPayInvoiceEvent(ctx context.Context, nip47Request
 			}
 			dTag := []string{"d", invoiceDTagValue}
 
-			resp := svc.checkPermission(nip47Request, requestEvent.NostrId, app, paymentRequest.MSatoshi)
+			// the budget is only checked once we know the invoice still has to be paid
+			resp := svc.checkPermission(nip47Request, requestEvent.NostrId, app, 0)
+			if resp != nil {
+				publishResponse(resp, nostr.Tags{dTag})
+				return
+			}
+
+			resp = svc.beginPayment(ctx, nip47Request, requestEvent, app, paymentRequest.PaymentHash)
+			if resp != nil {
+				publishResponse(resp, nostr.Tags{dTag})
+				return
+			}
+			var paymentResponse *nip47.Response
+			defer func() {
+				svc.endPayment(paymentRequest.PaymentHash, paymentResponse)
+			}()
+			publish := publishResponse
+			publishResponse = func(response *nip47.Response, tags nostr.Tags) {
+				paymentResponse = response
+				publish(response, tags)
+			}
+
+			resp = svc.checkPaymentPermission(nip47Request, requestEvent.NostrId, app, paymentRequest.MSatoshi, invoiceInfo.MaxFee)
 			if resp != nil {
 				publishResponse(resp, nostr.Tags{dTag})
 				return
 			}
 
-			payment := db.Payment{App: *app, RequestEventId: requestEvent.ID, PaymentRequest: bolt11, Amount: uint(paymentRequest.MSatoshi / 1000)}
+			payment := db.Payment{App: *app, RequestEventId: requestEvent.ID, PaymentRequest: bolt11, PaymentHash: paymentRequest.PaymentHash, AmountMsat: uint64(paymentRequest.MSatoshi), Destination: paymentRequest.Payee, State: db.PAYMENT_STATE_PENDING}
 			mu.Lock()
 			insertPaymentResult := svc.db.Create(&payment)
 			mu.Unlock()
@@ -76,6 +109,13 @@ func (svc *Service) HandleMultiPayInvoiceEvent(ctx context.Context, nip47Request
 					"paymentRequest":      bolt11,
 					"invoiceId":           invoiceInfo.Id,
 				}).Errorf("Failed to process event: %v", insertPaymentResult.Error)
+				publishResponse(&nip47.Response{
+					ResultType: nip47Request.Method,
+					Error: &nip47.Error{
+						Code:    nip47.ERROR_INTERNAL,
+						Message: insertPaymentResult.Error.Error(),
+					},
+				}, nostr.Tags{dTag})
 				return
 			}
 
@@ -85,46 +125,29 @@ func (svc *Service) HandleMultiPayInvoiceEvent(ctx context.Context, nip47Request
 				"bolt11":              bolt11,
 			}).Info("Sending payment")
 
-			response, err := svc.lnClient.SendPaymentSync(ctx, bolt11)
+			response, err := svc.sendInvoicePayment(ctx, app, bolt11, &paymentRequest, invoiceInfo.MaxFee)
 			if err != nil {
 				svc.logger.WithFields(logrus.Fields{
 					"requestEventNostrId": requestEvent.NostrId,
 					"appId":               app.ID,
 					"bolt11":              bolt11,
 				}).Infof("Failed to send payment: %v", err)
-
-				svc.eventPublisher.Publish(&events.Event{
-					Event: "nwc_payment_failed",
-					Properties: map[string]interface{}{
-						// "error":   fmt.Sprintf("%v", err),
-						"multi":   true,
-						"invoice": bolt11,
-						"amount":  paymentRequest.MSatoshi / 1000,
-					},
-				})
+				mu.Lock()
+				svc.paymentFailed(&payment, err)
+				mu.Unlock()
 
 				publishResponse(&nip47.Response{
 					ResultType: nip47Request.Method,
 					Error: &nip47.Error{
-						Code:    nip47.ERROR_INTERNAL,
+						Code:    paymentErrorCode(err),
 						Message: err.Error(),
 					},
 				}, nostr.Tags{dTag})
 				return
 			}
-			payment.Preimage = &response.Preimage
-			// TODO: also set fee
-
 			mu.Lock()
-			svc.db.Save(&payment)
+			svc.paymentSucceeded(&payment, response.Preimage, response.Fee)
 			mu.Unlock()
-			svc.eventPublisher.Publish(&events.Event{
-				Event: "nwc_payment_succeeded",
-				Properties: map[string]interface{}{
-					"multi":  true,
-					"amount": paymentRequest.MSatoshi / 1000,
-				},
-			})
 			publishResponse(&nip47.Response{
 				ResultType: nip47Request.Method,
 				Result: nip47.PayResponse{
@@ -132,8 +155,11 @@ func (svc *Service) HandleMultiPayInvoiceEvent(ctx context.Context, nip47Request
 					FeesPaid: response.Fee,
 				},
 			}, nostr.Tags{dTag})
-		}(invoiceInfo)
+		})
 	}
 
-	wg.Wait()
+	batch.wait()
+	if multiPayParams.Summary {
+		publishResponse(batch.summaryResponse(nip47Request), nostr.Tags{})
+	}
 }
@@ -2,10 +2,10 @@ package main
 
 import (
 	"context"
+	"strings"
 	"sync"
 
 	"github.com/getAlby/nostr-wallet-connect/db"
-	"github.com/getAlby/nostr-wallet-connect/events"
 	"github.com/getAlby/nostr-wallet-connect/nip47"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/sirupsen/logrus"
@@ -20,12 +20,20 @@ func (svc *Service) HandleMultiPayKeysendEvent(ctx context.Context, nip47Request
 		return
 	}
 
-	var wg sync.WaitGroup
+	batch, resp := svc.newMultiPayBatch(nip47Request, len(multiPayParams.Keysends))
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
 	var mu sync.Mutex
 	for _, keysendInfo := range multiPayParams.Keysends {
-		wg.Add(1)
-		go func(keysendInfo nip47.MultiPayKeysendElement) {
-			defer wg.Done()
+		keysendInfo := keysendInfo
+		batch.run(func() {
+			publishResponse := func(response *nip47.Response, tags nostr.Tags) {
+				batch.record(response, tags, keysendInfo.Amount)
+				publishResponse(response, tags)
+			}
 
 			keysendDTagValue := keysendInfo.Id
 			if keysendDTagValue == "" {
@@ -33,13 +41,13 @@ func (svc *Service) HandleMultiPayKeysendEvent(ctx context.Context, nip47Request
 			}
 			dTag := []string{"d", keysendDTagValue}
 
-			resp := svc.checkPermission(nip47Request, requestEvent.NostrId, app, keysendInfo.Amount)
+			resp := svc.checkPaymentPermission(nip47Request, requestEvent.NostrId, app, keysendInfo.Amount, keysendInfo.MaxFee)
 			if resp != nil {
 				publishResponse(resp, nostr.Tags{dTag})
 				return
 			}
 
-			payment := db.Payment{App: *app, RequestEvent: *requestEvent, Amount: uint(keysendInfo.Amount / 1000)}
+			payment := db.Payment{App: *app, RequestEvent: *requestEvent, AmountMsat: uint64(keysendInfo.Amount), Destination: strings.ToLower(keysendInfo.Pubkey), State: db.PAYMENT_STATE_PENDING}
 			mu.Lock()
 			insertPaymentResult := svc.db.Create(&payment)
 			mu.Unlock()
@@ -49,6 +57,13 @@ func (svc *Service) HandleMultiPayKeysendEvent(ctx context.Context, nip47Request
 					"recipientPubkey":     keysendInfo.Pubkey,
 					"keysendId":           keysendInfo.Id,
 				}).Errorf("Failed to process event: %v", insertPaymentResult.Error)
+				publishResponse(&nip47.Response{
+					ResultType: nip47Request.Method,
+					Error: &nip47.Error{
+						Code:    nip47.ERROR_INTERNAL,
+						Message: insertPaymentResult.Error.Error(),
+					},
+				}, nostr.Tags{dTag})
 				return
 			}
 
@@ -58,52 +73,41 @@ func (svc *Service) HandleMultiPayKeysendEvent(ctx context.Context, nip47Request
 				"recipientPubkey":     keysendInfo.Pubkey,
 			}).Info("Sending payment")
 
-			preimage, err := svc.lnClient.SendKeysend(ctx, keysendInfo.Amount, keysendInfo.Pubkey, keysendInfo.Preimage, keysendInfo.TLVRecords)
+			response, err := svc.lnClient.SendKeysend(ctx, keysendInfo.Amount, keysendInfo.Pubkey, keysendInfo.Preimage, keysendInfo.TLVRecords, svc.paymentOptions(app, keysendInfo.MaxFee))
 			if err != nil {
 				svc.logger.WithFields(logrus.Fields{
 					"requestEventNostrId": requestEvent.NostrId,
 					"appId":               app.ID,
 					"recipientPubkey":     keysendInfo.Pubkey,
 				}).Infof("Failed to send payment: %v", err)
-				svc.eventPublisher.Publish(&events.Event{
-					Event: "nwc_payment_failed",
-					Properties: map[string]interface{}{
-						// "error":   fmt.Sprintf("%v", err),
-						"keysend": true,
-						"multi":   true,
-						"amount":  keysendInfo.Amount / 1000,
-					},
-				})
+				mu.Lock()
+				svc.paymentFailed(&payment, err)
+				mu.Unlock()
 
 				publishResponse(&nip47.Response{
 					ResultType: nip47Request.Method,
 					Error: &nip47.Error{
-						Code:    nip47.ERROR_INTERNAL,
+						Code:    paymentErrorCode(err),
 						Message: err.Error(),
 					},
 				}, nostr.Tags{dTag})
 				return
 			}
-			payment.Preimage = &preimage
 			mu.Lock()
-			svc.db.Save(&payment)
+			svc.paymentSucceeded(&payment, response.Preimage, response.Fee)
 			mu.Unlock()
-			svc.eventPublisher.Publish(&events.Event{
-				Event: "nwc_payment_succeeded",
-				Properties: map[string]interface{}{
-					"keysend": true,
-					"multi":   true,
-					"amount":  keysendInfo.Amount / 1000,
-				},
-			})
 			publishResponse(&nip47.Response{
 				ResultType: nip47Request.Method,
 				Result: nip47.PayResponse{
-					Preimage: preimage,
+					Preimage: response.Preimage,
+					FeesPaid: response.Fee,
 				},
 			}, nostr.Tags{dTag})
-		}(keysendInfo)
+		})
 	}
 
-	wg.Wait()
+	batch.wait()
+	if multiPayParams.Summary {
+		publishResponse(batch.summaryResponse(nip47Request), nostr.Tags{})
+	}
 }
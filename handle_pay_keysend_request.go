@@ -2,9 +2,9 @@ package main
 
 import (
 	"context"
+	"strings"
 
 	"github.com/getAlby/nostr-wallet-connect/db"
-	"github.com/getAlby/nostr-wallet-connect/events"
 	"github.com/getAlby/nostr-wallet-connect/nip47"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/sirupsen/logrus"
@@ -19,13 +19,13 @@ func (svc *Service) HandlePayKeysendEvent(ctx context.Context, nip47Request *nip
 		return
 	}
 
-	resp = svc.checkPermission(nip47Request, requestEvent.NostrId, app, payParams.Amount)
+	resp = svc.checkPaymentPermission(nip47Request, requestEvent.NostrId, app, payParams.Amount, payParams.MaxFee)
 	if resp != nil {
 		publishResponse(resp, nostr.Tags{})
 		return
 	}
 
-	payment := db.Payment{App: *app, RequestEvent: *requestEvent, Amount: uint(payParams.Amount / 1000)}
+	payment := db.Payment{App: *app, RequestEvent: *requestEvent, AmountMsat: uint64(payParams.Amount), Destination: strings.ToLower(payParams.Pubkey), State: db.PAYMENT_STATE_PENDING}
 	err := svc.db.Create(&payment).Error
 	if err != nil {
 		publishResponse(&nip47.Response{
@@ -44,43 +44,29 @@ func (svc *Service) HandlePayKeysendEvent(ctx context.Context, nip47Request *nip
 		"senderPubkey":        payParams.Pubkey,
 	}).Info("Sending payment")
 
-	preimage, err := svc.lnClient.SendKeysend(ctx, payParams.Amount, payParams.Pubkey, payParams.Preimage, payParams.TLVRecords)
+	response, err := svc.lnClient.SendKeysend(ctx, payParams.Amount, payParams.Pubkey, payParams.Preimage, payParams.TLVRecords, svc.paymentOptions(app, payParams.MaxFee))
 	if err != nil {
 		svc.logger.WithFields(logrus.Fields{
 			"requestEventNostrId": requestEvent.NostrId,
 			"appId":               app.ID,
 			"recipientPubkey":     payParams.Pubkey,
 		}).Infof("Failed to send payment: %v", err)
-		svc.eventPublisher.Publish(&events.Event{
-			Event: "nwc_payment_failed",
-			Properties: map[string]interface{}{
-				// "error":   fmt.Sprintf("%v", err),
-				"keysend": true,
-				"amount":  payParams.Amount / 1000,
-			},
-		})
+		svc.paymentFailed(&payment, err)
 		publishResponse(&nip47.Response{
 			ResultType: nip47Request.Method,
 			Error: &nip47.Error{
-				Code:    nip47.ERROR_INTERNAL,
+				Code:    paymentErrorCode(err),
 				Message: err.Error(),
 			},
 		}, nostr.Tags{})
 		return
 	}
-	payment.Preimage = &preimage
-	svc.db.Save(&payment)
-	svc.eventPublisher.Publish(&events.Event{
-		Event: "nwc_payment_succeeded",
-		Properties: map[string]interface{}{
-			"keysend": true,
-			"amount":  payParams.Amount / 1000,
-		},
-	})
+	svc.paymentSucceeded(&payment, response.Preimage, response.Fee)
 	publishResponse(&nip47.Response{
 		ResultType: nip47Request.Method,
 		Result: nip47.PayResponse{
-			Preimage: preimage,
+			Preimage: response.Preimage,
+			FeesPaid: response.Fee,
 		},
 	}, nostr.Tags{})
 }
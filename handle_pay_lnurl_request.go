@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/lnurl"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sirupsen/logrus"
+)
+
+// HandlePayLnurlEvent handles both pay_lightning_address and pay_lnurl
+func (svc *Service) HandlePayLnurlEvent(ctx context.Context, nip47Request *nip47.Request, requestEvent *db.RequestEvent, app *db.App, publishResponse func(*nip47.Response, nostr.Tags)) {
+
+	payLnurlParams := &nip47.PayLnurlParams{}
+	resp := svc.decodeNip47Request(nip47Request, requestEvent, app, payLnurlParams)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	target := payLnurlParams.Lnurl
+	if nip47Request.Method == nip47.PAY_LIGHTNING_ADDRESS_METHOD {
+		target = payLnurlParams.LightningAddress
+	}
+
+	if target == "" || payLnurlParams.Amount <= 0 {
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_BAD_REQUEST,
+				Message: "Missing destination or amount",
+			},
+		}, nostr.Tags{})
+		return
+	}
+
+	// fail early before making any requests to the LNURL service
+	resp = svc.checkPaymentPermission(nip47Request, requestEvent.NostrId, app, payLnurlParams.Amount, payLnurlParams.MaxFee)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	svc.logger.WithFields(logrus.Fields{
+		"requestEventNostrId": requestEvent.NostrId,
+		"appId":               app.ID,
+		"target":              target,
+		"amount":              payLnurlParams.Amount,
+	}).Info("Fetching LNURL-pay invoice")
+
+	payParams, err := svc.lnurlClient.FetchPayParams(ctx, target)
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+			"target":              target,
+		}).Infof("Failed to fetch LNURL-pay params: %v", err)
+
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.OTHER,
+				Message: fmt.Sprintf("Failed to fetch LNURL-pay params: %s", err.Error()),
+			},
+		}, nostr.Tags{})
+		return
+	}
+
+	bolt11, err := svc.lnurlClient.FetchInvoice(ctx, payParams, &lnurl.PayRequestOptions{
+		AmountMsat: payLnurlParams.Amount,
+		Comment:    payLnurlParams.Comment,
+		PayerData:  payLnurlParams.PayerData,
+	})
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+			"target":              target,
+			"amount":              payLnurlParams.Amount,
+		}).Infof("Failed to fetch LNURL-pay invoice: %v", err)
+
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.OTHER,
+				Message: fmt.Sprintf("Failed to fetch invoice: %s", err.Error()),
+			},
+		}, nostr.Tags{})
+		return
+	}
+
+	svc.payInvoice(ctx, nip47Request, requestEvent, app, &nip47.PayParams{Invoice: bolt11, MaxFee: payLnurlParams.MaxFee}, lnurl.Normalize(target), publishResponse)
+}
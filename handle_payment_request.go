@@ -6,7 +6,6 @@ import (
 	"strings"
 
 	"github.com/getAlby/nostr-wallet-connect/db"
-	"github.com/getAlby/nostr-wallet-connect/events"
 	"github.com/getAlby/nostr-wallet-connect/nip47"
 	"github.com/nbd-wtf/go-nostr"
 	decodepay "github.com/nbd-wtf/ln-decodepay"
@@ -22,9 +21,16 @@ func (svc *Service) HandlePayInvoiceEvent(ctx context.Context, nip47Request *nip
 		return
 	}
 
-	bolt11 := payParams.Invoice
+	svc.payInvoice(ctx, nip47Request, requestEvent, app, payParams, "", publishResponse)
+}
+
+// payInvoice is the budgeted bolt11 payment path shared by methods which end up paying an invoice.
+// In async mode a pending response is published once the payment is recorded, and the outcome
+// is sent as a payment_sent or payment_failed notification instead of a response.
+// destination is the lightning address or LNURL the invoice was fetched from, if any, otherwise the payee's pubkey is recorded.
+func (svc *Service) payInvoice(ctx context.Context, nip47Request *nip47.Request, requestEvent *db.RequestEvent, app *db.App, payParams *nip47.PayParams, destination string, publishResponse func(*nip47.Response, nostr.Tags)) {
 	// Convert invoice to lowercase string
-	bolt11 = strings.ToLower(bolt11)
+	bolt11 := strings.ToLower(payParams.Invoice)
 	paymentRequest, err := decodepay.Decodepay(bolt11)
 	if err != nil {
 		svc.logger.WithFields(logrus.Fields{
@@ -43,13 +49,39 @@ func (svc *Service) HandlePayInvoiceEvent(ctx context.Context, nip47Request *nip
 		return
 	}
 
-	resp = svc.checkPermission(nip47Request, requestEvent.NostrId, app, paymentRequest.MSatoshi)
+	// the budget is only checked once we know the invoice still has to be paid
+	resp := svc.checkPermission(nip47Request, requestEvent.NostrId, app, 0)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	resp = svc.beginPayment(ctx, nip47Request, requestEvent, app, paymentRequest.PaymentHash)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+	// retried requests for the same invoice get the response of this payment
+	var paymentResponse *nip47.Response
+	defer func() {
+		svc.endPayment(paymentRequest.PaymentHash, paymentResponse)
+	}()
+	publish := publishResponse
+	publishResponse = func(response *nip47.Response, tags nostr.Tags) {
+		paymentResponse = response
+		publish(response, tags)
+	}
+
+	resp = svc.checkPaymentPermission(nip47Request, requestEvent.NostrId, app, paymentRequest.MSatoshi, payParams.MaxFee)
 	if resp != nil {
 		publishResponse(resp, nostr.Tags{})
 		return
 	}
 
-	payment := db.Payment{App: *app, RequestEvent: *requestEvent, PaymentRequest: bolt11, Amount: uint(paymentRequest.MSatoshi / 1000)}
+	if destination == "" {
+		destination = paymentRequest.Payee
+	}
+	payment := db.Payment{App: *app, RequestEvent: *requestEvent, PaymentRequest: bolt11, PaymentHash: paymentRequest.PaymentHash, AmountMsat: uint64(paymentRequest.MSatoshi), Destination: destination, State: db.PAYMENT_STATE_PENDING}
 	err = svc.db.Create(&payment).Error
 	if err != nil {
 		publishResponse(&nip47.Response{
@@ -62,47 +94,46 @@ func (svc *Service) HandlePayInvoiceEvent(ctx context.Context, nip47Request *nip
 		return
 	}
 
+	if payParams.Async {
+		pendingResponse := &nip47.Response{
+			ResultType: nip47Request.Method,
+			Result: nip47.PayResponse{
+				Status: nip47.PAYMENT_STATUS_PENDING,
+			},
+		}
+		svc.markPaymentPending(paymentRequest.PaymentHash, pendingResponse)
+		publish(pendingResponse, nostr.Tags{})
+		// the final response is only handed to retried requests waiting on this payment
+		publishResponse = func(response *nip47.Response, tags nostr.Tags) {
+			paymentResponse = response
+		}
+	}
+
 	svc.logger.WithFields(logrus.Fields{
 		"requestEventNostrId": requestEvent.NostrId,
 		"appId":               app.ID,
 		"bolt11":              bolt11,
+		"async":               payParams.Async,
 	}).Info("Sending payment")
 
-	response, err := svc.lnClient.SendPaymentSync(ctx, bolt11)
+	response, err := svc.sendInvoicePayment(ctx, app, bolt11, &paymentRequest, payParams.MaxFee)
 	if err != nil {
 		svc.logger.WithFields(logrus.Fields{
 			"requestEventNostrId": requestEvent.NostrId,
 			"appId":               app.ID,
 			"bolt11":              bolt11,
 		}).Infof("Failed to send payment: %v", err)
-		svc.eventPublisher.Publish(&events.Event{
-			Event: "nwc_payment_failed",
-			Properties: map[string]interface{}{
-				// "error":   fmt.Sprintf("%v", err),
-				"invoice": bolt11,
-				"amount":  paymentRequest.MSatoshi / 1000,
-			},
-		})
+		svc.paymentFailed(&payment, err)
 		publishResponse(&nip47.Response{
 			ResultType: nip47Request.Method,
 			Error: &nip47.Error{
-				Code:    nip47.ERROR_INTERNAL,
+				Code:    paymentErrorCode(err),
 				Message: err.Error(),
 			},
 		}, nostr.Tags{})
 		return
 	}
-	payment.Preimage = &response.Preimage
-	// TODO: save payment fee
-	svc.db.Save(&payment)
-
-	svc.eventPublisher.Publish(&events.Event{
-		Event: "nwc_payment_succeeded",
-		Properties: map[string]interface{}{
-			"bolt11": bolt11,
-			"amount": paymentRequest.MSatoshi / 1000,
-		},
-	})
+	svc.paymentSucceeded(&payment, response.Preimage, response.Fee)
 
 	publishResponse(&nip47.Response{
 		ResultType: nip47Request.Method,
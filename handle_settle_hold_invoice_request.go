@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sirupsen/logrus"
+)
+
+func (svc *Service) HandleSettleHoldInvoiceEvent(ctx context.Context, nip47Request *nip47.Request, requestEvent *db.RequestEvent, app *db.App, publishResponse func(*nip47.Response, nostr.Tags)) {
+
+	settleHoldInvoiceParams := &nip47.SettleHoldInvoiceParams{}
+	resp := svc.decodeNip47Request(nip47Request, requestEvent, app, settleHoldInvoiceParams)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	resp = svc.checkPermission(nip47Request, requestEvent.NostrId, app, 0)
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	preimageBytes, err := hex.DecodeString(settleHoldInvoiceParams.Preimage)
+	if err != nil {
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_BAD_REQUEST,
+				Message: fmt.Sprintf("invalid preimage: %v", err),
+			},
+		}, nostr.Tags{})
+		return
+	}
+	paymentHash := sha256.Sum256(preimageBytes)
+	resp = svc.checkHoldInvoiceOwner(nip47Request, app, hex.EncodeToString(paymentHash[:]))
+	if resp != nil {
+		publishResponse(resp, nostr.Tags{})
+		return
+	}
+
+	svc.logger.WithFields(logrus.Fields{
+		"requestEventNostrId": requestEvent.NostrId,
+		"appId":               app.ID,
+	}).Info("Settling hold invoice")
+
+	err = svc.lnClient.SettleHoldInvoice(ctx, settleHoldInvoiceParams.Preimage)
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+		}).Infof("Failed to settle hold invoice: %v", err)
+
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_INTERNAL,
+				Message: err.Error(),
+			},
+		}, nostr.Tags{})
+		return
+	}
+
+	publishResponse(&nip47.Response{
+		ResultType: nip47Request.Method,
+		Result:     &nip47.SettleHoldInvoiceResponse{},
+	}, nostr.Tags{})
+}
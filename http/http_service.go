@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
 	echologrus "github.com/davrux/echo-logrus/v4"
@@ -19,6 +22,7 @@ import (
 	"github.com/getAlby/nostr-wallet-connect/config"
 	"github.com/getAlby/nostr-wallet-connect/db"
 	"github.com/getAlby/nostr-wallet-connect/events"
+	"github.com/getAlby/nostr-wallet-connect/lnurl"
 	"github.com/getAlby/nostr-wallet-connect/lsp"
 	"github.com/getAlby/nostr-wallet-connect/service"
 
@@ -73,9 +77,16 @@ func (httpSvc *HttpService) RegisterSharedRoutes(e *echo.Echo) {
 	authMiddleware := httpSvc.validateUserMiddleware
 	e.GET("/api/apps", httpSvc.appsListHandler, authMiddleware)
 	e.GET("/api/apps/:pubkey", httpSvc.appsShowHandler, authMiddleware)
+	e.GET("/api/apps/:pubkey/payments", httpSvc.appsPaymentsHandler, authMiddleware)
+	e.GET("/api/apps/:pubkey/events", httpSvc.appsEventsHandler, authMiddleware)
+	e.GET("/api/apps/:pubkey/stats", httpSvc.appsStatsHandler, authMiddleware)
 	e.PATCH("/api/apps/:pubkey", httpSvc.appsUpdateHandler, authMiddleware)
 	e.DELETE("/api/apps/:pubkey", httpSvc.appsDeleteHandler, authMiddleware)
 	e.POST("/api/apps", httpSvc.appsCreateHandler, authMiddleware)
+	e.GET("/api/connection-requests", httpSvc.connectionRequestsListHandler, authMiddleware)
+	e.POST("/api/connection-requests", httpSvc.connectionRequestsCreateHandler, authMiddleware)
+	e.POST("/api/connection-requests/:id/approve", httpSvc.connectionRequestsApproveHandler, authMiddleware)
+	e.POST("/api/connection-requests/:id/reject", httpSvc.connectionRequestsRejectHandler, authMiddleware)
 	e.GET("/api/encrypted-mnemonic", httpSvc.encryptedMnemonicHandler, authMiddleware)
 	e.PATCH("/api/backup-reminder", httpSvc.backupReminderHandler, authMiddleware)
 
@@ -118,11 +129,33 @@ func (httpSvc *HttpService) RegisterSharedRoutes(e *echo.Echo) {
 
 	e.POST("/api/send-payment-probes", httpSvc.sendPaymentProbesHandler, authMiddleware)
 	e.POST("/api/send-spontaneous-payment-probes", httpSvc.sendSpontaneousPaymentProbesHandler, authMiddleware)
+	e.POST("/api/estimate-fee", httpSvc.estimateFeeHandler, authMiddleware)
 	e.GET("/api/log/:type", httpSvc.getLogOutputHandler, authMiddleware)
+	e.GET("/api/transactions/export", httpSvc.exportTransactionsHandler, authMiddleware)
+	e.GET("/api/transactions", httpSvc.listTransactionsHandler, authMiddleware)
+	e.POST("/api/payments", httpSvc.sendPaymentHandler, authMiddleware)
+	e.POST("/api/invoices", httpSvc.makeInvoiceHandler, authMiddleware)
+	e.GET("/api/invoices/:paymentHash", httpSvc.lookupInvoiceHandler, authMiddleware)
+	e.GET("/api/labels", httpSvc.labelsListHandler, authMiddleware)
+	e.PUT("/api/labels", httpSvc.setLabelHandler, authMiddleware)
+	e.GET("/api/labels/:type/:ref", httpSvc.getLabelHandler, authMiddleware)
+	e.DELETE("/api/labels/:type/:ref", httpSvc.deleteLabelHandler, authMiddleware)
+	e.GET("/api/labels/export", httpSvc.exportLabelsHandler, authMiddleware)
+	e.POST("/api/labels/import", httpSvc.importLabelsHandler, authMiddleware)
+	e.GET("/api/contacts", httpSvc.contactsListHandler, authMiddleware)
+	e.POST("/api/contacts", httpSvc.contactsCreateHandler, authMiddleware)
+	e.PATCH("/api/contacts/:id", httpSvc.contactsUpdateHandler, authMiddleware)
+	e.DELETE("/api/contacts/:id", httpSvc.contactsDeleteHandler, authMiddleware)
 
 	e.POST("/api/backup", httpSvc.createBackupHandler, authMiddleware)
 	e.POST("/api/restore", httpSvc.restoreBackupHandler)
 
+	// LNURL-pay (LUD-06 / LUD-16) endpoints are public
+	// every callback creates an invoice on the node, allow five per second
+	lnurlCallbackRateLimiter := middleware.RateLimiter(middleware.NewRateLimiterMemoryStore(5))
+	e.GET("/.well-known/lnurlp/:username", httpSvc.lnurlPayParamsHandler)
+	e.GET("/lnurlp/:username/callback", httpSvc.lnurlPayCallbackHandler, lnurlCallbackRateLimiter)
+
 	frontend.RegisterHandlers(e)
 }
 
@@ -618,6 +651,80 @@ func (httpSvc *HttpService) appsShowHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
+func (httpSvc *HttpService) appsPaymentsHandler(c echo.Context) error {
+	app := db.App{}
+	findResult := httpSvc.db.Where("nostr_pubkey = ?", c.Param("pubkey")).First(&app)
+
+	if findResult.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Message: "App does not exist",
+		})
+	}
+
+	payments, err := httpSvc.api.ListAppPayments(&app)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Message: fmt.Sprintf("Failed to list payments: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, payments)
+}
+
+func (httpSvc *HttpService) appsEventsHandler(c echo.Context) error {
+	var listAppEventsRequest api.ListAppEventsRequest
+	if err := c.Bind(&listAppEventsRequest); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Bad request: %s", err.Error()),
+		})
+	}
+
+	app := db.App{}
+	findResult := httpSvc.db.Where("nostr_pubkey = ?", c.Param("pubkey")).First(&app)
+
+	if findResult.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Message: "App does not exist",
+		})
+	}
+
+	events, err := httpSvc.api.ListAppEvents(&app, &listAppEventsRequest)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Message: fmt.Sprintf("Failed to list events: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, events)
+}
+
+func (httpSvc *HttpService) appsStatsHandler(c echo.Context) error {
+	var getAppStatsRequest api.GetAppStatsRequest
+	if err := c.Bind(&getAppStatsRequest); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Bad request: %s", err.Error()),
+		})
+	}
+
+	app := db.App{}
+	findResult := httpSvc.db.Where("nostr_pubkey = ?", c.Param("pubkey")).First(&app)
+
+	if findResult.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, ErrorResponse{
+			Message: "App does not exist",
+		})
+	}
+
+	stats, err := httpSvc.api.GetAppStats(&app, &getAppStatsRequest)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Message: fmt.Sprintf("Failed to get app stats: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, stats)
+}
+
 func (httpSvc *HttpService) appsUpdateHandler(c echo.Context) error {
 	var requestData api.UpdateAppRequest
 	if err := c.Bind(&requestData); err != nil {
@@ -695,6 +802,130 @@ func (httpSvc *HttpService) appsCreateHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, responseBody)
 }
 
+func (httpSvc *HttpService) connectionRequestsListHandler(c echo.Context) error {
+	connectionRequests, err := httpSvc.api.ListConnectionRequests()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Message: fmt.Sprintf("Failed to list connection requests: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, connectionRequests)
+}
+
+func (httpSvc *HttpService) connectionRequestsCreateHandler(c echo.Context) error {
+	var requestData api.CreateConnectionRequestRequest
+	if err := c.Bind(&requestData); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Bad request: %s", err.Error()),
+		})
+	}
+
+	connectionRequest, err := httpSvc.api.CreateConnectionRequest(&requestData)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Failed to add connection request: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, connectionRequest)
+}
+
+func (httpSvc *HttpService) connectionRequestsApproveHandler(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Invalid connection request id: %v", err),
+		})
+	}
+
+	var requestData api.ApproveConnectionRequestRequest
+	if err := c.Bind(&requestData); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Bad request: %s", err.Error()),
+		})
+	}
+
+	connectionRequest, err := httpSvc.api.ApproveConnectionRequest(c.Request().Context(), uint(id), &requestData)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, api.ErrConnectionRequestNotFound) {
+			status = http.StatusNotFound
+		}
+		if errors.Is(err, api.ErrConnectionRequestExpired) {
+			status = http.StatusGone
+		}
+		return c.JSON(status, ErrorResponse{
+			Message: fmt.Sprintf("Failed to approve connection request: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, connectionRequest)
+}
+
+func (httpSvc *HttpService) connectionRequestsRejectHandler(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Invalid connection request id: %v", err),
+		})
+	}
+
+	err = httpSvc.api.RejectConnectionRequest(c.Request().Context(), uint(id))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, api.ErrConnectionRequestNotFound) {
+			status = http.StatusNotFound
+		}
+		if errors.Is(err, api.ErrConnectionRequestExpired) {
+			status = http.StatusGone
+		}
+		return c.JSON(status, ErrorResponse{
+			Message: fmt.Sprintf("Failed to reject connection request: %v", err),
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (httpSvc *HttpService) lnurlPayParamsHandler(c echo.Context) error {
+	payParams, err := httpSvc.api.GetLnurlPayParams(c.Param("username"))
+	if err != nil {
+		return httpSvc.lnurlErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, payParams)
+}
+
+func (httpSvc *HttpService) lnurlPayCallbackHandler(c echo.Context) error {
+	amount, err := strconv.ParseInt(c.QueryParam("amount"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, lnurl.ErrorResponse{
+			Status: lnurl.STATUS_ERROR,
+			Reason: "Invalid amount",
+		})
+	}
+
+	callbackResponse, err := httpSvc.api.LnurlPayCallback(c.Request().Context(), c.Param("username"), amount, c.QueryParam("comment"))
+	if err != nil {
+		httpSvc.logger.WithField("username", c.Param("username")).WithError(err).Error("Failed to create lightning address invoice")
+		return httpSvc.lnurlErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, callbackResponse)
+}
+
+func (httpSvc *HttpService) lnurlErrorResponse(c echo.Context, err error) error {
+	status := http.StatusBadRequest
+	if errors.Is(err, api.ErrLightningAddressNotFound) {
+		status = http.StatusNotFound
+	}
+	return c.JSON(status, lnurl.ErrorResponse{
+		Status: lnurl.STATUS_ERROR,
+		Reason: err.Error(),
+	})
+}
+
 func (httpSvc *HttpService) setupHandler(c echo.Context) error {
 	var setupRequest api.SetupRequest
 	if err := c.Bind(&setupRequest); err != nil {
@@ -749,6 +980,24 @@ func (httpSvc *HttpService) sendSpontaneousPaymentProbesHandler(c echo.Context)
 	return c.JSON(http.StatusOK, sendSpontaneousPaymentProbesResponse)
 }
 
+func (httpSvc *HttpService) estimateFeeHandler(c echo.Context) error {
+	var estimateFeeRequest api.EstimateFeeRequest
+	if err := c.Bind(&estimateFeeRequest); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Bad request: %s", err.Error()),
+		})
+	}
+
+	estimateFeeResponse, err := httpSvc.api.EstimateFee(c.Request().Context(), &estimateFeeRequest)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Message: fmt.Sprintf("Failed to estimate fee: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, estimateFeeResponse)
+}
+
 func (httpSvc *HttpService) getLogOutputHandler(c echo.Context) error {
 	var getLogRequest api.GetLogOutputRequest
 	if err := c.Bind(&getLogRequest); err != nil {
@@ -774,6 +1023,298 @@ func (httpSvc *HttpService) getLogOutputHandler(c echo.Context) error {
 	return c.JSON(http.StatusOK, getLogResponse)
 }
 
+func (httpSvc *HttpService) sendPaymentHandler(c echo.Context) error {
+	var sendPaymentRequest api.SendPaymentRequest
+	if err := c.Bind(&sendPaymentRequest); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Bad request: %s", err.Error()),
+		})
+	}
+
+	payResponse, err := httpSvc.api.SendPayment(c.Request().Context(), &sendPaymentRequest)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Message: fmt.Sprintf("Failed to send payment: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, payResponse)
+}
+
+func (httpSvc *HttpService) makeInvoiceHandler(c echo.Context) error {
+	var makeInvoiceRequest api.MakeInvoiceRequest
+	if err := c.Bind(&makeInvoiceRequest); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Bad request: %s", err.Error()),
+		})
+	}
+
+	invoice, err := httpSvc.api.MakeInvoice(c.Request().Context(), &makeInvoiceRequest)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Message: fmt.Sprintf("Failed to make invoice: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, invoice)
+}
+
+func (httpSvc *HttpService) lookupInvoiceHandler(c echo.Context) error {
+	invoice, err := httpSvc.api.LookupInvoice(c.Request().Context(), c.Param("paymentHash"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Message: fmt.Sprintf("Failed to lookup invoice: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, invoice)
+}
+
+func (httpSvc *HttpService) listTransactionsHandler(c echo.Context) error {
+	var listTransactionsRequest api.ListTransactionsRequest
+	if err := c.Bind(&listTransactionsRequest); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Bad request: %s", err.Error()),
+		})
+	}
+
+	transactions, err := httpSvc.api.ListTransactions(c.Request().Context(), &listTransactionsRequest)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Message: fmt.Sprintf("Failed to list transactions: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, transactions)
+}
+
+func (httpSvc *HttpService) exportTransactionsHandler(c echo.Context) error {
+	var exportRequest api.ExportTransactionsRequest
+	if err := c.Bind(&exportRequest); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Bad request: %s", err.Error()),
+		})
+	}
+
+	contentType := "text/csv"
+	switch exportRequest.Format {
+	case "":
+		exportRequest.Format = api.ExportFormatCSV
+	case api.ExportFormatCSV:
+	case api.ExportFormatJSON:
+		contentType = "application/json"
+	default:
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Invalid export format: '%s'", exportRequest.Format),
+		})
+	}
+
+	// streamed, so an error after the first row can only be logged
+	c.Response().Header().Set("Content-Type", contentType)
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=transactions.%s", exportRequest.Format))
+	c.Response().WriteHeader(http.StatusOK)
+	err := httpSvc.api.ExportTransactions(c.Request().Context(), &exportRequest, c.Response())
+	if err != nil {
+		httpSvc.logger.WithError(err).Error("Failed to export transactions")
+	}
+	return nil
+}
+
+func (httpSvc *HttpService) labelsListHandler(c echo.Context) error {
+	labels, err := httpSvc.api.ListLabels()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Message: fmt.Sprintf("Failed to list labels: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, labels)
+}
+
+func (httpSvc *HttpService) setLabelHandler(c echo.Context) error {
+	var label api.Label
+	if err := c.Bind(&label); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Bad request: %s", err.Error()),
+		})
+	}
+
+	err := httpSvc.api.SetLabel(&label)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Failed to set label: %v", err),
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (httpSvc *HttpService) getLabelHandler(c echo.Context) error {
+	ref, err := url.PathUnescape(c.Param("ref"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Invalid label reference: %v", err),
+		})
+	}
+
+	label, err := httpSvc.api.GetLabel(c.Param("type"), ref)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, api.ErrLabelNotFound) {
+			status = http.StatusNotFound
+		}
+		return c.JSON(status, ErrorResponse{
+			Message: fmt.Sprintf("Failed to get label: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, label)
+}
+
+func (httpSvc *HttpService) deleteLabelHandler(c echo.Context) error {
+	ref, err := url.PathUnescape(c.Param("ref"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Invalid label reference: %v", err),
+		})
+	}
+
+	err = httpSvc.api.DeleteLabel(c.Param("type"), ref)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Message: fmt.Sprintf("Failed to delete label: %v", err),
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (httpSvc *HttpService) exportLabelsHandler(c echo.Context) error {
+	var buffer bytes.Buffer
+	err := httpSvc.api.ExportLabels(&buffer)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Message: fmt.Sprintf("Failed to export labels: %v", err),
+		})
+	}
+
+	c.Response().Header().Set("Content-Type", "application/jsonl")
+	c.Response().Header().Set("Content-Disposition", "attachment; filename=labels.jsonl")
+	c.Response().WriteHeader(http.StatusOK)
+	c.Response().Write(buffer.Bytes())
+	return nil
+}
+
+// importLabelsHandler takes a BIP-329 file upload, or the JSONL as the request body
+func (httpSvc *HttpService) importLabelsHandler(c echo.Context) error {
+	var reader io.Reader = c.Request().Body
+	if strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		fileHeader, err := c.FormFile("labels")
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, ErrorResponse{
+				Message: fmt.Sprintf("Failed to get labels file header: %v", err),
+			})
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, ErrorResponse{
+				Message: fmt.Sprintf("Failed to open labels file: %v", err),
+			})
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	importLabelsResponse, err := httpSvc.api.ImportLabels(reader)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Failed to import labels: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, importLabelsResponse)
+}
+
+func (httpSvc *HttpService) contactsListHandler(c echo.Context) error {
+	contacts, err := httpSvc.api.ListContacts()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Message: fmt.Sprintf("Failed to list contacts: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, contacts)
+}
+
+func (httpSvc *HttpService) contactsCreateHandler(c echo.Context) error {
+	var contactRequest api.ContactRequest
+	if err := c.Bind(&contactRequest); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Bad request: %s", err.Error()),
+		})
+	}
+
+	contact, err := httpSvc.api.CreateContact(&contactRequest)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Failed to create contact: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, contact)
+}
+
+func (httpSvc *HttpService) contactsUpdateHandler(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Invalid contact id: %v", err),
+		})
+	}
+
+	var contactRequest api.ContactRequest
+	if err := c.Bind(&contactRequest); err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Bad request: %s", err.Error()),
+		})
+	}
+
+	contact, err := httpSvc.api.UpdateContact(uint(id), &contactRequest)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, api.ErrContactNotFound) {
+			status = http.StatusNotFound
+		}
+		return c.JSON(status, ErrorResponse{
+			Message: fmt.Sprintf("Failed to update contact: %v", err),
+		})
+	}
+
+	return c.JSON(http.StatusOK, contact)
+}
+
+func (httpSvc *HttpService) contactsDeleteHandler(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, ErrorResponse{
+			Message: fmt.Sprintf("Invalid contact id: %v", err),
+		})
+	}
+
+	err = httpSvc.api.DeleteContact(uint(id))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, api.ErrContactNotFound) {
+			status = http.StatusNotFound
+		}
+		return c.JSON(status, ErrorResponse{
+			Message: fmt.Sprintf("Failed to delete contact: %v", err),
+		})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
 func (httpSvc *HttpService) createBackupHandler(c echo.Context) error {
 	var backupRequest api.BasicBackupRequest
 	if err := c.Bind(&backupRequest); err != nil {
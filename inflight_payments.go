@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/lnclient"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	"github.com/sirupsen/logrus"
+)
+
+type inflightPayment struct {
+	appId    uint
+	done     chan struct{}
+	response *nip47.Response
+	// set for async payments, retries get it immediately instead of waiting
+	pendingResponse *nip47.Response
+}
+
+// inflightPayments tracks the invoices currently being paid by payment hash,
+// so that a retried request does not pay the same invoice again
+type inflightPayments struct {
+	mu       sync.Mutex
+	payments map[string]*inflightPayment
+}
+
+// beginPayment returns the response to publish if the invoice was already paid or is being paid.
+// Otherwise the payment is marked in flight and nil is returned; the caller must then call endPayment.
+func (svc *Service) beginPayment(ctx context.Context, nip47Request *nip47.Request, requestEvent *db.RequestEvent, app *db.App, paymentHash string) *nip47.Response {
+	svc.inflightPayments.mu.Lock()
+	if svc.inflightPayments.payments == nil {
+		svc.inflightPayments.payments = map[string]*inflightPayment{}
+	}
+
+	if inflight, ok := svc.inflightPayments.payments[paymentHash]; ok {
+		pendingResponse := inflight.pendingResponse
+		svc.inflightPayments.mu.Unlock()
+		if inflight.appId != app.ID {
+			return duplicatePaymentResponse(nip47Request, "Invoice is already being paid")
+		}
+		if pendingResponse != nil {
+			response := *pendingResponse
+			response.ResultType = nip47Request.Method
+			return &response
+		}
+
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+			"paymentHash":         paymentHash,
+		}).Info("Payment already in flight, waiting for its result")
+
+		select {
+		case <-inflight.done:
+		case <-ctx.Done():
+			return duplicatePaymentResponse(nip47Request, "Invoice is already being paid")
+		}
+		if inflight.response == nil {
+			return duplicatePaymentResponse(nip47Request, "Payment of this invoice did not complete")
+		}
+		response := *inflight.response
+		response.ResultType = nip47Request.Method
+		return &response
+	}
+	defer svc.inflightPayments.mu.Unlock()
+
+	existingPayment := db.Payment{}
+	result := svc.db.Where("payment_hash = ? AND preimage IS NOT NULL", paymentHash).Limit(1).Find(&existingPayment)
+	if result.Error != nil {
+		return &nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_INTERNAL,
+				Message: result.Error.Error(),
+			},
+		}
+	}
+	if result.RowsAffected > 0 {
+		if existingPayment.AppId != app.ID {
+			return duplicatePaymentResponse(nip47Request, "Invoice has already been paid")
+		}
+
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+			"paymentHash":         paymentHash,
+			"paymentId":           existingPayment.ID,
+		}).Info("Invoice already paid, returning existing preimage")
+		return &nip47.Response{
+			ResultType: nip47Request.Method,
+			Result: nip47.PayResponse{
+				Preimage: *existingPayment.Preimage,
+			},
+		}
+	}
+
+	// a payment can still be pending without being in flight here, when the node had not finished it when the hub restarted
+	pendingPayment := db.Payment{}
+	result = svc.db.Where("payment_hash = ? AND state = ?", paymentHash, db.PAYMENT_STATE_PENDING).Limit(1).Find(&pendingPayment)
+	if result.Error != nil {
+		return &nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_INTERNAL,
+				Message: result.Error.Error(),
+			},
+		}
+	}
+	if result.RowsAffected > 0 {
+		if pendingPayment.AppId != app.ID {
+			return duplicatePaymentResponse(nip47Request, "Invoice is already being paid")
+		}
+
+		svc.logger.WithFields(logrus.Fields{
+			"requestEventNostrId": requestEvent.NostrId,
+			"appId":               app.ID,
+			"paymentHash":         paymentHash,
+			"paymentId":           pendingPayment.ID,
+		}).Info("Payment of the invoice is still pending, not paying again")
+		return &nip47.Response{
+			ResultType: nip47Request.Method,
+			Result: nip47.PayResponse{
+				Status: nip47.PAYMENT_STATUS_PENDING,
+			},
+		}
+	}
+
+	svc.inflightPayments.payments[paymentHash] = &inflightPayment{
+		appId: app.ID,
+		done:  make(chan struct{}),
+	}
+	return nil
+}
+
+// markPaymentPending makes retried requests for an async payment return the pending response right away
+func (svc *Service) markPaymentPending(paymentHash string, response *nip47.Response) {
+	svc.inflightPayments.mu.Lock()
+	defer svc.inflightPayments.mu.Unlock()
+
+	if inflight, ok := svc.inflightPayments.payments[paymentHash]; ok {
+		inflight.pendingResponse = response
+	}
+}
+
+// endPayment releases requests waiting for the in-flight payment with the response that was published for it
+func (svc *Service) endPayment(paymentHash string, response *nip47.Response) {
+	svc.inflightPayments.mu.Lock()
+	defer svc.inflightPayments.mu.Unlock()
+
+	inflight, ok := svc.inflightPayments.payments[paymentHash]
+	if !ok {
+		return
+	}
+	inflight.response = response
+	close(inflight.done)
+	delete(svc.inflightPayments.payments, paymentHash)
+}
+
+// startPendingPaymentsResolution settles or fails the payments which were still pending when the hub stopped,
+// so that their invoices can be paid again and their amounts stop counting against budgets and isolated balances.
+// It runs before requests are handled and is repeated until the node finished every such payment.
+func (svc *Service) startPendingPaymentsResolution(ctx context.Context) {
+	startedAt := time.Now()
+	if svc.resolvePendingPayments(ctx, startedAt) {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(transactionsReconcileInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if svc.resolvePendingPayments(ctx, startedAt) {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// resolvePendingPayments looks up the payments created before the hub started which are still pending,
+// and returns whether none of them is left pending
+func (svc *Service) resolvePendingPayments(ctx context.Context, startedAt time.Time) bool {
+	pendingPayments := []db.Payment{}
+	err := svc.db.Where("state = ? AND created_at < ?", db.PAYMENT_STATE_PENDING, startedAt).Find(&pendingPayments).Error
+	if err != nil {
+		svc.logger.WithError(err).Error("Failed to list pending payments")
+		return false
+	}
+
+	resolved := true
+	for i := range pendingPayments {
+		payment := &pendingPayments[i]
+		logger := svc.logger.WithFields(logrus.Fields{
+			"paymentId":   payment.ID,
+			"appId":       payment.AppId,
+			"paymentHash": payment.PaymentHash,
+		})
+
+		if payment.PaymentHash == "" {
+			// keysend payments get their hash from the node when they succeed, so there is nothing to look up
+			logger.Info("Outcome of pending keysend payment is unknown, marking it as failed")
+			svc.paymentFailed(payment, errors.New("the hub stopped before the outcome of the payment was known"))
+			continue
+		}
+
+		transaction, err := svc.lookupPayment(ctx, payment.PaymentHash)
+		if errors.Is(err, lnclient.ErrPaymentNotFound) {
+			logger.Info("Pending payment was not made by the node, marking it as failed")
+			svc.paymentFailed(payment, errors.New("the hub stopped before the payment was made"))
+			continue
+		}
+		if err != nil {
+			logger.WithError(err).Error("Failed to look up pending payment")
+			resolved = false
+			continue
+		}
+
+		switch transaction.State {
+		case nip47.TRANSACTION_STATE_SETTLED:
+			logger.Info("Pending payment succeeded")
+			feeMsat := uint64(transaction.FeesPaid)
+			svc.paymentSucceeded(payment, transaction.Preimage, &feeMsat)
+		case nip47.TRANSACTION_STATE_FAILED:
+			logger.Info("Pending payment failed")
+			svc.paymentFailed(payment, errors.New("the payment failed after the hub stopped"))
+		default:
+			resolved = false
+		}
+	}
+	return resolved
+}
+
+// lookupPayment returns the outgoing payment of the hash from the LN backend,
+// searching its transactions if the backend cannot look payments up
+func (svc *Service) lookupPayment(ctx context.Context, paymentHash string) (*nip47.Transaction, error) {
+	transaction, err := svc.lnClient.LookupPayment(ctx, paymentHash)
+	if !errors.Is(err, lnclient.ErrNotImplemented) {
+		return transaction, err
+	}
+
+	seen := map[string]bool{}
+	for offset := uint64(0); ; offset += transactionsReconcilePageSize {
+		transactions, err := svc.lnClient.ListTransactions(ctx, 0, 0, transactionsReconcilePageSize, offset, true, "outgoing")
+		if err != nil {
+			return nil, err
+		}
+
+		found := false
+		for i := range transactions {
+			if transactions[i].PaymentHash == paymentHash {
+				transaction := &transactions[i]
+				if transaction.State == "" {
+					transaction.State = nip47.TRANSACTION_STATE_PENDING
+					if transaction.SettledAt != nil {
+						transaction.State = nip47.TRANSACTION_STATE_SETTLED
+					}
+				}
+				return transaction, nil
+			}
+			if !seen[transactions[i].PaymentHash] {
+				seen[transactions[i].PaymentHash] = true
+				found = true
+			}
+		}
+		// some backends return the last page again past the end
+		if !found {
+			return nil, lnclient.ErrPaymentNotFound
+		}
+	}
+}
+
+func duplicatePaymentResponse(nip47Request *nip47.Request, message string) *nip47.Response {
+	return &nip47.Response{
+		ResultType: nip47Request.Method,
+		Error: &nip47.Error{
+			Code:    nip47.ERROR_BAD_REQUEST,
+			Message: message,
+		},
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/events"
+	"github.com/getAlby/nostr-wallet-connect/lnclient"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+	"github.com/sirupsen/logrus"
+)
+
+// sendInvoicePayment settles invoices of apps on this hub internally and pays other invoices over the network
+func (svc *Service) sendInvoicePayment(ctx context.Context, app *db.App, bolt11 string, paymentRequest *decodepay.Bolt11, maxFeeMsat *uint64) (*lnclient.PayInvoiceResponse, error) {
+	internalInvoice := svc.findInternalInvoice(ctx, paymentRequest)
+	if internalInvoice == nil {
+		return svc.lnClient.SendPaymentSync(ctx, bolt11, svc.paymentOptions(app, maxFeeMsat))
+	}
+
+	response, err := svc.settleInternalInvoice(ctx, paymentRequest, internalInvoice)
+	if err != nil {
+		return nil, err
+	}
+	nodeType, _ := svc.cfg.Get("LNBackendType", "")
+	svc.eventPublisher.Publish(&events.Event{
+		Event: "nwc_payment_received",
+		Properties: &events.PaymentReceivedEventProperties{
+			PaymentHash: paymentRequest.PaymentHash,
+			Amount:      uint64(paymentRequest.MSatoshi / 1000),
+			NodeType:    nodeType,
+			Internal:    true,
+		},
+	})
+	return response, nil
+}
+
+// findInternalInvoice returns the invoice if it was created for an app on this hub.
+// Most backends cannot pay their own invoices, so these are settled in the database instead.
+func (svc *Service) findInternalInvoice(ctx context.Context, paymentRequest *decodepay.Bolt11) *db.Invoice {
+	// amountless invoices are paid over the network, we would not know what to credit
+	if paymentRequest.MSatoshi == 0 {
+		return nil
+	}
+	// the node invoice must be cancelled once settled internally, otherwise it could still be paid over the network
+	if !svc.supportsFeature(lnclient.FeatureHoldInvoices) {
+		return nil
+	}
+
+	info, err := svc.lnClient.GetInfo(ctx)
+	if err != nil {
+		svc.logger.WithError(err).Error("Failed to get node info")
+		return nil
+	}
+	if info.Pubkey != paymentRequest.Payee {
+		return nil
+	}
+
+	invoice := db.Invoice{}
+	// the preimage of hold invoices is only known to the app
+	result := svc.db.Where("payment_hash = ? AND hold = ?", paymentRequest.PaymentHash, false).Limit(1).Find(&invoice)
+	if result.Error != nil {
+		svc.logger.WithField("paymentHash", paymentRequest.PaymentHash).WithError(result.Error).Error("Failed to find invoice")
+		return nil
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+	return &invoice
+}
+
+// settleInternalInvoice claims an invoice of another app on this hub for the payer.
+// The preimage is taken from the node, which never sees a payment for the invoice.
+func (svc *Service) settleInternalInvoice(ctx context.Context, paymentRequest *decodepay.Bolt11, invoice *db.Invoice) (*lnclient.PayInvoiceResponse, error) {
+	transaction, err := svc.lnClient.LookupInvoice(ctx, invoice.PaymentHash)
+	if err != nil {
+		return nil, err
+	}
+	if transaction.SettledAt != nil {
+		return nil, errors.New("invoice has already been paid")
+	}
+	if transaction.ExpiresAt != nil && time.Now().Unix() > *transaction.ExpiresAt {
+		return nil, errors.New("invoice has expired")
+	}
+	if transaction.Preimage == "" {
+		return nil, errors.New("preimage of the invoice is unknown")
+	}
+
+	result := svc.db.Model(invoice).Where("settled_at IS NULL").Update("settled_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.New("invoice has already been paid")
+	}
+
+	err = svc.lnClient.CancelHoldInvoice(ctx, invoice.PaymentHash)
+	if err != nil {
+		svc.logger.WithField("paymentHash", invoice.PaymentHash).WithError(err).Error("Failed to cancel internally settled invoice")
+		svc.db.Model(invoice).Update("settled_at", nil)
+		return nil, fmt.Errorf("failed to cancel the invoice on the node: %w", err)
+	}
+
+	svc.logger.WithFields(logrus.Fields{
+		"paymentHash": invoice.PaymentHash,
+		"appId":       invoice.AppId,
+		"amount":      paymentRequest.MSatoshi,
+	}).Info("Settled invoice internally")
+
+	return &lnclient.PayInvoiceResponse{
+		Preimage: transaction.Preimage,
+	}, nil
+}
+
+// withInternalSettlement reports an invoice settled internally as paid, the node has it cancelled
+func (svc *Service) withInternalSettlement(transaction *nip47.Transaction) *nip47.Transaction {
+	if transaction.SettledAt != nil {
+		return transaction
+	}
+
+	invoice := db.Invoice{}
+	result := svc.db.Where("payment_hash = ? AND settled_at IS NOT NULL", transaction.PaymentHash).Limit(1).Find(&invoice)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return transaction
+	}
+
+	settled := *transaction
+	settledAt := invoice.SettledAt.Unix()
+	settled.SettledAt = &settledAt
+	settled.State = nip47.TRANSACTION_STATE_SETTLED
+	return &settled
+}
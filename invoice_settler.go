@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/events"
+)
+
+// InvoiceSettler records payments to invoices created for apps, which are credited to isolated apps
+type InvoiceSettler struct {
+	svc *Service
+}
+
+func NewInvoiceSettler(svc *Service) *InvoiceSettler {
+	return &InvoiceSettler{
+		svc: svc,
+	}
+}
+
+func (settler *InvoiceSettler) ConsumeEvent(ctx context.Context, event *events.Event, globalProperties map[string]interface{}) error {
+	if event.Event != "nwc_payment_received" {
+		return nil
+	}
+
+	paymentReceivedEventProperties, ok := event.Properties.(*events.PaymentReceivedEventProperties)
+	if !ok {
+		settler.svc.logger.WithField("event", event).Error("Failed to cast event")
+		return errors.New("failed to cast event")
+	}
+	if paymentReceivedEventProperties.Internal {
+		// already settled by the paying app
+		return nil
+	}
+
+	// the amount paid is recorded as it can differ from the invoice amount, e.g. for invoices without amount
+	return settler.svc.db.Model(&db.Invoice{}).
+		Where("payment_hash = ? AND settled_at IS NULL", paymentReceivedEventProperties.PaymentHash).
+		Updates(map[string]interface{}{
+			"settled_at": time.Now(),
+			"amount":     paymentReceivedEventProperties.Amount,
+		}).Error
+}
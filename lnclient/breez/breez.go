@@ -103,7 +103,11 @@ func (bs *BreezService) Shutdown() error {
 	return bs.svc.Disconnect()
 }
 
-func (bs *BreezService) SendPaymentSync(ctx context.Context, payReq string) (*lnclient.PayInvoiceResponse, error) {
+func (bs *BreezService) SendPaymentSync(ctx context.Context, payReq string, options lnclient.PaymentOptions) (*lnclient.PayInvoiceResponse, error) {
+	if options.MaxFeeMsat != nil {
+		// the SDK applies its own fee limit
+		return nil, lnclient.ErrMaxFeeNotSupported
+	}
 	sendPaymentRequest := breez_sdk.SendPaymentRequest{
 		Bolt11: payReq,
 	}
@@ -121,7 +125,10 @@ func (bs *BreezService) SendPaymentSync(ctx context.Context, payReq string) (*ln
 
 }
 
-func (bs *BreezService) SendKeysend(ctx context.Context, amount int64, destination, preimage string, custom_records []lnclient.TLVRecord) (preImage string, err error) {
+func (bs *BreezService) SendKeysend(ctx context.Context, amount int64, destination, preimage string, custom_records []lnclient.TLVRecord, options lnclient.PaymentOptions) (*lnclient.PayInvoiceResponse, error) {
+	if options.MaxFeeMsat != nil {
+		return nil, lnclient.ErrMaxFeeNotSupported
+	}
 	extraTlvs := []breez_sdk.TlvEntry{}
 	for _, record := range custom_records {
 		extraTlvs = append(extraTlvs, breez_sdk.TlvEntry{
@@ -137,13 +144,16 @@ func (bs *BreezService) SendKeysend(ctx context.Context, amount int64, destinati
 	}
 	resp, err := bs.svc.SendSpontaneousPayment(sendSpontaneousPaymentRequest)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	var lnDetails breez_sdk.PaymentDetailsLn
 	if resp.Payment.Details != nil {
 		lnDetails, _ = resp.Payment.Details.(breez_sdk.PaymentDetailsLn)
 	}
-	return lnDetails.Data.PaymentPreimage, nil
+	return &lnclient.PayInvoiceResponse{
+		Preimage: lnDetails.Data.PaymentPreimage,
+		Fee:      &resp.Payment.FeeMsat,
+	}, nil
 }
 
 func (bs *BreezService) GetBalance(ctx context.Context) (balance int64, err error) {
@@ -192,6 +202,26 @@ func (bs *BreezService) MakeInvoice(ctx context.Context, amount int64, descripti
 	return tx, nil
 }
 
+func (bs *BreezService) MakeHoldInvoice(ctx context.Context, amount int64, description string, descriptionHash string, expiry int64, paymentHash string) (transaction *nip47.Transaction, err error) {
+	return nil, errors.New("hold invoices are not supported by Breez")
+}
+
+func (bs *BreezService) SettleHoldInvoice(ctx context.Context, preimage string) (err error) {
+	return errors.New("hold invoices are not supported by Breez")
+}
+
+func (bs *BreezService) CancelHoldInvoice(ctx context.Context, paymentHash string) (err error) {
+	return errors.New("hold invoices are not supported by Breez")
+}
+
+func (bs *BreezService) SubscribeHoldInvoice(ctx context.Context, paymentHash string) (err error) {
+	return errors.New("hold invoices are not supported by Breez")
+}
+
+func (bs *BreezService) LookupPayment(ctx context.Context, paymentHash string) (transaction *nip47.Transaction, err error) {
+	return nil, lnclient.ErrNotImplemented
+}
+
 func (bs *BreezService) LookupInvoice(ctx context.Context, paymentHash string) (transaction *nip47.Transaction, err error) {
 	log.Printf("p: %v", paymentHash)
 	payment, err := bs.svc.PaymentByHash(paymentHash)
@@ -412,6 +442,10 @@ func (bs *BreezService) SendSpontaneousPaymentProbes(ctx context.Context, amount
 	return nil
 }
 
+func (bs *BreezService) EstimateFee(ctx context.Context, request *lnclient.EstimateFeeRequest) (*lnclient.FeeEstimate, error) {
+	return nil, lnclient.ErrNotImplemented
+}
+
 func (bs *BreezService) ListPeers(ctx context.Context) ([]lnclient.PeerDetails, error) {
 	return nil, nil
 }
@@ -466,6 +500,9 @@ func (bs *BreezService) GetNetworkGraph(nodeIds []string) (lnclient.NetworkGraph
 }
 
 func (bs *BreezService) UpdateLastWalletSyncRequest() {}
+func (bs *BreezService) GetSupportedFeatures() []string {
+	return []string{}
+}
 
 func (bs *BreezService) DisconnectPeer(ctx context.Context, peerId string) error {
 	return nil
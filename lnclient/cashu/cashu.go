@@ -3,12 +3,14 @@ package cashu
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"path/filepath"
 	"sort"
 	"time"
 
+	"github.com/elnosh/gonuts/cashu/nuts/nut05"
 	"github.com/elnosh/gonuts/wallet"
 	"github.com/elnosh/gonuts/wallet/storage"
 	"github.com/getAlby/nostr-wallet-connect/lnclient"
@@ -59,7 +61,19 @@ func (cs *CashuService) Shutdown() error {
 	return nil
 }
 
-func (cs *CashuService) SendPaymentSync(ctx context.Context, invoice string) (response *lnclient.PayInvoiceResponse, err error) {
+func (cs *CashuService) SendPaymentSync(ctx context.Context, invoice string, options lnclient.PaymentOptions) (response *lnclient.PayInvoiceResponse, err error) {
+	if options.MaxFeeMsat != nil {
+		// the mint reserves the fee up front, so the quote tells whether the limit can be met
+		meltQuoteResponse, err := wallet.PostMeltQuoteBolt11(cs.wallet.CurrentMint(), nut05.PostMeltQuoteBolt11Request{Request: invoice, Unit: "sat"})
+		if err != nil {
+			cs.logger.WithError(err).Error("Failed to request melt quote")
+			return nil, err
+		}
+		if meltQuoteResponse.FeeReserve*1000 > *options.MaxFeeMsat {
+			return nil, fmt.Errorf("%w: mint fee reserve is %d sats", lnclient.ErrMaxFeeExceeded, meltQuoteResponse.FeeReserve)
+		}
+	}
+
 	meltResponse, err := cs.wallet.Melt(invoice, cs.wallet.CurrentMint())
 	if err != nil {
 		cs.logger.WithError(err).Error("Failed to melt invoice")
@@ -91,8 +105,8 @@ func (cs *CashuService) SendPaymentSync(ctx context.Context, invoice string) (re
 	}, nil
 }
 
-func (cs *CashuService) SendKeysend(ctx context.Context, amount int64, destination, preimage string, custom_records []lnclient.TLVRecord) (preImage string, err error) {
-	return "", errors.New("Keysend not supported")
+func (cs *CashuService) SendKeysend(ctx context.Context, amount int64, destination, preimage string, custom_records []lnclient.TLVRecord, options lnclient.PaymentOptions) (*lnclient.PayInvoiceResponse, error) {
+	return nil, errors.New("Keysend not supported")
 }
 
 func (cs *CashuService) GetBalance(ctx context.Context) (balance int64, err error) {
@@ -124,6 +138,26 @@ func (cs *CashuService) MakeInvoice(ctx context.Context, amount int64, descripti
 	return cs.LookupInvoice(ctx, paymentRequest.PaymentHash)
 }
 
+func (cs *CashuService) MakeHoldInvoice(ctx context.Context, amount int64, description string, descriptionHash string, expiry int64, paymentHash string) (transaction *nip47.Transaction, err error) {
+	return nil, errors.New("Hold invoices not supported")
+}
+
+func (cs *CashuService) SettleHoldInvoice(ctx context.Context, preimage string) (err error) {
+	return errors.New("Hold invoices not supported")
+}
+
+func (cs *CashuService) CancelHoldInvoice(ctx context.Context, paymentHash string) (err error) {
+	return errors.New("Hold invoices not supported")
+}
+
+func (cs *CashuService) SubscribeHoldInvoice(ctx context.Context, paymentHash string) (err error) {
+	return errors.New("Hold invoices not supported")
+}
+
+func (cs *CashuService) LookupPayment(ctx context.Context, paymentHash string) (transaction *nip47.Transaction, err error) {
+	return nil, lnclient.ErrNotImplemented
+}
+
 func (cs *CashuService) LookupInvoice(ctx context.Context, paymentHash string) (transaction *nip47.Transaction, err error) {
 	cashuInvoice := cs.wallet.GetInvoiceByPaymentHash(paymentHash)
 
@@ -242,6 +276,10 @@ func (cs *CashuService) GetNetworkGraph(nodeIds []string) (lnclient.NetworkGraph
 }
 func (cs *CashuService) UpdateLastWalletSyncRequest() {}
 
+func (cs *CashuService) GetSupportedFeatures() []string {
+	return []string{lnclient.FeatureMaxFee}
+}
+
 func (cs *CashuService) GetNodeStatus(ctx context.Context) (nodeStatus *lnclient.NodeStatus, err error) {
 	return nil, nil
 }
@@ -253,6 +291,10 @@ func (cs *CashuService) SendSpontaneousPaymentProbes(ctx context.Context, amount
 	return nil
 }
 
+func (cs *CashuService) EstimateFee(ctx context.Context, request *lnclient.EstimateFeeRequest) (*lnclient.FeeEstimate, error) {
+	return nil, lnclient.ErrNotImplemented
+}
+
 func (cs *CashuService) GetBalances(ctx context.Context) (*lnclient.BalancesResponse, error) {
 	balance, err := cs.GetBalance(ctx)
 	if err != nil {
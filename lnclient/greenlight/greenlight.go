@@ -113,7 +113,12 @@ func (gs *GreenlightService) Shutdown() error {
 	return nil
 }
 
-func (gs *GreenlightService) SendPaymentSync(ctx context.Context, payReq string) (*lnclient.PayInvoiceResponse, error) {
+func (gs *GreenlightService) SendPaymentSync(ctx context.Context, payReq string, options lnclient.PaymentOptions) (*lnclient.PayInvoiceResponse, error) {
+	if options.MaxFeeMsat != nil {
+		// TODO: pass maxfee once glalby exposes it on PayRequest
+		return nil, lnclient.ErrMaxFeeNotSupported
+	}
+
 	response, err := gs.client.Pay(glalby.PayRequest{
 		Bolt11: payReq,
 	})
@@ -128,7 +133,10 @@ func (gs *GreenlightService) SendPaymentSync(ctx context.Context, payReq string)
 	}, nil
 }
 
-func (gs *GreenlightService) SendKeysend(ctx context.Context, amount int64, destination, preimage string, custom_records []lnclient.TLVRecord) (preImage string, err error) {
+func (gs *GreenlightService) SendKeysend(ctx context.Context, amount int64, destination, preimage string, custom_records []lnclient.TLVRecord, options lnclient.PaymentOptions) (*lnclient.PayInvoiceResponse, error) {
+	if options.MaxFeeMsat != nil {
+		return nil, lnclient.ErrMaxFeeNotSupported
+	}
 
 	extraTlvs := []glalby.TlvEntry{}
 
@@ -149,10 +157,13 @@ func (gs *GreenlightService) SendKeysend(ctx context.Context, amount int64, dest
 
 	if err != nil {
 		gs.logger.Errorf("Failed to send keysend payment: %v", err)
-		return "", err
+		return nil, err
 	}
 
-	return response.PaymentPreimage, nil
+	// the fee is not part of the keysend response
+	return &lnclient.PayInvoiceResponse{
+		Preimage: response.PaymentPreimage,
+	}, nil
 }
 
 func (gs *GreenlightService) GetBalance(ctx context.Context) (balance int64, err error) {
@@ -213,6 +224,26 @@ func (gs *GreenlightService) MakeInvoice(ctx context.Context, amount int64, desc
 	return transaction, nil
 }
 
+func (gs *GreenlightService) MakeHoldInvoice(ctx context.Context, amount int64, description string, descriptionHash string, expiry int64, paymentHash string) (transaction *nip47.Transaction, err error) {
+	return nil, errors.New("hold invoices are not supported by Greenlight")
+}
+
+func (gs *GreenlightService) SettleHoldInvoice(ctx context.Context, preimage string) (err error) {
+	return errors.New("hold invoices are not supported by Greenlight")
+}
+
+func (gs *GreenlightService) CancelHoldInvoice(ctx context.Context, paymentHash string) (err error) {
+	return errors.New("hold invoices are not supported by Greenlight")
+}
+
+func (gs *GreenlightService) SubscribeHoldInvoice(ctx context.Context, paymentHash string) (err error) {
+	return errors.New("hold invoices are not supported by Greenlight")
+}
+
+func (gs *GreenlightService) LookupPayment(ctx context.Context, paymentHash string) (transaction *nip47.Transaction, err error) {
+	return nil, lnclient.ErrNotImplemented
+}
+
 func (gs *GreenlightService) LookupInvoice(ctx context.Context, paymentHash string) (transaction *nip47.Transaction, err error) {
 	response, err := gs.client.ListInvoices(glalby.ListInvoicesRequest{
 		PaymentHash: &paymentHash,
@@ -523,6 +554,10 @@ func (gs *GreenlightService) SendSpontaneousPaymentProbes(ctx context.Context, a
 	return nil
 }
 
+func (gs *GreenlightService) EstimateFee(ctx context.Context, request *lnclient.EstimateFeeRequest) (*lnclient.FeeEstimate, error) {
+	return nil, lnclient.ErrNotImplemented
+}
+
 func (gs *GreenlightService) ListPeers(ctx context.Context) ([]lnclient.PeerDetails, error) {
 	return nil, nil
 }
@@ -667,6 +702,10 @@ func (gs *GreenlightService) GetNetworkGraph(nodeIds []string) (lnclient.Network
 
 func (gs *GreenlightService) UpdateLastWalletSyncRequest() {}
 
+func (gs *GreenlightService) GetSupportedFeatures() []string {
+	return []string{}
+}
+
 func (gs *GreenlightService) DisconnectPeer(ctx context.Context, peerId string) error {
 	return nil
 }
@@ -376,7 +376,12 @@ func (ls *LDKService) resetRouterInternal() {
 	}
 }
 
-func (ls *LDKService) SendPaymentSync(ctx context.Context, invoice string) (*lnclient.PayInvoiceResponse, error) {
+func (ls *LDKService) SendPaymentSync(ctx context.Context, invoice string, options lnclient.PaymentOptions) (*lnclient.PayInvoiceResponse, error) {
+	if options.MaxFeeMsat != nil {
+		// TODO: pass max_total_routing_fee_msat once ldk-node exposes sending parameters
+		return nil, lnclient.ErrMaxFeeNotSupported
+	}
+
 	paymentRequest, err := decodepay.Decodepay(invoice)
 	if err != nil {
 		ls.logger.WithFields(logrus.Fields{
@@ -492,7 +497,11 @@ func (ls *LDKService) SendPaymentSync(ctx context.Context, invoice string) (*lnc
 	}, nil
 }
 
-func (ls *LDKService) SendKeysend(ctx context.Context, amount int64, destination, preimage string, custom_records []lnclient.TLVRecord) (preImage string, err error) {
+func (ls *LDKService) SendKeysend(ctx context.Context, amount int64, destination, preimage string, custom_records []lnclient.TLVRecord, options lnclient.PaymentOptions) (*lnclient.PayInvoiceResponse, error) {
+	if options.MaxFeeMsat != nil {
+		return nil, lnclient.ErrMaxFeeNotSupported
+	}
+
 	paymentStart := time.Now()
 	customTlvs := []ldk_node.TlvEntry{}
 
@@ -509,7 +518,7 @@ func (ls *LDKService) SendKeysend(ctx context.Context, amount int64, destination
 	paymentHash, err := ls.node.SpontaneousPayment().Send(uint64(amount), destination, customTlvs)
 	if err != nil {
 		ls.logger.WithError(err).Error("Keysend failed")
-		return "", err
+		return nil, err
 	}
 
 	fee := uint64(0)
@@ -525,7 +534,7 @@ func (ls *LDKService) SendKeysend(ctx context.Context, amount int64, destination
 			payment := ls.node.Payment(paymentHash)
 			if payment == nil {
 				ls.logger.Errorf("Couldn't find payment by payment hash: %v", paymentHash)
-				return "", errors.New("Payment not found")
+				return nil, errors.New("Payment not found")
 			}
 
 			spontaneousPaymentKind, ok := payment.Kind.(ldk_node.PaymentKindSpontaneous)
@@ -538,7 +547,7 @@ func (ls *LDKService) SendKeysend(ctx context.Context, amount int64, destination
 
 			if spontaneousPaymentKind.Preimage == nil {
 				ls.logger.Errorf("No payment preimage for payment hash: %v", paymentHash)
-				return "", errors.New("Payment preimage not found")
+				return nil, errors.New("Payment preimage not found")
 			}
 			preimage = *spontaneousPaymentKind.Preimage
 
@@ -576,19 +585,22 @@ func (ls *LDKService) SendKeysend(ctx context.Context, amount int64, destination
 				"failureReasonMessage": failureReasonMessage,
 			}).Error("Received payment failed event")
 
-			return "", fmt.Errorf("payment failed event: %v %s", failureReason, failureReasonMessage)
+			return nil, fmt.Errorf("payment failed event: %v %s", failureReason, failureReasonMessage)
 		}
 	}
 	if preimage == "" {
 		// TODO: this doesn't necessarily mean it will fail - we should return a different response
-		return "", errors.New("keysend payment timed out")
+		return nil, errors.New("keysend payment timed out")
 	}
 
 	ls.logger.WithFields(logrus.Fields{
 		"duration": time.Since(paymentStart).Milliseconds(),
 		"fee":      fee,
 	}).Info("Successful keysend payment")
-	return preimage, nil
+	return &lnclient.PayInvoiceResponse{
+		Preimage: preimage,
+		Fee:      &fee,
+	}, nil
 }
 
 func (ls *LDKService) GetBalance(ctx context.Context) (balance int64, err error) {
@@ -680,6 +692,23 @@ func (ls *LDKService) MakeInvoice(ctx context.Context, amount int64, description
 	return transaction, nil
 }
 
+// TODO: ldk-node does not allow claiming payments manually yet, which hold invoices depend on
+func (ls *LDKService) MakeHoldInvoice(ctx context.Context, amount int64, description string, descriptionHash string, expiry int64, paymentHash string) (transaction *nip47.Transaction, err error) {
+	return nil, errors.New("hold invoices are not supported by this version of LDK node")
+}
+
+func (ls *LDKService) SettleHoldInvoice(ctx context.Context, preimage string) (err error) {
+	return errors.New("hold invoices are not supported by this version of LDK node")
+}
+
+func (ls *LDKService) CancelHoldInvoice(ctx context.Context, paymentHash string) (err error) {
+	return errors.New("hold invoices are not supported by this version of LDK node")
+}
+
+func (ls *LDKService) SubscribeHoldInvoice(ctx context.Context, paymentHash string) (err error) {
+	return errors.New("hold invoices are not supported by this version of LDK node")
+}
+
 func (ls *LDKService) LookupInvoice(ctx context.Context, paymentHash string) (transaction *nip47.Transaction, err error) {
 
 	payment := ls.node.Payment(paymentHash)
@@ -698,6 +727,21 @@ func (ls *LDKService) LookupInvoice(ctx context.Context, paymentHash string) (tr
 	return transaction, nil
 }
 
+func (ls *LDKService) LookupPayment(ctx context.Context, paymentHash string) (transaction *nip47.Transaction, err error) {
+	payment := ls.node.Payment(paymentHash)
+	if payment == nil || payment.Direction != ldk_node.PaymentDirectionOutbound {
+		return nil, lnclient.ErrPaymentNotFound
+	}
+
+	transaction, err = ls.ldkPaymentToTransaction(payment)
+	if err != nil {
+		ls.logger.Errorf("Failed to map transaction: %v", err)
+		return nil, err
+	}
+
+	return transaction, nil
+}
+
 func (ls *LDKService) ListTransactions(ctx context.Context, from, until, limit, offset uint64, unpaid bool, invoiceType string) (transactions []nip47.Transaction, err error) {
 	transactions = []nip47.Transaction{}
 
@@ -1000,6 +1044,8 @@ func (ls *LDKService) ldkPaymentToTransaction(payment *ldk_node.PaymentDetails)
 		// keysend payment
 		// currently no access to created at or the TLVs to get the description
 		// TODO: store these in NWC database
+		// Metadata stays empty: neither PaymentKindSpontaneous nor EventPaymentReceived carry the custom TLVs in this
+		// version of ldk-node. Fill it with lnclient.TLVRecordsToMetadata once they are exposed.
 		lastUpdate := int64(payment.LastUpdate)
 		// TODO: use proper created at time
 		createdAt = lastUpdate
@@ -1022,8 +1068,17 @@ func (ls *LDKService) ldkPaymentToTransaction(payment *ldk_node.PaymentDetails)
 		fee = *payment.FeeMsat
 	}
 
+	state := nip47.TRANSACTION_STATE_PENDING
+	switch payment.Status {
+	case ldk_node.PaymentStatusSucceeded:
+		state = nip47.TRANSACTION_STATE_SETTLED
+	case ldk_node.PaymentStatusFailed:
+		state = nip47.TRANSACTION_STATE_FAILED
+	}
+
 	return &nip47.Transaction{
 		Type:            transactionType,
+		State:           state,
 		Preimage:        preimage,
 		PaymentHash:     paymentHash,
 		SettledAt:       settledAt,
@@ -1057,6 +1112,12 @@ func (ls *LDKService) SendSpontaneousPaymentProbes(ctx context.Context, amountMs
 	return nil
 }
 
+// EstimateFee cannot be implemented with probes yet: the ldk-node bindings the hub is built with
+// can send probes (SendProbes) but return neither their route nor their fee, and emit no probe events
+func (ls *LDKService) EstimateFee(ctx context.Context, request *lnclient.EstimateFeeRequest) (*lnclient.FeeEstimate, error) {
+	return nil, fmt.Errorf("the ldk-node bindings do not report the route or fee of probes: %w", lnclient.ErrNotImplemented)
+}
+
 func (ls *LDKService) ListPeers(ctx context.Context) ([]lnclient.PeerDetails, error) {
 	peers := ls.node.ListPeers()
 	ret := make([]lnclient.PeerDetails, 0, len(peers))
@@ -1301,6 +1362,11 @@ func (ls *LDKService) UpdateLastWalletSyncRequest() {
 	ls.lastWalletSyncRequest = time.Now()
 }
 
+// hold invoices are left out until ldk-node allows claiming payments manually
+func (ls *LDKService) GetSupportedFeatures() []string {
+	return []string{lnclient.FeaturePaymentReceivedEvents}
+}
+
 func (ls *LDKService) getChannelCloseReason(event *ldk_node.EventChannelClosed) string {
 	var reason string
 
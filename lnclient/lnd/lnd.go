@@ -6,12 +6,15 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
-	"sort"
+	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	decodepay "github.com/nbd-wtf/ln-decodepay"
 
+	"github.com/getAlby/nostr-wallet-connect/config"
+	"github.com/getAlby/nostr-wallet-connect/events"
 	"github.com/getAlby/nostr-wallet-connect/lnclient"
 	"github.com/getAlby/nostr-wallet-connect/lnclient/lnd/wrapper"
 	"github.com/getAlby/nostr-wallet-connect/nip47"
@@ -20,6 +23,10 @@ import (
 	// "gorm.io/gorm"
 
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // wrap it again :sweat_smile:
@@ -27,7 +34,13 @@ import (
 type LNDService struct {
 	client *wrapper.LNDWrapper
 	// db     *gorm.DB
-	Logger *logrus.Logger
+	Logger         *logrus.Logger
+	ctx            context.Context
+	cancel         context.CancelFunc
+	eventPublisher events.EventPublisher
+	// where the last pages of ListTransactions stopped, by their parameters and offset
+	listPositions   map[string]lndListPosition
+	listPositionsMu sync.Mutex
 }
 
 func (svc *LNDService) GetBalance(ctx context.Context) (balance int64, err error) {
@@ -38,90 +51,140 @@ func (svc *LNDService) GetBalance(ctx context.Context) (balance int64, err error
 	return int64(resp.LocalBalance.Msat), nil
 }
 
+// maxListBatchSize bounds the invoices and payments fetched from LND at once
+const maxListBatchSize = 1000
+
+// maxListPositions bounds the remembered positions of ListTransactions pages
+const maxListPositions = 32
+
+// lndListPosition is how far a walk through the invoices and payments of LND, newest first, got:
+// the index offsets of the last invoice and payment taken, and whether none are left
+type lndListPosition struct {
+	invoiceIndexOffset uint64
+	paymentIndexOffset uint64
+	invoicesDone       bool
+	paymentsDone       bool
+}
+
 func (svc *LNDService) ListTransactions(ctx context.Context, from, until, limit, offset uint64, unpaid bool, invoiceType string) (transactions []nip47.Transaction, err error) {
-	// Fetch invoices
-	var invoices []*lnrpc.Invoice
-	if invoiceType == "" || invoiceType == "incoming" {
-		incomingResp, err := svc.client.ListInvoices(ctx, &lnrpc.ListInvoiceRequest{Reversed: true, NumMaxInvoices: limit, IndexOffset: offset})
-		if err != nil {
-			return nil, err
+	// LND pages invoices and payments separately by their index, not by a count of skipped entries,
+	// so where a page ended is remembered and the next page continues from there with the index offsets
+	positionKey := fmt.Sprintf("%t:%s", unpaid, invoiceType)
+	position := svc.listPosition(positionKey, offset)
+	if position == nil {
+		position = &lndListPosition{
+			invoicesDone: invoiceType == "outgoing",
+			paymentsDone: invoiceType == "incoming",
 		}
-		invoices = incomingResp.Invoices
-	}
-	for _, invoice := range invoices {
-		// this will cause retrieved amount to be less than limit if unpaid is false
-		if !unpaid && invoice.State != lnrpc.Invoice_SETTLED {
-			continue
+		for skipped := uint64(0); skipped < offset; {
+			skippedTransactions, err := svc.nextTransactions(ctx, position, min(offset-skipped, maxListBatchSize), unpaid)
+			if err != nil {
+				return nil, err
+			}
+			if len(skippedTransactions) == 0 {
+				return []nip47.Transaction{}, nil
+			}
+			skipped += uint64(len(skippedTransactions))
 		}
-
-		transaction := lndInvoiceToTransaction(invoice)
-		transactions = append(transactions, *transaction)
 	}
-	// Fetch payments
-	var payments []*lnrpc.Payment
-	if invoiceType == "" || invoiceType == "outgoing" {
-		// Not just pending but failed payments will also be included because of IncludeIncomplete
-		outgoingResp, err := svc.client.ListPayments(ctx, &lnrpc.ListPaymentsRequest{Reversed: true, MaxPayments: limit, IndexOffset: offset, IncludeIncomplete: unpaid})
-		if err != nil {
-			return nil, err
-		}
-		payments = outgoingResp.Payments
+
+	transactions, err = svc.nextTransactions(ctx, position, limit, unpaid)
+	if err != nil {
+		return nil, err
 	}
-	for _, payment := range payments {
-		if payment.Status == lnrpc.Payment_FAILED {
-			// don't return failed payments for now
-			// this will cause retrieved amount to be less than limit
-			continue
+	svc.storeListPosition(positionKey, offset+uint64(len(transactions)), position)
+	return transactions, nil
+}
+
+// nextTransactions takes up to count transactions, or all if count is 0, which pass the unpaid filter
+// from the position on, newest first, and moves the position past them
+func (svc *LNDService) nextTransactions(ctx context.Context, position *lndListPosition, count uint64, unpaid bool) ([]nip47.Transaction, error) {
+	transactions := []nip47.Transaction{}
+	for (count == 0 || uint64(len(transactions)) < count) && !(position.invoicesDone && position.paymentsDone) {
+		batchSize := uint64(maxListBatchSize)
+		if count != 0 {
+			batchSize = min(count-uint64(len(transactions)), maxListBatchSize)
 		}
-		var paymentRequest decodepay.Bolt11
-		var expiresAt *int64
-		var description string
-		var descriptionHash string
-		if payment.PaymentRequest != "" {
-			paymentRequest, err = decodepay.Decodepay(strings.ToLower(payment.PaymentRequest))
-			if err != nil {
-				svc.Logger.WithFields(logrus.Fields{
-					"bolt11": payment.PaymentRequest,
-				}).Errorf("Failed to decode bolt11 invoice: %v", err)
 
+		var invoices []*lnrpc.Invoice
+		if !position.invoicesDone {
+			invoicesResp, err := svc.client.ListInvoices(ctx, &lnrpc.ListInvoiceRequest{Reversed: true, IndexOffset: position.invoiceIndexOffset, NumMaxInvoices: batchSize})
+			if err != nil {
 				return nil, err
 			}
-			expiresAtUnix := time.UnixMilli(int64(paymentRequest.CreatedAt) * 1000).Add(time.Duration(paymentRequest.Expiry) * time.Second).Unix()
-			expiresAt = &expiresAtUnix
-			description = paymentRequest.Description
-			descriptionHash = paymentRequest.DescriptionHash
+			invoices = invoicesResp.Invoices
 		}
-
-		var settledAt *int64
-		if payment.Status == lnrpc.Payment_SUCCEEDED {
-			// FIXME: how to get the actual settled at time?
-			settledAtUnix := time.Unix(0, payment.CreationTimeNs).Unix()
-			settledAt = &settledAtUnix
+		var payments []*lnrpc.Payment
+		if !position.paymentsDone {
+			// pending and failed payments are included as well, the unpaid filter decides
+			paymentsResp, err := svc.client.ListPayments(ctx, &lnrpc.ListPaymentsRequest{Reversed: true, IndexOffset: position.paymentIndexOffset, MaxPayments: batchSize, IncludeIncomplete: true})
+			if err != nil {
+				return nil, err
+			}
+			payments = paymentsResp.Payments
 		}
+		// a short batch is the last one
+		lastInvoices := uint64(len(invoices)) < batchSize
+		lastPayments := uint64(len(payments)) < batchSize
+
+		i, j := 0, 0
+		for count == 0 || uint64(len(transactions)) < count {
+			if i == len(invoices) && lastInvoices {
+				position.invoicesDone = true
+			}
+			if j == len(payments) && lastPayments {
+				position.paymentsDone = true
+			}
+			// which transaction is next is only known while neither batch is used up
+			if (i == len(invoices) && !position.invoicesDone) || (j == len(payments) && !position.paymentsDone) ||
+				(position.invoicesDone && position.paymentsDone) {
+				break
+			}
 
-		transaction := nip47.Transaction{
-			Type:            "outgoing",
-			Invoice:         payment.PaymentRequest,
-			Preimage:        payment.PaymentPreimage,
-			PaymentHash:     payment.PaymentHash,
-			Amount:          payment.ValueMsat,
-			FeesPaid:        payment.FeeMsat,
-			CreatedAt:       time.Unix(0, payment.CreationTimeNs).Unix(),
-			Description:     description,
-			DescriptionHash: descriptionHash,
-			ExpiresAt:       expiresAt,
-			SettledAt:       settledAt,
-			//TODO: Metadata:  (e.g. keysend),
+			if j == len(payments) || (i < len(invoices) && invoices[i].CreationDate*int64(time.Second) >= payments[j].CreationTimeNs) {
+				invoice := invoices[i]
+				i++
+				position.invoiceIndexOffset = invoice.AddIndex
+				if !unpaid && invoice.State != lnrpc.Invoice_SETTLED {
+					continue
+				}
+				transactions = append(transactions, *lndInvoiceToTransaction(invoice))
+			} else {
+				payment := payments[j]
+				j++
+				position.paymentIndexOffset = payment.PaymentIndex
+				if !unpaid && payment.Status != lnrpc.Payment_SUCCEEDED {
+					continue
+				}
+				transaction, err := svc.lndPaymentToTransaction(payment)
+				if err != nil {
+					return nil, err
+				}
+				transactions = append(transactions, *transaction)
+			}
 		}
-		transactions = append(transactions, transaction)
 	}
+	return transactions, nil
+}
 
-	// sort by created date descending
-	sort.SliceStable(transactions, func(i, j int) bool {
-		return transactions[i].CreatedAt > transactions[j].CreatedAt
-	})
+// listPosition returns a copy of where the page ending at offset stopped, nil if it is not known
+func (svc *LNDService) listPosition(key string, offset uint64) *lndListPosition {
+	svc.listPositionsMu.Lock()
+	defer svc.listPositionsMu.Unlock()
+	position, ok := svc.listPositions[fmt.Sprintf("%s:%d", key, offset)]
+	if !ok {
+		return nil
+	}
+	return &position
+}
 
-	return transactions, nil
+func (svc *LNDService) storeListPosition(key string, offset uint64, position *lndListPosition) {
+	svc.listPositionsMu.Lock()
+	defer svc.listPositionsMu.Unlock()
+	if svc.listPositions == nil || len(svc.listPositions) >= maxListPositions {
+		svc.listPositions = map[string]lndListPosition{}
+	}
+	svc.listPositions[fmt.Sprintf("%s:%d", key, offset)] = *position
 }
 
 func (svc *LNDService) GetInfo(ctx context.Context) (info *lnclient.NodeInfo, err error) {
@@ -175,6 +238,169 @@ func (svc *LNDService) MakeInvoice(ctx context.Context, amount int64, descriptio
 	return transaction, nil
 }
 
+func (svc *LNDService) MakeHoldInvoice(ctx context.Context, amount int64, description string, descriptionHash string, expiry int64, paymentHash string) (transaction *nip47.Transaction, err error) {
+	paymentHashBytes, err := hex.DecodeString(paymentHash)
+	if err != nil || len(paymentHashBytes) != 32 {
+		svc.Logger.WithFields(logrus.Fields{
+			"paymentHash": paymentHash,
+		}).Errorf("Invalid payment hash")
+		return nil, errors.New("payment hash must be 32 bytes hex")
+	}
+
+	var descriptionHashBytes []byte
+	if descriptionHash != "" {
+		descriptionHashBytes, err = hex.DecodeString(descriptionHash)
+
+		if err != nil || len(descriptionHashBytes) != 32 {
+			svc.Logger.WithFields(logrus.Fields{
+				"amount":          amount,
+				"description":     description,
+				"descriptionHash": descriptionHash,
+				"expiry":          expiry,
+			}).Errorf("Invalid description hash")
+			return nil, errors.New("description hash must be 32 bytes hex")
+		}
+	}
+
+	_, err = svc.client.AddHoldInvoice(ctx, &invoicesrpc.AddHoldInvoiceRequest{Hash: paymentHashBytes, ValueMsat: amount, Memo: description, DescriptionHash: descriptionHashBytes, Expiry: expiry})
+	if err != nil {
+		return nil, err
+	}
+
+	inv, err := svc.client.LookupInvoice(ctx, &lnrpc.PaymentHash{RHash: paymentHashBytes})
+	if err != nil {
+		return nil, err
+	}
+
+	go svc.subscribeHoldInvoice(paymentHashBytes)
+
+	transaction = lndInvoiceToTransaction(inv)
+	return transaction, nil
+}
+
+func (svc *LNDService) SubscribeHoldInvoice(ctx context.Context, paymentHash string) (err error) {
+	paymentHashBytes, err := hex.DecodeString(paymentHash)
+	if err != nil || len(paymentHashBytes) != 32 {
+		return errors.New("payment hash must be 32 bytes hex")
+	}
+
+	go svc.subscribeHoldInvoice(paymentHashBytes)
+	return nil
+}
+
+// the regular invoice subscription does not report accepted HTLCs,
+// so each hold invoice gets its own subscription until it is settled or canceled
+func (svc *LNDService) subscribeHoldInvoice(paymentHashBytes []byte) {
+	paymentHash := hex.EncodeToString(paymentHashBytes)
+	stream, err := svc.client.SubscribeSingleInvoice(svc.ctx, &invoicesrpc.SubscribeSingleInvoiceRequest{RHash: paymentHashBytes})
+	if err != nil {
+		svc.Logger.WithField("paymentHash", paymentHash).WithError(err).Error("Failed to subscribe to hold invoice")
+		return
+	}
+
+	for {
+		invoice, err := stream.Recv()
+		if err != nil {
+			if svc.ctx.Err() == nil {
+				svc.Logger.WithField("paymentHash", paymentHash).WithError(err).Error("Hold invoice subscription failed")
+			}
+			return
+		}
+
+		switch invoice.State {
+		case lnrpc.Invoice_ACCEPTED:
+			svc.Logger.WithFields(logrus.Fields{
+				"paymentHash": paymentHash,
+				"amountPaid":  invoice.AmtPaidMsat,
+			}).Info("Hold invoice accepted")
+			svc.eventPublisher.Publish(&events.Event{
+				Event: "nwc_hold_invoice_accepted",
+				Properties: &events.HoldInvoiceAcceptedEventProperties{
+					PaymentHash: paymentHash,
+					Amount:      uint64(invoice.AmtPaidMsat / 1000),
+					NodeType:    config.LNDBackendType,
+				},
+			})
+		case lnrpc.Invoice_SETTLED, lnrpc.Invoice_CANCELED:
+			return
+		}
+	}
+}
+
+// subscribeInvoices resubscribes until the service is shut down, invoices settled while
+// the subscription was down are replayed from the last seen settle index
+func (svc *LNDService) subscribeInvoices() {
+	var settleIndex uint64
+	for {
+		err := svc.receiveInvoices(&settleIndex)
+		if svc.ctx.Err() != nil {
+			return
+		}
+		svc.Logger.WithError(err).Error("Invoice subscription failed, resubscribing")
+		select {
+		case <-svc.ctx.Done():
+			return
+		case <-time.After(10 * time.Second):
+		}
+	}
+}
+
+func (svc *LNDService) receiveInvoices(settleIndex *uint64) error {
+	stream, err := svc.client.SubscribeInvoices(svc.ctx, &lnrpc.InvoiceSubscription{SettleIndex: *settleIndex})
+	if err != nil {
+		return err
+	}
+
+	for {
+		invoice, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+
+		if invoice.State != lnrpc.Invoice_SETTLED {
+			continue
+		}
+		if invoice.SettleIndex > *settleIndex {
+			*settleIndex = invoice.SettleIndex
+		}
+
+		paymentHash := hex.EncodeToString(invoice.RHash)
+		svc.Logger.WithFields(logrus.Fields{
+			"paymentHash": paymentHash,
+			"amountPaid":  invoice.AmtPaidMsat,
+			"isKeysend":   invoice.IsKeysend,
+		}).Info("Received payment")
+		svc.eventPublisher.Publish(&events.Event{
+			Event: "nwc_payment_received",
+			Properties: &events.PaymentReceivedEventProperties{
+				PaymentHash: paymentHash,
+				Amount:      uint64(invoice.AmtPaidMsat / 1000),
+				NodeType:    config.LNDBackendType,
+			},
+		})
+	}
+}
+
+func (svc *LNDService) SettleHoldInvoice(ctx context.Context, preimage string) (err error) {
+	preimageBytes, err := hex.DecodeString(preimage)
+	if err != nil || len(preimageBytes) != 32 {
+		return errors.New("preimage must be 32 bytes hex")
+	}
+
+	_, err = svc.client.SettleInvoice(ctx, &invoicesrpc.SettleInvoiceMsg{Preimage: preimageBytes})
+	return err
+}
+
+func (svc *LNDService) CancelHoldInvoice(ctx context.Context, paymentHash string) (err error) {
+	paymentHashBytes, err := hex.DecodeString(paymentHash)
+	if err != nil || len(paymentHashBytes) != 32 {
+		return errors.New("payment hash must be 32 bytes hex")
+	}
+
+	_, err = svc.client.CancelInvoice(ctx, &invoicesrpc.CancelInvoiceMsg{PaymentHash: paymentHashBytes})
+	return err
+}
+
 func (svc *LNDService) LookupInvoice(ctx context.Context, paymentHash string) (transaction *nip47.Transaction, err error) {
 	paymentHashBytes, err := hex.DecodeString(paymentHash)
 
@@ -194,20 +420,61 @@ func (svc *LNDService) LookupInvoice(ctx context.Context, paymentHash string) (t
 	return transaction, nil
 }
 
-func (svc *LNDService) SendPaymentSync(ctx context.Context, payReq string) (*lnclient.PayInvoiceResponse, error) {
-	resp, err := svc.client.SendPaymentSync(ctx, &lnrpc.SendRequest{PaymentRequest: payReq})
+func (svc *LNDService) LookupPayment(ctx context.Context, paymentHash string) (transaction *nip47.Transaction, err error) {
+	paymentHashBytes, err := hex.DecodeString(paymentHash)
+
+	if err != nil || len(paymentHashBytes) != 32 {
+		svc.Logger.WithFields(logrus.Fields{
+			"paymentHash": paymentHash,
+		}).Errorf("Invalid payment hash")
+		return nil, errors.New("Payment hash must be 32 bytes hex")
+	}
+
+	// the first update of a tracked payment is its current state, the stream is closed after it
+	trackCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	paymentStream, err := svc.client.SubscribePayment(trackCtx, &routerrpc.TrackPaymentRequest{PaymentHash: paymentHashBytes})
+	if err != nil {
+		return nil, err
+	}
+	payment, err := paymentStream.Recv()
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, lnclient.ErrPaymentNotFound
+		}
+		return nil, err
+	}
+
+	return svc.lndPaymentToTransaction(payment)
+}
+
+func (svc *LNDService) SendPaymentSync(ctx context.Context, payReq string, options lnclient.PaymentOptions) (*lnclient.PayInvoiceResponse, error) {
+	resp, err := svc.client.SendPaymentSync(ctx, &lnrpc.SendRequest{PaymentRequest: payReq, FeeLimit: lndFeeLimit(options)})
 	if err != nil {
 		return nil, err
 	}
+	if resp.PaymentError != "" {
+		return nil, lndPaymentError(resp.PaymentError, options)
+	}
 	return &lnclient.PayInvoiceResponse{
 		Preimage: hex.EncodeToString(resp.PaymentPreimage),
+		Fee:      lndRouteFee(resp.PaymentRoute),
 	}, nil
 }
 
-func (svc *LNDService) SendKeysend(ctx context.Context, amount int64, destination, preimage string, custom_records []lnclient.TLVRecord) (respPreimage string, err error) {
+// lndRouteFee returns the routing fee of a payment in msat, nil if LND did not report the route
+func lndRouteFee(route *lnrpc.Route) *uint64 {
+	if route == nil {
+		return nil
+	}
+	fee := uint64(route.TotalFeesMsat)
+	return &fee
+}
+
+func (svc *LNDService) SendKeysend(ctx context.Context, amount int64, destination, preimage string, custom_records []lnclient.TLVRecord, options lnclient.PaymentOptions) (*lnclient.PayInvoiceResponse, error) {
 	destBytes, err := hex.DecodeString(destination)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	var preImageBytes []byte
 
@@ -225,7 +492,7 @@ func (svc *LNDService) SendKeysend(ctx context.Context, amount int64, destinatio
 			"customRecords": custom_records,
 			"error":         err,
 		}).Errorf("Invalid preimage")
-		return "", err
+		return nil, err
 	}
 
 	paymentHash := sha256.New()
@@ -245,6 +512,7 @@ func (svc *LNDService) SendKeysend(ctx context.Context, amount int64, destinatio
 		PaymentHash:       paymentHashBytes,
 		DestFeatures:      []lnrpc.FeatureBit{lnrpc.FeatureBit_TLV_ONION_REQ},
 		DestCustomRecords: destCustomRecords,
+		FeeLimit:          lndFeeLimit(options),
 	}
 
 	resp, err := svc.client.SendPaymentSync(ctx, sendPaymentRequest)
@@ -257,7 +525,7 @@ func (svc *LNDService) SendKeysend(ctx context.Context, amount int64, destinatio
 			"customRecords": custom_records,
 			"error":         err,
 		}).Errorf("Failed to send keysend payment")
-		return "", err
+		return nil, err
 	}
 	if resp.PaymentError != "" {
 		svc.Logger.WithFields(logrus.Fields{
@@ -268,9 +536,9 @@ func (svc *LNDService) SendKeysend(ctx context.Context, amount int64, destinatio
 			"customRecords": custom_records,
 			"paymentError":  resp.PaymentError,
 		}).Errorf("Keysend payment has payment error")
-		return "", errors.New(resp.PaymentError)
+		return nil, lndPaymentError(resp.PaymentError, options)
 	}
-	respPreimage = hex.EncodeToString(resp.PaymentPreimage)
+	respPreimage := hex.EncodeToString(resp.PaymentPreimage)
 	if respPreimage == "" {
 		svc.Logger.WithFields(logrus.Fields{
 			"amount":        amount,
@@ -280,7 +548,7 @@ func (svc *LNDService) SendKeysend(ctx context.Context, amount int64, destinatio
 			"customRecords": custom_records,
 			"paymentError":  resp.PaymentError,
 		}).Errorf("No preimage in keysend response")
-		return "", errors.New("no preimage in keysend response")
+		return nil, errors.New("no preimage in keysend response")
 	}
 	svc.Logger.WithFields(logrus.Fields{
 		"amount":        amount,
@@ -291,7 +559,10 @@ func (svc *LNDService) SendKeysend(ctx context.Context, amount int64, destinatio
 		"respPreimage":  respPreimage,
 	}).Info("Keysend payment successful")
 
-	return respPreimage, nil
+	return &lnclient.PayInvoiceResponse{
+		Preimage: respPreimage,
+		Fee:      lndRouteFee(resp.PaymentRoute),
+	}, nil
 }
 
 func makePreimageHex() ([]byte, error) {
@@ -303,7 +574,7 @@ func makePreimageHex() ([]byte, error) {
 	return bytes, nil
 }
 
-func NewLNDService(ctx context.Context, logger *logrus.Logger, lndAddress, lndCertHex, lndMacaroonHex string) (result lnclient.LNClient, err error) {
+func NewLNDService(ctx context.Context, logger *logrus.Logger, eventPublisher events.EventPublisher, lndAddress, lndCertHex, lndMacaroonHex string) (result lnclient.LNClient, err error) {
 	if lndAddress == "" || lndCertHex == "" || lndMacaroonHex == "" {
 		return nil, errors.New("one or more required LND configuration are missing")
 	}
@@ -322,14 +593,18 @@ func NewLNDService(ctx context.Context, logger *logrus.Logger, lndAddress, lndCe
 		return nil, err
 	}
 
-	lndService := &LNDService{client: lndClient, Logger: logger}
+	lndCtx, cancel := context.WithCancel(ctx)
+	lndService := &LNDService{client: lndClient, Logger: logger, ctx: lndCtx, cancel: cancel, eventPublisher: eventPublisher}
 
 	logger.Infof("Connected to LND - alias %s", info.Alias)
 
+	go lndService.subscribeInvoices()
+
 	return lndService, nil
 }
 
 func (svc *LNDService) Shutdown() error {
+	svc.cancel()
 	return nil
 }
 
@@ -368,6 +643,47 @@ func (svc *LNDService) SendSpontaneousPaymentProbes(ctx context.Context, amountM
 	return nil
 }
 
+func (svc *LNDService) EstimateFee(ctx context.Context, request *lnclient.EstimateFeeRequest) (*lnclient.FeeEstimate, error) {
+	queryRoutesRequest := &lnrpc.QueryRoutesRequest{
+		PubKey:            request.Destination,
+		AmtMsat:           int64(request.AmountMsat),
+		UseMissionControl: true,
+	}
+
+	if request.Invoice != "" {
+		payReq, err := svc.client.DecodeBolt11(ctx, request.Invoice)
+		if err != nil {
+			return nil, err
+		}
+		queryRoutesRequest.PubKey = payReq.Destination
+		if payReq.NumMsat > 0 {
+			queryRoutesRequest.AmtMsat = payReq.NumMsat
+		}
+		queryRoutesRequest.FinalCltvDelta = int32(payReq.CltvExpiry)
+		queryRoutesRequest.RouteHints = payReq.RouteHints
+		for featureBit := range payReq.Features {
+			queryRoutesRequest.DestFeatures = append(queryRoutesRequest.DestFeatures, lnrpc.FeatureBit(featureBit))
+		}
+	} else {
+		queryRoutesRequest.DestFeatures = []lnrpc.FeatureBit{lnrpc.FeatureBit_TLV_ONION_REQ}
+	}
+
+	resp, err := svc.client.QueryRoutes(ctx, queryRoutesRequest)
+	if err != nil {
+		// LND returns an error rather than an empty response if no route is found
+		return nil, fmt.Errorf("failed to query routes: %w", err)
+	}
+	if len(resp.Routes) == 0 {
+		return nil, errors.New("no route found")
+	}
+
+	fee := uint64(resp.Routes[0].TotalFeesMsat)
+	return &lnclient.FeeEstimate{
+		FeeMsat:            &fee,
+		SuccessProbability: resp.SuccessProb,
+	}, nil
+}
+
 func (svc *LNDService) ListPeers(ctx context.Context) ([]lnclient.PeerDetails, error) {
 	return nil, nil
 }
@@ -407,6 +723,54 @@ func (svc *LNDService) GetBalances(ctx context.Context) (*lnclient.BalancesRespo
 	}, nil
 }
 
+func (svc *LNDService) lndPaymentToTransaction(payment *lnrpc.Payment) (*nip47.Transaction, error) {
+	var expiresAt *int64
+	var description string
+	var descriptionHash string
+	if payment.PaymentRequest != "" {
+		paymentRequest, err := decodepay.Decodepay(strings.ToLower(payment.PaymentRequest))
+		if err != nil {
+			svc.Logger.WithFields(logrus.Fields{
+				"bolt11": payment.PaymentRequest,
+			}).Errorf("Failed to decode bolt11 invoice: %v", err)
+
+			return nil, err
+		}
+		expiresAtUnix := time.UnixMilli(int64(paymentRequest.CreatedAt) * 1000).Add(time.Duration(paymentRequest.Expiry) * time.Second).Unix()
+		expiresAt = &expiresAtUnix
+		description = paymentRequest.Description
+		descriptionHash = paymentRequest.DescriptionHash
+	}
+
+	state := nip47.TRANSACTION_STATE_PENDING
+	var settledAt *int64
+	switch payment.Status {
+	case lnrpc.Payment_SUCCEEDED:
+		state = nip47.TRANSACTION_STATE_SETTLED
+		// FIXME: how to get the actual settled at time?
+		settledAtUnix := time.Unix(0, payment.CreationTimeNs).Unix()
+		settledAt = &settledAtUnix
+	case lnrpc.Payment_FAILED:
+		state = nip47.TRANSACTION_STATE_FAILED
+	}
+
+	return &nip47.Transaction{
+		Type:            "outgoing",
+		State:           state,
+		Invoice:         payment.PaymentRequest,
+		Preimage:        payment.PaymentPreimage,
+		PaymentHash:     payment.PaymentHash,
+		Amount:          payment.ValueMsat,
+		FeesPaid:        payment.FeeMsat,
+		CreatedAt:       time.Unix(0, payment.CreationTimeNs).Unix(),
+		Description:     description,
+		DescriptionHash: descriptionHash,
+		ExpiresAt:       expiresAt,
+		SettledAt:       settledAt,
+		//TODO: Metadata:  (e.g. keysend),
+	}, nil
+}
+
 func lndInvoiceToTransaction(invoice *lnrpc.Invoice) *nip47.Transaction {
 	var settledAt *int64
 	var preimage string
@@ -421,6 +785,18 @@ func lndInvoiceToTransaction(invoice *lnrpc.Invoice) *nip47.Transaction {
 		expiresAt = &expiresAtUnix
 	}
 
+	// keysend payments carry their data (e.g. boostagrams) in the custom records of the HTLCs
+	var metadata interface{}
+	customRecords := map[uint64][]byte{}
+	for _, htlc := range invoice.Htlcs {
+		for tlvType, value := range htlc.CustomRecords {
+			customRecords[tlvType] = value
+		}
+	}
+	if tlvMetadata := lnclient.TLVRecordsToMetadata(customRecords); tlvMetadata != nil {
+		metadata = tlvMetadata
+	}
+
 	return &nip47.Transaction{
 		Type:            "incoming",
 		Invoice:         invoice.PaymentRequest,
@@ -433,7 +809,7 @@ func lndInvoiceToTransaction(invoice *lnrpc.Invoice) *nip47.Transaction {
 		CreatedAt:       invoice.CreationDate,
 		SettledAt:       settledAt,
 		ExpiresAt:       expiresAt,
-		// TODO: Metadata (e.g. keysend)
+		Metadata:        metadata,
 	}
 }
 
@@ -455,6 +831,30 @@ func (svc *LNDService) GetNetworkGraph(nodeIds []string) (lnclient.NetworkGraphR
 
 func (svc *LNDService) UpdateLastWalletSyncRequest() {}
 
+func (svc *LNDService) GetSupportedFeatures() []string {
+	return []string{lnclient.FeatureHoldInvoices, lnclient.FeatureDescriptionHash, lnclient.FeatureMaxFee, lnclient.FeaturePaymentReceivedEvents}
+}
+
 func (svc *LNDService) DisconnectPeer(ctx context.Context, peerId string) error {
 	return nil
 }
+
+func lndFeeLimit(options lnclient.PaymentOptions) *lnrpc.FeeLimit {
+	if options.MaxFeeMsat == nil {
+		return nil
+	}
+	return &lnrpc.FeeLimit{
+		Limit: &lnrpc.FeeLimit_FixedMsat{
+			FixedMsat: int64(*options.MaxFeeMsat),
+		},
+	}
+}
+
+// lndPaymentError converts the payment error of a send response.
+// LND does not consider routes above the fee limit, so a fee limit makes "no route" a max fee error.
+func lndPaymentError(paymentError string, options lnclient.PaymentOptions) error {
+	if options.MaxFeeMsat != nil && (strings.Contains(paymentError, "no_route") || strings.Contains(paymentError, "unable to find a path")) {
+		return fmt.Errorf("%w: %s", lnclient.ErrMaxFeeExceeded, paymentError)
+	}
+	return errors.New(paymentError)
+}
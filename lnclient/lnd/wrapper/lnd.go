@@ -8,6 +8,7 @@ import (
 	"errors"
 
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/invoicesrpc"
 	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
 	"github.com/lightningnetwork/lnd/macaroons"
 	"google.golang.org/grpc"
@@ -32,6 +33,7 @@ type LNDoptions struct {
 type LNDWrapper struct {
 	client         lnrpc.LightningClient
 	routerClient   routerrpc.RouterClient
+	invoicesClient invoicesrpc.InvoicesClient
 	IdentityPubkey string
 }
 
@@ -82,8 +84,9 @@ func NewLNDclient(lndOptions LNDoptions) (result *LNDWrapper, err error) {
 	}
 	lnClient := lnrpc.NewLightningClient(conn)
 	return &LNDWrapper{
-		client:       lnClient,
-		routerClient: routerrpc.NewRouterClient(conn),
+		client:         lnClient,
+		routerClient:   routerrpc.NewRouterClient(conn),
+		invoicesClient: invoicesrpc.NewInvoicesClient(conn),
 	}, nil
 }
 
@@ -103,6 +106,22 @@ func (wrapper *LNDWrapper) AddInvoice(ctx context.Context, req *lnrpc.Invoice, o
 	return wrapper.client.AddInvoice(ctx, req, options...)
 }
 
+func (wrapper *LNDWrapper) AddHoldInvoice(ctx context.Context, req *invoicesrpc.AddHoldInvoiceRequest, options ...grpc.CallOption) (*invoicesrpc.AddHoldInvoiceResp, error) {
+	return wrapper.invoicesClient.AddHoldInvoice(ctx, req, options...)
+}
+
+func (wrapper *LNDWrapper) SettleInvoice(ctx context.Context, req *invoicesrpc.SettleInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.SettleInvoiceResp, error) {
+	return wrapper.invoicesClient.SettleInvoice(ctx, req, options...)
+}
+
+func (wrapper *LNDWrapper) CancelInvoice(ctx context.Context, req *invoicesrpc.CancelInvoiceMsg, options ...grpc.CallOption) (*invoicesrpc.CancelInvoiceResp, error) {
+	return wrapper.invoicesClient.CancelInvoice(ctx, req, options...)
+}
+
+func (wrapper *LNDWrapper) SubscribeSingleInvoice(ctx context.Context, req *invoicesrpc.SubscribeSingleInvoiceRequest, options ...grpc.CallOption) (SubscribeInvoicesWrapper, error) {
+	return wrapper.invoicesClient.SubscribeSingleInvoice(ctx, req, options...)
+}
+
 func (wrapper *LNDWrapper) SubscribeInvoices(ctx context.Context, req *lnrpc.InvoiceSubscription, options ...grpc.CallOption) (SubscribeInvoicesWrapper, error) {
 	return wrapper.client.SubscribeInvoices(ctx, req, options...)
 }
@@ -129,6 +148,10 @@ func (wrapper *LNDWrapper) DecodeBolt11(ctx context.Context, bolt11 string, opti
 	})
 }
 
+func (wrapper *LNDWrapper) QueryRoutes(ctx context.Context, req *lnrpc.QueryRoutesRequest, options ...grpc.CallOption) (*lnrpc.QueryRoutesResponse, error) {
+	return wrapper.client.QueryRoutes(ctx, req, options...)
+}
+
 func (wrapper *LNDWrapper) SubscribePayment(ctx context.Context, req *routerrpc.TrackPaymentRequest, options ...grpc.CallOption) (SubscribePaymentWrapper, error) {
 	return wrapper.routerClient.TrackPaymentV2(ctx, req, options...)
 }
@@ -3,8 +3,40 @@ package lnclient
 
 import (
 	"context"
+	"errors"
+	"fmt"
 )
 
+// ErrNotImplemented is returned by backends which do not support an operation
+var ErrNotImplemented = errors.New("not implemented")
+
+// ErrPaymentNotFound is returned by LookupPayment when the node never made a payment of the hash
+var ErrPaymentNotFound = errors.New("payment not found")
+
+// ErrMaxFeeExceeded is returned when a payment cannot be made within PaymentOptions.MaxFeeMsat
+var ErrMaxFeeExceeded = errors.New("payment cannot be made within the maximum fee")
+
+// ErrMaxFeeNotSupported is returned by backends which cannot bound the routing fee
+var ErrMaxFeeNotSupported = fmt.Errorf("%w: max fee is not supported by this backend", ErrNotImplemented)
+
+// Optional features, the hub only offers the methods of a feature when the backend lists it in GetSupportedFeatures
+const (
+	// FeatureHoldInvoices is supported by backends implementing MakeHoldInvoice, SettleHoldInvoice and CancelHoldInvoice
+	FeatureHoldInvoices = "hold_invoices"
+	// FeatureDescriptionHash is supported by backends committing MakeInvoice invoices to the given description hash
+	FeatureDescriptionHash = "description_hash"
+	// FeatureMaxFee is supported by backends enforcing PaymentOptions.MaxFeeMsat, others return ErrMaxFeeNotSupported
+	FeatureMaxFee = "max_fee"
+	// FeaturePaymentReceivedEvents is supported by backends publishing nwc_payment_received for every incoming payment,
+	// which is what credits the balances of isolated apps
+	FeaturePaymentReceivedEvents = "payment_received_events"
+)
+
+type PaymentOptions struct {
+	// MaxFeeMsat bounds the routing fee, nil leaves it to the backend default
+	MaxFeeMsat *uint64
+}
+
 type TLVRecord struct {
 	Type  uint64 `json:"type"`
 	Value string `json:"value"`
@@ -33,6 +65,10 @@ type Transaction struct {
 	ExpiresAt       *int64      `json:"expires_at"`
 	SettledAt       *int64      `json:"settled_at"`
 	Metadata        interface{} `json:"metadata,omitempty"`
+	State           string      `json:"state,omitempty"`
+	// the amount in a fiat currency, set by the hub rather than the LN backend
+	FiatAmount   *float64 `json:"fiat_amount,omitempty"`
+	FiatCurrency string   `json:"fiat_currency,omitempty"`
 }
 
 type NodeConnectionInfo struct {
@@ -42,12 +78,20 @@ type NodeConnectionInfo struct {
 }
 
 type LNClient interface {
-	SendPaymentSync(ctx context.Context, payReq string) (*PayInvoiceResponse, error)
-	SendKeysend(ctx context.Context, amount int64, destination, preimage string, customRecords []TLVRecord) (preImage string, err error)
+	SendPaymentSync(ctx context.Context, payReq string, options PaymentOptions) (*PayInvoiceResponse, error)
+	SendKeysend(ctx context.Context, amount int64, destination, preimage string, customRecords []TLVRecord, options PaymentOptions) (*PayInvoiceResponse, error)
 	GetBalance(ctx context.Context) (balance int64, err error)
 	GetInfo(ctx context.Context) (info *NodeInfo, err error)
 	MakeInvoice(ctx context.Context, amount int64, description string, descriptionHash string, expiry int64) (transaction *Transaction, err error)
+	MakeHoldInvoice(ctx context.Context, amount int64, description string, descriptionHash string, expiry int64, paymentHash string) (transaction *Transaction, err error)
+	SettleHoldInvoice(ctx context.Context, preimage string) (err error)
+	CancelHoldInvoice(ctx context.Context, paymentHash string) (err error)
+	// SubscribeHoldInvoice publishes nwc_hold_invoice_accepted for a hold invoice made before the hub restarted,
+	// once it is accepted or right away if it already is
+	SubscribeHoldInvoice(ctx context.Context, paymentHash string) (err error)
 	LookupInvoice(ctx context.Context, paymentHash string) (transaction *Transaction, err error)
+	// LookupPayment returns the outgoing payment of the hash with its current state
+	LookupPayment(ctx context.Context, paymentHash string) (transaction *Transaction, err error)
 	ListTransactions(ctx context.Context, from, until, limit, offset uint64, unpaid bool, invoiceType string) (transactions []Transaction, err error)
 	Shutdown() error
 	ListChannels(ctx context.Context) (channels []Channel, err error)
@@ -64,12 +108,14 @@ type LNClient interface {
 	RedeemOnchainFunds(ctx context.Context, toAddress string) (txId string, err error)
 	SendPaymentProbes(ctx context.Context, invoice string) error
 	SendSpontaneousPaymentProbes(ctx context.Context, amountMsat uint64, nodeId string) error
+	EstimateFee(ctx context.Context, request *EstimateFeeRequest) (*FeeEstimate, error)
 	ListPeers(ctx context.Context) ([]PeerDetails, error)
 	GetLogOutput(ctx context.Context, maxLen int) ([]byte, error)
 	SignMessage(ctx context.Context, message string) (string, error)
 	GetStorageDir() (string, error)
 	GetNetworkGraph(nodeIds []string) (NetworkGraphResponse, error)
 	UpdateLastWalletSyncRequest()
+	GetSupportedFeatures() []string
 }
 
 type Channel struct {
@@ -135,9 +181,23 @@ type LightningBalanceResponse struct {
 	NextMaxReceivableMPP int64 `json:"nextMaxReceivableMPP"`
 }
 
+// EstimateFeeRequest is either for a bolt11 invoice or for a keysend payment of AmountMsat to Destination.
+// AmountMsat is also used for invoices without an amount.
+type EstimateFeeRequest struct {
+	Invoice     string
+	Destination string
+	AmountMsat  uint64
+}
+
+type FeeEstimate struct {
+	// nil when the backend can only tell whether a route exists
+	FeeMsat            *uint64
+	SuccessProbability float64
+}
+
 type PayInvoiceResponse struct {
 	Preimage string  `json:"preimage"`
-	Fee      *uint64 `json:"fee"`
+	Fee      *uint64 `json:"fee"` // in msat
 }
 
 type BalancesResponse struct {
@@ -326,6 +326,26 @@ func (svc *PhoenixService) MakeInvoice(ctx context.Context, amount int64, descri
 	return tx, nil
 }
 
+func (svc *PhoenixService) MakeHoldInvoice(ctx context.Context, amount int64, description string, descriptionHash string, expiry int64, paymentHash string) (transaction *nip47.Transaction, err error) {
+	return nil, errors.New("not implemented")
+}
+
+func (svc *PhoenixService) SettleHoldInvoice(ctx context.Context, preimage string) (err error) {
+	return errors.New("not implemented")
+}
+
+func (svc *PhoenixService) CancelHoldInvoice(ctx context.Context, paymentHash string) (err error) {
+	return errors.New("not implemented")
+}
+
+func (svc *PhoenixService) SubscribeHoldInvoice(ctx context.Context, paymentHash string) (err error) {
+	return errors.New("not implemented")
+}
+
+func (svc *PhoenixService) LookupPayment(ctx context.Context, paymentHash string) (transaction *nip47.Transaction, err error) {
+	return nil, lnclient.ErrNotImplemented
+}
+
 func (svc *PhoenixService) LookupInvoice(ctx context.Context, paymentHash string) (transaction *nip47.Transaction, err error) {
 	req, err := http.NewRequest(http.MethodGet, svc.Address+"/payments/incoming/"+paymentHash, nil)
 	if err != nil {
@@ -363,7 +383,12 @@ func (svc *PhoenixService) LookupInvoice(ctx context.Context, paymentHash string
 	return transaction, nil
 }
 
-func (svc *PhoenixService) SendPaymentSync(ctx context.Context, payReq string) (*lnclient.PayInvoiceResponse, error) {
+func (svc *PhoenixService) SendPaymentSync(ctx context.Context, payReq string, options lnclient.PaymentOptions) (*lnclient.PayInvoiceResponse, error) {
+	if options.MaxFeeMsat != nil {
+		// phoenixd pays through its trampoline node with its own fee policy
+		return nil, lnclient.ErrMaxFeeNotSupported
+	}
+
 	form := url.Values{}
 	form.Add("invoice", payReq)
 	req, err := http.NewRequest(http.MethodPost, svc.Address+"/payinvoice", strings.NewReader(form.Encode()))
@@ -391,8 +416,8 @@ func (svc *PhoenixService) SendPaymentSync(ctx context.Context, payReq string) (
 	}, nil
 }
 
-func (svc *PhoenixService) SendKeysend(ctx context.Context, amount int64, destination, preimage string, custom_records []lnclient.TLVRecord) (respPreimage string, err error) {
-	return "", errors.New("not implemented")
+func (svc *PhoenixService) SendKeysend(ctx context.Context, amount int64, destination, preimage string, custom_records []lnclient.TLVRecord, options lnclient.PaymentOptions) (*lnclient.PayInvoiceResponse, error) {
+	return nil, errors.New("not implemented")
 }
 
 func (svc *PhoenixService) RedeemOnchainFunds(ctx context.Context, toAddress string) (txId string, err error) {
@@ -460,6 +485,10 @@ func (svc *PhoenixService) SendSpontaneousPaymentProbes(ctx context.Context, amo
 	return nil
 }
 
+func (svc *PhoenixService) EstimateFee(ctx context.Context, request *lnclient.EstimateFeeRequest) (*lnclient.FeeEstimate, error) {
+	return nil, lnclient.ErrNotImplemented
+}
+
 func (svc *PhoenixService) ListPeers(ctx context.Context) ([]lnclient.PeerDetails, error) {
 	return nil, nil
 }
@@ -481,6 +510,11 @@ func (svc *PhoenixService) GetNetworkGraph(nodeIds []string) (lnclient.NetworkGr
 }
 
 func (svc *PhoenixService) UpdateLastWalletSyncRequest() {}
+
+func (svc *PhoenixService) GetSupportedFeatures() []string {
+	return []string{lnclient.FeatureDescriptionHash}
+}
+
 func (svc *PhoenixService) DisconnectPeer(ctx context.Context, peerId string) error {
 	return nil
 }
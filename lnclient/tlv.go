@@ -0,0 +1,59 @@
+package lnclient
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"unicode/utf8"
+)
+
+// well-known custom record types of incoming keysend payments
+const (
+	TLV_BOOSTAGRAM       = 7629169    // podcasting 2.0 boostagram (JSON)
+	TLV_WHATSAT_MESSAGE  = 34349334   // chat message (UTF-8 text)
+	TLV_KEYSEND_PREIMAGE = 5482373484 // keysend preimage
+)
+
+// TLVRecordsToMetadata decodes the custom records received with a payment into transaction metadata.
+// All records except the keysend preimage are returned hex-encoded under "tlv_records",
+// known ones are additionally decoded (e.g. "boostagram", "message").
+func TLVRecordsToMetadata(customRecords map[uint64][]byte) map[string]interface{} {
+	types := make([]uint64, 0, len(customRecords))
+	for tlvType := range customRecords {
+		if tlvType == TLV_KEYSEND_PREIMAGE {
+			continue
+		}
+		types = append(types, tlvType)
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i] < types[j]
+	})
+
+	metadata := map[string]interface{}{}
+	tlvRecords := make([]TLVRecord, 0, len(types))
+	for _, tlvType := range types {
+		value := customRecords[tlvType]
+		tlvRecords = append(tlvRecords, TLVRecord{
+			Type:  tlvType,
+			Value: hex.EncodeToString(value),
+		})
+
+		switch tlvType {
+		case TLV_BOOSTAGRAM:
+			boostagram := map[string]interface{}{}
+			if json.Unmarshal(value, &boostagram) == nil {
+				metadata["boostagram"] = boostagram
+			}
+		case TLV_WHATSAT_MESSAGE:
+			if utf8.Valid(value) {
+				metadata["message"] = string(value)
+			}
+		}
+	}
+	metadata["tlv_records"] = tlvRecords
+
+	return metadata
+}
@@ -0,0 +1,270 @@
+package lnurl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/btcsuite/btcd/btcutil/bech32"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+)
+
+// maxResponseSize bounds the responses read from LNURL services, which are small JSON documents
+const maxResponseSize = 1 << 20
+
+// ErrNonPublicAddress is returned when an LNURL or relay points to the hub's own machine or network
+var ErrNonPublicAddress = errors.New("must not point to a loopback, private or link-local address")
+
+type Client struct {
+	httpClient *http.Client
+}
+
+func NewClient(httpClient *http.Client) *Client {
+	return &Client{
+		httpClient: httpClient,
+	}
+}
+
+// NewHttpClient returns an http client which only connects to public addresses, so lightning addresses and LNURLs
+// given by apps cannot reach services on the hub's machine or network. The address is checked when connecting,
+// which also covers redirects and host names resolving to a private address.
+func NewHttpClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicIP(ip) {
+				return ErrNonPublicAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be dialed instead of the LNURL service and defeat the check
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+	}
+}
+
+// CheckPublicHost returns ErrNonPublicAddress if the host resolves to an address which is not public.
+// It is for connections which cannot be checked when dialing, such as websockets to relays given by apps or payers.
+func CheckPublicHost(ctx context.Context, host string) error {
+	ipAddrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, ipAddr := range ipAddrs {
+		if !isPublicIP(ipAddr.IP) {
+			return ErrNonPublicAddress
+		}
+	}
+	return nil
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// Normalize returns the lightning address or LNURL without a lightning: prefix, so the same destination
+// written differently can be compared. Lightning addresses and bech32 LNURLs are case-insensitive and lower cased,
+// of LNURLs written as URLs only the scheme and host are, as their path can be case-sensitive.
+func Normalize(target string) string {
+	target = strings.TrimSpace(target)
+	if len(target) >= len("lightning:") && strings.EqualFold(target[:len("lightning:")], "lightning:") {
+		target = target[len("lightning:"):]
+	}
+
+	if strings.Contains(target, "://") {
+		parsedUrl, err := url.Parse(target)
+		if err != nil {
+			return target
+		}
+		parsedUrl.Scheme = strings.ToLower(parsedUrl.Scheme)
+		parsedUrl.Host = strings.ToLower(parsedUrl.Host)
+		return parsedUrl.String()
+	}
+	return strings.ToLower(target)
+}
+
+// ResolveUrl turns a lightning address, bech32 LNURL or LUD-17 lnurlp:// url into the URL of the LNURL-pay endpoint
+func ResolveUrl(target string) (string, error) {
+	target = strings.TrimSpace(target)
+	target = strings.TrimPrefix(strings.TrimPrefix(target, "lightning:"), "LIGHTNING:")
+
+	if strings.Contains(target, "@") {
+		parts := strings.Split(target, "@")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return "", fmt.Errorf("invalid lightning address: %s", target)
+		}
+		return fmt.Sprintf("https://%s/.well-known/lnurlp/%s", parts[1], strings.ToLower(parts[0])), nil
+	}
+
+	if strings.HasPrefix(strings.ToLower(target), "lnurl1") {
+		_, data, err := bech32.DecodeNoLimit(strings.ToLower(target))
+		if err != nil {
+			return "", fmt.Errorf("invalid lnurl: %w", err)
+		}
+		decoded, err := bech32.ConvertBits(data, 5, 8, false)
+		if err != nil {
+			return "", fmt.Errorf("invalid lnurl: %w", err)
+		}
+		return string(decoded), nil
+	}
+
+	if strings.HasPrefix(target, "lnurlp://") {
+		return "https://" + strings.TrimPrefix(target, "lnurlp://"), nil
+	}
+
+	if strings.HasPrefix(target, "https://") {
+		return target, nil
+	}
+
+	return "", fmt.Errorf("unsupported lnurl: %s", target)
+}
+
+// Encode returns the bech32 LNURL for the given URL
+func Encode(rawUrl string) (string, error) {
+	data, err := bech32.ConvertBits([]byte(rawUrl), 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	return bech32.Encode("lnurl", data)
+}
+
+func (client *Client) FetchPayParams(ctx context.Context, target string) (*PayParams, error) {
+	payUrl, err := ResolveUrl(target)
+	if err != nil {
+		return nil, err
+	}
+
+	payParams := &PayParams{}
+	err = client.getJson(ctx, payUrl, payParams)
+	if err != nil {
+		return nil, err
+	}
+	if payParams.Status == STATUS_ERROR {
+		return nil, fmt.Errorf("lnurl error: %s", payParams.Reason)
+	}
+	if payParams.Tag != PAY_REQUEST_TAG {
+		return nil, fmt.Errorf("unexpected lnurl tag: %s", payParams.Tag)
+	}
+	if payParams.Callback == "" {
+		return nil, errors.New("lnurl response has no callback")
+	}
+
+	return payParams, nil
+}
+
+// FetchInvoice requests an invoice from the callback and verifies that it
+// matches the requested amount and the metadata of the pay params
+func (client *Client) FetchInvoice(ctx context.Context, payParams *PayParams, options *PayRequestOptions) (string, error) {
+	if options.AmountMsat < payParams.MinSendable || options.AmountMsat > payParams.MaxSendable {
+		return "", fmt.Errorf("amount %d msat is outside of the sendable range %d - %d msat", options.AmountMsat, payParams.MinSendable, payParams.MaxSendable)
+	}
+	if len(options.Comment) > payParams.CommentAllowed {
+		return "", fmt.Errorf("comment is longer than the %d characters allowed", payParams.CommentAllowed)
+	}
+
+	callbackUrl, err := url.Parse(payParams.Callback)
+	if err != nil {
+		return "", fmt.Errorf("invalid lnurl callback: %w", err)
+	}
+	query := callbackUrl.Query()
+	query.Set("amount", strconv.FormatInt(options.AmountMsat, 10))
+	if options.Comment != "" {
+		query.Set("comment", options.Comment)
+	}
+
+	var payerData string
+	if len(options.PayerData) > 0 {
+		if payParams.PayerData == nil {
+			return "", errors.New("lnurl service does not accept payer data")
+		}
+		compactPayerData := &bytes.Buffer{}
+		err = json.Compact(compactPayerData, options.PayerData)
+		if err != nil {
+			return "", fmt.Errorf("invalid payer data: %w", err)
+		}
+		payerData = compactPayerData.String()
+		query.Set("payerdata", payerData)
+	}
+	callbackUrl.RawQuery = query.Encode()
+
+	response := &PayCallbackResponse{}
+	err = client.getJson(ctx, callbackUrl.String(), response)
+	if err != nil {
+		return "", err
+	}
+	if response.Status == STATUS_ERROR {
+		return "", fmt.Errorf("lnurl error: %s", response.Reason)
+	}
+
+	paymentRequest, err := decodepay.Decodepay(strings.ToLower(response.PR))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode lnurl invoice: %w", err)
+	}
+	if paymentRequest.MSatoshi != options.AmountMsat {
+		return "", fmt.Errorf("lnurl invoice amount %d msat does not match requested amount %d msat", paymentRequest.MSatoshi, options.AmountMsat)
+	}
+
+	// LUD-18: the payer data is part of the committed description
+	descriptionHash := sha256.Sum256([]byte(payParams.Metadata + payerData))
+	if paymentRequest.DescriptionHash != hex.EncodeToString(descriptionHash[:]) {
+		return "", errors.New("lnurl invoice description hash does not match metadata")
+	}
+
+	return response.PR, nil
+}
+
+func (client *Client) getJson(ctx context.Context, requestUrl string, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return fmt.Errorf("failed to read lnurl response: %w", err)
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		// LNURL services may explain the error in the body
+		errorResponse := &ErrorResponse{}
+		if json.Unmarshal(body, errorResponse) == nil && errorResponse.Status == STATUS_ERROR && errorResponse.Reason != "" {
+			return fmt.Errorf("lnurl error: %s", errorResponse.Reason)
+		}
+		return fmt.Errorf("unexpected lnurl response status %d from %s", res.StatusCode, requestUrl)
+	}
+
+	err = json.Unmarshal(body, result)
+	if err != nil {
+		return fmt.Errorf("failed to deserialize lnurl response %s %s", requestUrl, string(body))
+	}
+	return nil
+}
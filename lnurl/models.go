@@ -0,0 +1,40 @@
+package lnurl
+
+import "encoding/json"
+
+const (
+	PAY_REQUEST_TAG = "payRequest"
+	STATUS_ERROR    = "ERROR"
+)
+
+// PayParams is the first step response of LUD-06 (and extensions LUD-12 / LUD-18)
+type PayParams struct {
+	Tag            string                 `json:"tag"`
+	Callback       string                 `json:"callback"`
+	MinSendable    int64                  `json:"minSendable"`
+	MaxSendable    int64                  `json:"maxSendable"`
+	Metadata       string                 `json:"metadata"`
+	CommentAllowed int                    `json:"commentAllowed,omitempty"`
+	PayerData      map[string]interface{} `json:"payerData,omitempty"`
+	Status         string                 `json:"status,omitempty"`
+	Reason         string                 `json:"reason,omitempty"`
+}
+
+type PayRequestOptions struct {
+	AmountMsat int64
+	Comment    string
+	PayerData  json.RawMessage
+}
+
+// PayCallbackResponse is the second step response of LUD-06
+type PayCallbackResponse struct {
+	PR     string        `json:"pr"`
+	Routes []interface{} `json:"routes"`
+	Status string        `json:"status,omitempty"`
+	Reason string        `json:"reason,omitempty"`
+}
+
+type ErrorResponse struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
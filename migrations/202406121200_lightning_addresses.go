@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// Adds per-app lightning address usernames and the invoices created through them
+var _202406121200_lightning_addresses = &gormigrate.Migration{
+	ID: "202406121200_lightning_addresses",
+	Migrate: func(tx *gorm.DB) error {
+		if err := tx.Exec("ALTER TABLE apps ADD COLUMN lightning_address_username TEXT").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("CREATE UNIQUE INDEX `idx_apps_lightning_address_username` ON `apps`(`lightning_address_username`)").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("CREATE TABLE `invoices` (`id` integer,`app_id` integer,`payment_hash` text,`payment_request` text,`amount` integer,`comment` text,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`id`),CONSTRAINT `fk_invoices_app` FOREIGN KEY (`app_id`) REFERENCES `apps`(`id`) ON DELETE CASCADE)").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("CREATE INDEX `idx_invoices_app_id` ON `invoices`(`app_id`)").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("CREATE UNIQUE INDEX `idx_invoices_payment_hash` ON `invoices`(`payment_hash`)").Error; err != nil {
+			return err
+		}
+
+		return nil
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return nil
+	},
+}
@@ -0,0 +1,27 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// Stores NIP-57 zap requests so zap receipts can be published once the invoice is paid
+var _202406131200_zaps = &gormigrate.Migration{
+	ID: "202406131200_zaps",
+	Migrate: func(tx *gorm.DB) error {
+		if err := tx.Exec("CREATE TABLE `zaps` (`id` integer,`app_id` integer,`payment_hash` text,`zap_request` text,`receipt_id` text,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`id`),CONSTRAINT `fk_zaps_app` FOREIGN KEY (`app_id`) REFERENCES `apps`(`id`) ON DELETE CASCADE)").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("CREATE UNIQUE INDEX `idx_zaps_payment_hash` ON `zaps`(`payment_hash`)").Error; err != nil {
+			return err
+		}
+
+		return nil
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return nil
+	},
+}
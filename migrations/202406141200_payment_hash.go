@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	_ "embed"
+	"strings"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// Store the payment hash of payments so the same invoice is not paid twice
+func _202406141200_payment_hash(logger *logrus.Logger) *gormigrate.Migration {
+	return &gormigrate.Migration{
+		ID: "202406141200_payment_hash",
+		Migrate: func(tx *gorm.DB) error {
+			if err := tx.Exec("ALTER TABLE payments ADD COLUMN payment_hash TEXT").Error; err != nil {
+				return err
+			}
+
+			if err := tx.Exec("CREATE INDEX `idx_payments_payment_hash` ON `payments`(`payment_hash`)").Error; err != nil {
+				return err
+			}
+
+			type payment struct {
+				ID             uint
+				PaymentRequest string
+			}
+			payments := []payment{}
+			if err := tx.Raw("SELECT id, payment_request FROM payments WHERE payment_request LIKE 'ln%'").Scan(&payments).Error; err != nil {
+				return err
+			}
+
+			for _, p := range payments {
+				paymentRequest, err := decodepay.Decodepay(strings.ToLower(p.PaymentRequest))
+				if err != nil {
+					// e.g. offers
+					logger.WithField("paymentId", p.ID).WithError(err).Warn("Could not decode payment request, skipping payment hash")
+					continue
+				}
+				if err := tx.Exec("UPDATE payments SET payment_hash = ? WHERE id = ?", paymentRequest.PaymentHash, p.ID).Error; err != nil {
+					return err
+				}
+			}
+
+			return nil
+		},
+		Rollback: func(tx *gorm.DB) error {
+			return nil
+		},
+	}
+}
@@ -0,0 +1,34 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// Record whether a payment is pending, succeeded or failed, and why it failed.
+// Existing payments without a preimage can only have failed, as nothing is in flight while migrating.
+var _202406151200_payment_state = &gormigrate.Migration{
+	ID: "202406151200_payment_state",
+	Migrate: func(tx *gorm.DB) error {
+		for _, column := range []string{"state TEXT", "failure_reason TEXT", "settled_at DATETIME", "failed_at DATETIME"} {
+			if err := tx.Exec("ALTER TABLE payments ADD COLUMN " + column).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Exec("UPDATE payments SET state = 'succeeded', settled_at = updated_at WHERE preimage IS NOT NULL").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("UPDATE payments SET state = 'failed', failed_at = updated_at WHERE preimage IS NULL").Error; err != nil {
+			return err
+		}
+
+		return nil
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return nil
+	},
+}
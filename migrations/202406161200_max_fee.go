@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// Per-app default routing fee limit for outgoing payments
+var _202406161200_max_fee = &gormigrate.Migration{
+	ID: "202406161200_max_fee",
+	Migrate: func(tx *gorm.DB) error {
+		if err := tx.Exec("ALTER TABLE app_permissions ADD COLUMN max_fee_msat INTEGER").Error; err != nil {
+			return err
+		}
+
+		return nil
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return nil
+	},
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// Invoices paid by another app on the same hub are settled in the database only
+var _202406171200_internal_transfers = &gormigrate.Migration{
+	ID: "202406171200_internal_transfers",
+	Migrate: func(tx *gorm.DB) error {
+		if err := tx.Exec("ALTER TABLE invoices ADD COLUMN settled_at DATETIME").Error; err != nil {
+			return err
+		}
+
+		return nil
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return nil
+	},
+}
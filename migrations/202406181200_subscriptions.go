@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// Recurring payments the hub makes on behalf of apps
+var _202406181200_subscriptions = &gormigrate.Migration{
+	ID: "202406181200_subscriptions",
+	Migrate: func(tx *gorm.DB) error {
+		if err := tx.Exec("CREATE TABLE `subscriptions` (`id` integer,`app_id` integer,`request_event_id` integer,`pubkey` text,`lightning_address` text,`amount` integer,`interval` text,`description` text,`next_run_at` datetime,`last_run_at` datetime,`cancelled_at` datetime,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`id`),CONSTRAINT `fk_subscriptions_app` FOREIGN KEY (`app_id`) REFERENCES `apps`(`id`) ON DELETE CASCADE,CONSTRAINT `fk_subscriptions_request_event` FOREIGN KEY (`request_event_id`) REFERENCES `request_events`(`id`))").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("CREATE INDEX `idx_subscriptions_next_run_at` ON `subscriptions`(`next_run_at`)").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("ALTER TABLE payments ADD COLUMN subscription_id INTEGER REFERENCES subscriptions(id) ON DELETE SET NULL").Error; err != nil {
+			return err
+		}
+
+		return nil
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return nil
+	},
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// Apps which spend from their own ledger balance. Their balance is summed from their invoices and payments.
+var _202406191200_isolated_apps = &gormigrate.Migration{
+	ID: "202406191200_isolated_apps",
+	Migrate: func(tx *gorm.DB) error {
+		if err := tx.Exec("ALTER TABLE apps ADD COLUMN isolated BOOLEAN NOT NULL DEFAULT FALSE").Error; err != nil {
+			return err
+		}
+
+		return nil
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return nil
+	},
+}
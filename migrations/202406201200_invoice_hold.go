@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// Hold invoices are stored with the app that created them, but can not be settled internally
+var _202406201200_invoice_hold = &gormigrate.Migration{
+	ID: "202406201200_invoice_hold",
+	Migrate: func(tx *gorm.DB) error {
+		if err := tx.Exec("ALTER TABLE invoices ADD COLUMN hold BOOLEAN NOT NULL DEFAULT FALSE").Error; err != nil {
+			return err
+		}
+
+		return nil
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return nil
+	},
+}
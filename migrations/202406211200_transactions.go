@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// Transactions of the node, kept in sync with the LN backend. Filled by reconciling with the backend after startup.
+var _202406211200_transactions = &gormigrate.Migration{
+	ID: "202406211200_transactions",
+	Migrate: func(tx *gorm.DB) error {
+		if err := tx.Exec("CREATE TABLE `transactions` (`id` integer,`app_id` integer,`type` text,`state` text,`payment_request` text,`payment_hash` text,`preimage` text,`description` text,`description_hash` text,`amount` integer,`fees_paid` integer,`metadata` text,`expires_at` datetime,`settled_at` datetime,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`id`),CONSTRAINT `fk_transactions_app` FOREIGN KEY (`app_id`) REFERENCES `apps`(`id`) ON DELETE SET NULL)").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("CREATE UNIQUE INDEX `idx_transactions_type_payment_hash` ON `transactions`(`type`,`payment_hash`)").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("CREATE INDEX `idx_transactions_created_at` ON `transactions`(`created_at`)").Error; err != nil {
+			return err
+		}
+
+		return nil
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return nil
+	},
+}
@@ -0,0 +1,27 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// BIP-329 labels, keyed by their type and reference
+var _202406221200_labels = &gormigrate.Migration{
+	ID: "202406221200_labels",
+	Migrate: func(tx *gorm.DB) error {
+		if err := tx.Exec("CREATE TABLE `labels` (`id` integer,`type` text NOT NULL,`ref` text NOT NULL,`label` text,`origin` text,`spendable` numeric,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`id`))").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("CREATE UNIQUE INDEX `idx_labels_type_ref` ON `labels`(`type`,`ref`)").Error; err != nil {
+			return err
+		}
+
+		return nil
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return nil
+	},
+}
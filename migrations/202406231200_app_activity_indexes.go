@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// Indexes for the activity history and spending stats of an app
+var _202406231200_app_activity_indexes = &gormigrate.Migration{
+	ID: "202406231200_app_activity_indexes",
+	Migrate: func(tx *gorm.DB) error {
+		if err := tx.Exec("CREATE INDEX `idx_request_events_app_id_created_at` ON `request_events`(`app_id`,`created_at`)").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("CREATE INDEX `idx_payments_app_id_created_at` ON `payments`(`app_id`,`created_at`)").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("CREATE INDEX `idx_response_events_request_id` ON `response_events`(`request_id`)").Error; err != nil {
+			return err
+		}
+
+		return nil
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return nil
+	},
+}
@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// Store payment amounts in millisats, so payments of a fraction of a sat are neither truncated
+// nor left out of app budgets. Existing amounts were stored in sats.
+var _202406241200_payment_amount_msat = &gormigrate.Migration{
+	ID: "202406241200_payment_amount_msat",
+	Migrate: func(tx *gorm.DB) error {
+		if err := tx.Exec("ALTER TABLE payments RENAME COLUMN amount TO amount_msat").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("UPDATE payments SET amount_msat = amount_msat * 1000").Error; err != nil {
+			return err
+		}
+
+		return nil
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return nil
+	},
+}
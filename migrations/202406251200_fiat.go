@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// Budgets in a fiat currency, and the rate payments were made at for accounting
+var _202406251200_fiat = &gormigrate.Migration{
+	ID: "202406251200_fiat",
+	Migrate: func(tx *gorm.DB) error {
+		for _, column := range []string{"budget_currency TEXT", "max_amount_fiat INTEGER"} {
+			if err := tx.Exec("ALTER TABLE app_permissions ADD COLUMN " + column).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, column := range []string{"fiat_currency TEXT", "fiat_rate REAL"} {
+			if err := tx.Exec("ALTER TABLE payments ADD COLUMN " + column).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return nil
+	},
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// An address book of payees, and what each payment was made to so it can be matched with them
+var _202406261200_contacts = &gormigrate.Migration{
+	ID: "202406261200_contacts",
+	Migrate: func(tx *gorm.DB) error {
+		if err := tx.Exec("CREATE TABLE `contacts` (`id` integer,`name` text NOT NULL,`lightning_address` text,`lnurl` text,`node_pubkey` text,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`id`))").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("CREATE UNIQUE INDEX `idx_contacts_name` ON `contacts`(`name` COLLATE NOCASE)").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("ALTER TABLE payments ADD COLUMN destination TEXT").Error; err != nil {
+			return err
+		}
+
+		return nil
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return nil
+	},
+}
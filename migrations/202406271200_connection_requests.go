@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// Connections requested by apps themselves, until the owner approves or rejects them
+var _202406271200_connection_requests = &gormigrate.Migration{
+	ID: "202406271200_connection_requests",
+	Migrate: func(tx *gorm.DB) error {
+		if err := tx.Exec("CREATE TABLE `connection_requests` (`id` integer,`nostr_id` text NOT NULL,`app_pubkey` text NOT NULL,`name` text,`request_methods` text,`max_amount` integer,`budget_renewal` text,`expires_at` datetime,`relay` text,`secret` text,`state` text,`app_id` integer,`responded_at` datetime,`created_at` datetime,`updated_at` datetime,PRIMARY KEY (`id`),CONSTRAINT `fk_connection_requests_app` FOREIGN KEY (`app_id`) REFERENCES `apps`(`id`) ON DELETE SET NULL)").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("CREATE UNIQUE INDEX `idx_connection_requests_nostr_id` ON `connection_requests`(`nostr_id`)").Error; err != nil {
+			return err
+		}
+
+		if err := tx.Exec("CREATE INDEX `idx_connection_requests_state` ON `connection_requests`(`state`)").Error; err != nil {
+			return err
+		}
+
+		return nil
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return nil
+	},
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	_ "embed"
+
+	"github.com/go-gormigrate/gormigrate/v2"
+	"gorm.io/gorm"
+)
+
+// The routing fee of each payment, which is spent from the balance of isolated apps too
+var _202406281200_payment_fee = &gormigrate.Migration{
+	ID: "202406281200_payment_fee",
+	Migrate: func(tx *gorm.DB) error {
+		if err := tx.Exec("ALTER TABLE payments ADD COLUMN fee_msat INTEGER").Error; err != nil {
+			return err
+		}
+
+		return nil
+	},
+	Rollback: func(tx *gorm.DB) error {
+		return nil
+	},
+}
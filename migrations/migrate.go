@@ -16,6 +16,23 @@ func Migrate(db *gorm.DB, appConfig *config.AppConfig, logger *logrus.Logger) er
 		_202405302121_store_decrypted_request,
 		_202406061259_delete_content,
 		_202406071726_vacuum,
+		_202406121200_lightning_addresses,
+		_202406131200_zaps,
+		_202406141200_payment_hash(logger),
+		_202406151200_payment_state,
+		_202406161200_max_fee,
+		_202406171200_internal_transfers,
+		_202406181200_subscriptions,
+		_202406191200_isolated_apps,
+		_202406201200_invoice_hold,
+		_202406211200_transactions,
+		_202406221200_labels,
+		_202406231200_app_activity_indexes,
+		_202406241200_payment_amount_msat,
+		_202406251200_fiat,
+		_202406261200_contacts,
+		_202406271200_connection_requests,
+		_202406281200_payment_fee,
 	})
 
 	return m.Migrate()
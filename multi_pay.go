@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// used when the limits are not configured, e.g. in tests
+const (
+	defaultMultiPayMaxBatchSize = 50
+	defaultMultiPayConcurrency  = 5
+)
+
+// multiPayBatch limits how many payments of a multi_pay request are sent at once
+// and collects their outcome for the optional summary response
+type multiPayBatch struct {
+	wg        sync.WaitGroup
+	semaphore chan struct{}
+	mu        sync.Mutex
+	summary   nip47.MultiPaySummaryResponse
+}
+
+// newMultiPayBatch returns an error response if the request has more payments than allowed
+func (svc *Service) newMultiPayBatch(nip47Request *nip47.Request, size int) (*multiPayBatch, *nip47.Response) {
+	maxBatchSize := svc.cfg.GetEnv().MultiPayMaxBatchSize
+	if maxBatchSize <= 0 {
+		maxBatchSize = defaultMultiPayMaxBatchSize
+	}
+	if size > maxBatchSize {
+		return nil, &nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_BAD_REQUEST,
+				Message: fmt.Sprintf("Too many payments in a single request: %d (max %d)", size, maxBatchSize),
+			},
+		}
+	}
+
+	concurrency := svc.cfg.GetEnv().MultiPayConcurrency
+	if concurrency <= 0 {
+		concurrency = defaultMultiPayConcurrency
+	}
+	return &multiPayBatch{
+		semaphore: make(chan struct{}, concurrency),
+		summary: nip47.MultiPaySummaryResponse{
+			Succeeded: []nip47.MultiPaySummaryElement{},
+			Failed:    []nip47.MultiPaySummaryElement{},
+		},
+	}, nil
+}
+
+// run waits for a free slot and then pays in the background
+func (batch *multiPayBatch) run(pay func()) {
+	batch.wg.Add(1)
+	batch.semaphore <- struct{}{}
+	go func() {
+		defer func() {
+			<-batch.semaphore
+			batch.wg.Done()
+		}()
+		pay()
+	}()
+}
+
+func (batch *multiPayBatch) wait() {
+	batch.wg.Wait()
+}
+
+// record adds the response published for a payment of the batch to the summary
+func (batch *multiPayBatch) record(response *nip47.Response, tags nostr.Tags, amountMsat int64) {
+	element := nip47.MultiPaySummaryElement{
+		Amount: amountMsat,
+		Error:  response.Error,
+	}
+	if dTag := tags.GetFirst([]string{"d"}); dTag != nil {
+		element.Id = dTag.Value()
+	}
+	if payResponse, ok := response.Result.(nip47.PayResponse); ok {
+		element.Preimage = payResponse.Preimage
+		if payResponse.FeesPaid != nil {
+			element.FeesPaid = *payResponse.FeesPaid
+		}
+	}
+
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+	if element.Error != nil {
+		batch.summary.Failed = append(batch.summary.Failed, element)
+		return
+	}
+	batch.summary.Succeeded = append(batch.summary.Succeeded, element)
+	batch.summary.TotalAmount += element.Amount
+	batch.summary.TotalFees += element.FeesPaid
+}
+
+func (batch *multiPayBatch) summaryResponse(nip47Request *nip47.Request) *nip47.Response {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+	return &nip47.Response{
+		ResultType: nip47Request.Method,
+		Result:     &batch.summary,
+	}
+}
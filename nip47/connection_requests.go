@@ -0,0 +1,100 @@
+package nip47
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// CONNECTION_REQUEST_URI_SCHEME is the scheme of deep links carrying a connection request,
+// e.g. nostr+walletauth://<app pubkey>?event=<signed connection request event as JSON>
+const CONNECTION_REQUEST_URI_SCHEME = "nostr+walletauth"
+
+// anyone can send connection requests, so their fields are bounded
+const (
+	maxConnectionRequestNameLength          = 100
+	maxConnectionRequestMethods             = 32
+	maxConnectionRequestMethodLength        = 64
+	maxConnectionRequestBudgetRenewalLength = 16
+	maxConnectionRequestRelayLength         = 512
+	maxConnectionRequestSecretLength        = 256
+)
+
+// ParseConnectionRequestUri returns the signed connection request event of the deep link
+func ParseConnectionRequestUri(uri string) (*nostr.Event, error) {
+	parsedUri, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection request uri: %w", err)
+	}
+	if parsedUri.Scheme != CONNECTION_REQUEST_URI_SCHEME {
+		return nil, fmt.Errorf("unsupported connection request uri scheme: %s", parsedUri.Scheme)
+	}
+
+	event := &nostr.Event{}
+	err = json.Unmarshal([]byte(parsedUri.Query().Get("event")), event)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection request event: %w", err)
+	}
+	if event.PubKey != parsedUri.Host {
+		return nil, errors.New("connection request event is not signed by the app pubkey of the uri")
+	}
+	return event, nil
+}
+
+// ParseConnectionRequestEvent checks the signature of the event and decodes the connection request it carries
+func ParseConnectionRequestEvent(event *nostr.Event) (*ConnectionRequest, error) {
+	if event.Kind != CONNECTION_REQUEST_KIND {
+		return nil, fmt.Errorf("unexpected connection request event kind: %d", event.Kind)
+	}
+	valid, err := event.CheckSignature()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check connection request signature: %w", err)
+	}
+	if !valid {
+		return nil, errors.New("invalid connection request signature")
+	}
+
+	connectionRequest := &ConnectionRequest{}
+	err = json.Unmarshal([]byte(event.Content), connectionRequest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection request content: %w", err)
+	}
+	if connectionRequest.Name == "" {
+		return nil, errors.New("connection request name is required")
+	}
+	if len(connectionRequest.Name) > maxConnectionRequestNameLength {
+		return nil, fmt.Errorf("connection request name is longer than %d characters", maxConnectionRequestNameLength)
+	}
+	if len(connectionRequest.RequestMethods) == 0 {
+		return nil, errors.New("connection request methods are required")
+	}
+	if len(connectionRequest.RequestMethods) > maxConnectionRequestMethods {
+		return nil, fmt.Errorf("connection request has more than %d methods", maxConnectionRequestMethods)
+	}
+	for _, requestMethod := range connectionRequest.RequestMethods {
+		if len(requestMethod) > maxConnectionRequestMethodLength {
+			return nil, fmt.Errorf("connection request method is longer than %d characters", maxConnectionRequestMethodLength)
+		}
+	}
+	if len(connectionRequest.BudgetRenewal) > maxConnectionRequestBudgetRenewalLength {
+		return nil, fmt.Errorf("connection request budget renewal is longer than %d characters", maxConnectionRequestBudgetRenewalLength)
+	}
+	if len(connectionRequest.Relay) > maxConnectionRequestRelayLength {
+		return nil, fmt.Errorf("connection request relay is longer than %d characters", maxConnectionRequestRelayLength)
+	}
+	relayUrl, err := url.Parse(connectionRequest.Relay)
+	if err != nil || (relayUrl.Scheme != "wss" && relayUrl.Scheme != "ws") {
+		return nil, fmt.Errorf("invalid connection request relay: %s", connectionRequest.Relay)
+	}
+	if connectionRequest.Secret == "" {
+		return nil, errors.New("connection request secret is required")
+	}
+	if len(connectionRequest.Secret) > maxConnectionRequestSecretLength {
+		return nil, fmt.Errorf("connection request secret is longer than %d characters", maxConnectionRequestSecretLength)
+	}
+	return connectionRequest, nil
+}
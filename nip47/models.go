@@ -7,40 +7,71 @@ import (
 )
 
 const (
-	INFO_EVENT_KIND            = 13194
-	REQUEST_KIND               = 23194
-	RESPONSE_KIND              = 23195
-	NOTIFICATION_KIND          = 23196
-	PAY_INVOICE_METHOD         = "pay_invoice"
-	GET_BALANCE_METHOD         = "get_balance"
-	GET_INFO_METHOD            = "get_info"
-	MAKE_INVOICE_METHOD        = "make_invoice"
-	LOOKUP_INVOICE_METHOD      = "lookup_invoice"
-	LIST_TRANSACTIONS_METHOD   = "list_transactions"
-	PAY_KEYSEND_METHOD         = "pay_keysend"
-	MULTI_PAY_INVOICE_METHOD   = "multi_pay_invoice"
-	MULTI_PAY_KEYSEND_METHOD   = "multi_pay_keysend"
-	SIGN_MESSAGE_METHOD        = "sign_message"
-	ERROR_INTERNAL             = "INTERNAL"
-	ERROR_NOT_IMPLEMENTED      = "NOT_IMPLEMENTED"
-	ERROR_QUOTA_EXCEEDED       = "QUOTA_EXCEEDED"
-	ERROR_INSUFFICIENT_BALANCE = "INSUFFICIENT_BALANCE"
-	ERROR_UNAUTHORIZED         = "UNAUTHORIZED"
-	ERROR_EXPIRED              = "EXPIRED"
-	ERROR_RESTRICTED           = "RESTRICTED"
-	ERROR_BAD_REQUEST          = "BAD_REQUEST"
-	OTHER                      = "OTHER"
-	CAPABILITIES               = "pay_invoice pay_keysend get_balance get_info make_invoice lookup_invoice list_transactions multi_pay_invoice multi_pay_keysend sign_message notifications"
-	NOTIFICATION_TYPES         = "payment_received" // same format as above e.g. "payment_received balance_updated payment_sent channel_opened channel_closed ..."
+	INFO_EVENT_KIND              = 13194
+	REQUEST_KIND                 = 23194
+	RESPONSE_KIND                = 23195
+	NOTIFICATION_KIND            = 23196
+	CONNECTION_REQUEST_KIND      = 23197
+	CONNECTION_RESPONSE_KIND     = 33194
+	PAY_INVOICE_METHOD           = "pay_invoice"
+	GET_BALANCE_METHOD           = "get_balance"
+	GET_INFO_METHOD              = "get_info"
+	MAKE_INVOICE_METHOD          = "make_invoice"
+	LOOKUP_INVOICE_METHOD        = "lookup_invoice"
+	LIST_TRANSACTIONS_METHOD     = "list_transactions"
+	PAY_KEYSEND_METHOD           = "pay_keysend"
+	MULTI_PAY_INVOICE_METHOD     = "multi_pay_invoice"
+	MULTI_PAY_KEYSEND_METHOD     = "multi_pay_keysend"
+	SIGN_MESSAGE_METHOD          = "sign_message"
+	MAKE_HOLD_INVOICE_METHOD     = "make_hold_invoice"
+	SETTLE_HOLD_INVOICE_METHOD   = "settle_hold_invoice"
+	CANCEL_HOLD_INVOICE_METHOD   = "cancel_hold_invoice"
+	PAY_LIGHTNING_ADDRESS_METHOD = "pay_lightning_address"
+	PAY_LNURL_METHOD             = "pay_lnurl"
+	ESTIMATE_FEE_METHOD          = "estimate_fee"
+	CREATE_SUBSCRIPTION_METHOD   = "create_subscription"
+	LIST_SUBSCRIPTIONS_METHOD    = "list_subscriptions"
+	CANCEL_SUBSCRIPTION_METHOD   = "cancel_subscription"
+	ERROR_INTERNAL               = "INTERNAL"
+	ERROR_NOT_IMPLEMENTED        = "NOT_IMPLEMENTED"
+	ERROR_QUOTA_EXCEEDED         = "QUOTA_EXCEEDED"
+	ERROR_INSUFFICIENT_BALANCE   = "INSUFFICIENT_BALANCE"
+	ERROR_UNAUTHORIZED           = "UNAUTHORIZED"
+	ERROR_EXPIRED                = "EXPIRED"
+	ERROR_RESTRICTED             = "RESTRICTED"
+	ERROR_BAD_REQUEST            = "BAD_REQUEST"
+	ERROR_MAX_FEE_EXCEEDED       = "MAX_FEE_EXCEEDED"
+	ERROR_NOT_FOUND              = "NOT_FOUND"
+	OTHER                        = "OTHER"
+	CAPABILITIES                 = "pay_invoice pay_keysend get_balance get_info make_invoice lookup_invoice list_transactions multi_pay_invoice multi_pay_keysend sign_message make_hold_invoice settle_hold_invoice cancel_hold_invoice pay_lightning_address pay_lnurl estimate_fee create_subscription list_subscriptions cancel_subscription notifications"
+	NOTIFICATION_TYPES           = "payment_received payment_sent payment_failed hold_invoice_accepted" // same format as above e.g. "payment_received balance_updated payment_sent channel_opened channel_closed ..."
 )
 
 // TODO: move other permissions here (e.g. all payment methods use pay_invoice)
 const (
 	NOTIFICATIONS_PERMISSION = "notifications"
+	// lets lookup_invoice and list_transactions return every transaction of the node, not only the app's own
+	ALL_TRANSACTIONS_PERMISSION = "all_transactions"
+	// lets apps which only see their own transactions also see and be notified of the keysend payments
+	// the node receives, which were paid to no app's invoice (e.g. boostagrams)
+	KEYSEND_RECEIPTS_PERMISSION = "keysend_receipts"
 )
 
 const (
-	PAYMENT_RECEIVED_NOTIFICATION = "payment_received"
+	PAYMENT_RECEIVED_NOTIFICATION      = "payment_received"
+	PAYMENT_SENT_NOTIFICATION          = "payment_sent"
+	PAYMENT_FAILED_NOTIFICATION        = "payment_failed"
+	HOLD_INVOICE_ACCEPTED_NOTIFICATION = "hold_invoice_accepted"
+)
+
+const (
+	PAYMENT_STATUS_PENDING = "pending"
+)
+
+const (
+	TRANSACTION_STATE_PENDING = "pending"
+	TRANSACTION_STATE_SETTLED = "settled"
+	TRANSACTION_STATE_FAILED  = "failed"
 )
 
 const (
@@ -82,16 +113,46 @@ type PaymentReceivedNotification struct {
 	Transaction
 }
 
+type PaymentSentNotification struct {
+	Transaction
+}
+
+type PaymentFailedNotification struct {
+	Transaction
+	Reason string `json:"reason,omitempty"`
+}
+
+type HoldInvoiceAcceptedNotification struct {
+	Transaction
+}
+
 type PayParams struct {
 	Invoice string `json:"invoice"`
+	// only pay_invoice: respond with a pending status right away and send a
+	// payment_sent or payment_failed notification once the payment resolves
+	Async bool `json:"async,omitempty"`
+	// in msat, bounds the routing fee together with the app's default fee limit
+	MaxFee *uint64 `json:"max_fee,omitempty"`
 }
 type PayResponse struct {
 	Preimage string  `json:"preimage"`
 	FeesPaid *uint64 `json:"fees_paid"`
+	Status   string  `json:"status,omitempty"`
+}
+
+type PayLnurlParams struct {
+	LightningAddress string          `json:"lightning_address"`
+	Lnurl            string          `json:"lnurl"`
+	Amount           int64           `json:"amount"`
+	Comment          string          `json:"comment"`
+	PayerData        json.RawMessage `json:"payer_data,omitempty"`
+	MaxFee           *uint64         `json:"max_fee,omitempty"`
 }
 
 type MultiPayKeysendParams struct {
 	Keysends []MultiPayKeysendElement `json:"keysends"`
+	// publish a final summary response once every keysend completed
+	Summary bool `json:"summary,omitempty"`
 }
 
 type MultiPayKeysendElement struct {
@@ -101,6 +162,8 @@ type MultiPayKeysendElement struct {
 
 type MultiPayInvoiceParams struct {
 	Invoices []MultiPayInvoiceElement `json:"invoices"`
+	// publish a final summary response once every invoice completed
+	Summary bool `json:"summary,omitempty"`
 }
 
 type MultiPayInvoiceElement struct {
@@ -108,17 +171,40 @@ type MultiPayInvoiceElement struct {
 	Id string `json:"id"`
 }
 
+// MultiPaySummaryResponse is published after the responses of the individual payments of a multi_pay request
+type MultiPaySummaryResponse struct {
+	Succeeded []MultiPaySummaryElement `json:"succeeded"`
+	Failed    []MultiPaySummaryElement `json:"failed"`
+	// in msat, of the succeeded payments only
+	TotalAmount int64  `json:"total_amount"`
+	TotalFees   uint64 `json:"total_fees"`
+}
+
+type MultiPaySummaryElement struct {
+	// the d tag of the payment's response
+	Id       string `json:"id"`
+	Amount   int64  `json:"amount"`
+	Preimage string `json:"preimage,omitempty"`
+	FeesPaid uint64 `json:"fees_paid,omitempty"`
+	Error    *Error `json:"error,omitempty"`
+}
+
 type KeysendParams struct {
 	Amount     int64                `json:"amount"`
 	Pubkey     string               `json:"pubkey"`
 	Preimage   string               `json:"preimage"`
 	TLVRecords []lnclient.TLVRecord `json:"tlv_records"`
+	MaxFee     *uint64              `json:"max_fee,omitempty"`
 }
 
 type BalanceResponse struct {
 	Balance       int64  `json:"balance"`
 	MaxAmount     int    `json:"max_amount"`
 	BudgetRenewal string `json:"budget_renewal"`
+
+	FiatBalance   *float64 `json:"fiat_balance,omitempty"`
+	FiatMaxAmount *float64 `json:"fiat_max_amount,omitempty"`
+	FiatCurrency  string   `json:"fiat_currency,omitempty"`
 }
 
 type GetInfoResponse struct {
@@ -141,6 +227,27 @@ type MakeInvoiceResponse struct {
 	Transaction
 }
 
+type MakeHoldInvoiceParams struct {
+	Amount          int64  `json:"amount"`
+	Description     string `json:"description"`
+	DescriptionHash string `json:"description_hash"`
+	Expiry          int64  `json:"expiry"`
+	PaymentHash     string `json:"payment_hash"`
+}
+type MakeHoldInvoiceResponse struct {
+	Transaction
+}
+
+type SettleHoldInvoiceParams struct {
+	Preimage string `json:"preimage"`
+}
+type SettleHoldInvoiceResponse struct{}
+
+type CancelHoldInvoiceParams struct {
+	PaymentHash string `json:"payment_hash"`
+}
+type CancelHoldInvoiceResponse struct{}
+
 type LookupInvoiceParams struct {
 	Invoice     string `json:"invoice"`
 	PaymentHash string `json:"payment_hash"`
@@ -163,6 +270,57 @@ type ListTransactionsResponse struct {
 	Transactions []Transaction `json:"transactions"`
 }
 
+// EstimateFeeParams is either for an invoice or for a keysend payment of amount (msat) to pubkey.
+// The amount is also used for invoices without an amount.
+type EstimateFeeParams struct {
+	Invoice string `json:"invoice"`
+	Pubkey  string `json:"pubkey"`
+	Amount  uint64 `json:"amount"`
+}
+
+type EstimateFeeResponse struct {
+	// in msat, omitted when the wallet can only tell whether a route exists
+	Fee                *uint64 `json:"fee,omitempty"`
+	SuccessProbability float64 `json:"success_probability"`
+}
+
+// CreateSubscriptionParams schedules a payment of amount (msat) to either a keysend pubkey or a lightning address.
+// Interval is one of @hourly, @daily, @weekly, @monthly, @yearly or @every <duration>, e.g. "@every 720h".
+type CreateSubscriptionParams struct {
+	Pubkey           string `json:"pubkey"`
+	LightningAddress string `json:"lightning_address"`
+	Amount           int64  `json:"amount"`
+	Interval         string `json:"interval"`
+	// sent as comment to lightning addresses
+	Description string `json:"description"`
+}
+
+type CreateSubscriptionResponse struct {
+	Subscription
+}
+
+type ListSubscriptionsResponse struct {
+	Subscriptions []Subscription `json:"subscriptions"`
+}
+
+type CancelSubscriptionParams struct {
+	Id uint `json:"id"`
+}
+
+type CancelSubscriptionResponse struct{}
+
+type Subscription struct {
+	Id               uint   `json:"id"`
+	Pubkey           string `json:"pubkey,omitempty"`
+	LightningAddress string `json:"lightning_address,omitempty"`
+	Amount           int64  `json:"amount"`
+	Interval         string `json:"interval"`
+	Description      string `json:"description,omitempty"`
+	NextRunAt        int64  `json:"next_run_at"`
+	LastRunAt        *int64 `json:"last_run_at"`
+	CreatedAt        int64  `json:"created_at"`
+}
+
 type SignMessageParams struct {
 	Message string `json:"message"`
 }
@@ -171,3 +329,27 @@ type SignMessageResponse struct {
 	Message   string `json:"message"`
 	Signature string `json:"signature"`
 }
+
+// ConnectionRequest is the content of an app's request for a connection (Nostr Wallet Auth).
+// The event carrying it is signed with the key the app will use for its NIP-47 requests.
+type ConnectionRequest struct {
+	Name           string   `json:"name"`
+	RequestMethods []string `json:"request_methods"`
+	MaxAmount      int      `json:"max_amount,omitempty"` // in sats
+	BudgetRenewal  string   `json:"budget_renewal,omitempty"`
+	ExpiresAt      int64    `json:"expires_at,omitempty"`
+	// the relay the app listens for the answer on
+	Relay string `json:"relay"`
+	// chosen by the app to recognize the answer to its request
+	Secret string `json:"secret"`
+}
+
+// ConnectionResponse is the encrypted content of the hub's answer to a connection request
+type ConnectionResponse struct {
+	Secret         string   `json:"secret"`
+	Pubkey         string   `json:"pubkey,omitempty"` // of the wallet service the app sends its requests to
+	Relay          string   `json:"relay,omitempty"`
+	RequestMethods []string `json:"request_methods,omitempty"`
+	Lud16          string   `json:"lud16,omitempty"`
+	Error          *Error   `json:"error,omitempty"`
+}
@@ -31,43 +31,148 @@ func NewNip47Notifier(svc *Service, relay Relay) *Nip47Notifier {
 }
 
 func (notifier *Nip47Notifier) ConsumeEvent(ctx context.Context, event *events.Event) error {
-	if event.Event != "nwc_payment_received" {
+	if notifier.svc.lnClient == nil {
 		return nil
 	}
 
-	if notifier.svc.lnClient == nil {
-		return nil
+	switch event.Event {
+	case "nwc_payment_received":
+		paymentReceivedEventProperties, ok := event.Properties.(*events.PaymentReceivedEventProperties)
+		if !ok {
+			notifier.svc.logger.WithField("event", event).Error("Failed to cast event")
+			return errors.New("failed to cast event")
+		}
+
+		transaction, err := notifier.svc.lnClient.LookupInvoice(ctx, paymentReceivedEventProperties.PaymentHash)
+		if err != nil {
+			notifier.svc.logger.
+				WithField("paymentHash", paymentReceivedEventProperties.PaymentHash).
+				WithError(err).
+				Error("Failed to lookup invoice by payment hash")
+			return err
+		}
+		if paymentReceivedEventProperties.Internal {
+			transaction = notifier.svc.withInternalSettlement(transaction)
+		}
+
+		transactions := []nip47.Transaction{*transaction}
+		notifier.svc.withFiatValues(transactions, notifier.svc.cfg.GetEnv().FiatCurrency)
+
+		// other apps must not learn about the payments of an app, e.g. to the invoices of an isolated app.
+		// Keysend payments were paid to no app's invoice, they go to the apps allowed to see them.
+		keysend := transaction.Invoice == ""
+		notifier.notifyInvoiceApps(ctx, paymentReceivedEventProperties.PaymentHash, func(app *db.App) bool {
+			return notifier.svc.canSeeAllTransactions(app) || (keysend && notifier.svc.canSeeKeysendReceipts(app))
+		}, &nip47.Notification{
+			Notification:     &transactions[0],
+			NotificationType: nip47.PAYMENT_RECEIVED_NOTIFICATION,
+		}, nostr.Tags{})
+	case "nwc_hold_invoice_accepted":
+		holdInvoiceAcceptedEventProperties, ok := event.Properties.(*events.HoldInvoiceAcceptedEventProperties)
+		if !ok {
+			notifier.svc.logger.WithField("event", event).Error("Failed to cast event")
+			return errors.New("failed to cast event")
+		}
+
+		transaction, err := notifier.svc.lnClient.LookupInvoice(ctx, holdInvoiceAcceptedEventProperties.PaymentHash)
+		if err != nil {
+			notifier.svc.logger.
+				WithField("paymentHash", holdInvoiceAcceptedEventProperties.PaymentHash).
+				WithError(err).
+				Error("Failed to lookup hold invoice by payment hash")
+			return err
+		}
+
+		transactions := []nip47.Transaction{*transaction}
+		notifier.svc.withFiatValues(transactions, notifier.svc.cfg.GetEnv().FiatCurrency)
+
+		// only the app holding the invoice can settle or cancel it
+		notifier.notifyInvoiceApps(ctx, holdInvoiceAcceptedEventProperties.PaymentHash, nil, &nip47.Notification{
+			Notification:     &transactions[0],
+			NotificationType: nip47.HOLD_INVOICE_ACCEPTED_NOTIFICATION,
+		}, nostr.Tags{})
+	case "nwc_payment_succeeded":
+		// other payment methods still publish untyped properties
+		paymentSucceededEventProperties, ok := event.Properties.(*events.PaymentSucceededEventProperties)
+		if !ok {
+			return nil
+		}
+		notifier.notifyPayingApp(ctx, paymentSucceededEventProperties.AppId, paymentSucceededEventProperties.PaymentHash, func(transaction *nip47.Transaction) *nip47.Notification {
+			return &nip47.Notification{
+				Notification: &nip47.PaymentSentNotification{
+					Transaction: *transaction,
+				},
+				NotificationType: nip47.PAYMENT_SENT_NOTIFICATION,
+			}
+		})
+	case "nwc_payment_failed":
+		paymentFailedEventProperties, ok := event.Properties.(*events.PaymentFailedEventProperties)
+		if !ok {
+			return nil
+		}
+		notifier.notifyPayingApp(ctx, paymentFailedEventProperties.AppId, paymentFailedEventProperties.PaymentHash, func(transaction *nip47.Transaction) *nip47.Notification {
+			return &nip47.Notification{
+				Notification: &nip47.PaymentFailedNotification{
+					Transaction: *transaction,
+					Reason:      paymentFailedEventProperties.Reason,
+				},
+				NotificationType: nip47.PAYMENT_FAILED_NOTIFICATION,
+			}
+		})
+	}
+	return nil
+}
+
+// notifyPayingApp sends the outcome of an outgoing payment only to the app which made it
+func (notifier *Nip47Notifier) notifyPayingApp(ctx context.Context, appId uint, paymentHash string, buildNotification func(transaction *nip47.Transaction) *nip47.Notification) {
+	app := db.App{}
+	err := notifier.svc.db.First(&app, appId).Error
+	if err != nil {
+		notifier.svc.logger.WithField("appId", appId).WithError(err).Error("Failed to find app for payment notification")
+		return
 	}
 
-	paymentReceivedEventProperties, ok := event.Properties.(*events.PaymentReceivedEventProperties)
-	if !ok {
-		notifier.svc.logger.WithField("event", event).Error("Failed to cast event")
-		return errors.New("failed to cast event")
+	hasPermission, _, _ := notifier.svc.hasPermission(&app, nip47.NOTIFICATIONS_PERMISSION, 0)
+	if !hasPermission {
+		return
 	}
 
-	transaction, err := notifier.svc.lnClient.LookupInvoice(ctx, paymentReceivedEventProperties.PaymentHash)
+	payment := db.Payment{}
+	err = notifier.svc.db.Where("app_id = ? AND payment_hash = ?", appId, paymentHash).Order("id desc").First(&payment).Error
 	if err != nil {
-		notifier.svc.logger.
-			WithField("paymentHash", paymentReceivedEventProperties.PaymentHash).
-			WithError(err).
-			Error("Failed to lookup invoice by payment hash")
-		return err
+		notifier.svc.logger.WithFields(logrus.Fields{
+			"appId":       appId,
+			"paymentHash": paymentHash,
+		}).WithError(err).Error("Failed to find payment for notification")
+		return
 	}
 
-	notifier.notifySubscribers(ctx, &nip47.Notification{
-		Notification:     transaction,
-		NotificationType: nip47.PAYMENT_RECEIVED_NOTIFICATION,
-	}, nostr.Tags{})
-	return nil
+	transactions := []nip47.Transaction{*notifier.svc.paymentToTransaction(&payment)}
+	notifier.svc.withFiatValues(transactions, notifier.svc.fiatCurrency(&app))
+	notifier.notifySubscriber(ctx, &app, buildNotification(&transactions[0]), nostr.Tags{})
 }
 
-func (notifier *Nip47Notifier) notifySubscribers(ctx context.Context, notification *nip47.Notification, tags nostr.Tags) {
-	apps := []db.App{}
+// notifyInvoiceApps sends a notification about an invoice to the app which created it,
+// and to the other apps for which canSee is given and returns true
+func (notifier *Nip47Notifier) notifyInvoiceApps(ctx context.Context, paymentHash string, canSee func(app *db.App) bool, notification *nip47.Notification, tags nostr.Tags) {
+	invoices := []db.Invoice{}
+	err := notifier.svc.db.Where("payment_hash = ?", paymentHash).Find(&invoices).Error
+	if err != nil {
+		notifier.svc.logger.WithField("paymentHash", paymentHash).WithError(err).Error("Failed to find invoice for notification")
+		return
+	}
+	invoiceAppIds := map[uint]bool{}
+	for _, invoice := range invoices {
+		invoiceAppIds[invoice.AppId] = true
+	}
 
-	// TODO: join apps and permissions
+	apps := []db.App{}
 	notifier.svc.db.Find(&apps)
 
 	for _, app := range apps {
+		if !invoiceAppIds[app.ID] && !(canSee != nil && canSee(&app)) {
+			continue
+		}
 		hasPermission, _, _ := notifier.svc.hasPermission(&app, nip47.NOTIFICATIONS_PERMISSION, 0)
 		if !hasPermission {
 			continue
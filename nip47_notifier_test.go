@@ -2,13 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"log"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/getAlby/nostr-wallet-connect/db"
 	"github.com/getAlby/nostr-wallet-connect/events"
+	"github.com/getAlby/nostr-wallet-connect/lnclient"
 	"github.com/getAlby/nostr-wallet-connect/nip47"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip04"
@@ -53,6 +56,12 @@ func TestSendNotification(t *testing.T) {
 	relay := NewMockRelay()
 
 	n := NewNip47Notifier(svc, relay)
+	// payments to invoices of other apps are not notified
+	n.ConsumeEvent(ctx, receivedEvent)
+	assert.Nil(t, relay.publishedEvent)
+
+	err = svc.db.Create(&db.Invoice{AppId: app.ID, PaymentHash: mockPaymentHash}).Error
+	assert.NoError(t, err)
 	n.ConsumeEvent(ctx, receivedEvent)
 
 	assert.NotNil(t, relay.publishedEvent)
@@ -80,6 +89,242 @@ func TestSendNotification(t *testing.T) {
 	assert.Equal(t, mockTransaction.SettledAt, transaction.SettledAt)
 }
 
+func TestSendHoldInvoiceAcceptedNotification(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, ss, err := createApp(svc)
+	assert.NoError(t, err)
+
+	appPermission := &db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.NOTIFICATIONS_PERMISSION,
+	}
+	err = svc.db.Create(appPermission).Error
+	assert.NoError(t, err)
+
+	err = svc.db.Create(&db.Invoice{AppId: app.ID, PaymentHash: mockPaymentHash, Hold: true}).Error
+	assert.NoError(t, err)
+
+	// other apps are not notified of the invoice
+	otherApp, _, err := createApp(svc)
+	assert.NoError(t, err)
+	err = svc.db.Create(&db.AppPermission{
+		AppId:         otherApp.ID,
+		App:           *otherApp,
+		RequestMethod: nip47.NOTIFICATIONS_PERMISSION,
+	}).Error
+	assert.NoError(t, err)
+
+	testEvent := &events.Event{
+		Event: "nwc_hold_invoice_accepted",
+		Properties: &events.HoldInvoiceAcceptedEventProperties{
+			PaymentHash: mockPaymentHash,
+			Amount:      uint64(mockTransaction.Amount),
+			NodeType:    "LND",
+		},
+	}
+
+	relay := NewMockRelay()
+
+	n := NewNip47Notifier(svc, relay)
+	n.ConsumeEvent(ctx, testEvent)
+
+	assert.NotNil(t, relay.publishedEvent)
+	assert.Equal(t, app.NostrPubkey, relay.publishedEvent.Tags.GetFirst([]string{"p"}).Value())
+
+	decrypted, err := nip04.Decrypt(relay.publishedEvent.Content, ss)
+	assert.NoError(t, err)
+	unmarshalledResponse := nip47.Notification{
+		Notification: &nip47.HoldInvoiceAcceptedNotification{},
+	}
+
+	err = json.Unmarshal([]byte(decrypted), &unmarshalledResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, nip47.HOLD_INVOICE_ACCEPTED_NOTIFICATION, unmarshalledResponse.NotificationType)
+
+	transaction := (unmarshalledResponse.Notification.(*nip47.HoldInvoiceAcceptedNotification))
+	assert.Equal(t, mockTransaction.PaymentHash, transaction.PaymentHash)
+	assert.Equal(t, mockTransaction.Amount, transaction.Amount)
+}
+
+func TestSendPaymentNotifications(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, ss, err := createApp(svc)
+	assert.NoError(t, err)
+
+	err = svc.db.Create(&db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.NOTIFICATIONS_PERMISSION,
+	}).Error
+	assert.NoError(t, err)
+
+	requestEvent := &db.RequestEvent{NostrId: "payment_notifications"}
+	err = svc.db.Create(requestEvent).Error
+	assert.NoError(t, err)
+	preimage := "123preimage"
+	settledAt := time.Now()
+	payment := &db.Payment{
+		App:            *app,
+		RequestEvent:   *requestEvent,
+		PaymentRequest: mockInvoice,
+		PaymentHash:    mockPaymentHash,
+		AmountMsat:     123000,
+		Preimage:       &preimage,
+		State:          db.PAYMENT_STATE_SUCCEEDED,
+		SettledAt:      &settledAt,
+	}
+	err = svc.db.Create(payment).Error
+	assert.NoError(t, err)
+
+	relay := NewMockRelay()
+	n := NewNip47Notifier(svc, relay)
+	n.ConsumeEvent(ctx, &events.Event{
+		Event: "nwc_payment_succeeded",
+		Properties: &events.PaymentSucceededEventProperties{
+			Bolt11:      mockInvoice,
+			Amount:      123,
+			AppId:       app.ID,
+			PaymentHash: mockPaymentHash,
+		},
+	})
+
+	assert.NotNil(t, relay.publishedEvent)
+	assert.Equal(t, app.NostrPubkey, relay.publishedEvent.Tags.GetFirst([]string{"p"}).Value())
+	decrypted, err := nip04.Decrypt(relay.publishedEvent.Content, ss)
+	assert.NoError(t, err)
+	sentNotification := nip47.Notification{
+		Notification: &nip47.PaymentSentNotification{},
+	}
+	err = json.Unmarshal([]byte(decrypted), &sentNotification)
+	assert.NoError(t, err)
+	assert.Equal(t, nip47.PAYMENT_SENT_NOTIFICATION, sentNotification.NotificationType)
+	sentTransaction := sentNotification.Notification.(*nip47.PaymentSentNotification)
+	assert.Equal(t, "outgoing", sentTransaction.Type)
+	assert.Equal(t, mockPaymentHash, sentTransaction.PaymentHash)
+	assert.Equal(t, preimage, sentTransaction.Preimage)
+	assert.Equal(t, int64(123000), sentTransaction.Amount)
+	assert.Equal(t, nip47.TRANSACTION_STATE_SETTLED, sentTransaction.State)
+	assert.Equal(t, settledAt.Unix(), *sentTransaction.SettledAt)
+
+	payment.Preimage = nil
+	payment.SettledAt = nil
+	payment.State = db.PAYMENT_STATE_FAILED
+	err = svc.db.Save(payment).Error
+	assert.NoError(t, err)
+
+	relay = NewMockRelay()
+	n = NewNip47Notifier(svc, relay)
+	n.ConsumeEvent(ctx, &events.Event{
+		Event: "nwc_payment_failed",
+		Properties: &events.PaymentFailedEventProperties{
+			Invoice:     mockInvoice,
+			Amount:      123,
+			AppId:       app.ID,
+			PaymentHash: mockPaymentHash,
+			Reason:      "no route",
+		},
+	})
+
+	assert.NotNil(t, relay.publishedEvent)
+	decrypted, err = nip04.Decrypt(relay.publishedEvent.Content, ss)
+	assert.NoError(t, err)
+	failedNotification := nip47.Notification{
+		Notification: &nip47.PaymentFailedNotification{},
+	}
+	err = json.Unmarshal([]byte(decrypted), &failedNotification)
+	assert.NoError(t, err)
+	assert.Equal(t, nip47.PAYMENT_FAILED_NOTIFICATION, failedNotification.NotificationType)
+	failedTransaction := failedNotification.Notification.(*nip47.PaymentFailedNotification)
+	assert.Equal(t, mockPaymentHash, failedTransaction.PaymentHash)
+	assert.Equal(t, "no route", failedTransaction.Reason)
+	assert.Equal(t, nip47.TRANSACTION_STATE_FAILED, failedTransaction.State)
+}
+
+func TestSendKeysendNotificationWithTLVMetadata(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, ss, err := createApp(svc)
+	assert.NoError(t, err)
+
+	// keysend payments are not made to an invoice of an app
+	for _, method := range []string{nip47.NOTIFICATIONS_PERMISSION, nip47.KEYSEND_RECEIPTS_PERMISSION} {
+		err = svc.db.Create(&db.AppPermission{
+			AppId:         app.ID,
+			App:           *app,
+			RequestMethod: method,
+		}).Error
+		assert.NoError(t, err)
+	}
+	// apps which only see their own transactions are not told about keysend payments without the permission
+	otherApp, _, err := createApp(svc)
+	assert.NoError(t, err)
+	err = svc.db.Create(&db.AppPermission{
+		AppId:         otherApp.ID,
+		App:           *otherApp,
+		RequestMethod: nip47.NOTIFICATIONS_PERMISSION,
+	}).Error
+	assert.NoError(t, err)
+
+	keysendTransaction := *mockTransaction
+	keysendTransaction.Invoice = ""
+	keysendTransaction.Metadata = lnclient.TLVRecordsToMetadata(map[uint64][]byte{
+		lnclient.TLV_BOOSTAGRAM:       []byte(`{"action":"boost","app_name":"Fountain","message":"Great episode!","value_msat_total":1000}`),
+		lnclient.TLV_KEYSEND_PREIMAGE: []byte("preimage"),
+		696969:                        {0x01, 0x02},
+	})
+	originalMockTransaction := mockTransaction
+	mockTransaction = &keysendTransaction
+	defer func() { mockTransaction = originalMockTransaction }()
+
+	relay := NewMockRelay()
+
+	n := NewNip47Notifier(svc, relay)
+	n.ConsumeEvent(ctx, &events.Event{
+		Event: "nwc_payment_received",
+		Properties: &events.PaymentReceivedEventProperties{
+			PaymentHash: mockPaymentHash,
+			Amount:      uint64(mockTransaction.Amount),
+			NodeType:    "LND",
+		},
+	})
+
+	assert.NotNil(t, relay.publishedEvent)
+	assert.Equal(t, app.NostrPubkey, relay.publishedEvent.Tags.GetFirst([]string{"p"}).Value())
+
+	decrypted, err := nip04.Decrypt(relay.publishedEvent.Content, ss)
+	assert.NoError(t, err)
+	unmarshalledResponse := nip47.Notification{
+		Notification: &nip47.PaymentReceivedNotification{},
+	}
+	err = json.Unmarshal([]byte(decrypted), &unmarshalledResponse)
+	assert.NoError(t, err)
+
+	metadata := unmarshalledResponse.Notification.(*nip47.PaymentReceivedNotification).Metadata.(map[string]interface{})
+	boostagram := metadata["boostagram"].(map[string]interface{})
+	assert.Equal(t, "Great episode!", boostagram["message"])
+	assert.Equal(t, "Fountain", boostagram["app_name"])
+	// the keysend preimage is not exposed
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"type": float64(696969), "value": "0102"},
+		map[string]interface{}{"type": float64(lnclient.TLV_BOOSTAGRAM), "value": hex.EncodeToString([]byte(`{"action":"boost","app_name":"Fountain","message":"Great episode!","value_msat_total":1000}`))},
+	}, metadata["tlv_records"])
+}
+
 func TestSendNotificationNoPermission(t *testing.T) {
 	ctx := context.TODO()
 	defer os.Remove(testDB)
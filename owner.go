@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	"github.com/nbd-wtf/go-nostr"
+	"gorm.io/gorm"
+)
+
+// methods the owner can request through the hub's API
+var ownerMethods = []string{
+	nip47.PAY_INVOICE_METHOD,
+	nip47.PAY_KEYSEND_METHOD,
+	nip47.PAY_LIGHTNING_ADDRESS_METHOD,
+	nip47.PAY_LNURL_METHOD,
+	nip47.MAKE_INVOICE_METHOD,
+	nip47.LOOKUP_INVOICE_METHOD,
+	nip47.LIST_TRANSACTIONS_METHOD,
+}
+
+// getOwnerApp returns the built-in app the owner's payments and invoices are recorded under, creating it on first use
+func (svc *Service) getOwnerApp() (*db.App, error) {
+	app := db.App{}
+	err := svc.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("nostr_pubkey = ?", db.OWNER_APP_NOSTR_PUBKEY).Limit(1).Find(&app)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			return nil
+		}
+
+		app = db.App{
+			Name:        "Owner",
+			Description: "Payments and invoices made through the hub's API",
+			NostrPubkey: db.OWNER_APP_NOSTR_PUBKEY,
+		}
+		err := tx.Create(&app).Error
+		if err != nil {
+			return err
+		}
+		// no budget and no expiry, the owner can see every transaction of the node
+		for _, method := range []string{nip47.PAY_INVOICE_METHOD, nip47.MAKE_INVOICE_METHOD, nip47.LOOKUP_INVOICE_METHOD, nip47.LIST_TRANSACTIONS_METHOD, nip47.ALL_TRANSACTIONS_PERMISSION} {
+			err = tx.Create(&db.AppPermission{
+				App:           app,
+				RequestMethod: method,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &app, nil
+}
+
+// HandleOwnerRequest handles a NIP-47 request of the owner the same way as requests of connected apps,
+// so that payments and invoices made through the hub's API show up in the history
+func (svc *Service) HandleOwnerRequest(ctx context.Context, nip47Request *nip47.Request) (*nip47.Response, error) {
+	if svc.lnClient == nil {
+		return nil, errors.New("LNClient not started")
+	}
+	if !slices.Contains(ownerMethods, nip47Request.Method) {
+		return nil, fmt.Errorf("unsupported method: %s", nip47Request.Method)
+	}
+
+	app, err := svc.getOwnerApp()
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(nip47Request)
+	if err != nil {
+		return nil, err
+	}
+
+	// there is no nostr event, the request is identified by a random ID instead
+	idBytes := make([]byte, 32)
+	_, err = rand.Read(idBytes)
+	if err != nil {
+		return nil, err
+	}
+	requestEvent := db.RequestEvent{
+		AppId:       &app.ID,
+		NostrId:     hex.EncodeToString(idBytes),
+		ContentData: string(payload),
+		Method:      nip47Request.Method,
+		State:       db.REQUEST_EVENT_STATE_HANDLER_EXECUTING,
+	}
+	err = svc.db.Create(&requestEvent).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var response *nip47.Response
+	svc.dispatchNip47Request(ctx, nip47Request, &requestEvent, app, func(nip47Response *nip47.Response, tags nostr.Tags) {
+		response = nip47Response
+	})
+
+	requestEvent.State = db.REQUEST_EVENT_STATE_HANDLER_EXECUTED
+	err = svc.db.Save(&requestEvent).Error
+	if err != nil {
+		svc.logger.WithField("requestEventId", requestEvent.ID).WithError(err).Error("Failed to save state of owner request")
+	}
+
+	if response == nil {
+		return nil, errors.New("no response to the request")
+	}
+	return response, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/events"
+	"github.com/getAlby/nostr-wallet-connect/fiat"
+	"github.com/getAlby/nostr-wallet-connect/lnclient"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+	"github.com/sirupsen/logrus"
+)
+
+// paymentOptions bounds the routing fee by the lower of the requested max fee and the app's default fee limit
+func (svc *Service) paymentOptions(app *db.App, maxFeeMsat *uint64) lnclient.PaymentOptions {
+	options := lnclient.PaymentOptions{
+		MaxFeeMsat: maxFeeMsat,
+	}
+
+	appPermission := db.AppPermission{}
+	result := svc.db.Where("app_id = ? AND request_method = ?", app.ID, nip47.PAY_INVOICE_METHOD).Limit(1).Find(&appPermission)
+	if result.RowsAffected > 0 && appPermission.MaxFeeMsat != nil && (options.MaxFeeMsat == nil || *appPermission.MaxFeeMsat < *options.MaxFeeMsat) {
+		options.MaxFeeMsat = appPermission.MaxFeeMsat
+	}
+	return options
+}
+
+// paymentErrorCode maps the error of a failed payment to a NIP-47 error code
+func paymentErrorCode(err error) string {
+	switch {
+	case errors.Is(err, lnclient.ErrMaxFeeExceeded):
+		return nip47.ERROR_MAX_FEE_EXCEEDED
+	case errors.Is(err, lnclient.ErrNotImplemented):
+		return nip47.ERROR_NOT_IMPLEMENTED
+	}
+	return nip47.ERROR_INTERNAL
+}
+
+func (svc *Service) markPaymentSucceeded(payment *db.Payment, preimage string, feeMsat *uint64) error {
+	now := time.Now()
+	if payment.PaymentHash == "" {
+		// keysend payments are only identified by their preimage
+		preimageBytes, err := hex.DecodeString(preimage)
+		if err == nil {
+			paymentHash := sha256.Sum256(preimageBytes)
+			payment.PaymentHash = hex.EncodeToString(paymentHash[:])
+		}
+	}
+	payment.Preimage = &preimage
+	payment.FeeMsat = feeMsat
+	payment.State = db.PAYMENT_STATE_SUCCEEDED
+	payment.SettledAt = &now
+	svc.recordFiatRate(payment)
+	err := svc.db.Save(payment).Error
+	if err != nil {
+		return err
+	}
+	return svc.recordTransaction(svc.paymentToTransaction(payment), &payment.AppId)
+}
+
+func (svc *Service) markPaymentFailed(payment *db.Payment, reason error) error {
+	now := time.Now()
+	payment.State = db.PAYMENT_STATE_FAILED
+	payment.FailureReason = reason.Error()
+	payment.FailedAt = &now
+	err := svc.db.Save(payment).Error
+	if err != nil {
+		return err
+	}
+	return svc.recordTransaction(svc.paymentToTransaction(payment), &payment.AppId)
+}
+
+// paymentSucceeded records the outcome of the payment and publishes nwc_payment_succeeded, which notifies the paying app
+func (svc *Service) paymentSucceeded(payment *db.Payment, preimage string, feeMsat *uint64) {
+	err := svc.markPaymentSucceeded(payment, preimage, feeMsat)
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"paymentId": payment.ID,
+			"appId":     payment.AppId,
+		}).WithError(err).Error("Failed to mark payment as succeeded")
+	}
+	svc.eventPublisher.Publish(&events.Event{
+		Event: "nwc_payment_succeeded",
+		Properties: &events.PaymentSucceededEventProperties{
+			Bolt11:      payment.PaymentRequest,
+			Amount:      payment.AmountMsat / 1000,
+			AppId:       payment.AppId,
+			PaymentHash: payment.PaymentHash,
+		},
+	})
+}
+
+// paymentFailed records the outcome of the payment and publishes nwc_payment_failed, which notifies the paying app
+func (svc *Service) paymentFailed(payment *db.Payment, reason error) {
+	err := svc.markPaymentFailed(payment, reason)
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"paymentId": payment.ID,
+			"appId":     payment.AppId,
+		}).WithError(err).Error("Failed to mark payment as failed")
+	}
+	svc.eventPublisher.Publish(&events.Event{
+		Event: "nwc_payment_failed",
+		Properties: &events.PaymentFailedEventProperties{
+			Invoice:     payment.PaymentRequest,
+			Amount:      payment.AmountMsat / 1000,
+			AppId:       payment.AppId,
+			PaymentHash: payment.PaymentHash,
+			Reason:      reason.Error(),
+		},
+	})
+}
+
+// paymentToTransaction describes an outgoing payment from its stored state
+func (svc *Service) paymentToTransaction(payment *db.Payment) *nip47.Transaction {
+	transaction := &nip47.Transaction{
+		Type:        "outgoing",
+		Invoice:     payment.PaymentRequest,
+		PaymentHash: payment.PaymentHash,
+		Amount:      int64(payment.AmountMsat),
+		CreatedAt:   payment.CreatedAt.Unix(),
+		State:       nip47.TRANSACTION_STATE_PENDING,
+	}
+
+	paymentRequest, err := decodepay.Decodepay(payment.PaymentRequest)
+	if err == nil {
+		transaction.Description = paymentRequest.Description
+		transaction.DescriptionHash = paymentRequest.DescriptionHash
+		expiresAt := int64(paymentRequest.CreatedAt + paymentRequest.Expiry)
+		transaction.ExpiresAt = &expiresAt
+	}
+
+	if payment.Preimage != nil {
+		transaction.Preimage = *payment.Preimage
+	}
+	if payment.FeeMsat != nil {
+		transaction.FeesPaid = int64(*payment.FeeMsat)
+	}
+	if payment.SubscriptionId != nil {
+		transaction.Metadata = map[string]interface{}{
+			"subscription_id": *payment.SubscriptionId,
+		}
+	}
+	switch payment.State {
+	case db.PAYMENT_STATE_SUCCEEDED:
+		transaction.State = nip47.TRANSACTION_STATE_SETTLED
+		if payment.SettledAt != nil {
+			settledAt := payment.SettledAt.Unix()
+			transaction.SettledAt = &settledAt
+		}
+	case db.PAYMENT_STATE_FAILED:
+		transaction.State = nip47.TRANSACTION_STATE_FAILED
+	}
+	if payment.FiatRate != nil {
+		// the value when the payment was made rather than today's
+		fiatAmount := fiat.MsatToFiat(transaction.Amount, &fiat.Rate{Currency: payment.FiatCurrency, BtcPrice: *payment.FiatRate})
+		transaction.FiatAmount = &fiatAmount
+		transaction.FiatCurrency = payment.FiatCurrency
+	}
+
+	return transaction
+}
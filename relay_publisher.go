@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/lnurl"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// relayPublishTimeout bounds connecting and publishing to a relay which is not the hub's own
+	relayPublishTimeout = 10 * time.Second
+	// maxPublishRelays bounds the relays an event is published to, the list comes from apps or payers
+	maxPublishRelays = 10
+)
+
+// publishToRelay connects to a relay given by an app or a payer only to publish the event.
+// Only public websocket relays are dialed, so the hub cannot be used to reach services on its own machine or network.
+func publishToRelay(ctx context.Context, relayUrl string, event nostr.Event) error {
+	parsedUrl, err := url.Parse(relayUrl)
+	if err != nil {
+		return fmt.Errorf("invalid relay url: %w", err)
+	}
+	if parsedUrl.Scheme != "wss" && parsedUrl.Scheme != "ws" {
+		return fmt.Errorf("relay url must use ws or wss: %s", relayUrl)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, relayPublishTimeout)
+	defer cancel()
+
+	err = lnurl.CheckPublicHost(ctx, parsedUrl.Hostname())
+	if err != nil {
+		return fmt.Errorf("relay %w", err)
+	}
+
+	relay, err := nostr.RelayConnect(ctx, relayUrl)
+	if err != nil {
+		return err
+	}
+	defer relay.Close()
+
+	return relay.Publish(ctx, event)
+}
+
+// publishToRelays publishes the event to the first maxPublishRelays distinct relays at the same time,
+// it fails only if the event could not be published to any of them
+func (svc *Service) publishToRelays(ctx context.Context, relayUrls []string, event nostr.Event) error {
+	uniqueRelayUrls := []string{}
+	for _, relayUrl := range relayUrls {
+		if len(uniqueRelayUrls) == maxPublishRelays {
+			break
+		}
+		if !slices.Contains(uniqueRelayUrls, relayUrl) {
+			uniqueRelayUrls = append(uniqueRelayUrls, relayUrl)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	published := false
+	for _, relayUrl := range uniqueRelayUrls {
+		wg.Add(1)
+		go func(relayUrl string) {
+			defer wg.Done()
+			err := svc.publishToRelay(ctx, relayUrl, event)
+			if err != nil {
+				svc.logger.WithFields(logrus.Fields{
+					"eventId":  event.ID,
+					"relayUrl": relayUrl,
+				}).WithError(err).Error("Failed to publish event to relay")
+				return
+			}
+			mu.Lock()
+			published = true
+			mu.Unlock()
+		}(relayUrl)
+	}
+	wg.Wait()
+
+	if !published {
+		return errors.New("failed to publish to any relay")
+	}
+	return nil
+}
@@ -31,6 +31,7 @@ import (
 
 	alby "github.com/getAlby/nostr-wallet-connect/alby"
 	"github.com/getAlby/nostr-wallet-connect/events"
+	"github.com/getAlby/nostr-wallet-connect/fiat"
 	"github.com/getAlby/nostr-wallet-connect/utils"
 
 	"github.com/getAlby/nostr-wallet-connect/config"
@@ -42,6 +43,7 @@ import (
 	"github.com/getAlby/nostr-wallet-connect/lnclient/ldk"
 	"github.com/getAlby/nostr-wallet-connect/lnclient/lnd"
 	"github.com/getAlby/nostr-wallet-connect/lnclient/phoenixd"
+	"github.com/getAlby/nostr-wallet-connect/lnurl"
 	"github.com/getAlby/nostr-wallet-connect/migrations"
 	"github.com/getAlby/nostr-wallet-connect/nip47"
 )
@@ -65,6 +67,13 @@ type Service struct {
 	wg                     *sync.WaitGroup
 	nip47NotificationQueue nip47.Nip47NotificationQueue
 	appCancelFn            context.CancelFunc
+	lnurlClient            *lnurl.Client
+	inflightPayments       inflightPayments
+	feeEstimates           feeEstimates
+	// held while the budget of a subscription payment is checked and the payment is recorded
+	subscriptionPaymentsMu sync.Mutex
+	rateProvider           fiat.RateProvider
+	publishToRelay         func(ctx context.Context, relayUrl string, event nostr.Event) error
 }
 
 // TODO: move to service.go
@@ -167,9 +176,15 @@ func NewService(ctx context.Context) (*Service, error) {
 		eventPublisher:         eventPublisher,
 		nip47NotificationQueue: nip47NotificationQueue,
 		albyOAuthSvc:           alby.NewAlbyOAuthService(logger, cfg, cfg.GetEnv(), db.NewDBService(gormDB, logger)),
+		lnurlClient:            lnurl.NewClient(lnurl.NewHttpClient(10 * time.Second)),
+		rateProvider:           fiat.NewCachedRateProvider(fiat.NewHttpRateProvider(&http.Client{Timeout: 10 * time.Second}, appConfig.FiatRatesApi), fiatRateCacheDuration),
+		publishToRelay:         publishToRelay,
 	}
 
 	eventPublisher.RegisterSubscriber(svc.albyOAuthSvc)
+	eventPublisher.RegisterSubscriber(NewZapReceiptPublisher(svc))
+	eventPublisher.RegisterSubscriber(NewInvoiceSettler(svc))
+	eventPublisher.RegisterSubscriber(NewTransactionsLedger(svc))
 
 	eventPublisher.Publish(&events.Event{
 		Event: "nwc_started",
@@ -228,7 +243,7 @@ func (svc *Service) launchLNBackend(ctx context.Context, encryptionKey string) e
 		LNDAddress, _ := svc.cfg.Get("LNDAddress", encryptionKey)
 		LNDCertHex, _ := svc.cfg.Get("LNDCertHex", encryptionKey)
 		LNDMacaroonHex, _ := svc.cfg.Get("LNDMacaroonHex", encryptionKey)
-		lnClient, err = lnd.NewLNDService(ctx, svc.logger, LNDAddress, LNDCertHex, LNDMacaroonHex)
+		lnClient, err = lnd.NewLNDService(ctx, svc.logger, svc.eventPublisher, LNDAddress, LNDCertHex, LNDMacaroonHex)
 	case config.LDKBackendType:
 		Mnemonic, _ := svc.cfg.Get("Mnemonic", encryptionKey)
 		LDKWorkdir := path.Join(svc.cfg.GetEnv().Workdir, "ldk")
@@ -286,7 +301,7 @@ func (svc *Service) launchLNBackend(ctx context.Context, encryptionKey string) e
 func (svc *Service) createFilters(identityPubkey string) nostr.Filters {
 	filter := nostr.Filter{
 		Tags:  nostr.TagMap{"p": []string{identityPubkey}},
-		Kinds: []int{nip47.REQUEST_KIND},
+		Kinds: []int{nip47.REQUEST_KIND, nip47.CONNECTION_REQUEST_KIND},
 	}
 	return []nostr.Filter{filter}
 }
@@ -320,6 +335,10 @@ func (svc *Service) StartSubscription(ctx context.Context, sub *nostr.Subscripti
 
 		// loop through incoming events
 		for event := range sub.Events {
+			if event.Kind == nip47.CONNECTION_REQUEST_KIND {
+				go svc.HandleConnectionRequestEvent(event)
+				continue
+			}
 			go svc.HandleEvent(ctx, sub, event)
 		}
 		svc.logger.Info("Relay subscription events channel ended")
@@ -601,27 +620,58 @@ func (svc *Service) HandleEvent(ctx context.Context, sub *nostr.Subscription, ev
 		}
 	}
 
+	svc.dispatchNip47Request(ctx, nip47Request, &requestEvent, &app, publishResponse)
+}
+
+// dispatchNip47Request runs the handler of the request method
+func (svc *Service) dispatchNip47Request(ctx context.Context, nip47Request *nip47.Request, requestEvent *db.RequestEvent, app *db.App, publishResponse func(*nip47.Response, nostr.Tags)) {
+	if !svc.supportsMethod(nip47Request.Method) {
+		publishResponse(&nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    nip47.ERROR_NOT_IMPLEMENTED,
+				Message: fmt.Sprintf("The wallet's node does not support %s", nip47Request.Method),
+			},
+		}, nostr.Tags{})
+		return
+	}
 	switch nip47Request.Method {
 	case nip47.MULTI_PAY_INVOICE_METHOD:
-		svc.HandleMultiPayInvoiceEvent(ctx, nip47Request, &requestEvent, &app, publishResponse)
+		svc.HandleMultiPayInvoiceEvent(ctx, nip47Request, requestEvent, app, publishResponse)
 	case nip47.MULTI_PAY_KEYSEND_METHOD:
-		svc.HandleMultiPayKeysendEvent(ctx, nip47Request, &requestEvent, &app, publishResponse)
+		svc.HandleMultiPayKeysendEvent(ctx, nip47Request, requestEvent, app, publishResponse)
 	case nip47.PAY_INVOICE_METHOD:
-		svc.HandlePayInvoiceEvent(ctx, nip47Request, &requestEvent, &app, publishResponse)
+		svc.HandlePayInvoiceEvent(ctx, nip47Request, requestEvent, app, publishResponse)
 	case nip47.PAY_KEYSEND_METHOD:
-		svc.HandlePayKeysendEvent(ctx, nip47Request, &requestEvent, &app, publishResponse)
+		svc.HandlePayKeysendEvent(ctx, nip47Request, requestEvent, app, publishResponse)
+	case nip47.PAY_LIGHTNING_ADDRESS_METHOD, nip47.PAY_LNURL_METHOD:
+		svc.HandlePayLnurlEvent(ctx, nip47Request, requestEvent, app, publishResponse)
 	case nip47.GET_BALANCE_METHOD:
-		svc.HandleGetBalanceEvent(ctx, nip47Request, &requestEvent, &app, publishResponse)
+		svc.HandleGetBalanceEvent(ctx, nip47Request, requestEvent, app, publishResponse)
 	case nip47.MAKE_INVOICE_METHOD:
-		svc.HandleMakeInvoiceEvent(ctx, nip47Request, &requestEvent, &app, publishResponse)
+		svc.HandleMakeInvoiceEvent(ctx, nip47Request, requestEvent, app, publishResponse)
 	case nip47.LOOKUP_INVOICE_METHOD:
-		svc.HandleLookupInvoiceEvent(ctx, nip47Request, &requestEvent, &app, publishResponse)
+		svc.HandleLookupInvoiceEvent(ctx, nip47Request, requestEvent, app, publishResponse)
 	case nip47.LIST_TRANSACTIONS_METHOD:
-		svc.HandleListTransactionsEvent(ctx, nip47Request, &requestEvent, &app, publishResponse)
+		svc.HandleListTransactionsEvent(ctx, nip47Request, requestEvent, app, publishResponse)
 	case nip47.GET_INFO_METHOD:
-		svc.HandleGetInfoEvent(ctx, nip47Request, &requestEvent, &app, publishResponse)
+		svc.HandleGetInfoEvent(ctx, nip47Request, requestEvent, app, publishResponse)
 	case nip47.SIGN_MESSAGE_METHOD:
-		svc.HandleSignMessageEvent(ctx, nip47Request, &requestEvent, &app, publishResponse)
+		svc.HandleSignMessageEvent(ctx, nip47Request, requestEvent, app, publishResponse)
+	case nip47.MAKE_HOLD_INVOICE_METHOD:
+		svc.HandleMakeHoldInvoiceEvent(ctx, nip47Request, requestEvent, app, publishResponse)
+	case nip47.SETTLE_HOLD_INVOICE_METHOD:
+		svc.HandleSettleHoldInvoiceEvent(ctx, nip47Request, requestEvent, app, publishResponse)
+	case nip47.CANCEL_HOLD_INVOICE_METHOD:
+		svc.HandleCancelHoldInvoiceEvent(ctx, nip47Request, requestEvent, app, publishResponse)
+	case nip47.ESTIMATE_FEE_METHOD:
+		svc.HandleEstimateFeeEvent(ctx, nip47Request, requestEvent, app, publishResponse)
+	case nip47.CREATE_SUBSCRIPTION_METHOD:
+		svc.HandleCreateSubscriptionEvent(ctx, nip47Request, requestEvent, app, publishResponse)
+	case nip47.LIST_SUBSCRIPTIONS_METHOD:
+		svc.HandleListSubscriptionsEvent(ctx, nip47Request, requestEvent, app, publishResponse)
+	case nip47.CANCEL_SUBSCRIPTION_METHOD:
+		svc.HandleCancelSubscriptionEvent(ctx, nip47Request, requestEvent, app, publishResponse)
 	default:
 		svc.handleUnknownMethod(ctx, nip47Request, publishResponse)
 	}
@@ -675,10 +725,44 @@ func (svc *Service) GetMethods(app *db.App) []string {
 	}
 	if slices.Contains(requestMethods, nip47.PAY_INVOICE_METHOD) {
 		// all payment methods are tied to the pay_invoice permission
-		requestMethods = append(requestMethods, nip47.PAY_KEYSEND_METHOD, nip47.MULTI_PAY_INVOICE_METHOD, nip47.MULTI_PAY_KEYSEND_METHOD)
+		requestMethods = append(requestMethods, nip47.PAY_KEYSEND_METHOD, nip47.MULTI_PAY_INVOICE_METHOD, nip47.MULTI_PAY_KEYSEND_METHOD, nip47.PAY_LIGHTNING_ADDRESS_METHOD, nip47.PAY_LNURL_METHOD,
+			nip47.CREATE_SUBSCRIPTION_METHOD, nip47.LIST_SUBSCRIPTIONS_METHOD, nip47.CANCEL_SUBSCRIPTION_METHOD)
+		if !slices.Contains(requestMethods, nip47.ESTIMATE_FEE_METHOD) {
+			requestMethods = append(requestMethods, nip47.ESTIMATE_FEE_METHOD)
+		}
+	}
+
+	return slices.DeleteFunc(requestMethods, func(requestMethod string) bool {
+		return !svc.supportsMethod(requestMethod)
+	})
+}
+
+// requiredFeatures maps the methods and notification types which only some LN backends support to the feature they need
+var requiredFeatures = map[string]string{
+	nip47.MAKE_HOLD_INVOICE_METHOD:           lnclient.FeatureHoldInvoices,
+	nip47.SETTLE_HOLD_INVOICE_METHOD:         lnclient.FeatureHoldInvoices,
+	nip47.CANCEL_HOLD_INVOICE_METHOD:         lnclient.FeatureHoldInvoices,
+	nip47.HOLD_INVOICE_ACCEPTED_NOTIFICATION: lnclient.FeatureHoldInvoices,
+}
+
+// supportsMethod returns false for methods or notification types needing a feature the LN backend does not have
+func (svc *Service) supportsMethod(method string) bool {
+	feature, ok := requiredFeatures[method]
+	if !ok {
+		return true
 	}
+	return svc.supportsFeature(feature)
+}
 
-	return requestMethods
+func (svc *Service) supportsFeature(feature string) bool {
+	return svc.lnClient != nil && slices.Contains(svc.lnClient.GetSupportedFeatures(), feature)
+}
+
+// filterSupported removes the unsupported entries of a space separated list like nip47.CAPABILITIES
+func (svc *Service) filterSupported(methods string) string {
+	return strings.Join(slices.DeleteFunc(strings.Fields(methods), func(method string) bool {
+		return !svc.supportsMethod(method)
+	}), " ")
 }
 
 func (svc *Service) decodeNip47Request(nip47Request *nip47.Request, requestEvent *db.RequestEvent, app *db.App, methodParams interface{}) *nip47.Response {
@@ -699,7 +783,12 @@ func (svc *Service) decodeNip47Request(nip47Request *nip47.Request, requestEvent
 }
 
 func (svc *Service) checkPermission(nip47Request *nip47.Request, requestNostrEventId string, app *db.App, amount int64) *nip47.Response {
-	hasPermission, code, message := svc.hasPermission(app, nip47Request.Method, amount)
+	return svc.checkPaymentPermission(nip47Request, requestNostrEventId, app, amount, nil)
+}
+
+// checkPaymentPermission is checkPermission for a payment limited to the requested max fee
+func (svc *Service) checkPaymentPermission(nip47Request *nip47.Request, requestNostrEventId string, app *db.App, amount int64, maxFeeMsat *uint64) *nip47.Response {
+	hasPermission, code, message := svc.hasPaymentPermission(app, nip47Request.Method, amount, maxFeeMsat)
 	if !hasPermission {
 		svc.logger.WithFields(logrus.Fields{
 			"requestEventNostrId": requestNostrEventId,
@@ -731,8 +820,20 @@ func (svc *Service) checkPermission(nip47Request *nip47.Request, requestNostrEve
 }
 
 func (svc *Service) hasPermission(app *db.App, requestMethod string, amount int64) (result bool, code string, message string) {
+	return svc.hasPaymentPermission(app, requestMethod, amount, nil)
+}
+
+func (svc *Service) hasPaymentPermission(app *db.App, requestMethod string, amount int64, maxFeeMsat *uint64) (result bool, code string, message string) {
 	switch requestMethod {
-	case nip47.PAY_INVOICE_METHOD, nip47.PAY_KEYSEND_METHOD, nip47.MULTI_PAY_INVOICE_METHOD, nip47.MULTI_PAY_KEYSEND_METHOD:
+	case nip47.ESTIMATE_FEE_METHOD:
+		// apps which can pay may estimate the fees of their payments without the estimate_fee permission
+		var estimateFeePermissionCount int64
+		svc.db.Model(&db.AppPermission{}).Where("app_id = ? AND request_method = ?", app.ID, nip47.ESTIMATE_FEE_METHOD).Count(&estimateFeePermissionCount)
+		if estimateFeePermissionCount == 0 {
+			requestMethod = nip47.PAY_INVOICE_METHOD
+		}
+	case nip47.PAY_INVOICE_METHOD, nip47.PAY_KEYSEND_METHOD, nip47.MULTI_PAY_INVOICE_METHOD, nip47.MULTI_PAY_KEYSEND_METHOD, nip47.PAY_LIGHTNING_ADDRESS_METHOD, nip47.PAY_LNURL_METHOD,
+		nip47.CREATE_SUBSCRIPTION_METHOD, nip47.LIST_SUBSCRIPTIONS_METHOD, nip47.CANCEL_SUBSCRIPTION_METHOD:
 		requestMethod = nip47.PAY_INVOICE_METHOD
 	}
 
@@ -762,31 +863,72 @@ func (svc *Service) hasPermission(app *db.App, requestMethod string, amount int6
 		if maxAmount != 0 {
 			budgetUsage := svc.GetBudgetUsage(&appPermission)
 
-			if budgetUsage+amount/1000 > int64(maxAmount) {
+			if budgetUsage+amount > int64(maxAmount)*MSAT_PER_SAT {
 				return false, nip47.ERROR_QUOTA_EXCEEDED, "Insufficient budget remaining to make payment"
 			}
 		}
+		if appPermission.BudgetCurrency != "" && appPermission.MaxAmountFiat > 0 {
+			rate, err := svc.getFiatRate(appPermission.BudgetCurrency)
+			if err != nil {
+				svc.logger.WithField("currency", appPermission.BudgetCurrency).WithError(err).Error("Failed to fetch fiat rate for budget")
+				return false, nip47.ERROR_INTERNAL, "Failed to fetch the exchange rate of the budget"
+			}
+			budgetUsage := svc.GetBudgetUsage(&appPermission)
+
+			if budgetUsage+amount > fiat.CentsToMsat(appPermission.MaxAmountFiat, rate) {
+				return false, nip47.ERROR_QUOTA_EXCEEDED, "Insufficient budget remaining to make payment"
+			}
+		}
+		if app.Isolated {
+			// the routing fee is spent from the balance too, so the highest fee the payment may take is reserved
+			var feeReserveMsat int64
+			if amount > 0 {
+				if options := svc.paymentOptions(app, maxFeeMsat); options.MaxFeeMsat != nil {
+					feeReserveMsat = int64(*options.MaxFeeMsat)
+				}
+			}
+			if amount+feeReserveMsat > svc.GetIsolatedBalance(app) {
+				return false, nip47.ERROR_INSUFFICIENT_BALANCE, "Insufficient balance remaining to make payment"
+			}
+		}
 	}
 	return true, "", ""
 }
 
+// GetBudgetUsage returns how much the app spent in the current budget period in msat,
+// pending payments count so that concurrent payments cannot exceed the budget together
 // TODO: move somewhere else
 func (svc *Service) GetBudgetUsage(appPermission *db.AppPermission) int64 {
 	var result struct {
-		Sum uint
+		Sum uint64
 	}
-	// TODO: discard failed payments from this check instead of checking payments that have a preimage
-	svc.db.Table("payments").Select("SUM(amount) as sum").Where("app_id = ? AND preimage IS NOT NULL AND created_at > ?", appPermission.AppId, utils.GetStartOfBudget(appPermission.BudgetRenewal, appPermission.App.CreatedAt)).Scan(&result)
+	svc.db.Table("payments").Select("SUM(amount_msat) as sum").Where("app_id = ? AND state != ? AND created_at > ?", appPermission.AppId, db.PAYMENT_STATE_FAILED, utils.GetStartOfBudget(appPermission.BudgetRenewal, appPermission.App.CreatedAt)).Scan(&result)
 	return int64(result.Sum)
 }
 
+// GetIsolatedBalance returns the ledger balance of an isolated app in msat: what was paid to its invoices minus
+// its payments which did not fail and their routing fees
+func (svc *Service) GetIsolatedBalance(app *db.App) int64 {
+	var received struct {
+		Sum uint
+	}
+	svc.db.Table("invoices").Select("SUM(amount) as sum").Where("app_id = ? AND settled_at IS NOT NULL", app.ID).Scan(&received)
+
+	var spent struct {
+		Sum uint64
+	}
+	svc.db.Table("payments").Select("SUM(amount_msat + COALESCE(fee_msat, 0)) as sum").Where("app_id = ? AND state != ?", app.ID, db.PAYMENT_STATE_FAILED).Scan(&spent)
+
+	return int64(received.Sum)*MSAT_PER_SAT - int64(spent.Sum)
+}
+
 func (svc *Service) PublishNip47Info(ctx context.Context, relay *nostr.Relay) error {
 	ev := &nostr.Event{}
 	ev.Kind = nip47.INFO_EVENT_KIND
-	ev.Content = nip47.CAPABILITIES
+	ev.Content = svc.filterSupported(nip47.CAPABILITIES)
 	ev.CreatedAt = nostr.Now()
 	ev.PubKey = svc.cfg.GetNostrPublicKey()
-	ev.Tags = nostr.Tags{[]string{"notifications", nip47.NOTIFICATION_TYPES}}
+	ev.Tags = nostr.Tags{[]string{"notifications", svc.filterSupported(nip47.NOTIFICATION_TYPES)}}
 	err := ev.Sign(svc.cfg.GetNostrSecretKey())
 	if err != nil {
 		return err
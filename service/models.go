@@ -1,10 +1,14 @@
 package service
 
 import (
+	"context"
+
 	"github.com/getAlby/nostr-wallet-connect/alby"
 	"github.com/getAlby/nostr-wallet-connect/config"
 	"github.com/getAlby/nostr-wallet-connect/db"
 	"github.com/getAlby/nostr-wallet-connect/lnclient"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	"github.com/nbd-wtf/go-nostr"
 )
 
 type Service interface {
@@ -15,6 +19,11 @@ type Service interface {
 	StopLNClient() error
 	StopDb() error
 	GetBudgetUsage(appPermission *db.AppPermission) int64
+	GetIsolatedBalance(app *db.App) int64
 	GetLogFilePath() string
 	GetAlbyOAuthSvc() alby.AlbyOAuthService
+	EstimateFee(ctx context.Context, request *lnclient.EstimateFeeRequest) (*lnclient.FeeEstimate, error)
+	HandleOwnerRequest(ctx context.Context, nip47Request *nip47.Request) (*nip47.Response, error)
+	AddConnectionRequest(event *nostr.Event) (*db.ConnectionRequest, error)
+	PublishConnectionResponse(ctx context.Context, connectionRequest *db.ConnectionRequest) error
 }
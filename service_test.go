@@ -1,23 +1,44 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/ecdsa"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/glebarez/sqlite"
+	"github.com/lightningnetwork/lnd/lnwire"
+	"github.com/lightningnetwork/lnd/zpay32"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip04"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/gorm"
 
+	"github.com/getAlby/nostr-wallet-connect/api"
 	"github.com/getAlby/nostr-wallet-connect/config"
 	"github.com/getAlby/nostr-wallet-connect/db"
 	"github.com/getAlby/nostr-wallet-connect/events"
+	"github.com/getAlby/nostr-wallet-connect/fiat"
 	"github.com/getAlby/nostr-wallet-connect/lnclient"
+	"github.com/getAlby/nostr-wallet-connect/lnurl"
 	"github.com/getAlby/nostr-wallet-connect/migrations"
 	"github.com/getAlby/nostr-wallet-connect/nip47"
 )
@@ -56,6 +77,27 @@ const nip47MakeInvoiceJson = `
 }
 `
 
+const nip47MakeHoldInvoiceJson = `
+{
+	"method": "make_hold_invoice",
+	"params": {
+		"amount": 1000,
+		"description": "escrow",
+		"expiry": 3600,
+		"payment_hash": "320c2c5a1492ccfd5bc7aa4ad9b657d6aaec3cfcc0d1d98413a29af4ac772ccf"
+	}
+}
+`
+
+const nip47SettleHoldInvoiceJson = `
+{
+	"method": "settle_hold_invoice",
+	"params": {
+		"preimage": "c8aeb44dd01a4e8ae7e0e1ef5e6e2a4e4fd2d8e0e3f4cb8d4b5ffd1a6a1e3f3a"
+	}
+}
+`
+
 const nip47ListTransactionsJson = `
 {
 	"method": "list_transactions",
@@ -143,6 +185,15 @@ const nip47PayJson = `
 }
 `
 
+const nip47Pay500Json = `
+{
+	"method": "pay_invoice",
+	"params": {
+		"invoice": "lnbcrt5u1pjuywzppp5h69dt59cypca2wxu69sw8ga0g39a3yx7dqug5nthrw3rcqgfdu4qdqqcqzzsxqyz5vqsp5gzlpzszyj2k30qmpme7jsfzr24wqlvt9xdmr7ay34lfelz050krs9qyyssq038x07nh8yuv8hdpjh5y8kqp7zcd62ql9na9xh7pla44htjyy02sz23q7qm2tza6ct4ypljk54w9k9qsrsu95usk8ce726ytep6vhhsq9mhf9a"
+	}
+}
+`
+
 const nip47MultiPayJson = `
 {
 	"method": "multi_pay_invoice",
@@ -197,6 +248,25 @@ const nip47PayWrongMethodJson = `
 	}
 }
 `
+const nip47PayAsyncJson = `
+{
+	"method": "pay_invoice",
+	"params": {
+		"invoice": "lntb1230n1pjypux0pp5xgxzcks5jtx06k784f9dndjh664wc08ucrganpqn52d0ftrh9n8sdqyw3jscqzpgxqyz5vqsp5rkx7cq252p3frx8ytjpzc55rkgyx2mfkzzraa272dqvr2j6leurs9qyyssqhutxa24r5hqxstchz5fxlslawprqjnarjujp5sm3xj7ex73s32sn54fthv2aqlhp76qmvrlvxppx9skd3r5ut5xutgrup8zuc6ay73gqmra29m",
+		"async": true
+	}
+}
+`
+
+const nip47LookupOutgoingJson = `
+{
+	"method": "lookup_invoice",
+	"params": {
+		"payment_hash": "320c2c5a1492ccfd5bc7aa4ad9b657d6aaec3cfcc0d1d98413a29af4ac772ccf"
+	}
+}
+`
+
 const nip47PayJsonNoInvoice = `
 {
 	"method": "pay_invoice",
@@ -234,6 +304,7 @@ var mockTransactions = []nip47.Transaction{
 		PaymentHash:     "payment_hash_1",
 		Amount:          1000,
 		FeesPaid:        50,
+		CreatedAt:       mockTimeUnix + 20,
 		SettledAt:       &mockTimeUnix,
 		Metadata: map[string]interface{}{
 			"key1": "value1",
@@ -249,11 +320,23 @@ var mockTransactions = []nip47.Transaction{
 		PaymentHash:     "payment_hash_2",
 		Amount:          2000,
 		FeesPaid:        75,
+		CreatedAt:       mockTimeUnix + 10,
 		SettledAt:       &mockTimeUnix,
 	},
 }
 var mockTransaction = &mockTransactions[0]
 
+var mockChannels = []lnclient.Channel{
+	{
+		Id:          "channel_1",
+		FundingTxId: "funding_tx_1",
+	},
+	{
+		Id:          "channel_2",
+		FundingTxId: "funding_tx_2",
+	},
+}
+
 // TODO: split each method into separate files (requires moving out of the main package)
 // TODO: add E2E tests as well (currently the LNClient and relay are not tested)
 // TODO: test a request cannot be processed twice
@@ -363,6 +446,125 @@ func TestHasPermission_OK(t *testing.T) {
 	assert.Empty(t, message)
 }
 
+func TestHasPermission_ExceededBySubSatPayments(t *testing.T) {
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+
+	appPermission := &db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.PAY_INVOICE_METHOD,
+		MaxAmount:     1,
+		BudgetRenewal: "never",
+	}
+	err = svc.db.Create(appPermission).Error
+	assert.NoError(t, err)
+
+	result, _, _ := svc.hasPermission(app, nip47.PAY_INVOICE_METHOD, 999)
+	assert.True(t, result)
+
+	preimage := "preimage"
+	err = svc.db.Create(&db.Payment{AppId: app.ID, AmountMsat: 999, Preimage: &preimage, State: db.PAYMENT_STATE_SUCCEEDED}).Error
+	assert.NoError(t, err)
+	assert.Equal(t, int64(999), svc.GetBudgetUsage(appPermission))
+
+	result, code, _ := svc.hasPermission(app, nip47.PAY_INVOICE_METHOD, 999)
+	assert.False(t, result)
+	assert.Equal(t, nip47.ERROR_QUOTA_EXCEEDED, code)
+
+	// pending payments count, failed payments do not
+	err = svc.db.Create(&db.Payment{AppId: app.ID, AmountMsat: 1000, State: db.PAYMENT_STATE_PENDING}).Error
+	assert.NoError(t, err)
+	err = svc.db.Create(&db.Payment{AppId: app.ID, AmountMsat: 5000, State: db.PAYMENT_STATE_FAILED}).Error
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1999), svc.GetBudgetUsage(appPermission))
+}
+
+func TestFiatBudgets(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+
+	rateRequests := 0
+	rateServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/usd.json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rateRequests++
+		w.Write([]byte(`{"code":"USD","symbol":"$","rate":"50,000.00","rate_float":50000}`))
+	}))
+	defer rateServer.Close()
+	svc.rateProvider = fiat.NewCachedRateProvider(fiat.NewHttpRateProvider(rateServer.Client(), rateServer.URL), time.Minute)
+
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+	// 1 USD is 2000 sats at 50,000 USD per bitcoin
+	err = svc.db.Create(&db.AppPermission{
+		AppId:          app.ID,
+		RequestMethod:  nip47.PAY_INVOICE_METHOD,
+		BudgetRenewal:  "never",
+		BudgetCurrency: "USD",
+		MaxAmountFiat:  100,
+	}).Error
+	assert.NoError(t, err)
+	err = svc.db.Create(&db.AppPermission{AppId: app.ID, RequestMethod: nip47.GET_BALANCE_METHOD}).Error
+	assert.NoError(t, err)
+
+	result, _, _ := svc.hasPermission(app, nip47.PAY_INVOICE_METHOD, 2_000_000)
+	assert.True(t, result)
+
+	requestEvent := &db.RequestEvent{AppId: &app.ID, NostrId: "fiat_budget_payment"}
+	err = svc.db.Create(requestEvent).Error
+	assert.NoError(t, err)
+	payment := &db.Payment{AppId: app.ID, RequestEventId: requestEvent.ID, AmountMsat: 1_500_000, State: db.PAYMENT_STATE_PENDING}
+	err = svc.db.Create(payment).Error
+	assert.NoError(t, err)
+	err = svc.markPaymentSucceeded(payment, "preimage", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "USD", payment.FiatCurrency)
+	assert.Equal(t, 50000.0, *payment.FiatRate)
+	transaction := svc.paymentToTransaction(payment)
+	assert.Equal(t, 0.75, *transaction.FiatAmount)
+	assert.Equal(t, "USD", transaction.FiatCurrency)
+
+	result, code, _ := svc.hasPermission(app, nip47.PAY_INVOICE_METHOD, 600_000)
+	assert.False(t, result)
+	assert.Equal(t, nip47.ERROR_QUOTA_EXCEEDED, code)
+
+	request := &nip47.Request{}
+	err = json.Unmarshal([]byte(nip47GetBalanceJson), request)
+	assert.NoError(t, err)
+	responses := []*nip47.Response{}
+	svc.HandleGetBalanceEvent(ctx, request, &db.RequestEvent{NostrId: "fiat_balance"}, app, func(response *nip47.Response, tags nostr.Tags) {
+		responses = append(responses, response)
+	})
+	assert.Equal(t, 1, len(responses))
+	balance := responses[0].Result.(*nip47.BalanceResponse)
+	assert.Equal(t, "USD", balance.FiatCurrency)
+	assert.Equal(t, 0.01, *balance.FiatBalance)
+	assert.Equal(t, 1.0, *balance.FiatMaxAmount)
+	assert.Equal(t, 2_000_000, balance.MaxAmount)
+
+	assert.Equal(t, 1, rateRequests)
+
+	// budgets cannot be checked without a rate
+	err = svc.db.Model(&db.AppPermission{}).Where("app_id = ?", app.ID).Update("budget_currency", "EUR").Error
+	assert.NoError(t, err)
+	result, code, _ = svc.hasPermission(app, nip47.PAY_INVOICE_METHOD, 1000)
+	assert.False(t, result)
+	assert.Equal(t, nip47.ERROR_INTERNAL, code)
+}
+
 func TestCreateResponse(t *testing.T) {
 	defer os.Remove(testDB)
 	mockLn, err := NewMockLn()
@@ -442,7 +644,11 @@ func TestHandleMultiPayInvoiceEvent(t *testing.T) {
 	responses := []*nip47.Response{}
 	dTags := []nostr.Tags{}
 
+	// invoices are paid concurrently
+	var mu sync.Mutex
 	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
+		mu.Lock()
+		defer mu.Unlock()
 		responses = append(responses, response)
 		dTags = append(dTags, tags)
 	}
@@ -498,13 +704,10 @@ func TestHandleMultiPayInvoiceEvent(t *testing.T) {
 	svc.HandleMultiPayInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
 
 	assert.Equal(t, 2, len(responses))
-	assert.Equal(t, "invoiceId123", dTags[0].GetFirst([]string{"d"}).Value())
-	assert.Equal(t, responses[0].Error.Code, nip47.ERROR_INTERNAL)
-
-	assert.Equal(t, mockPaymentHash, dTags[1].GetFirst([]string{"d"}).Value())
-	assert.Equal(t, responses[1].Result.(nip47.PayResponse).Preimage, "123preimage")
+	assert.Equal(t, nip47.ERROR_INTERNAL, findResponseByDTag(t, responses, dTags, "invoiceId123").Error.Code)
+	assert.Equal(t, "123preimage", findResponseByDTag(t, responses, dTags, mockPaymentHash).Result.(nip47.PayResponse).Preimage)
 
-	// we've spent 369 till here in three payments
+	// we've spent 123 till here: the same invoice was only paid once
 
 	// budget overflow
 	newMaxAmount := 500
@@ -524,13 +727,82 @@ func TestHandleMultiPayInvoiceEvent(t *testing.T) {
 	dTags = []nostr.Tags{}
 	svc.HandleMultiPayInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
 
-	// might be flaky because the two requests run concurrently
-	// and there's more chance that the failed respons calls the
-	// publishResponse as it's called earlier
-	assert.Equal(t, responses[0].Error.Code, nip47.ERROR_QUOTA_EXCEEDED)
-	assert.Equal(t, mockPaymentHash500, dTags[0].GetFirst([]string{"d"}).Value())
-	assert.Equal(t, responses[1].Result.(nip47.PayResponse).Preimage, "123preimage")
-	assert.Equal(t, mockPaymentHash, dTags[1].GetFirst([]string{"d"}).Value())
+	assert.Equal(t, 2, len(responses))
+	assert.Equal(t, nip47.ERROR_QUOTA_EXCEEDED, findResponseByDTag(t, responses, dTags, mockPaymentHash500).Error.Code)
+	assert.Equal(t, "123preimage", findResponseByDTag(t, responses, dTags, mockPaymentHash).Result.(nip47.PayResponse).Preimage)
+
+	var paymentCount int64
+	err = svc.db.Model(&db.Payment{}).Where("app_id = ? AND payment_hash = ?", app.ID, mockPaymentHash).Count(&paymentCount).Error
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), paymentCount)
+}
+
+func TestHandleMultiPayInvoiceEvent_Summary(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+
+	appPermission := &db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.PAY_INVOICE_METHOD,
+	}
+	err = svc.db.Create(appPermission).Error
+	assert.NoError(t, err)
+
+	request := &nip47.Request{}
+	err = json.Unmarshal([]byte(fmt.Sprintf(`{"method": "multi_pay_invoice", "params": {"summary": true, "invoices": [{"invoice": "", "id": "invoiceId123"}, {"invoice": "%s"}]}}`, mockInvoice)), request)
+	assert.NoError(t, err)
+
+	responses := []*nip47.Response{}
+	dTags := []nostr.Tags{}
+	var mu sync.Mutex
+	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
+		mu.Lock()
+		defer mu.Unlock()
+		responses = append(responses, response)
+		dTags = append(dTags, tags)
+	}
+
+	requestEvent := &db.RequestEvent{NostrId: "multi_pay_invoice_with_summary"}
+	svc.HandleMultiPayInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+
+	// the summary is published last, without a d tag
+	assert.Equal(t, 3, len(responses))
+	assert.Nil(t, dTags[2].GetFirst([]string{"d"}))
+	summary := responses[2].Result.(*nip47.MultiPaySummaryResponse)
+	assert.Equal(t, 1, len(summary.Succeeded))
+	assert.Equal(t, mockPaymentHash, summary.Succeeded[0].Id)
+	assert.Equal(t, "123preimage", summary.Succeeded[0].Preimage)
+	assert.Equal(t, 1, len(summary.Failed))
+	assert.Equal(t, "invoiceId123", summary.Failed[0].Id)
+	assert.Equal(t, nip47.ERROR_INTERNAL, summary.Failed[0].Error.Code)
+	assert.Equal(t, int64(123000), summary.TotalAmount)
+
+	// too many invoices
+	svc.cfg.GetEnv().MultiPayMaxBatchSize = 1
+	responses = []*nip47.Response{}
+	dTags = []nostr.Tags{}
+	requestEvent = &db.RequestEvent{NostrId: "multi_pay_invoice_too_many_invoices"}
+	svc.HandleMultiPayInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Equal(t, 1, len(responses))
+	assert.Equal(t, nip47.ERROR_BAD_REQUEST, responses[0].Error.Code)
+}
+
+func findResponseByDTag(t *testing.T, responses []*nip47.Response, dTags []nostr.Tags, dTagValue string) *nip47.Response {
+	for i, tags := range dTags {
+		if tags.GetFirst([]string{"d"}).Value() == dTagValue {
+			return responses[i]
+		}
+	}
+	t.Fatalf("no response with d tag %s", dTagValue)
+	return nil
 }
 
 func TestHandleMultiPayKeysendEvent(t *testing.T) {
@@ -805,15 +1077,72 @@ func TestHandlePayInvoiceEvent(t *testing.T) {
 
 	assert.Equal(t, nip47.ERROR_RESTRICTED, responses[0].Error.Code)
 
+	// retrying an already paid invoice returns the stored preimage without paying again
+	err = json.Unmarshal([]byte(nip47PayJson), request)
+	assert.NoError(t, err)
+
+	reqEvent.ID = "pay_invoice_retry"
+	requestEvent.NostrId = reqEvent.ID
+	responses = []*nip47.Response{}
+	svc.HandlePayInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Equal(t, "123preimage", responses[0].Result.(nip47.PayResponse).Preimage)
+	var paymentCount int64
+	err = svc.db.Model(&db.Payment{}).Where("payment_hash = ?", mockPaymentHash).Count(&paymentCount).Error
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), paymentCount)
+
+	// another app cannot get the preimage of an invoice it did not pay
+	otherApp, _, err := createApp(svc)
+	assert.NoError(t, err)
+	err = svc.db.Create(&db.AppPermission{
+		AppId:         otherApp.ID,
+		App:           *otherApp,
+		RequestMethod: nip47.PAY_INVOICE_METHOD,
+		MaxAmount:     maxAmount,
+		BudgetRenewal: budgetRenewal,
+	}).Error
+	assert.NoError(t, err)
+	reqEvent.ID = "pay_invoice_paid_by_other_app"
+	requestEvent.NostrId = reqEvent.ID
+	responses = []*nip47.Response{}
+	svc.HandlePayInvoiceEvent(ctx, request, requestEvent, otherApp, publishResponse)
+
+	assert.Equal(t, nip47.ERROR_BAD_REQUEST, responses[0].Error.Code)
+
+	// a payment left pending, e.g. by a restart of the hub, is not paid again
+	err = svc.db.Model(&db.Payment{}).Where("payment_hash = ?", mockPaymentHash).Updates(map[string]interface{}{"preimage": nil, "state": db.PAYMENT_STATE_PENDING}).Error
+	assert.NoError(t, err)
+	reqEvent.ID = "pay_invoice_retry_pending"
+	requestEvent.NostrId = reqEvent.ID
+	responses = []*nip47.Response{}
+	svc.HandlePayInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Nil(t, responses[0].Error)
+	assert.Equal(t, nip47.PAYMENT_STATUS_PENDING, responses[0].Result.(nip47.PayResponse).Status)
+	err = svc.db.Model(&db.Payment{}).Where("payment_hash = ?", mockPaymentHash).Count(&paymentCount).Error
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), paymentCount)
+
+	reqEvent.ID = "pay_invoice_pending_for_other_app"
+	requestEvent.NostrId = reqEvent.ID
+	responses = []*nip47.Response{}
+	svc.HandlePayInvoiceEvent(ctx, request, requestEvent, otherApp, publishResponse)
+
+	assert.Equal(t, nip47.ERROR_BAD_REQUEST, responses[0].Error.Code)
+
+	err = svc.db.Model(&db.Payment{}).Where("payment_hash = ?", mockPaymentHash).Updates(map[string]interface{}{"preimage": "123preimage", "state": db.PAYMENT_STATE_SUCCEEDED}).Error
+	assert.NoError(t, err)
+
 	// budget overflow
 	newMaxAmount := 100
 	err = svc.db.Model(&db.AppPermission{}).Where("app_id = ?", app.ID).Update("max_amount", newMaxAmount).Error
 	assert.NoError(t, err)
 
-	err = json.Unmarshal([]byte(nip47PayJson), request)
+	err = json.Unmarshal([]byte(nip47Pay500Json), request)
 	assert.NoError(t, err)
 
-	payload, err = nip04.Encrypt(nip47PayJson, ss)
+	payload, err = nip04.Encrypt(nip47Pay500Json, ss)
 	assert.NoError(t, err)
 	reqEvent.Content = payload
 
@@ -848,277 +1177,2070 @@ func TestHandlePayInvoiceEvent(t *testing.T) {
 	assert.Equal(t, responses[0].Result.(nip47.PayResponse).Preimage, "123preimage")
 }
 
-func TestHandlePayKeysendEvent(t *testing.T) {
+func TestHandlePayInvoiceEvent_Async(t *testing.T) {
 	ctx := context.TODO()
 	defer os.Remove(testDB)
 	mockLn, err := NewMockLn()
 	assert.NoError(t, err)
 	svc, err := createTestService(mockLn)
 	assert.NoError(t, err)
-	app, ss, err := createApp(svc)
-	assert.NoError(t, err)
-
-	request := &nip47.Request{}
-	err = json.Unmarshal([]byte(nip47KeysendJson), request)
+	app, _, err := createApp(svc)
 	assert.NoError(t, err)
 
-	// without permission
-	payload, err := nip04.Encrypt(nip47KeysendJson, ss)
-	assert.NoError(t, err)
-	reqEvent := &nostr.Event{
-		Kind:    nip47.REQUEST_KIND,
-		PubKey:  app.NostrPubkey,
-		Content: payload,
+	for _, method := range []string{nip47.PAY_INVOICE_METHOD, nip47.LOOKUP_INVOICE_METHOD} {
+		err = svc.db.Create(&db.AppPermission{
+			AppId:         app.ID,
+			App:           *app,
+			RequestMethod: method,
+		}).Error
+		assert.NoError(t, err)
 	}
 
-	reqEvent.ID = "pay_keysend_without_permission"
+	request := &nip47.Request{}
+	err = json.Unmarshal([]byte(nip47PayAsyncJson), request)
+	assert.NoError(t, err)
 	requestEvent := &db.RequestEvent{
-		NostrId: reqEvent.ID,
+		NostrId: "pay_invoice_async",
 	}
+	err = svc.db.Create(requestEvent).Error
+	assert.NoError(t, err)
 
 	responses := []*nip47.Response{}
-
 	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
 		responses = append(responses, response)
 	}
 
-	svc.HandlePayKeysendEvent(ctx, request, requestEvent, app, publishResponse)
+	svc.HandlePayInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
 
-	assert.Equal(t, nip47.ERROR_RESTRICTED, responses[0].Error.Code)
+	// only the pending response is published, the outcome follows as a notification
+	assert.Equal(t, 1, len(responses))
+	assert.Nil(t, responses[0].Error)
+	assert.Equal(t, nip47.PAYMENT_STATUS_PENDING, responses[0].Result.(nip47.PayResponse).Status)
+	assert.Empty(t, responses[0].Result.(nip47.PayResponse).Preimage)
+
+	payment := db.Payment{}
+	err = svc.db.Where("payment_hash = ?", mockPaymentHash).First(&payment).Error
+	assert.NoError(t, err)
+	assert.Equal(t, "123preimage", *payment.Preimage)
+	assert.Equal(t, db.PAYMENT_STATE_SUCCEEDED, payment.State)
+	assert.NotNil(t, payment.SettledAt)
+	assert.Nil(t, payment.FailedAt)
+
+	// the outgoing payment can be looked up by its payment hash
+	request = &nip47.Request{}
+	err = json.Unmarshal([]byte(nip47LookupOutgoingJson), request)
+	assert.NoError(t, err)
+	requestEvent.NostrId = "lookup_outgoing_payment"
+	responses = []*nip47.Response{}
+	svc.HandleLookupInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Nil(t, responses[0].Error)
+	transaction := responses[0].Result.(*nip47.LookupInvoiceResponse)
+	assert.Equal(t, "outgoing", transaction.Type)
+	assert.Equal(t, mockInvoice, transaction.Invoice)
+	assert.Equal(t, mockPaymentHash, transaction.PaymentHash)
+	assert.Equal(t, "123preimage", transaction.Preimage)
+	assert.Equal(t, int64(123000), transaction.Amount)
+	assert.Equal(t, nip47.TRANSACTION_STATE_SETTLED, transaction.State)
+}
+
+func TestHandlePayInvoiceEvent_MaxFee(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
 
-	// with permission
-	maxAmount := 1000
-	budgetRenewal := "never"
-	expiresAt := time.Now().Add(24 * time.Hour)
-	// because we need the same permission for keysend although
-	// it works even with nip47.PAY_KEYSEND_METHOD, see
-	// https://github.com/getAlby/nostr-wallet-connect/issues/189
 	appPermission := &db.AppPermission{
 		AppId:         app.ID,
 		App:           *app,
 		RequestMethod: nip47.PAY_INVOICE_METHOD,
-		MaxAmount:     maxAmount,
-		BudgetRenewal: budgetRenewal,
-		ExpiresAt:     &expiresAt,
 	}
 	err = svc.db.Create(appPermission).Error
 	assert.NoError(t, err)
 
-	reqEvent.ID = "pay_keysend_with_permission"
-	requestEvent.NostrId = reqEvent.ID
-	responses = []*nip47.Response{}
-	svc.HandlePayKeysendEvent(ctx, request, requestEvent, app, publishResponse)
+	pay := func(id string, maxFee uint64) *nip47.Response {
+		request := &nip47.Request{}
+		err := json.Unmarshal([]byte(fmt.Sprintf(`{"method": "pay_invoice", "params": {"invoice": "%s", "max_fee": %d}}`, mockInvoice, maxFee)), request)
+		assert.NoError(t, err)
+		requestEvent := &db.RequestEvent{NostrId: id}
+		err = svc.db.Create(requestEvent).Error
+		assert.NoError(t, err)
 
-	assert.Equal(t, responses[0].Result.(nip47.PayResponse).Preimage, "12345preimage")
+		responses := []*nip47.Response{}
+		svc.HandlePayInvoiceEvent(ctx, request, requestEvent, app, func(response *nip47.Response, tags nostr.Tags) {
+			responses = append(responses, response)
+		})
+		assert.Equal(t, 1, len(responses))
+		return responses[0]
+	}
 
-	// budget overflow
-	newMaxAmount := 100
-	err = svc.db.Model(&db.AppPermission{}).Where("app_id = ?", app.ID).Update("max_amount", newMaxAmount).Error
+	// requested max fee too low
+	response := pay("max_fee_too_low", mockRoutingFeeMsat-1)
+	assert.Equal(t, nip47.ERROR_MAX_FEE_EXCEEDED, response.Error.Code)
+	payment := db.Payment{}
+	err = svc.db.Last(&payment).Error
 	assert.NoError(t, err)
+	assert.Equal(t, db.PAYMENT_STATE_FAILED, payment.State)
 
-	err = json.Unmarshal([]byte(nip47KeysendJson), request)
+	// the app's default fee limit applies when it is lower than the requested one
+	defaultMaxFee := uint64(mockRoutingFeeMsat - 1)
+	err = svc.db.Model(appPermission).Update("max_fee_msat", &defaultMaxFee).Error
 	assert.NoError(t, err)
+	response = pay("app_max_fee_too_low", mockRoutingFeeMsat)
+	assert.Equal(t, nip47.ERROR_MAX_FEE_EXCEEDED, response.Error.Code)
 
-	payload, err = nip04.Encrypt(nip47KeysendJson, ss)
+	defaultMaxFee = mockRoutingFeeMsat * 2
+	err = svc.db.Model(appPermission).Update("max_fee_msat", &defaultMaxFee).Error
 	assert.NoError(t, err)
-	reqEvent.Content = payload
-
-	reqEvent.ID = "pay_keysend_with_budget_overflow"
-	requestEvent.NostrId = reqEvent.ID
-	responses = []*nip47.Response{}
-	svc.HandlePayKeysendEvent(ctx, request, requestEvent, app, publishResponse)
-
-	assert.Equal(t, nip47.ERROR_QUOTA_EXCEEDED, responses[0].Error.Code)
+	response = pay("max_fee_ok", mockRoutingFeeMsat)
+	assert.Nil(t, response.Error)
+	assert.Equal(t, "123preimage", response.Result.(nip47.PayResponse).Preimage)
 }
 
-func TestHandleLookupInvoiceEvent(t *testing.T) {
+func TestHandlePayInvoiceEvent_Internal(t *testing.T) {
 	ctx := context.TODO()
 	defer os.Remove(testDB)
 	mockLn, err := NewMockLn()
 	assert.NoError(t, err)
 	svc, err := createTestService(mockLn)
 	assert.NoError(t, err)
-	app, ss, err := createApp(svc)
-	assert.NoError(t, err)
 
-	request := &nip47.Request{}
-	err = json.Unmarshal([]byte(nip47LookupInvoiceJson), request)
+	// the mock node issued the invoice, but has not been paid for it
+	paymentRequest, err := decodepay.Decodepay(mockInvoice)
 	assert.NoError(t, err)
+	mockLn.pubkey = paymentRequest.Payee
+	mockLn.invoice = &nip47.Transaction{
+		Type:        "incoming",
+		Invoice:     mockInvoice,
+		PaymentHash: mockPaymentHash,
+		Preimage:    "internalpreimage",
+		Amount:      123000,
+	}
 
-	// without permission
-	payload, err := nip04.Encrypt(nip47LookupInvoiceJson, ss)
+	receivingApp, _, err := createApp(svc)
 	assert.NoError(t, err)
-	reqEvent := &nostr.Event{
+	err = svc.db.Create(&db.Invoice{
+		AppId:          receivingApp.ID,
+		PaymentHash:    mockPaymentHash,
+		PaymentRequest: mockInvoice,
+		Amount:         123,
+	}).Error
+	assert.NoError(t, err)
+	for _, method := range []string{nip47.LOOKUP_INVOICE_METHOD, nip47.PAY_INVOICE_METHOD} {
+		err = svc.db.Create(&db.AppPermission{
+			AppId:         receivingApp.ID,
+			RequestMethod: method,
+		}).Error
+		assert.NoError(t, err)
+	}
+
+	payingApp, _, err := createApp(svc)
+	assert.NoError(t, err)
+	payPermission := &db.AppPermission{
+		AppId:         payingApp.ID,
+		RequestMethod: nip47.PAY_INVOICE_METHOD,
+		MaxAmount:     1000,
+		BudgetRenewal: "never",
+	}
+	err = svc.db.Create(payPermission).Error
+	assert.NoError(t, err)
+
+	pay := func(id string, app *db.App) *nip47.Response {
+		request := &nip47.Request{}
+		err := json.Unmarshal([]byte(nip47PayJson), request)
+		assert.NoError(t, err)
+		requestEvent := &db.RequestEvent{NostrId: id}
+		err = svc.db.Create(requestEvent).Error
+		assert.NoError(t, err)
+
+		responses := []*nip47.Response{}
+		svc.HandlePayInvoiceEvent(ctx, request, requestEvent, app, func(response *nip47.Response, tags nostr.Tags) {
+			responses = append(responses, response)
+		})
+		assert.Equal(t, 1, len(responses))
+		return responses[0]
+	}
+
+	response := pay("internal_payment", payingApp)
+	assert.Nil(t, response.Error)
+	assert.Equal(t, "internalpreimage", response.Result.(nip47.PayResponse).Preimage)
+	assert.Equal(t, int64(123000), svc.GetBudgetUsage(payPermission))
+
+	invoice := db.Invoice{}
+	err = svc.db.Where("payment_hash = ?", mockPaymentHash).First(&invoice).Error
+	assert.NoError(t, err)
+	assert.NotNil(t, invoice.SettledAt)
+
+	// the receiving app sees its invoice paid, not the other app's payment
+	request := &nip47.Request{}
+	err = json.Unmarshal([]byte(fmt.Sprintf(`{"method": "lookup_invoice", "params": {"payment_hash": "%s"}}`, mockPaymentHash)), request)
+	assert.NoError(t, err)
+	requestEvent := &db.RequestEvent{NostrId: "internal_lookup"}
+	err = svc.db.Create(requestEvent).Error
+	assert.NoError(t, err)
+	responses := []*nip47.Response{}
+	svc.HandleLookupInvoiceEvent(ctx, request, requestEvent, receivingApp, func(response *nip47.Response, tags nostr.Tags) {
+		responses = append(responses, response)
+	})
+	assert.Equal(t, 1, len(responses))
+	transaction := responses[0].Result.(*nip47.LookupInvoiceResponse)
+	assert.Equal(t, "incoming", transaction.Type)
+	assert.Equal(t, nip47.TRANSACTION_STATE_SETTLED, transaction.State)
+	assert.Equal(t, invoice.SettledAt.Unix(), *transaction.SettledAt)
+	assert.Nil(t, mockLn.invoice.SettledAt)
+
+	// the invoice can only be paid once, the node invoice is cancelled so it cannot be paid over the network either
+	response = pay("internal_payment_other_app", receivingApp)
+	assert.Equal(t, nip47.ERROR_BAD_REQUEST, response.Error.Code)
+	assert.Equal(t, []string{mockPaymentHash}, mockLn.cancelledPaymentHashes)
+
+	// forgets the payments, so the invoice can be paid again
+	resetInvoice := func() {
+		err := svc.db.Where("payment_hash = ?", mockPaymentHash).Delete(&db.Payment{}).Error
+		assert.NoError(t, err)
+		err = svc.db.Model(&invoice).Update("settled_at", nil).Error
+		assert.NoError(t, err)
+	}
+
+	// invoices in a batch are settled internally too
+	resetInvoice()
+	request = &nip47.Request{}
+	err = json.Unmarshal([]byte(nip47MultiPayJson), request)
+	assert.NoError(t, err)
+	requestEvent = &db.RequestEvent{NostrId: "internal_multi_payment"}
+	err = svc.db.Create(requestEvent).Error
+	assert.NoError(t, err)
+	responses = []*nip47.Response{}
+	var mu sync.Mutex
+	svc.HandleMultiPayInvoiceEvent(ctx, request, requestEvent, payingApp, func(response *nip47.Response, tags nostr.Tags) {
+		mu.Lock()
+		defer mu.Unlock()
+		responses = append(responses, response)
+	})
+	assert.Equal(t, 2, len(responses))
+	preimages := []string{}
+	for _, response := range responses {
+		if response.Error == nil {
+			preimages = append(preimages, response.Result.(nip47.PayResponse).Preimage)
+		}
+	}
+	// the duplicate invoice is answered with the result of the first one
+	assert.Equal(t, []string{"internalpreimage", "internalpreimage"}, preimages)
+	assert.Equal(t, []string{mockPaymentHash, mockPaymentHash}, mockLn.cancelledPaymentHashes)
+
+	// backends which cannot cancel the node invoice pay it over the network
+	resetInvoice()
+	mockLn.features = []string{}
+	response = pay("network_payment", payingApp)
+	assert.Nil(t, response.Error)
+	assert.Equal(t, "123preimage", response.Result.(nip47.PayResponse).Preimage)
+}
+
+func TestResolvePendingPayments(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+	err = svc.db.Create(&db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.PAY_INVOICE_METHOD,
+	}).Error
+	assert.NoError(t, err)
+
+	// payments left pending by a restart of the hub, none of them is in flight
+	createPendingPayment := func(paymentHash string) *db.Payment {
+		requestEvent := &db.RequestEvent{NostrId: "pending_" + paymentHash}
+		err := svc.db.Create(requestEvent).Error
+		assert.NoError(t, err)
+		payment := &db.Payment{AppId: app.ID, RequestEventId: requestEvent.ID, AmountMsat: 123000, PaymentHash: paymentHash, State: db.PAYMENT_STATE_PENDING}
+		err = svc.db.Create(payment).Error
+		assert.NoError(t, err)
+		return payment
+	}
+	settledPayment := createPendingPayment("settled_payment_hash")
+	unknownPayment := createPendingPayment(mockPaymentHash)
+	inflightPayment := createPendingPayment("inflight_payment_hash")
+	keysendPayment := createPendingPayment("")
+
+	mockLn.payments = map[string]*nip47.Transaction{
+		"settled_payment_hash":  {Type: "outgoing", State: nip47.TRANSACTION_STATE_SETTLED, PaymentHash: "settled_payment_hash", Preimage: "settled_preimage", FeesPaid: 2000},
+		"inflight_payment_hash": {Type: "outgoing", State: nip47.TRANSACTION_STATE_PENDING, PaymentHash: "inflight_payment_hash"},
+	}
+	resolved := svc.resolvePendingPayments(ctx, time.Now())
+	assert.False(t, resolved)
+
+	err = svc.db.First(settledPayment, settledPayment.ID).Error
+	assert.NoError(t, err)
+	assert.Equal(t, db.PAYMENT_STATE_SUCCEEDED, settledPayment.State)
+	assert.Equal(t, "settled_preimage", *settledPayment.Preimage)
+	assert.Equal(t, uint64(2000), *settledPayment.FeeMsat)
+	// the node never got the payment
+	err = svc.db.First(unknownPayment, unknownPayment.ID).Error
+	assert.NoError(t, err)
+	assert.Equal(t, db.PAYMENT_STATE_FAILED, unknownPayment.State)
+	err = svc.db.First(keysendPayment, keysendPayment.ID).Error
+	assert.NoError(t, err)
+	assert.Equal(t, db.PAYMENT_STATE_FAILED, keysendPayment.State)
+	// still in flight on the node
+	err = svc.db.First(inflightPayment, inflightPayment.ID).Error
+	assert.NoError(t, err)
+	assert.Equal(t, db.PAYMENT_STATE_PENDING, inflightPayment.State)
+
+	// the invoice whose payment failed can be paid again
+	response := handleRequest(t, svc, app, "pay_after_failed_pending_payment", nip47PayJson, svc.HandlePayInvoiceEvent)
+	assert.Nil(t, response.Error)
+	assert.Equal(t, "123preimage", response.Result.(nip47.PayResponse).Preimage)
+
+	// payments made after the start are left to their request
+	createPendingPayment("new_payment_hash")
+	mockLn.payments["inflight_payment_hash"] = &nip47.Transaction{Type: "outgoing", State: nip47.TRANSACTION_STATE_FAILED, PaymentHash: "inflight_payment_hash"}
+	resolved = svc.resolvePendingPayments(ctx, inflightPayment.CreatedAt.Add(time.Millisecond))
+	assert.True(t, resolved)
+	err = svc.db.First(inflightPayment, inflightPayment.ID).Error
+	assert.NoError(t, err)
+	assert.Equal(t, db.PAYMENT_STATE_FAILED, inflightPayment.State)
+	var pendingCount int64
+	err = svc.db.Model(&db.Payment{}).Where("state = ?", db.PAYMENT_STATE_PENDING).Count(&pendingCount).Error
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), pendingCount)
+
+	// backends which cannot look payments up are searched for the payment
+	mockLn.payments = nil
+	mockLn.transactions = []nip47.Transaction{{Type: "outgoing", PaymentHash: "listed_payment_hash", SettledAt: &mockTimeUnix}}
+	transaction, err := svc.lookupPayment(ctx, "listed_payment_hash")
+	assert.NoError(t, err)
+	assert.Equal(t, nip47.TRANSACTION_STATE_SETTLED, transaction.State)
+	_, err = svc.lookupPayment(ctx, "unknown_payment_hash")
+	assert.ErrorIs(t, err, lnclient.ErrPaymentNotFound)
+}
+
+func TestCreateIsolatedAppWithoutBackendSupport(t *testing.T) {
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	mockLn.features = []string{}
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	apiSvc := api.NewAPI(svc, svc.logger, svc.db)
+
+	createAppRequest := &api.CreateAppRequest{
+		Name:           "Isolated",
+		RequestMethods: nip47.PAY_INVOICE_METHOD,
+		Isolated:       true,
+	}
+	_, err = apiSvc.CreateApp(createAppRequest)
+	assert.EqualError(t, err, "isolated apps are not supported by this lightning backend")
+
+	mockLn.features = []string{lnclient.FeaturePaymentReceivedEvents}
+	_, err = apiSvc.CreateApp(createAppRequest)
+	assert.NoError(t, err)
+}
+
+func TestIsolatedApp(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+	app.Isolated = true
+	err = svc.db.Save(app).Error
+	assert.NoError(t, err)
+
+	for _, method := range []string{nip47.PAY_INVOICE_METHOD, nip47.GET_BALANCE_METHOD} {
+		err = svc.db.Create(&db.AppPermission{
+			AppId:         app.ID,
+			RequestMethod: method,
+		}).Error
+		assert.NoError(t, err)
+	}
+
+	// the node balance is not available to the app
+	response := handleRequest(t, svc, app, "isolated_balance_empty", nip47GetBalanceJson, svc.HandleGetBalanceEvent)
+	assert.Equal(t, int64(0), response.Result.(*nip47.BalanceResponse).Balance)
+	response = handleRequest(t, svc, app, "isolated_pay_without_balance", nip47PayJson, svc.HandlePayInvoiceEvent)
+	assert.Equal(t, nip47.ERROR_INSUFFICIENT_BALANCE, response.Error.Code)
+
+	// a payment to one of the app's invoices is credited to it
+	err = svc.db.Create(&db.Invoice{
+		AppId:       app.ID,
+		PaymentHash: "isolated_payment_hash",
+		Amount:      0,
+	}).Error
+	assert.NoError(t, err)
+	err = NewInvoiceSettler(svc).ConsumeEvent(ctx, &events.Event{
+		Event: "nwc_payment_received",
+		Properties: &events.PaymentReceivedEventProperties{
+			PaymentHash: "isolated_payment_hash",
+			Amount:      200,
+		},
+	}, map[string]interface{}{})
+	assert.NoError(t, err)
+	response = handleRequest(t, svc, app, "isolated_balance_received", nip47GetBalanceJson, svc.HandleGetBalanceEvent)
+	assert.Equal(t, int64(200_000), response.Result.(*nip47.BalanceResponse).Balance)
+
+	// the highest fee the payment may take is reserved from the balance
+	appMaxFeeMsat := uint64(80_000)
+	err = svc.db.Model(&db.AppPermission{}).Where("app_id = ? AND request_method = ?", app.ID, nip47.PAY_INVOICE_METHOD).Update("max_fee_msat", &appMaxFeeMsat).Error
+	assert.NoError(t, err)
+	response = handleRequest(t, svc, app, "isolated_pay_fee_reserve", nip47PayJson, svc.HandlePayInvoiceEvent)
+	assert.Equal(t, nip47.ERROR_INSUFFICIENT_BALANCE, response.Error.Code)
+	response = handleRequest(t, svc, app, "isolated_pay", fmt.Sprintf(`{"method": "pay_invoice", "params": {"invoice": "%s", "max_fee": %d}}`, mockInvoice, 77_000), svc.HandlePayInvoiceEvent)
+	assert.Nil(t, response.Error)
+	err = svc.db.Model(&db.AppPermission{}).Where("app_id = ? AND request_method = ?", app.ID, nip47.PAY_INVOICE_METHOD).Update("max_fee_msat", nil).Error
+	assert.NoError(t, err)
+	// the routing fee is spent from the app's balance too
+	assert.Equal(t, int64(77_000-mockRoutingFeeMsat), svc.GetIsolatedBalance(app))
+
+	// a subscription can spend the remaining balance, its pending payment does not count against it
+	requestEvent := &db.RequestEvent{NostrId: "isolated_create_subscription"}
+	err = svc.db.Create(requestEvent).Error
+	assert.NoError(t, err)
+	subscription := &db.Subscription{AppId: app.ID, App: *app, RequestEventId: requestEvent.ID, Pubkey: "123pubkey", Amount: 77_000 - 2*mockRoutingFeeMsat, Interval: "@monthly", NextRunAt: time.Now()}
+	err = svc.db.Create(subscription).Error
+	assert.NoError(t, err)
+	svc.runSubscription(ctx, subscription)
+	payment := db.Payment{}
+	err = svc.db.Where("subscription_id = ?", subscription.ID).First(&payment).Error
+	assert.NoError(t, err)
+	assert.Equal(t, db.PAYMENT_STATE_SUCCEEDED, payment.State)
+	// the keysend routing fee is recorded as well
+	assert.Equal(t, uint64(mockRoutingFeeMsat), *payment.FeeMsat)
+	assert.Equal(t, int64(0), svc.GetIsolatedBalance(app))
+}
+
+func TestHandlePayKeysendEvent(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, ss, err := createApp(svc)
+	assert.NoError(t, err)
+
+	request := &nip47.Request{}
+	err = json.Unmarshal([]byte(nip47KeysendJson), request)
+	assert.NoError(t, err)
+
+	// without permission
+	payload, err := nip04.Encrypt(nip47KeysendJson, ss)
+	assert.NoError(t, err)
+	reqEvent := &nostr.Event{
+		Kind:    nip47.REQUEST_KIND,
+		PubKey:  app.NostrPubkey,
+		Content: payload,
+	}
+
+	reqEvent.ID = "pay_keysend_without_permission"
+	requestEvent := &db.RequestEvent{
+		NostrId: reqEvent.ID,
+	}
+
+	responses := []*nip47.Response{}
+
+	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
+		responses = append(responses, response)
+	}
+
+	svc.HandlePayKeysendEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Equal(t, nip47.ERROR_RESTRICTED, responses[0].Error.Code)
+
+	// with permission
+	maxAmount := 1000
+	budgetRenewal := "never"
+	expiresAt := time.Now().Add(24 * time.Hour)
+	// because we need the same permission for keysend although
+	// it works even with nip47.PAY_KEYSEND_METHOD, see
+	// https://github.com/getAlby/nostr-wallet-connect/issues/189
+	appPermission := &db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.PAY_INVOICE_METHOD,
+		MaxAmount:     maxAmount,
+		BudgetRenewal: budgetRenewal,
+		ExpiresAt:     &expiresAt,
+	}
+	err = svc.db.Create(appPermission).Error
+	assert.NoError(t, err)
+
+	collector := &eventCollector{events: make(chan *events.Event, 10)}
+	svc.eventPublisher.RegisterSubscriber(collector)
+	reqEvent.ID = "pay_keysend_with_permission"
+	requestEvent.NostrId = reqEvent.ID
+	responses = []*nip47.Response{}
+	svc.HandlePayKeysendEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Equal(t, responses[0].Result.(nip47.PayResponse).Preimage, "12345preimage")
+	assert.Equal(t, uint64(mockRoutingFeeMsat), *responses[0].Result.(nip47.PayResponse).FeesPaid)
+	// the notifier only understands the typed properties
+	succeededEvent := <-collector.events
+	svc.eventPublisher.RemoveSubscriber(collector)
+	assert.Equal(t, "nwc_payment_succeeded", succeededEvent.Event)
+	assert.Equal(t, app.ID, succeededEvent.Properties.(*events.PaymentSucceededEventProperties).AppId)
+
+	// budget overflow
+	newMaxAmount := 100
+	err = svc.db.Model(&db.AppPermission{}).Where("app_id = ?", app.ID).Update("max_amount", newMaxAmount).Error
+	assert.NoError(t, err)
+
+	err = json.Unmarshal([]byte(nip47KeysendJson), request)
+	assert.NoError(t, err)
+
+	payload, err = nip04.Encrypt(nip47KeysendJson, ss)
+	assert.NoError(t, err)
+	reqEvent.Content = payload
+
+	reqEvent.ID = "pay_keysend_with_budget_overflow"
+	requestEvent.NostrId = reqEvent.ID
+	responses = []*nip47.Response{}
+	svc.HandlePayKeysendEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Equal(t, nip47.ERROR_QUOTA_EXCEEDED, responses[0].Error.Code)
+}
+
+const mockLnurlMetadata = `[["text/plain","Sats for Alice"],["text/identifier","alice@example.com"]]`
+
+// starts a LNURL-pay server for "alice" and for "bob", whose invoices do not commit to the metadata
+func newMockLnurlServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+
+	for _, username := range []string{"alice", "bob"} {
+		username := username
+		mux.HandleFunc("/.well-known/lnurlp/"+username, func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(&lnurl.PayParams{
+				Tag:            lnurl.PAY_REQUEST_TAG,
+				Callback:       server.URL + "/callback/" + username,
+				MinSendable:    1000,
+				MaxSendable:    1000000,
+				Metadata:       mockLnurlMetadata,
+				CommentAllowed: 10,
+			})
+		})
+		mux.HandleFunc("/callback/"+username, func(w http.ResponseWriter, r *http.Request) {
+			var amount int64
+			fmt.Sscan(r.URL.Query().Get("amount"), &amount)
+			descriptionHash := sha256.Sum256([]byte(mockLnurlMetadata))
+			if username == "bob" {
+				descriptionHash = sha256.Sum256([]byte("something else"))
+			}
+			invoice, err := makeMockInvoice(amount, descriptionHash)
+			assert.NoError(t, err)
+			json.NewEncoder(w).Encode(map[string]interface{}{"pr": invoice, "routes": []interface{}{}})
+		})
+	}
+	return server
+}
+
+func makeMockInvoice(amountMsat int64, descriptionHash [32]byte) (string, error) {
+	privateKey, err := btcec.NewPrivateKey()
+	if err != nil {
+		return "", err
+	}
+	invoice, err := zpay32.NewInvoice(&chaincfg.TestNet3Params, sha256.Sum256([]byte(mockTime.String())), mockTime,
+		zpay32.Amount(lnwire.MilliSatoshi(amountMsat)), zpay32.DescriptionHash(descriptionHash))
+	if err != nil {
+		return "", err
+	}
+	return invoice.Encode(zpay32.MessageSigner{
+		SignCompact: func(msg []byte) ([]byte, error) {
+			return ecdsa.SignCompact(privateKey, chainhash.HashB(msg), true)
+		},
+	})
+}
+
+func TestHandlePayLnurlEvent(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+
+	server := newMockLnurlServer(t)
+	defer server.Close()
+	svc.lnurlClient = lnurl.NewClient(server.Client())
+	host := server.Listener.Addr().String()
+
+	responses := []*nip47.Response{}
+	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
+		responses = append(responses, response)
+	}
+	payLnurl := func(method string, params *nip47.PayLnurlParams) *nip47.Response {
+		paramsJson, err := json.Marshal(params)
+		assert.NoError(t, err)
+		responses = []*nip47.Response{}
+		requestEvent := &db.RequestEvent{NostrId: fmt.Sprintf("pay_lnurl_%d", time.Now().UnixNano())}
+		svc.HandlePayLnurlEvent(ctx, &nip47.Request{Method: method, Params: paramsJson}, requestEvent, app, publishResponse)
+		assert.Equal(t, 1, len(responses))
+		return responses[0]
+	}
+
+	response := payLnurl(nip47.PAY_LIGHTNING_ADDRESS_METHOD, &nip47.PayLnurlParams{LightningAddress: "alice@" + host, Amount: 21000})
+	assert.Equal(t, nip47.ERROR_RESTRICTED, response.Error.Code)
+
+	appPermission := &db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.PAY_INVOICE_METHOD,
+	}
+	err = svc.db.Create(appPermission).Error
+	assert.NoError(t, err)
+
+	response = payLnurl(nip47.PAY_LIGHTNING_ADDRESS_METHOD, &nip47.PayLnurlParams{LightningAddress: "alice@" + host, Amount: 21000, Comment: "hi"})
+	assert.Nil(t, response.Error)
+	assert.Equal(t, "123preimage", response.Result.(nip47.PayResponse).Preimage)
+
+	payment := db.Payment{}
+	err = svc.db.Last(&payment).Error
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(21000), payment.AmountMsat)
+
+	encodedLnurl, err := lnurl.Encode(server.URL + "/.well-known/lnurlp/alice")
+	assert.NoError(t, err)
+	response = payLnurl(nip47.PAY_LNURL_METHOD, &nip47.PayLnurlParams{Lnurl: encodedLnurl, Amount: 1000})
+	assert.Nil(t, response.Error)
+
+	// above max sendable
+	response = payLnurl(nip47.PAY_LIGHTNING_ADDRESS_METHOD, &nip47.PayLnurlParams{LightningAddress: "alice@" + host, Amount: 2000000})
+	assert.Equal(t, nip47.OTHER, response.Error.Code)
+
+	// comment too long
+	response = payLnurl(nip47.PAY_LIGHTNING_ADDRESS_METHOD, &nip47.PayLnurlParams{LightningAddress: "alice@" + host, Amount: 1000, Comment: "this comment is too long"})
+	assert.Equal(t, nip47.OTHER, response.Error.Code)
+
+	// description hash does not match the metadata
+	response = payLnurl(nip47.PAY_LIGHTNING_ADDRESS_METHOD, &nip47.PayLnurlParams{LightningAddress: "bob@" + host, Amount: 1000})
+	assert.Equal(t, nip47.OTHER, response.Error.Code)
+	assert.Contains(t, response.Error.Message, "description hash")
+
+	// unknown user
+	response = payLnurl(nip47.PAY_LIGHTNING_ADDRESS_METHOD, &nip47.PayLnurlParams{LightningAddress: "nobody@" + host, Amount: 1000})
+	assert.Equal(t, nip47.OTHER, response.Error.Code)
+	assert.Contains(t, response.Error.Message, "status 404")
+
+	// the hub's own client does not connect to the loopback address of the mock server
+	svc.lnurlClient = lnurl.NewClient(lnurl.NewHttpClient(10 * time.Second))
+	response = payLnurl(nip47.PAY_LIGHTNING_ADDRESS_METHOD, &nip47.PayLnurlParams{LightningAddress: "alice@" + host, Amount: 1000})
+	assert.Equal(t, nip47.OTHER, response.Error.Code)
+	assert.Contains(t, response.Error.Message, lnurl.ErrNonPublicAddress.Error())
+}
+
+func TestLnurlPayCallback(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	mockLn.features = []string{lnclient.FeatureDescriptionHash}
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	svc.cfg.GetEnv().LightningAddressDomain = "example.com"
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+	err = svc.db.Model(app).Update("lightning_address_username", "alice").Error
+	assert.NoError(t, err)
+	apiSvc := api.NewAPI(svc, svc.logger, svc.db)
+
+	// invoices are stored in sats, so millisats cannot be credited to the app
+	_, err = apiSvc.LnurlPayCallback(ctx, "alice", 1500, "")
+	assert.EqualError(t, err, "amount must be a whole number of sats")
+	_, err = apiSvc.LnurlPayCallback(ctx, "alice", 999, "")
+	assert.Error(t, err)
+
+	var invoiceCount int64
+	err = svc.db.Model(&db.Invoice{}).Count(&invoiceCount).Error
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), invoiceCount)
+}
+
+func TestHandleLookupInvoiceEvent(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, ss, err := createApp(svc)
+	assert.NoError(t, err)
+
+	request := &nip47.Request{}
+	err = json.Unmarshal([]byte(nip47LookupInvoiceJson), request)
+	assert.NoError(t, err)
+
+	// without permission
+	payload, err := nip04.Encrypt(nip47LookupInvoiceJson, ss)
+	assert.NoError(t, err)
+	reqEvent := &nostr.Event{
+		Kind:    nip47.REQUEST_KIND,
+		PubKey:  app.NostrPubkey,
+		Content: payload,
+	}
+
+	reqEvent.ID = "test_lookup_invoice_without_permission"
+	requestEvent := &db.RequestEvent{
+		NostrId: reqEvent.ID,
+	}
+
+	responses := []*nip47.Response{}
+
+	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
+		responses = append(responses, response)
+	}
+
+	svc.HandleLookupInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Equal(t, nip47.ERROR_RESTRICTED, responses[0].Error.Code)
+
+	// with permission
+	expiresAt := time.Now().Add(24 * time.Hour)
+	appPermission := &db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.LOOKUP_INVOICE_METHOD,
+		ExpiresAt:     &expiresAt,
+	}
+	err = svc.db.Create(appPermission).Error
+	assert.NoError(t, err)
+
+	// invoices of other apps are not visible
+	reqEvent.ID = "test_lookup_invoice_with_permission"
+	requestEvent.NostrId = reqEvent.ID
+	responses = []*nip47.Response{}
+	svc.HandleLookupInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Equal(t, nip47.ERROR_NOT_FOUND, responses[0].Error.Code)
+
+	// own invoice
+	err = svc.db.Create(&db.Invoice{
+		AppId:          app.ID,
+		PaymentHash:    "4ad9cd27989b514d868e755178378019903a8d78767e3fceb211af9dd00e7a94",
+		PaymentRequest: mockTransaction.Invoice,
+		Amount:         uint(mockTransaction.Amount / 1000),
+	}).Error
+	assert.NoError(t, err)
+
+	reqEvent.ID = "test_lookup_invoice_own_invoice"
+	requestEvent.NostrId = reqEvent.ID
+	responses = []*nip47.Response{}
+	svc.HandleLookupInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+
+	transaction := responses[0].Result.(*nip47.LookupInvoiceResponse)
+	assert.Equal(t, mockTransaction.Type, transaction.Type)
+	assert.Equal(t, mockTransaction.Invoice, transaction.Invoice)
+	assert.Equal(t, mockTransaction.Description, transaction.Description)
+	assert.Equal(t, mockTransaction.DescriptionHash, transaction.DescriptionHash)
+	assert.Equal(t, mockTransaction.Preimage, transaction.Preimage)
+	assert.Equal(t, mockTransaction.PaymentHash, transaction.PaymentHash)
+	assert.Equal(t, mockTransaction.Amount, transaction.Amount)
+	assert.Equal(t, mockTransaction.FeesPaid, transaction.FeesPaid)
+	assert.Equal(t, mockTransaction.SettledAt, transaction.SettledAt)
+}
+
+func TestHandleMakeInvoiceEvent(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, ss, err := createApp(svc)
+	assert.NoError(t, err)
+
+	request := &nip47.Request{}
+	err = json.Unmarshal([]byte(nip47MakeInvoiceJson), request)
+	assert.NoError(t, err)
+
+	// without permission
+	payload, err := nip04.Encrypt(nip47MakeInvoiceJson, ss)
+	assert.NoError(t, err)
+	reqEvent := &nostr.Event{
+		Kind:    nip47.REQUEST_KIND,
+		PubKey:  app.NostrPubkey,
+		Content: payload,
+	}
+
+	reqEvent.ID = "test_make_invoice_without_permission"
+	requestEvent := &db.RequestEvent{
+		NostrId: reqEvent.ID,
+	}
+
+	responses := []*nip47.Response{}
+
+	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
+		responses = append(responses, response)
+	}
+
+	svc.HandleMakeInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Equal(t, nip47.ERROR_RESTRICTED, responses[0].Error.Code)
+
+	// with permission
+	expiresAt := time.Now().Add(24 * time.Hour)
+	appPermission := &db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.MAKE_INVOICE_METHOD,
+		ExpiresAt:     &expiresAt,
+	}
+	err = svc.db.Create(appPermission).Error
+	assert.NoError(t, err)
+
+	reqEvent.ID = "test_make_invoice_with_permission"
+	requestEvent.NostrId = reqEvent.ID
+	responses = []*nip47.Response{}
+	svc.HandleMakeInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Equal(t, mockTransaction.Preimage, responses[0].Result.(*nip47.MakeInvoiceResponse).Preimage)
+}
+
+func TestHandleMakeHoldInvoiceEvent(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+
+	request := &nip47.Request{}
+	err = json.Unmarshal([]byte(nip47MakeHoldInvoiceJson), request)
+	assert.NoError(t, err)
+
+	requestEvent := &db.RequestEvent{
+		NostrId: "test_make_hold_invoice_without_permission",
+	}
+
+	responses := []*nip47.Response{}
+
+	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
+		responses = append(responses, response)
+	}
+
+	// make_invoice does not grant make_hold_invoice
+	appPermission := &db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.MAKE_INVOICE_METHOD,
+	}
+	err = svc.db.Create(appPermission).Error
+	assert.NoError(t, err)
+
+	svc.HandleMakeHoldInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Equal(t, nip47.ERROR_RESTRICTED, responses[0].Error.Code)
+
+	appPermission = &db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.MAKE_HOLD_INVOICE_METHOD,
+	}
+	err = svc.db.Create(appPermission).Error
+	assert.NoError(t, err)
+
+	requestEvent.NostrId = "test_make_hold_invoice_with_permission"
+	responses = []*nip47.Response{}
+	svc.HandleMakeHoldInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Nil(t, responses[0].Error)
+	assert.Equal(t, mockTransaction.PaymentHash, responses[0].Result.(*nip47.MakeHoldInvoiceResponse).PaymentHash)
+
+	// after a restart the backend follows the hold invoices which were not settled again
+	settledAt := time.Now()
+	err = svc.db.Create(&db.Invoice{AppId: app.ID, PaymentHash: "settled_hold_payment_hash", Hold: true, SettledAt: &settledAt}).Error
+	assert.NoError(t, err)
+	err = svc.db.Create(&db.Invoice{AppId: app.ID, PaymentHash: "regular_payment_hash"}).Error
+	assert.NoError(t, err)
+	svc.resubscribeHoldInvoices(ctx)
+	assert.Equal(t, []string{mockTransaction.PaymentHash}, mockLn.subscribedHoldInvoiceHashes)
+}
+
+func TestHoldInvoicesWithoutBackendSupport(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	mockLn.features = []string{}
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+
+	appPermission := &db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.MAKE_HOLD_INVOICE_METHOD,
+	}
+	err = svc.db.Create(appPermission).Error
+	assert.NoError(t, err)
+
+	// the permission is kept, but not offered while the backend cannot make hold invoices
+	assert.NotContains(t, svc.GetMethods(app), nip47.MAKE_HOLD_INVOICE_METHOD)
+	assert.NotContains(t, svc.filterSupported(nip47.CAPABILITIES), nip47.MAKE_HOLD_INVOICE_METHOD)
+	assert.NotContains(t, svc.filterSupported(nip47.NOTIFICATION_TYPES), nip47.HOLD_INVOICE_ACCEPTED_NOTIFICATION)
+
+	request := &nip47.Request{}
+	err = json.Unmarshal([]byte(nip47MakeHoldInvoiceJson), request)
+	assert.NoError(t, err)
+
+	responses := []*nip47.Response{}
+	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
+		responses = append(responses, response)
+	}
+
+	svc.dispatchNip47Request(ctx, request, &db.RequestEvent{NostrId: "test_make_hold_invoice_unsupported"}, app, publishResponse)
+
+	assert.Equal(t, nip47.ERROR_NOT_IMPLEMENTED, responses[0].Error.Code)
+}
+
+func TestHandleSettleHoldInvoiceEvent(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+
+	request := &nip47.Request{}
+	err = json.Unmarshal([]byte(nip47SettleHoldInvoiceJson), request)
+	assert.NoError(t, err)
+
+	requestEvent := &db.RequestEvent{
+		NostrId: "test_settle_hold_invoice_without_permission",
+	}
+
+	responses := []*nip47.Response{}
+
+	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
+		responses = append(responses, response)
+	}
+
+	svc.HandleSettleHoldInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Equal(t, nip47.ERROR_RESTRICTED, responses[0].Error.Code)
+
+	appPermission := &db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.SETTLE_HOLD_INVOICE_METHOD,
+	}
+	err = svc.db.Create(appPermission).Error
+	assert.NoError(t, err)
+
+	// apps can only settle their own hold invoices
+	preimage, err := hex.DecodeString("c8aeb44dd01a4e8ae7e0e1ef5e6e2a4e4fd2d8e0e3f4cb8d4b5ffd1a6a1e3f3a")
+	assert.NoError(t, err)
+	paymentHash := sha256.Sum256(preimage)
+	otherApp, _, err := createApp(svc)
+	assert.NoError(t, err)
+	err = svc.db.Create(&db.Invoice{AppId: otherApp.ID, PaymentHash: hex.EncodeToString(paymentHash[:]), Hold: true}).Error
+	assert.NoError(t, err)
+
+	requestEvent.NostrId = "test_settle_hold_invoice_of_other_app"
+	responses = []*nip47.Response{}
+	svc.HandleSettleHoldInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Equal(t, nip47.ERROR_NOT_FOUND, responses[0].Error.Code)
+
+	err = svc.db.Model(&db.Invoice{}).Where("app_id = ?", otherApp.ID).Update("app_id", app.ID).Error
+	assert.NoError(t, err)
+	requestEvent.NostrId = "test_settle_hold_invoice_with_permission"
+	responses = []*nip47.Response{}
+	svc.HandleSettleHoldInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Nil(t, responses[0].Error)
+	assert.Equal(t, &nip47.SettleHoldInvoiceResponse{}, responses[0].Result)
+}
+
+func TestHandleListTransactionsEvent(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, ss, err := createApp(svc)
+	assert.NoError(t, err)
+
+	request := &nip47.Request{}
+	err = json.Unmarshal([]byte(nip47ListTransactionsJson), request)
+	assert.NoError(t, err)
+
+	// without permission
+	payload, err := nip04.Encrypt(nip47ListTransactionsJson, ss)
+	assert.NoError(t, err)
+	reqEvent := &nostr.Event{
 		Kind:    nip47.REQUEST_KIND,
 		PubKey:  app.NostrPubkey,
 		Content: payload,
 	}
 
-	reqEvent.ID = "test_lookup_invoice_without_permission"
-	requestEvent := &db.RequestEvent{
-		NostrId: reqEvent.ID,
+	reqEvent.ID = "test_list_transactions_without_permission"
+	requestEvent := &db.RequestEvent{
+		NostrId: reqEvent.ID,
+	}
+
+	responses := []*nip47.Response{}
+
+	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
+		responses = append(responses, response)
+	}
+
+	svc.HandleListTransactionsEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Equal(t, nip47.ERROR_RESTRICTED, responses[0].Error.Code)
+
+	// with permission
+	expiresAt := time.Now().Add(24 * time.Hour)
+	appPermission := &db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.LIST_TRANSACTIONS_METHOD,
+		ExpiresAt:     &expiresAt,
+	}
+	err = svc.db.Create(appPermission).Error
+	assert.NoError(t, err)
+
+	// only the app's own transactions are listed
+	reqEvent.ID = "test_list_transactions_with_permission"
+	requestEvent.NostrId = reqEvent.ID
+	responses = []*nip47.Response{}
+	svc.HandleListTransactionsEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Nil(t, responses[0].Error)
+	assert.Equal(t, 0, len(responses[0].Result.(*nip47.ListTransactionsResponse).Transactions))
+
+	// with permission to see all transactions of the node
+	err = svc.db.Create(&db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.ALL_TRANSACTIONS_PERMISSION,
+		ExpiresAt:     &expiresAt,
+	}).Error
+	assert.NoError(t, err)
+	svc.reconcileTransactions(ctx, true)
+
+	reqEvent.ID = "test_list_transactions_all_transactions"
+	requestEvent.NostrId = reqEvent.ID
+	responses = []*nip47.Response{}
+	svc.HandleListTransactionsEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Equal(t, 2, len(responses[0].Result.(*nip47.ListTransactionsResponse).Transactions))
+	transaction := responses[0].Result.(*nip47.ListTransactionsResponse).Transactions[0]
+	assert.Equal(t, mockTransactions[0].Type, transaction.Type)
+	assert.Equal(t, mockTransactions[0].Invoice, transaction.Invoice)
+	assert.Equal(t, mockTransactions[0].Description, transaction.Description)
+	assert.Equal(t, mockTransactions[0].DescriptionHash, transaction.DescriptionHash)
+	assert.Equal(t, mockTransactions[0].Preimage, transaction.Preimage)
+	assert.Equal(t, mockTransactions[0].PaymentHash, transaction.PaymentHash)
+	assert.Equal(t, mockTransactions[0].Amount, transaction.Amount)
+	assert.Equal(t, mockTransactions[0].FeesPaid, transaction.FeesPaid)
+	assert.Equal(t, mockTransactions[0].SettledAt, transaction.SettledAt)
+}
+
+func TestHandleListTransactionsEvent_OwnTransactions(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+	otherApp, _, err := createApp(svc)
+	assert.NoError(t, err)
+
+	expiresAt := time.Now().Add(24 * time.Hour)
+	err = svc.db.Create(&db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.LIST_TRANSACTIONS_METHOD,
+		ExpiresAt:     &expiresAt,
+	}).Error
+	assert.NoError(t, err)
+
+	settledAt := time.Now()
+	err = svc.db.Create(&db.Invoice{
+		AppId:          app.ID,
+		PaymentHash:    mockPaymentHash,
+		PaymentRequest: mockInvoice,
+		Amount:         123,
+		SettledAt:      &settledAt,
+		CreatedAt:      time.Now().Add(-time.Hour),
+	}).Error
+	assert.NoError(t, err)
+	err = svc.db.Create(&db.Invoice{
+		AppId:          otherApp.ID,
+		PaymentHash:    "other_payment_hash",
+		PaymentRequest: mockInvoice,
+		Amount:         123,
+		SettledAt:      &settledAt,
+	}).Error
+	assert.NoError(t, err)
+	// unpaid invoices are only listed when requested
+	err = svc.db.Create(&db.Invoice{
+		AppId:       app.ID,
+		PaymentHash: "unpaid_payment_hash",
+		Amount:      456,
+	}).Error
+	assert.NoError(t, err)
+	err = svc.db.Create(&db.Payment{
+		AppId:       app.ID,
+		PaymentHash: "outgoing_payment_hash",
+		AmountMsat:  100000,
+		State:       db.PAYMENT_STATE_SUCCEEDED,
+	}).Error
+	assert.NoError(t, err)
+
+	request := &nip47.Request{}
+	err = json.Unmarshal([]byte(`{"method": "list_transactions", "params": {}}`), request)
+	assert.NoError(t, err)
+	requestEvent := &db.RequestEvent{
+		NostrId: "test_list_own_transactions",
+	}
+
+	responses := []*nip47.Response{}
+	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
+		responses = append(responses, response)
+	}
+
+	svc.HandleListTransactionsEvent(ctx, request, requestEvent, app, publishResponse)
+
+	assert.Nil(t, responses[0].Error)
+	transactions := responses[0].Result.(*nip47.ListTransactionsResponse).Transactions
+	assert.Equal(t, 2, len(transactions))
+	// newest first
+	assert.Equal(t, "outgoing", transactions[0].Type)
+	assert.Equal(t, "outgoing_payment_hash", transactions[0].PaymentHash)
+	assert.Equal(t, int64(100000), transactions[0].Amount)
+	assert.Equal(t, "incoming", transactions[1].Type)
+	assert.Equal(t, mockPaymentHash, transactions[1].PaymentHash)
+	assert.Equal(t, int64(123000), transactions[1].Amount)
+	assert.Equal(t, nip47.TRANSACTION_STATE_SETTLED, transactions[1].State)
+
+	// keysend payments received by the node are only listed for apps allowed to see them
+	err = svc.db.Create(&db.Transaction{
+		Type:        "incoming",
+		State:       nip47.TRANSACTION_STATE_SETTLED,
+		PaymentHash: "keysend_payment_hash",
+		Amount:      21000,
+		Metadata:    `{"message":"hi"}`,
+		CreatedAt:   time.Now().Add(-time.Minute),
+	}).Error
+	assert.NoError(t, err)
+	err = svc.db.Create(&db.Transaction{
+		Type:           "incoming",
+		State:          nip47.TRANSACTION_STATE_SETTLED,
+		PaymentHash:    "other_payment_hash",
+		PaymentRequest: mockInvoice,
+		Amount:         123000,
+	}).Error
+	assert.NoError(t, err)
+
+	responses = []*nip47.Response{}
+	svc.HandleListTransactionsEvent(ctx, request, requestEvent, app, publishResponse)
+	assert.Equal(t, 2, len(responses[0].Result.(*nip47.ListTransactionsResponse).Transactions))
+
+	err = svc.db.Create(&db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.KEYSEND_RECEIPTS_PERMISSION,
+	}).Error
+	assert.NoError(t, err)
+	responses = []*nip47.Response{}
+	svc.HandleListTransactionsEvent(ctx, request, requestEvent, app, publishResponse)
+	transactions = responses[0].Result.(*nip47.ListTransactionsResponse).Transactions
+	assert.Equal(t, 3, len(transactions))
+	assert.Equal(t, "keysend_payment_hash", transactions[1].PaymentHash)
+	assert.Equal(t, int64(21000), transactions[1].Amount)
+	assert.Equal(t, map[string]interface{}{"message": "hi"}, transactions[1].Metadata)
+}
+
+func TestTransactionsLedger(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+
+	svc.reconcileTransactions(ctx, true)
+
+	// the node invoice of an internal payment is not settled on the node
+	err = svc.recordTransaction(&nip47.Transaction{
+		Type:        "incoming",
+		PaymentHash: "payment_hash_3",
+		Amount:      3000,
+		CreatedAt:   mockTimeUnix,
+	}, nil)
+	assert.NoError(t, err)
+	listParams := &nip47.ListTransactionsParams{Unpaid: true}
+	transactions, err := svc.listLedgerTransactions(listParams, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(transactions))
+	assert.Equal(t, nip47.TRANSACTION_STATE_PENDING, transactions[2].State)
+
+	settledAt := mockTimeUnix + 30
+	err = svc.recordTransaction(&nip47.Transaction{
+		Type:        "incoming",
+		PaymentHash: "payment_hash_3",
+		SettledAt:   &settledAt,
+	}, nil)
+	assert.NoError(t, err)
+	svc.reconcileTransactions(ctx, false)
+	err = svc.recordTransaction(&nip47.Transaction{
+		Type:        "incoming",
+		PaymentHash: "payment_hash_3",
+	}, nil)
+	assert.NoError(t, err)
+
+	// unpaid transactions are excluded by default
+	transactions, err = svc.listLedgerTransactions(&nip47.ListTransactionsParams{}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(transactions))
+	assert.Equal(t, "payment_hash_1", transactions[0].PaymentHash)
+	assert.Equal(t, "payment_hash_3", transactions[2].PaymentHash)
+	assert.Equal(t, nip47.TRANSACTION_STATE_SETTLED, transactions[2].State)
+	assert.Equal(t, int64(3000), transactions[2].Amount)
+
+	// limit and offset apply across all transactions
+	transactions, err = svc.listLedgerTransactions(&nip47.ListTransactionsParams{Offset: 1}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(transactions))
+	assert.Equal(t, "payment_hash_2", transactions[0].PaymentHash)
+
+	transactions, err = svc.listLedgerTransactions(&nip47.ListTransactionsParams{From: uint64(mockTimeUnix + 15), Type: "incoming"}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(transactions))
+	assert.Equal(t, "payment_hash_1", transactions[0].PaymentHash)
+}
+
+func TestTransactionsReconciliation(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+
+	settledAt := mockTimeUnix
+	nodeTransactions := func(from int, count int) []nip47.Transaction {
+		transactions := []nip47.Transaction{}
+		for i := from + count - 1; i >= from; i-- {
+			transactions = append(transactions, nip47.Transaction{
+				Type:        "incoming",
+				PaymentHash: fmt.Sprintf("payment_hash_%d", i),
+				Amount:      1000,
+				CreatedAt:   mockTimeUnix + int64(i),
+				SettledAt:   &settledAt,
+			})
+		}
+		return transactions
+	}
+	countTransactions := func() int64 {
+		var count int64
+		err := svc.db.Model(&db.Transaction{}).Count(&count).Error
+		assert.NoError(t, err)
+		return count
+	}
+
+	// the first run imports every page
+	mockLn.transactions = nodeTransactions(0, 2*transactionsReconcilePageSize+50)
+	svc.backfillTransactions(ctx)
+	assert.Equal(t, int64(2*transactionsReconcilePageSize+50), countTransactions())
+	backfilled, err := svc.cfg.Get(transactionsBackfilledKey, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "true", backfilled)
+
+	// later runs page through every page with new transactions, and stop at the first page without changes
+	newTransactions := nodeTransactions(2*transactionsReconcilePageSize+50, transactionsReconcilePageSize+50)
+	mockLn.transactions = append(newTransactions, nodeTransactions(0, 2*transactionsReconcilePageSize+50)...)
+	svc.backfillTransactions(ctx)
+	assert.Equal(t, int64(3*transactionsReconcilePageSize+100), countTransactions())
+
+	// pending transactions on pages which are not compared are looked up
+	err = svc.recordTransaction(&nip47.Transaction{
+		Type:        "outgoing",
+		PaymentHash: "in_flight_payment_hash",
+		Amount:      1000,
+		CreatedAt:   mockTimeUnix - 10,
+	}, nil)
+	assert.NoError(t, err)
+	mockLn.transactions = append(mockLn.transactions, nip47.Transaction{
+		Type:        "outgoing",
+		State:       nip47.TRANSACTION_STATE_SETTLED,
+		PaymentHash: "in_flight_payment_hash",
+		Preimage:    "in_flight_preimage",
+		Amount:      1000,
+		CreatedAt:   mockTimeUnix - 10,
+		SettledAt:   &settledAt,
+	})
+	mockLn.payments = map[string]*nip47.Transaction{"in_flight_payment_hash": &mockLn.transactions[len(mockLn.transactions)-1]}
+	svc.backfillTransactions(ctx)
+	dbTransaction := db.Transaction{}
+	err = svc.db.Where("payment_hash = ?", "in_flight_payment_hash").First(&dbTransaction).Error
+	assert.NoError(t, err)
+	assert.Equal(t, nip47.TRANSACTION_STATE_SETTLED, dbTransaction.State)
+	assert.Equal(t, "in_flight_preimage", dbTransaction.Preimage)
+}
+
+func TestExportTransactions(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+
+	err = svc.db.Create(&db.Invoice{
+		AppId:       app.ID,
+		PaymentHash: mockTransactions[0].PaymentHash,
+		Amount:      1,
+	}).Error
+	assert.NoError(t, err)
+
+	// the app of outgoing transactions is the one which made the payment
+	requestEvent := &db.RequestEvent{NostrId: "export_payment"}
+	err = svc.db.Create(requestEvent).Error
+	assert.NoError(t, err)
+	err = svc.db.Create(&db.Payment{AppId: app.ID, RequestEventId: requestEvent.ID, PaymentHash: "payment_hash_3", AmountMsat: 3000, State: db.PAYMENT_STATE_SUCCEEDED}).Error
+	assert.NoError(t, err)
+	settledAt := mockTimeUnix + 30
+	mockLn.transactions = append([]nip47.Transaction{{
+		Type:        "outgoing",
+		PaymentHash: "payment_hash_3",
+		Amount:      3000,
+		FeesPaid:    10,
+		CreatedAt:   mockTimeUnix + 30,
+		SettledAt:   &settledAt,
+	}}, mockTransactions...)
+
+	apiSvc := api.NewAPI(svc, svc.logger, svc.db)
+
+	var buffer bytes.Buffer
+	err = apiSvc.ExportTransactions(ctx, &api.ExportTransactionsRequest{Format: api.ExportFormatCSV}, &buffer)
+	assert.NoError(t, err)
+	records, err := csv.NewReader(&buffer).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(records))
+	assert.Equal(t, []string{"app_name", "type", "amount_msat", "fees_paid_msat", "description", "payment_hash", "settled_at"}, records[0])
+	assert.Equal(t, []string{"test", "outgoing", "3000", "10", "", "payment_hash_3", time.Unix(settledAt, 0).UTC().Format(time.RFC3339)}, records[1])
+	assert.Equal(t, []string{"test", "incoming", "1000", "50", "mock invoice 1", "payment_hash_1", mockTime.UTC().Format(time.RFC3339)}, records[2])
+	assert.Equal(t, "", records[3][0])
+	assert.Equal(t, "payment_hash_2", records[3][5])
+
+	buffer.Reset()
+	err = apiSvc.ExportTransactions(ctx, &api.ExportTransactionsRequest{Format: api.ExportFormatJSON}, &buffer)
+	assert.NoError(t, err)
+	exportedTransactions := []api.ExportedTransaction{}
+	err = json.Unmarshal(buffer.Bytes(), &exportedTransactions)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(exportedTransactions))
+	assert.Equal(t, "test", exportedTransactions[1].AppName)
+	assert.Equal(t, int64(2000), exportedTransactions[2].Amount)
+
+	// outside of the date range
+	buffer.Reset()
+	err = apiSvc.ExportTransactions(ctx, &api.ExportTransactionsRequest{Format: api.ExportFormatJSON, From: mockTimeUnix + 1, Until: mockTimeUnix + 29}, &buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "[]\n", buffer.String())
+}
+
+func TestAppActivity(t *testing.T) {
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	lastWeek := now.AddDate(0, 0, -7)
+	requestEvents := []db.RequestEvent{
+		{AppId: &app.ID, NostrId: "request_1", Method: nip47.PAY_INVOICE_METHOD, State: db.REQUEST_EVENT_STATE_HANDLER_EXECUTED, CreatedAt: lastWeek},
+		{AppId: &app.ID, NostrId: "request_2", Method: nip47.MULTI_PAY_INVOICE_METHOD, State: db.REQUEST_EVENT_STATE_HANDLER_EXECUTED, CreatedAt: now},
+		{AppId: &app.ID, NostrId: "request_3", Method: nip47.GET_BALANCE_METHOD, State: db.REQUEST_EVENT_STATE_HANDLER_ERROR, CreatedAt: now},
+	}
+	for i := range requestEvents {
+		err = svc.db.Create(&requestEvents[i]).Error
+		assert.NoError(t, err)
+	}
+	payments := []db.Payment{
+		{AppId: app.ID, RequestEventId: requestEvents[0].ID, AmountMsat: 100000, State: db.PAYMENT_STATE_SUCCEEDED, CreatedAt: lastWeek},
+		{AppId: app.ID, RequestEventId: requestEvents[1].ID, AmountMsat: 20500, State: db.PAYMENT_STATE_SUCCEEDED, CreatedAt: now},
+		{AppId: app.ID, RequestEventId: requestEvents[1].ID, AmountMsat: 30000, State: db.PAYMENT_STATE_FAILED, CreatedAt: now},
+	}
+	for i := range payments {
+		err = svc.db.Create(&payments[i]).Error
+		assert.NoError(t, err)
+	}
+	err = svc.db.Create(&db.ResponseEvent{NostrId: "response_1", RequestId: requestEvents[1].ID, State: db.RESPONSE_EVENT_STATE_PUBLISH_CONFIRMED, RepliedAt: now}).Error
+	assert.NoError(t, err)
+
+	apiSvc := api.NewAPI(svc, svc.logger, svc.db)
+
+	events, err := apiSvc.ListAppEvents(app, &api.ListAppEventsRequest{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), events.TotalCount)
+	assert.Equal(t, 2, len(events.Events))
+	assert.Equal(t, "request_3", events.Events[0].NostrId)
+	assert.Equal(t, uint64(0), events.Events[0].AmountMsat)
+	assert.Equal(t, 0, len(events.Events[0].Responses))
+	assert.Equal(t, "request_2", events.Events[1].NostrId)
+	assert.Equal(t, nip47.MULTI_PAY_INVOICE_METHOD, events.Events[1].Method)
+	assert.Equal(t, uint64(50500), events.Events[1].AmountMsat)
+	assert.Equal(t, 1, len(events.Events[1].Responses))
+	assert.Equal(t, db.RESPONSE_EVENT_STATE_PUBLISH_CONFIRMED, events.Events[1].Responses[0].State)
+
+	events, err = apiSvc.ListAppEvents(app, &api.ListAppEventsRequest{Limit: 2, Offset: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(events.Events))
+	assert.Equal(t, uint64(100000), events.Events[0].AmountMsat)
+
+	stats, err := apiSvc.GetAppStats(app, &api.GetAppStatsRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, api.AppStatsPeriodDay, stats.Period)
+	assert.Equal(t, 30, len(stats.Buckets))
+	today := stats.Buckets[len(stats.Buckets)-1]
+	assert.Equal(t, now.UTC().Format(time.DateOnly), today.Start.Format(time.DateOnly))
+	assert.Equal(t, int64(2), today.RequestCount)
+	assert.Equal(t, int64(1), today.FailedRequestCount)
+	assert.Equal(t, 0.5, today.RequestFailureRate)
+	assert.Equal(t, int64(2), today.PaymentCount)
+	assert.Equal(t, 0.5, today.PaymentFailureRate)
+	assert.Equal(t, uint64(20500), today.SpentMsat)
+	assert.Equal(t, int64(1), stats.Buckets[len(stats.Buckets)-8].RequestCount)
+	assert.Equal(t, int64(3), stats.Totals.RequestCount)
+	assert.Equal(t, int64(3), stats.Totals.PaymentCount)
+	assert.Equal(t, uint64(120500), stats.Totals.SpentMsat)
+
+	stats, err = apiSvc.GetAppStats(app, &api.GetAppStatsRequest{Period: api.AppStatsPeriodWeek})
+	assert.NoError(t, err)
+	assert.Equal(t, 12, len(stats.Buckets))
+	assert.Equal(t, time.Monday, stats.Buckets[0].Start.Weekday())
+	assert.Equal(t, int64(2), stats.Buckets[11].RequestCount)
+	assert.Equal(t, int64(1), stats.Buckets[10].RequestCount)
+	assert.Equal(t, uint64(100000), stats.Buckets[10].SpentMsat)
+
+	_, err = apiSvc.GetAppStats(app, &api.GetAppStatsRequest{Period: "month"})
+	assert.Error(t, err)
+}
+
+const mockLabelsJsonl = `{"type": "payment", "ref": "payment_hash_1", "label": "Coffee"}
+{"type": "channel", "ref": "channel_1", "label": "Channel to ACINQ"}
+{"type": "tx", "ref": "funding_tx_2", "label": "Funding from cold storage", "origin": "wpkh([d34db33f/84'/0'/0'])"}
+{"type": "unknown", "ref": "something", "label": "Skipped"}
+`
+
+func TestLabels(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+	apiSvc := api.NewAPI(svc, svc.logger, svc.db)
+
+	importLabelsResponse, err := apiSvc.ImportLabels(bytes.NewBufferString(mockLabelsJsonl))
+	assert.NoError(t, err)
+	assert.Equal(t, &api.ImportLabelsResponse{Imported: 3, Skipped: 1}, importLabelsResponse)
+
+	// invalid lines fail the whole import
+	_, err = apiSvc.ImportLabels(bytes.NewBufferString(`{"type": "addr", "ref": "bc1q"}` + "\nnot json\n"))
+	assert.Error(t, err)
+
+	err = apiSvc.SetLabel(&api.Label{Type: api.LabelTypePayment, Ref: "payment_hash_1", Label: "Coffee with friends"})
+	assert.NoError(t, err)
+	err = apiSvc.SetLabel(&api.Label{Type: "unknown", Ref: "something", Label: "Rejected"})
+	assert.Error(t, err)
+
+	labels, err := apiSvc.ListLabels()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(labels))
+
+	label, err := apiSvc.GetLabel(api.LabelTypePayment, "payment_hash_1")
+	assert.NoError(t, err)
+	assert.Equal(t, "Coffee with friends", label.Label)
+	_, err = apiSvc.GetLabel(api.LabelTypeAddr, "bc1q")
+	assert.ErrorIs(t, err, api.ErrLabelNotFound)
+
+	var buffer bytes.Buffer
+	err = apiSvc.ExportLabels(&buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"type":"channel","ref":"channel_1","label":"Channel to ACINQ"}
+{"type":"payment","ref":"payment_hash_1","label":"Coffee with friends"}
+{"type":"tx","ref":"funding_tx_2","label":"Funding from cold storage","origin":"wpkh([d34db33f/84'/0'/0'])"}
+`, buffer.String())
+
+	channels, err := apiSvc.ListChannels(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "Channel to ACINQ", channels[0].Label)
+	assert.Equal(t, "Funding from cold storage", channels[1].Label)
+
+	// payment labels are added to the transaction metadata
+	expiresAt := time.Now().Add(24 * time.Hour)
+	for _, method := range []string{nip47.LIST_TRANSACTIONS_METHOD, nip47.ALL_TRANSACTIONS_PERMISSION} {
+		err = svc.db.Create(&db.AppPermission{
+			AppId:         app.ID,
+			App:           *app,
+			RequestMethod: method,
+			ExpiresAt:     &expiresAt,
+		}).Error
+		assert.NoError(t, err)
+	}
+	svc.reconcileTransactions(ctx, true)
+
+	request := &nip47.Request{}
+	err = json.Unmarshal([]byte(`{"method": "list_transactions", "params": {}}`), request)
+	assert.NoError(t, err)
+	responses := []*nip47.Response{}
+	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
+		responses = append(responses, response)
+	}
+	svc.HandleListTransactionsEvent(ctx, request, &db.RequestEvent{NostrId: "test_list_transactions_labels"}, app, publishResponse)
+
+	assert.Nil(t, responses[0].Error)
+	transactions := responses[0].Result.(*nip47.ListTransactionsResponse).Transactions
+	assert.Equal(t, "Coffee with friends", transactions[0].Metadata.(map[string]interface{})["label"])
+	assert.Equal(t, "value1", transactions[0].Metadata.(map[string]interface{})["key1"])
+	assert.Nil(t, transactions[1].Metadata)
+}
+
+func TestContacts(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	apiSvc := api.NewAPI(svc, svc.logger, svc.db)
+
+	paymentRequest, err := decodepay.Decodepay(mockInvoice)
+	assert.NoError(t, err)
+	nodePubkey := paymentRequest.Payee
+	contact, err := apiSvc.CreateContact(&api.ContactRequest{Name: "Alice", NodePubkey: " " + nodePubkey})
+	assert.NoError(t, err)
+	assert.Equal(t, nodePubkey, contact.NodePubkey)
+	_, err = apiSvc.CreateContact(&api.ContactRequest{Name: "Bob", LightningAddress: "Lightning:Bob@Example.com"})
+	assert.NoError(t, err)
+
+	// the path of LNURLs written as URLs can be case-sensitive
+	contactWithUrl, err := apiSvc.CreateContact(&api.ContactRequest{Name: "Dave", Lnurl: "lightning:HTTPS://Example.com/lnurlp/Dave"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/lnurlp/Dave", contactWithUrl.Lnurl)
+	err = apiSvc.DeleteContact(contactWithUrl.ID)
+	assert.NoError(t, err)
+
+	_, err = apiSvc.CreateContact(&api.ContactRequest{Name: "alice", LightningAddress: "alice@example.com"})
+	assert.Error(t, err)
+	_, err = apiSvc.CreateContact(&api.ContactRequest{Name: "Carol"})
+	assert.Error(t, err)
+	_, err = apiSvc.CreateContact(&api.ContactRequest{Name: "Carol", NodePubkey: "not a pubkey"})
+	assert.Error(t, err)
+	_, err = apiSvc.CreateContact(&api.ContactRequest{Name: "Carol", Lnurl: "not an lnurl"})
+	assert.Error(t, err)
+
+	contacts, err := apiSvc.ListContacts()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(contacts))
+	assert.Equal(t, "bob@example.com", contacts[1].LightningAddress)
+
+	updatedContact, err := apiSvc.UpdateContact(contacts[1].ID, &api.ContactRequest{Name: "Bobby", LightningAddress: "bobby@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Bobby", updatedContact.Name)
+	_, err = apiSvc.UpdateContact(1000, &api.ContactRequest{Name: "Nobody", LightningAddress: "nobody@example.com"})
+	assert.ErrorIs(t, err, api.ErrContactNotFound)
+	err = apiSvc.DeleteContact(updatedContact.ID)
+	assert.NoError(t, err)
+	err = apiSvc.DeleteContact(updatedContact.ID)
+	assert.ErrorIs(t, err, api.ErrContactNotFound)
+
+	// contacts are paid by name
+	_, err = apiSvc.SendPayment(ctx, &api.SendPaymentRequest{Contact: "ALICE", Amount: 123000})
+	assert.NoError(t, err)
+	_, err = apiSvc.SendPayment(ctx, &api.SendPaymentRequest{Contact: "Bobby", Amount: 123000})
+	assert.ErrorIs(t, err, api.ErrContactNotFound)
+	payment := db.Payment{}
+	err = svc.db.First(&payment).Error
+	assert.NoError(t, err)
+	assert.Equal(t, nodePubkey, payment.Destination)
+
+	// and payments to their destinations are matched back to them
+	_, err = apiSvc.SendPayment(ctx, &api.SendPaymentRequest{Invoice: mockInvoice})
+	assert.NoError(t, err)
+	transactions, err := apiSvc.ListTransactions(ctx, &api.ListTransactionsRequest{Type: "outgoing"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(transactions))
+	assert.Equal(t, map[string]interface{}{"id": float64(contact.ID), "name": "Alice"}, transactions[0].Metadata.(map[string]interface{})["contact"])
+}
+
+func TestConnectionRequests(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	apiSvc := api.NewAPI(svc, svc.logger, svc.db)
+
+	publishedRelays := []string{}
+	publishedEvents := []nostr.Event{}
+	svc.publishToRelay = func(ctx context.Context, relayUrl string, event nostr.Event) error {
+		publishedRelays = append(publishedRelays, relayUrl)
+		publishedEvents = append(publishedEvents, event)
+		return nil
+	}
+
+	createConnectionRequestEvent := func(appPrivkey string, content string) *nostr.Event {
+		appPubkey, err := nostr.GetPublicKey(appPrivkey)
+		assert.NoError(t, err)
+		event := &nostr.Event{
+			PubKey:    appPubkey,
+			CreatedAt: nostr.Now(),
+			Kind:      nip47.CONNECTION_REQUEST_KIND,
+			Tags:      nostr.Tags{},
+			Content:   content,
+		}
+		err = event.Sign(appPrivkey)
+		assert.NoError(t, err)
+		return event
 	}
 
-	responses := []*nip47.Response{}
+	// the app asks for a connection through a deep link
+	appPrivkey := nostr.GeneratePrivateKey()
+	event := createConnectionRequestEvent(appPrivkey, `{"name": "Test App", "request_methods": ["pay_invoice", "get_balance"], "max_amount": 1000, "budget_renewal": "monthly", "relay": "wss://relay.example.com", "secret": "app_secret"}`)
+	eventJson, err := json.Marshal(event)
+	assert.NoError(t, err)
+	uri := fmt.Sprintf("nostr+walletauth://%s?event=%s", event.PubKey, url.QueryEscape(string(eventJson)))
+	connectionRequest, err := apiSvc.CreateConnectionRequest(&api.CreateConnectionRequestRequest{Uri: uri})
+	assert.NoError(t, err)
+	assert.Equal(t, db.CONNECTION_REQUEST_STATE_PENDING, connectionRequest.State)
+	assert.Equal(t, "pay_invoice get_balance", connectionRequest.RequestMethods)
 
-	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
-		responses = append(responses, response)
-	}
+	// the request must be signed by the app
+	tamperedEvent := *event
+	tamperedEvent.Content = strings.Replace(event.Content, "1000", "1000000", 1)
+	tamperedEventJson, err := json.Marshal(tamperedEvent)
+	assert.NoError(t, err)
+	_, err = apiSvc.CreateConnectionRequest(&api.CreateConnectionRequestRequest{Uri: fmt.Sprintf("nostr+walletauth://%s?event=%s", event.PubKey, url.QueryEscape(string(tamperedEventJson)))})
+	assert.Error(t, err)
+	_, err = apiSvc.CreateConnectionRequest(&api.CreateConnectionRequestRequest{Uri: fmt.Sprintf("nostr+walletauth://%s?event=%s", strings.Repeat("0", 64), url.QueryEscape(string(eventJson)))})
+	assert.Error(t, err)
 
-	svc.HandleLookupInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+	connectionRequests, err := apiSvc.ListConnectionRequests()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(connectionRequests))
 
-	assert.Equal(t, nip47.ERROR_RESTRICTED, responses[0].Error.Code)
+	// the owner lowers the budget before approving
+	maxAmount := 500
+	approvedConnectionRequest, err := apiSvc.ApproveConnectionRequest(ctx, connectionRequest.ID, &api.ApproveConnectionRequestRequest{MaxAmount: &maxAmount})
+	assert.NoError(t, err)
+	assert.Equal(t, db.CONNECTION_REQUEST_STATE_APPROVED, approvedConnectionRequest.State)
+	assert.NotNil(t, approvedConnectionRequest.RespondedAt)
+	_, err = apiSvc.ApproveConnectionRequest(ctx, connectionRequest.ID, &api.ApproveConnectionRequestRequest{})
+	assert.Error(t, err)
 
-	// with permission
-	expiresAt := time.Now().Add(24 * time.Hour)
-	appPermission := &db.AppPermission{
-		AppId:         app.ID,
-		App:           *app,
-		RequestMethod: nip47.LOOKUP_INVOICE_METHOD,
-		ExpiresAt:     &expiresAt,
-	}
-	err = svc.db.Create(appPermission).Error
+	app := db.App{}
+	err = svc.db.Where("nostr_pubkey = ?", event.PubKey).First(&app).Error
+	assert.NoError(t, err)
+	assert.Equal(t, "Test App", app.Name)
+	appPermission := db.AppPermission{}
+	err = svc.db.Where("app_id = ? AND request_method = ?", app.ID, nip47.PAY_INVOICE_METHOD).First(&appPermission).Error
 	assert.NoError(t, err)
+	assert.Equal(t, 500, appPermission.MaxAmount)
+	assert.Equal(t, "monthly", appPermission.BudgetRenewal)
 
-	reqEvent.ID = "test_lookup_invoice_with_permission"
-	requestEvent.NostrId = reqEvent.ID
-	responses = []*nip47.Response{}
-	svc.HandleLookupInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+	// the app is answered on its relay, only it can read the answer
+	assert.Equal(t, []string{"wss://relay.example.com"}, publishedRelays)
+	assert.Equal(t, nip47.CONNECTION_RESPONSE_KIND, publishedEvents[0].Kind)
+	assert.Equal(t, event.PubKey, publishedEvents[0].Tags.GetFirst([]string{"d"}).Value())
+	ss, err := nip04.ComputeSharedSecret(svc.cfg.GetNostrPublicKey(), appPrivkey)
+	assert.NoError(t, err)
+	decrypted, err := nip04.Decrypt(publishedEvents[0].Content, ss)
+	assert.NoError(t, err)
+	connectionResponse := nip47.ConnectionResponse{}
+	err = json.Unmarshal([]byte(decrypted), &connectionResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, "app_secret", connectionResponse.Secret)
+	assert.Equal(t, svc.cfg.GetNostrPublicKey(), connectionResponse.Pubkey)
+	assert.Contains(t, connectionResponse.RequestMethods, nip47.GET_BALANCE_METHOD)
+	assert.Nil(t, connectionResponse.Error)
 
-	transaction := responses[0].Result.(*nip47.LookupInvoiceResponse)
-	assert.Equal(t, mockTransaction.Type, transaction.Type)
-	assert.Equal(t, mockTransaction.Invoice, transaction.Invoice)
-	assert.Equal(t, mockTransaction.Description, transaction.Description)
-	assert.Equal(t, mockTransaction.DescriptionHash, transaction.DescriptionHash)
-	assert.Equal(t, mockTransaction.Preimage, transaction.Preimage)
-	assert.Equal(t, mockTransaction.PaymentHash, transaction.PaymentHash)
-	assert.Equal(t, mockTransaction.Amount, transaction.Amount)
-	assert.Equal(t, mockTransaction.FeesPaid, transaction.FeesPaid)
-	assert.Equal(t, mockTransaction.SettledAt, transaction.SettledAt)
+	// a connected app cannot ask again
+	_, err = svc.AddConnectionRequest(createConnectionRequestEvent(appPrivkey, event.Content))
+	assert.Error(t, err)
+
+	// requests published on the hub's relay can be rejected
+	otherAppPrivkey := nostr.GeneratePrivateKey()
+	svc.HandleConnectionRequestEvent(createConnectionRequestEvent(otherAppPrivkey, `{"name": "Other App", "request_methods": ["get_info"], "relay": "wss://other.example.com", "secret": "other_secret"}`))
+	connectionRequests, err = apiSvc.ListConnectionRequests()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(connectionRequests))
+	assert.Equal(t, "Other App", connectionRequests[0].Name)
+	err = apiSvc.RejectConnectionRequest(ctx, connectionRequests[0].ID)
+	assert.NoError(t, err)
+	ss, err = nip04.ComputeSharedSecret(svc.cfg.GetNostrPublicKey(), otherAppPrivkey)
+	assert.NoError(t, err)
+	decrypted, err = nip04.Decrypt(publishedEvents[1].Content, ss)
+	assert.NoError(t, err)
+	connectionResponse = nip47.ConnectionResponse{}
+	err = json.Unmarshal([]byte(decrypted), &connectionResponse)
+	assert.NoError(t, err)
+	assert.Equal(t, "other_secret", connectionResponse.Secret)
+	assert.Equal(t, nip47.ERROR_RESTRICTED, connectionResponse.Error.Code)
+	assert.Empty(t, connectionResponse.Pubkey)
+
+	var appCount int64
+	err = svc.db.Model(&db.App{}).Count(&appCount).Error
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), appCount)
+
+	// fields are bounded
+	_, err = svc.AddConnectionRequest(createConnectionRequestEvent(nostr.GeneratePrivateKey(), fmt.Sprintf(`{"name": "%s", "request_methods": ["get_info"], "relay": "wss://relay.example.com", "secret": "secret"}`, strings.Repeat("a", 101))))
+	assert.Error(t, err)
+
+	// requests which are not answered in time expire
+	expiringConnectionRequest, err := svc.AddConnectionRequest(createConnectionRequestEvent(nostr.GeneratePrivateKey(), `{"name": "Expiring App", "request_methods": ["get_info"], "relay": "wss://relay.example.com", "secret": "secret"}`))
+	assert.NoError(t, err)
+	err = svc.db.Model(expiringConnectionRequest).Update("created_at", time.Now().Add(-db.CONNECTION_REQUEST_EXPIRY-time.Minute)).Error
+	assert.NoError(t, err)
+	connectionRequests, err = apiSvc.ListConnectionRequests()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(connectionRequests))
+	_, err = apiSvc.ApproveConnectionRequest(ctx, expiringConnectionRequest.ID, &api.ApproveConnectionRequestRequest{})
+	assert.ErrorIs(t, err, api.ErrConnectionRequestExpired)
+
+	// only a limited number of requests wait for the owner, the oldest make room for new ones
+	spamConnectionRequests := []*db.ConnectionRequest{}
+	for i := 0; i < maxPendingConnectionRequests+2; i++ {
+		spamConnectionRequest, err := svc.AddConnectionRequest(createConnectionRequestEvent(nostr.GeneratePrivateKey(), `{"name": "Spam App", "request_methods": ["get_info"], "relay": "wss://relay.example.com", "secret": "secret"}`))
+		assert.NoError(t, err)
+		spamConnectionRequests = append(spamConnectionRequests, spamConnectionRequest)
+	}
+	// the expired request was removed when adding new ones
+	err = svc.db.Where("nostr_id = ?", expiringConnectionRequest.NostrId).First(&db.ConnectionRequest{}).Error
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	newConnectionRequest, err := svc.AddConnectionRequest(createConnectionRequestEvent(nostr.GeneratePrivateKey(), `{"name": "New App", "request_methods": ["get_info"], "relay": "wss://relay.example.com", "secret": "secret"}`))
+	assert.NoError(t, err)
+	connectionRequests, err = apiSvc.ListConnectionRequests()
+	assert.NoError(t, err)
+	assert.Equal(t, maxPendingConnectionRequests, len(connectionRequests))
+	for _, evictedConnectionRequest := range spamConnectionRequests[:3] {
+		err = svc.db.Where("nostr_id = ?", evictedConnectionRequest.NostrId).First(&db.ConnectionRequest{}).Error
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	}
+	err = svc.db.Where("nostr_id = ?", spamConnectionRequests[3].NostrId).First(&db.ConnectionRequest{}).Error
+	assert.NoError(t, err)
+	err = svc.db.Where("nostr_id = ?", newConnectionRequest.NostrId).First(&db.ConnectionRequest{}).Error
+	assert.NoError(t, err)
 }
 
-func TestHandleMakeInvoiceEvent(t *testing.T) {
+func TestOwnerRequests(t *testing.T) {
 	ctx := context.TODO()
 	defer os.Remove(testDB)
 	mockLn, err := NewMockLn()
 	assert.NoError(t, err)
 	svc, err := createTestService(mockLn)
 	assert.NoError(t, err)
-	app, ss, err := createApp(svc)
+	_, _, err = createApp(svc)
 	assert.NoError(t, err)
+	apiSvc := api.NewAPI(svc, svc.logger, svc.db)
 
-	request := &nip47.Request{}
-	err = json.Unmarshal([]byte(nip47MakeInvoiceJson), request)
+	invoice, err := apiSvc.MakeInvoice(ctx, &api.MakeInvoiceRequest{Amount: 1000, Description: "Hello"})
 	assert.NoError(t, err)
+	assert.Equal(t, mockTransaction.Invoice, invoice.Invoice)
 
-	// without permission
-	payload, err := nip04.Encrypt(nip47MakeInvoiceJson, ss)
+	payResponse, err := apiSvc.SendPayment(ctx, &api.SendPaymentRequest{Invoice: mockInvoice})
 	assert.NoError(t, err)
-	reqEvent := &nostr.Event{
-		Kind:    nip47.REQUEST_KIND,
-		PubKey:  app.NostrPubkey,
-		Content: payload,
-	}
+	assert.Equal(t, "123preimage", payResponse.Preimage)
 
-	reqEvent.ID = "test_make_invoice_without_permission"
-	requestEvent := &db.RequestEvent{
-		NostrId: reqEvent.ID,
-	}
+	_, err = apiSvc.SendPayment(ctx, &api.SendPaymentRequest{})
+	assert.Error(t, err)
 
-	responses := []*nip47.Response{}
+	// recorded under the owner app, which is not listed as a connection
+	ownerApp := db.App{}
+	err = svc.db.Where("nostr_pubkey = ?", db.OWNER_APP_NOSTR_PUBKEY).First(&ownerApp).Error
+	assert.NoError(t, err)
+	payment := db.Payment{}
+	err = svc.db.Where("app_id = ?", ownerApp.ID).First(&payment).Error
+	assert.NoError(t, err)
+	assert.Equal(t, db.PAYMENT_STATE_SUCCEEDED, payment.State)
+	apps, err := apiSvc.ListApps()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(apps))
 
-	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
-		responses = append(responses, response)
-	}
+	transactions, err := apiSvc.ListTransactions(ctx, &api.ListTransactionsRequest{Type: "outgoing"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(transactions))
+	assert.Equal(t, mockPaymentHash, transactions[0].PaymentHash)
 
-	svc.HandleMakeInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+	lookedUpInvoice, err := apiSvc.LookupInvoice(ctx, mockPaymentHash)
+	assert.NoError(t, err)
+	assert.Equal(t, "outgoing", lookedUpInvoice.Type)
+}
 
-	assert.Equal(t, nip47.ERROR_RESTRICTED, responses[0].Error.Code)
+func TestHandleEstimateFeeEvent(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
 
-	// with permission
-	expiresAt := time.Now().Add(24 * time.Hour)
-	appPermission := &db.AppPermission{
-		AppId:         app.ID,
-		App:           *app,
-		RequestMethod: nip47.MAKE_INVOICE_METHOD,
-		ExpiresAt:     &expiresAt,
+	estimateFee := func(params string) *nip47.Response {
+		request := &nip47.Request{}
+		err := json.Unmarshal([]byte(fmt.Sprintf(`{"method": "estimate_fee", "params": %s}`, params)), request)
+		assert.NoError(t, err)
+
+		responses := []*nip47.Response{}
+		svc.HandleEstimateFeeEvent(ctx, request, &db.RequestEvent{NostrId: "estimate_fee"}, app, func(response *nip47.Response, tags nostr.Tags) {
+			responses = append(responses, response)
+		})
+		assert.Equal(t, 1, len(responses))
+		return responses[0]
 	}
-	err = svc.db.Create(appPermission).Error
-	assert.NoError(t, err)
+	invoiceParams := fmt.Sprintf(`{"invoice": "%s"}`, mockInvoice)
 
-	reqEvent.ID = "test_make_invoice_with_permission"
-	requestEvent.NostrId = reqEvent.ID
-	responses = []*nip47.Response{}
-	svc.HandleMakeInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+	// without permission
+	response := estimateFee(invoiceParams)
+	assert.Equal(t, nip47.ERROR_RESTRICTED, response.Error.Code)
 
-	assert.Equal(t, mockTransaction.Preimage, responses[0].Result.(*nip47.MakeInvoiceResponse).Preimage)
+	// apps which can pay may estimate fees
+	err = svc.db.Create(&db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.PAY_INVOICE_METHOD,
+	}).Error
+	assert.NoError(t, err)
+	assert.Contains(t, svc.GetMethods(app), nip47.ESTIMATE_FEE_METHOD)
+
+	response = estimateFee(invoiceParams)
+	assert.Nil(t, response.Error)
+	estimate := response.Result.(nip47.EstimateFeeResponse)
+	assert.Equal(t, uint64(mockRoutingFeeMsat), *estimate.Fee)
+	assert.Equal(t, 0.9, estimate.SuccessProbability)
+
+	// the estimate is cached
+	response = estimateFee(invoiceParams)
+	assert.Nil(t, response.Error)
+	assert.Equal(t, 1, mockLn.estimateFeeCalls)
+
+	response = estimateFee(`{"pubkey": "123pubkey", "amount": 1000}`)
+	assert.Nil(t, response.Error)
+	assert.Equal(t, 2, mockLn.estimateFeeCalls)
+
+	// neither invoice nor destination
+	response = estimateFee(`{"amount": 1000}`)
+	assert.Equal(t, nip47.ERROR_BAD_REQUEST, response.Error.Code)
+
+	// failed estimates are not cached
+	unreachableParams := fmt.Sprintf(`{"pubkey": "%s", "amount": 1000}`, mockUnreachableDestination)
+	response = estimateFee(unreachableParams)
+	assert.Equal(t, nip47.ERROR_INTERNAL, response.Error.Code)
+	response = estimateFee(unreachableParams)
+	assert.Equal(t, nip47.ERROR_INTERNAL, response.Error.Code)
+	assert.Equal(t, 4, mockLn.estimateFeeCalls)
 }
 
-func TestHandleListTransactionsEvent(t *testing.T) {
+func TestEstimateFeeDoesNotWaitForOtherPayments(t *testing.T) {
 	ctx := context.TODO()
 	defer os.Remove(testDB)
 	mockLn, err := NewMockLn()
 	assert.NoError(t, err)
 	svc, err := createTestService(mockLn)
 	assert.NoError(t, err)
-	app, ss, err := createApp(svc)
-	assert.NoError(t, err)
 
-	request := &nip47.Request{}
-	err = json.Unmarshal([]byte(nip47ListTransactionsJson), request)
-	assert.NoError(t, err)
+	mockLn.estimateFeeRelease = make(chan struct{})
+	slowEstimateDone := make(chan error)
+	go func() {
+		_, err := svc.EstimateFee(ctx, &lnclient.EstimateFeeRequest{Destination: mockSlowEstimateDestination, AmountMsat: 1000})
+		slowEstimateDone <- err
+	}()
 
-	// without permission
-	payload, err := nip04.Encrypt(nip47ListTransactionsJson, ss)
+	estimate, err := svc.EstimateFee(ctx, &lnclient.EstimateFeeRequest{Invoice: mockInvoice})
 	assert.NoError(t, err)
-	reqEvent := &nostr.Event{
-		Kind:    nip47.REQUEST_KIND,
-		PubKey:  app.NostrPubkey,
-		Content: payload,
-	}
-
-	reqEvent.ID = "test_list_transactions_without_permission"
-	requestEvent := &db.RequestEvent{
-		NostrId: reqEvent.ID,
-	}
+	assert.Equal(t, uint64(mockRoutingFeeMsat), *estimate.FeeMsat)
 
-	responses := []*nip47.Response{}
+	close(mockLn.estimateFeeRelease)
+	assert.NoError(t, <-slowEstimateDone)
+	assert.Equal(t, 2, mockLn.estimateFeeCalls)
+}
 
-	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
-		responses = append(responses, response)
+func TestNextSubscriptionRun(t *testing.T) {
+	// a wednesday
+	from := time.Date(2024, time.June, 12, 15, 30, 0, 0, time.UTC)
+	for interval, expected := range map[string]time.Time{
+		"@hourly":     time.Date(2024, time.June, 12, 16, 0, 0, 0, time.UTC),
+		"@daily":      time.Date(2024, time.June, 13, 0, 0, 0, 0, time.UTC),
+		"@weekly":     time.Date(2024, time.June, 16, 0, 0, 0, 0, time.UTC),
+		"@monthly":    time.Date(2024, time.July, 1, 0, 0, 0, 0, time.UTC),
+		"@yearly":     time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+		"@every 720h": from.Add(720 * time.Hour),
+	} {
+		next, err := nextSubscriptionRun(interval, from)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, next, interval)
+	}
+
+	for _, interval := range []string{"", "0 0 * * *", "@every 1s", "@every month"} {
+		_, err := nextSubscriptionRun(interval, from)
+		assert.Error(t, err, interval)
 	}
+}
 
-	svc.HandleListTransactionsEvent(ctx, request, requestEvent, app, publishResponse)
+func TestHandleSubscriptionEvents(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
 
-	assert.Equal(t, nip47.ERROR_RESTRICTED, responses[0].Error.Code)
+	createRequestJson := `{"method": "create_subscription", "params": {"pubkey": "123pubkey", "amount": 100000, "interval": "@monthly"}}`
+
+	// without permission
+	response := handleRequest(t, svc, app, "create_subscription_without_permission", createRequestJson, svc.HandleCreateSubscriptionEvent)
+	assert.Equal(t, nip47.ERROR_RESTRICTED, response.Error.Code)
 
-	// with permission
-	expiresAt := time.Now().Add(24 * time.Hour)
 	appPermission := &db.AppPermission{
 		AppId:         app.ID,
 		App:           *app,
-		RequestMethod: nip47.LIST_TRANSACTIONS_METHOD,
-		ExpiresAt:     &expiresAt,
+		RequestMethod: nip47.PAY_INVOICE_METHOD,
+		MaxAmount:     150,
+		BudgetRenewal: "never",
 	}
 	err = svc.db.Create(appPermission).Error
 	assert.NoError(t, err)
 
-	reqEvent.ID = "test_list_transactions_with_permission"
-	requestEvent.NostrId = reqEvent.ID
-	responses = []*nip47.Response{}
-	svc.HandleListTransactionsEvent(ctx, request, requestEvent, app, publishResponse)
+	response = handleRequest(t, svc, app, "create_subscription_bad_interval", `{"method": "create_subscription", "params": {"pubkey": "123pubkey", "amount": 100000, "interval": "0 0 1 * *"}}`, svc.HandleCreateSubscriptionEvent)
+	assert.Equal(t, nip47.ERROR_BAD_REQUEST, response.Error.Code)
 
-	assert.Equal(t, 2, len(responses[0].Result.(*nip47.ListTransactionsResponse).Transactions))
-	transaction := responses[0].Result.(*nip47.ListTransactionsResponse).Transactions[0]
-	assert.Equal(t, mockTransactions[0].Type, transaction.Type)
-	assert.Equal(t, mockTransactions[0].Invoice, transaction.Invoice)
-	assert.Equal(t, mockTransactions[0].Description, transaction.Description)
-	assert.Equal(t, mockTransactions[0].DescriptionHash, transaction.DescriptionHash)
-	assert.Equal(t, mockTransactions[0].Preimage, transaction.Preimage)
-	assert.Equal(t, mockTransactions[0].PaymentHash, transaction.PaymentHash)
-	assert.Equal(t, mockTransactions[0].Amount, transaction.Amount)
-	assert.Equal(t, mockTransactions[0].FeesPaid, transaction.FeesPaid)
-	assert.Equal(t, mockTransactions[0].SettledAt, transaction.SettledAt)
+	response = handleRequest(t, svc, app, "create_subscription", createRequestJson, svc.HandleCreateSubscriptionEvent)
+	assert.Nil(t, response.Error)
+	subscription := response.Result.(*nip47.CreateSubscriptionResponse).Subscription
+	assert.Equal(t, int64(100000), subscription.Amount)
+	assert.Greater(t, subscription.NextRunAt, time.Now().Unix())
+
+	response = handleRequest(t, svc, app, "list_subscriptions", `{"method": "list_subscriptions", "params": {}}`, svc.HandleListSubscriptionsEvent)
+	assert.Equal(t, 1, len(response.Result.(*nip47.ListSubscriptionsResponse).Subscriptions))
+
+	// the subscription is due
+	err = svc.db.Model(&db.Subscription{}).Where("id = ?", subscription.Id).Update("next_run_at", time.Now().Add(-time.Minute)).Error
+	assert.NoError(t, err)
+	svc.runDueSubscriptions(ctx)
+
+	payment := db.Payment{}
+	err = svc.db.Where("subscription_id = ?", subscription.Id).Last(&payment).Error
+	assert.NoError(t, err)
+	assert.Equal(t, db.PAYMENT_STATE_SUCCEEDED, payment.State)
+	assert.Equal(t, uint64(100000), payment.AmountMsat)
+	assert.NotEmpty(t, payment.PaymentHash)
+	dbSubscription := db.Subscription{}
+	err = svc.db.First(&dbSubscription, subscription.Id).Error
+	assert.NoError(t, err)
+	assert.NotNil(t, dbSubscription.LastRunAt)
+	assert.True(t, dbSubscription.NextRunAt.After(time.Now()))
+
+	// not due again before the next run
+	svc.runDueSubscriptions(ctx)
+	var paymentCount int64
+	err = svc.db.Model(&db.Payment{}).Where("subscription_id = ?", subscription.Id).Count(&paymentCount).Error
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), paymentCount)
+
+	// the second run exceeds the budget
+	err = svc.db.Model(&db.Subscription{}).Where("id = ?", subscription.Id).Update("next_run_at", time.Now().Add(-time.Minute)).Error
+	assert.NoError(t, err)
+	svc.runDueSubscriptions(ctx)
+	payment = db.Payment{}
+	err = svc.db.Where("subscription_id = ?", subscription.Id).Last(&payment).Error
+	assert.NoError(t, err)
+	assert.Equal(t, db.PAYMENT_STATE_FAILED, payment.State)
+	assert.Equal(t, "Insufficient budget remaining to make payment", payment.FailureReason)
+
+	// apps can only cancel their own subscriptions
+	otherApp, _, err := createApp(svc)
+	assert.NoError(t, err)
+	otherSubscription := &db.Subscription{AppId: otherApp.ID, RequestEventId: 1, Pubkey: "123pubkey", Amount: 1000, Interval: "@daily"}
+	err = svc.db.Create(otherSubscription).Error
+	assert.NoError(t, err)
+	response = handleRequest(t, svc, app, "cancel_other_subscription", fmt.Sprintf(`{"method": "cancel_subscription", "params": {"id": %d}}`, otherSubscription.ID), svc.HandleCancelSubscriptionEvent)
+	assert.Equal(t, nip47.ERROR_BAD_REQUEST, response.Error.Code)
+
+	response = handleRequest(t, svc, app, "cancel_subscription", fmt.Sprintf(`{"method": "cancel_subscription", "params": {"id": %d}}`, subscription.Id), svc.HandleCancelSubscriptionEvent)
+	assert.Nil(t, response.Error)
+	response = handleRequest(t, svc, app, "list_subscriptions_after_cancel", `{"method": "list_subscriptions", "params": {}}`, svc.HandleListSubscriptionsEvent)
+	assert.Equal(t, 0, len(response.Result.(*nip47.ListSubscriptionsResponse).Subscriptions))
+}
+
+func TestLightningAddressSubscriptions(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+	err = svc.db.Create(&db.AppPermission{AppId: app.ID, App: *app, RequestMethod: nip47.PAY_INVOICE_METHOD}).Error
+	assert.NoError(t, err)
+
+	server := newMockLnurlServer(t)
+	defer server.Close()
+	svc.lnurlClient = lnurl.NewClient(server.Client())
+	host := server.Listener.Addr().String()
+
+	// due subscriptions are paid together, the mock server returns the same invoice for both
+	requestEvent := &db.RequestEvent{NostrId: "create_lightning_address_subscriptions"}
+	err = svc.db.Create(requestEvent).Error
+	assert.NoError(t, err)
+	for _, amount := range []int64{21000, 42000} {
+		err = svc.db.Create(&db.Subscription{AppId: app.ID, RequestEventId: requestEvent.ID, LightningAddress: "alice@" + host, Amount: amount, Interval: "@daily", NextRunAt: time.Now().Add(-time.Minute)}).Error
+		assert.NoError(t, err)
+	}
+	svc.runDueSubscriptions(ctx)
+
+	// the invoice is paid only once
+	payments := []db.Payment{}
+	err = svc.db.Where("subscription_id IS NOT NULL").Find(&payments).Error
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(payments))
+	if payments[0].State == db.PAYMENT_STATE_FAILED {
+		payments[0], payments[1] = payments[1], payments[0]
+	}
+	assert.Equal(t, db.PAYMENT_STATE_SUCCEEDED, payments[0].State)
+	assert.NotEmpty(t, payments[0].PaymentHash)
+	assert.NotEmpty(t, payments[0].PaymentRequest)
+	assert.Equal(t, db.PAYMENT_STATE_FAILED, payments[1].State)
+	assert.Equal(t, "invoice is already paid or being paid", payments[1].FailureReason)
+	// the rejected payment does not take over the ledger entry of the invoice
+	assert.Empty(t, payments[1].PaymentHash)
 }
 
 func TestHandleGetInfoEvent(t *testing.T) {
@@ -1218,6 +3340,7 @@ func createTestService(ln *MockLn) (svc *Service, err error) {
 		lnClient:       ln,
 		logger:         logger,
 		eventPublisher: events.NewEventPublisher(logger),
+		lnurlClient:    lnurl.NewClient(http.DefaultClient),
 	}, nil
 }
 
@@ -1242,21 +3365,82 @@ func createApp(svc *Service) (app *db.App, ss []byte, err error) {
 	return app, ss, nil
 }
 
+// handleRequest stores the request event and returns the only response the handler published for it
+func handleRequest(t *testing.T, svc *Service, app *db.App, id string, requestJson string, handler func(context.Context, *nip47.Request, *db.RequestEvent, *db.App, func(*nip47.Response, nostr.Tags))) *nip47.Response {
+	request := &nip47.Request{}
+	err := json.Unmarshal([]byte(requestJson), request)
+	assert.NoError(t, err)
+	requestEvent := &db.RequestEvent{NostrId: id}
+	err = svc.db.Create(requestEvent).Error
+	assert.NoError(t, err)
+
+	responses := []*nip47.Response{}
+	handler(context.TODO(), request, requestEvent, app, func(response *nip47.Response, tags nostr.Tags) {
+		responses = append(responses, response)
+	})
+	assert.Equal(t, 1, len(responses))
+	return responses[0]
+}
+
+// eventCollector hands the published events to the test
+type eventCollector struct {
+	events chan *events.Event
+}
+
+func (collector *eventCollector) ConsumeEvent(ctx context.Context, event *events.Event, globalProperties map[string]interface{}) error {
+	collector.events <- event
+	return nil
+}
+
 type MockLn struct {
+	estimateFeeCalls int
+	// estimates for mockSlowEstimateDestination wait until this is closed
+	estimateFeeRelease chan struct{}
+	// overrides the node pubkey, e.g. to make the mock the payee of an invoice
+	pubkey string
+	// overrides the invoice returned by LookupInvoice
+	invoice *nip47.Transaction
+	// overrides the optional features the mock supports
+	features []string
+	// payment hashes of the invoices cancelled on the mock node
+	cancelledPaymentHashes []string
+	// payment hashes of the hold invoices subscribed to after a restart
+	subscribedHoldInvoiceHashes []string
+	// overrides the transactions returned by ListTransactions, newest first
+	transactions []nip47.Transaction
+	// outgoing payments returned by LookupPayment by payment hash, without them LookupPayment is not implemented
+	payments map[string]*nip47.Transaction
 }
 
 func NewMockLn() (*MockLn, error) {
 	return &MockLn{}, nil
 }
 
-func (mln *MockLn) SendPaymentSync(ctx context.Context, payReq string) (*lnclient.PayInvoiceResponse, error) {
+// routing fee the mock needs for every payment
+const mockRoutingFeeMsat = 1000
+const mockSlowEstimateDestination = "slowpubkey"
+const mockUnreachableDestination = "unreachablepubkey"
+
+func (mln *MockLn) SendPaymentSync(ctx context.Context, payReq string, options lnclient.PaymentOptions) (*lnclient.PayInvoiceResponse, error) {
+	if options.MaxFeeMsat != nil && *options.MaxFeeMsat < mockRoutingFeeMsat {
+		return nil, lnclient.ErrMaxFeeExceeded
+	}
+	fee := uint64(mockRoutingFeeMsat)
 	return &lnclient.PayInvoiceResponse{
 		Preimage: "123preimage",
+		Fee:      &fee,
 	}, nil
 }
 
-func (mln *MockLn) SendKeysend(ctx context.Context, amount int64, destination, preimage string, custom_records []lnclient.TLVRecord) (preImage string, err error) {
-	return "12345preimage", nil
+func (mln *MockLn) SendKeysend(ctx context.Context, amount int64, destination, preimage string, custom_records []lnclient.TLVRecord, options lnclient.PaymentOptions) (*lnclient.PayInvoiceResponse, error) {
+	if options.MaxFeeMsat != nil && *options.MaxFeeMsat < mockRoutingFeeMsat {
+		return nil, lnclient.ErrMaxFeeExceeded
+	}
+	fee := uint64(mockRoutingFeeMsat)
+	return &lnclient.PayInvoiceResponse{
+		Preimage: "12345preimage",
+		Fee:      &fee,
+	}, nil
 }
 
 func (mln *MockLn) GetBalance(ctx context.Context) (balance int64, err error) {
@@ -1264,6 +3448,11 @@ func (mln *MockLn) GetBalance(ctx context.Context) (balance int64, err error) {
 }
 
 func (mln *MockLn) GetInfo(ctx context.Context) (info *lnclient.NodeInfo, err error) {
+	if mln.pubkey != "" {
+		nodeInfo := mockNodeInfo
+		nodeInfo.Pubkey = mln.pubkey
+		return &nodeInfo, nil
+	}
 	return &mockNodeInfo, nil
 }
 
@@ -1271,19 +3460,62 @@ func (mln *MockLn) MakeInvoice(ctx context.Context, amount int64, description st
 	return mockTransaction, nil
 }
 
+func (mln *MockLn) MakeHoldInvoice(ctx context.Context, amount int64, description string, descriptionHash string, expiry int64, paymentHash string) (transaction *nip47.Transaction, err error) {
+	return mockTransaction, nil
+}
+
+func (mln *MockLn) SettleHoldInvoice(ctx context.Context, preimage string) (err error) {
+	return nil
+}
+
+func (mln *MockLn) CancelHoldInvoice(ctx context.Context, paymentHash string) (err error) {
+	mln.cancelledPaymentHashes = append(mln.cancelledPaymentHashes, paymentHash)
+	return nil
+}
+
+func (mln *MockLn) SubscribeHoldInvoice(ctx context.Context, paymentHash string) (err error) {
+	mln.subscribedHoldInvoiceHashes = append(mln.subscribedHoldInvoiceHashes, paymentHash)
+	return nil
+}
+
 func (mln *MockLn) LookupInvoice(ctx context.Context, paymentHash string) (transaction *nip47.Transaction, err error) {
+	if mln.invoice != nil {
+		return mln.invoice, nil
+	}
 	return mockTransaction, nil
 }
 
+func (mln *MockLn) LookupPayment(ctx context.Context, paymentHash string) (transaction *nip47.Transaction, err error) {
+	if mln.payments == nil {
+		return nil, lnclient.ErrNotImplemented
+	}
+	payment, ok := mln.payments[paymentHash]
+	if !ok {
+		return nil, lnclient.ErrPaymentNotFound
+	}
+	return payment, nil
+}
+
 func (mln *MockLn) ListTransactions(ctx context.Context, from, until, limit, offset uint64, unpaid bool, invoiceType string) (invoices []nip47.Transaction, err error) {
-	return mockTransactions, nil
+	transactions := mockTransactions
+	if mln.transactions != nil {
+		transactions = mln.transactions
+	}
+	if offset >= uint64(len(transactions)) {
+		return []nip47.Transaction{}, nil
+	}
+	transactions = transactions[offset:]
+	if limit > 0 && uint64(len(transactions)) > limit {
+		transactions = transactions[:limit]
+	}
+	return transactions, nil
 }
 func (mln *MockLn) Shutdown() error {
 	return nil
 }
 
 func (mln *MockLn) ListChannels(ctx context.Context) (channels []lnclient.Channel, err error) {
-	return []lnclient.Channel{}, nil
+	return mockChannels, nil
 }
 func (mln *MockLn) GetNodeConnectionInfo(ctx context.Context) (nodeConnectionInfo *lnclient.NodeConnectionInfo, err error) {
 	return nil, nil
@@ -1318,6 +3550,20 @@ func (mln *MockLn) SendPaymentProbes(ctx context.Context, invoice string) error
 func (mln *MockLn) SendSpontaneousPaymentProbes(ctx context.Context, amountMsat uint64, nodeId string) error {
 	return nil
 }
+func (mln *MockLn) EstimateFee(ctx context.Context, request *lnclient.EstimateFeeRequest) (*lnclient.FeeEstimate, error) {
+	if request.Destination == mockSlowEstimateDestination {
+		<-mln.estimateFeeRelease
+	}
+	mln.estimateFeeCalls++
+	if request.Destination == mockUnreachableDestination {
+		return nil, errors.New("no route found")
+	}
+	fee := uint64(mockRoutingFeeMsat)
+	return &lnclient.FeeEstimate{
+		FeeMsat:            &fee,
+		SuccessProbability: 0.9,
+	}, nil
+}
 func (mln *MockLn) ListPeers(ctx context.Context) ([]lnclient.PeerDetails, error) {
 	return nil, nil
 }
@@ -1337,6 +3583,12 @@ func (mln *MockLn) GetNetworkGraph(nodeIds []string) (lnclient.NetworkGraphRespo
 	return nil, nil
 }
 func (mln *MockLn) UpdateLastWalletSyncRequest() {}
+func (mln *MockLn) GetSupportedFeatures() []string {
+	if mln.features != nil {
+		return mln.features
+	}
+	return []string{lnclient.FeatureHoldInvoices, lnclient.FeatureDescriptionHash, lnclient.FeatureMaxFee, lnclient.FeaturePaymentReceivedEvents}
+}
 func (mln *MockLn) DisconnectPeer(ctx context.Context, peerId string) error {
 	return nil
 }
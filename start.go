@@ -120,7 +120,11 @@ func (svc *Service) StartApp(encryptionKey string) error {
 		return err
 	}
 
+	svc.startPendingPaymentsResolution(ctx)
+	svc.resubscribeHoldInvoices(ctx)
 	svc.StartNostr(ctx, encryptionKey)
+	svc.startSubscriptionPayments(ctx)
+	svc.startTransactionsReconciliation(ctx)
 	svc.appCancelFn = cancelFn
 	return nil
 }
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/lnclient"
+	"github.com/getAlby/nostr-wallet-connect/lnurl"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// how often the hub checks for subscriptions which are due, also the shortest supported interval
+	subscriptionPaymentsInterval = time.Minute
+	// how long a single subscription payment may take before it is left to be resolved later
+	subscriptionPaymentTimeout = 2 * time.Minute
+	// how many subscriptions are paid at the same time
+	maxConcurrentSubscriptionPayments = 5
+)
+
+// nextSubscriptionRun returns the first time after from matching the interval.
+// Only the cron descriptors are supported: @hourly, @daily, @weekly, @monthly, @yearly and @every <duration>.
+func nextSubscriptionRun(interval string, from time.Time) (time.Time, error) {
+	from = from.UTC()
+	switch interval {
+	case "@hourly":
+		return from.Truncate(time.Hour).Add(time.Hour), nil
+	case "@daily":
+		return time.Date(from.Year(), from.Month(), from.Day()+1, 0, 0, 0, 0, time.UTC), nil
+	case "@weekly":
+		// weeks start on sunday, like in cron
+		return time.Date(from.Year(), from.Month(), from.Day()+7-int(from.Weekday()), 0, 0, 0, 0, time.UTC), nil
+	case "@monthly":
+		return time.Date(from.Year(), from.Month()+1, 1, 0, 0, 0, 0, time.UTC), nil
+	case "@yearly":
+		return time.Date(from.Year()+1, time.January, 1, 0, 0, 0, 0, time.UTC), nil
+	}
+
+	if every, ok := strings.CutPrefix(interval, "@every "); ok {
+		duration, err := time.ParseDuration(every)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid interval duration: %w", err)
+		}
+		if duration < subscriptionPaymentsInterval {
+			return time.Time{}, fmt.Errorf("interval must be at least %s", subscriptionPaymentsInterval)
+		}
+		return from.Add(duration), nil
+	}
+
+	return time.Time{}, fmt.Errorf("unsupported interval: %s", interval)
+}
+
+// startSubscriptionPayments pays due subscriptions until ctx is cancelled
+func (svc *Service) startSubscriptionPayments(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(subscriptionPaymentsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				svc.runDueSubscriptions(ctx)
+			}
+		}
+	}()
+}
+
+func (svc *Service) runDueSubscriptions(ctx context.Context) {
+	if svc.lnClient == nil {
+		return
+	}
+
+	subscriptions := []db.Subscription{}
+	err := svc.db.Preload("App").Where("cancelled_at IS NULL AND next_run_at <= ?", time.Now()).Find(&subscriptions).Error
+	if err != nil {
+		svc.logger.WithError(err).Error("Failed to find due subscriptions")
+		return
+	}
+
+	// the next run waits for this one, so a subscription is never picked up again before its next run is saved
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, maxConcurrentSubscriptionPayments)
+	for i := range subscriptions {
+		wg.Add(1)
+		go func(subscription *db.Subscription) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			ctx, cancel := context.WithTimeout(ctx, subscriptionPaymentTimeout)
+			defer cancel()
+			svc.runSubscription(ctx, subscription)
+		}(&subscriptions[i])
+	}
+	wg.Wait()
+}
+
+// runSubscription makes a single payment of the subscription and notifies the app of its outcome
+func (svc *Service) runSubscription(ctx context.Context, subscription *db.Subscription) {
+	// the next run is scheduled first, a failed payment is not retried before it.
+	// Runs missed while the hub was offline are skipped rather than paid at once.
+	now := time.Now()
+	nextRunAt, err := nextSubscriptionRun(subscription.Interval, now)
+	if err != nil {
+		svc.logger.WithField("subscriptionId", subscription.ID).WithError(err).Error("Failed to schedule subscription")
+		return
+	}
+	subscription.NextRunAt = nextRunAt
+	subscription.LastRunAt = &now
+	err = svc.db.Save(subscription).Error
+	if err != nil {
+		svc.logger.WithField("subscriptionId", subscription.ID).WithError(err).Error("Failed to save subscription")
+		return
+	}
+
+	payment, preimage, permissionResponse, err := svc.createSubscriptionPayment(subscription)
+	if err != nil {
+		svc.logger.WithField("subscriptionId", subscription.ID).WithError(err).Error("Failed to create subscription payment")
+		return
+	}
+
+	var feeMsat *uint64
+	if permissionResponse != nil {
+		err = errors.New(permissionResponse.Error.Message)
+	} else {
+		svc.logger.WithFields(logrus.Fields{
+			"subscriptionId": subscription.ID,
+			"appId":          subscription.AppId,
+			"amount":         subscription.Amount,
+		}).Info("Paying subscription")
+
+		var response *lnclient.PayInvoiceResponse
+		response, err = svc.paySubscription(ctx, subscription, payment, preimage)
+		if err == nil {
+			preimage, feeMsat = response.Preimage, response.Fee
+		}
+	}
+	if err != nil && ctx.Err() != nil && payment.PaymentHash != "" {
+		// the node may still complete the payment, it stays pending until the hub resolves pending payments on its next start
+		svc.logger.WithFields(logrus.Fields{
+			"subscriptionId": subscription.ID,
+			"appId":          subscription.AppId,
+			"paymentHash":    payment.PaymentHash,
+		}).WithError(err).Warn("Subscription payment did not complete in time, leaving it pending")
+		return
+	}
+	if err != nil {
+		svc.logger.WithFields(logrus.Fields{
+			"subscriptionId": subscription.ID,
+			"appId":          subscription.AppId,
+		}).Infof("Failed to pay subscription: %v", err)
+		svc.paymentFailed(payment, err)
+		return
+	}
+
+	svc.paymentSucceeded(payment, preimage, feeMsat)
+}
+
+// createSubscriptionPayment checks the budget of the subscription and records its pending payment,
+// along with the preimage of keysend subscriptions. Subscriptions are paid concurrently,
+// so no other subscription payment is checked or recorded in between.
+func (svc *Service) createSubscriptionPayment(subscription *db.Subscription) (*db.Payment, string, *nip47.Response, error) {
+	svc.subscriptionPaymentsMu.Lock()
+	defer svc.subscriptionPaymentsMu.Unlock()
+
+	// the budget is checked before the payment is recorded, which would otherwise count against it
+	permissionResponse := svc.checkPermission(&nip47.Request{Method: nip47.PAY_INVOICE_METHOD}, "", &subscription.App, subscription.Amount)
+
+	payment := &db.Payment{
+		AppId:          subscription.AppId,
+		RequestEventId: subscription.RequestEventId,
+		AmountMsat:     uint64(subscription.Amount),
+		State:          db.PAYMENT_STATE_PENDING,
+		SubscriptionId: &subscription.ID,
+	}
+	if subscription.Pubkey != "" {
+		payment.Destination = strings.ToLower(subscription.Pubkey)
+	} else {
+		payment.Destination = lnurl.Normalize(subscription.LightningAddress)
+	}
+	// the payment hash of keysend payments is known up front, so the app can be notified even if the payment never starts
+	var preimage string
+	if subscription.Pubkey != "" {
+		preimageBytes := make([]byte, 32)
+		_, err := rand.Read(preimageBytes)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("failed to generate keysend preimage: %w", err)
+		}
+		paymentHash := sha256.Sum256(preimageBytes)
+		preimage = hex.EncodeToString(preimageBytes)
+		payment.PaymentHash = hex.EncodeToString(paymentHash[:])
+	}
+	err := svc.db.Create(payment).Error
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return payment, preimage, permissionResponse, nil
+}
+
+// paySubscription pays the subscription by keysend with the given preimage or to its lightning address.
+// Lightning address invoices are paid like pay_invoice requests: they are not paid twice and invoices of apps on this hub are settled internally.
+func (svc *Service) paySubscription(ctx context.Context, subscription *db.Subscription, payment *db.Payment, keysendPreimage string) (*lnclient.PayInvoiceResponse, error) {
+	if subscription.Pubkey != "" {
+		return svc.lnClient.SendKeysend(ctx, subscription.Amount, subscription.Pubkey, keysendPreimage, nil, svc.paymentOptions(&subscription.App, nil))
+	}
+
+	payParams, err := svc.lnurlClient.FetchPayParams(ctx, subscription.LightningAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch LNURL-pay params: %w", err)
+	}
+	bolt11, err := svc.lnurlClient.FetchInvoice(ctx, payParams, &lnurl.PayRequestOptions{
+		AmountMsat: subscription.Amount,
+		Comment:    subscription.Description,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch invoice: %w", err)
+	}
+	paymentRequest, err := decodepay.Decodepay(strings.ToLower(bolt11))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode bolt11 invoice: %w", err)
+	}
+
+	nip47Request := &nip47.Request{Method: nip47.PAY_INVOICE_METHOD}
+	resp := svc.beginPayment(ctx, nip47Request, &db.RequestEvent{ID: subscription.RequestEventId}, &subscription.App, paymentRequest.PaymentHash)
+	if resp != nil {
+		if resp.Error != nil {
+			return nil, errors.New(resp.Error.Message)
+		}
+		return nil, errors.New("invoice is already paid or being paid")
+	}
+	var paymentResponse *nip47.Response
+	defer func() {
+		svc.endPayment(paymentRequest.PaymentHash, paymentResponse)
+	}()
+
+	// the hash is stored before paying, so the payment is resolved on the next start if the hub stops during it
+	payment.PaymentRequest = bolt11
+	payment.PaymentHash = paymentRequest.PaymentHash
+	err = svc.db.Model(payment).Updates(&db.Payment{PaymentRequest: payment.PaymentRequest, PaymentHash: payment.PaymentHash}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := svc.sendInvoicePayment(ctx, &subscription.App, bolt11, &paymentRequest, nil)
+	if err != nil {
+		paymentResponse = &nip47.Response{
+			ResultType: nip47Request.Method,
+			Error: &nip47.Error{
+				Code:    paymentErrorCode(err),
+				Message: err.Error(),
+			},
+		}
+		return nil, err
+	}
+	paymentResponse = &nip47.Response{
+		ResultType: nip47Request.Method,
+		Result: nip47.PayResponse{
+			Preimage: response.Preimage,
+			FeesPaid: response.Fee,
+		},
+	}
+	return response, nil
+}
+
+func subscriptionToNip47(subscription *db.Subscription) nip47.Subscription {
+	result := nip47.Subscription{
+		Id:               subscription.ID,
+		Pubkey:           subscription.Pubkey,
+		LightningAddress: subscription.LightningAddress,
+		Amount:           subscription.Amount,
+		Interval:         subscription.Interval,
+		Description:      subscription.Description,
+		NextRunAt:        subscription.NextRunAt.Unix(),
+		CreatedAt:        subscription.CreatedAt.Unix(),
+	}
+	if subscription.LastRunAt != nil {
+		lastRunAt := subscription.LastRunAt.Unix()
+		result.LastRunAt = &lastRunAt
+	}
+	return result
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/api"
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	decodepay "github.com/nbd-wtf/ln-decodepay"
+	"gorm.io/gorm"
+)
+
+// canSeeAllTransactions reports whether the app may see every transaction of the node instead of only its own
+func (svc *Service) canSeeAllTransactions(app *db.App) bool {
+	hasPermission, _, _ := svc.hasPermission(app, nip47.ALL_TRANSACTIONS_PERMISSION, 0)
+	return hasPermission
+}
+
+// canSeeKeysendReceipts reports whether the app may see the keysend payments received by the node
+func (svc *Service) canSeeKeysendReceipts(app *db.App) bool {
+	hasPermission, _, _ := svc.hasPermission(app, nip47.KEYSEND_RECEIPTS_PERMISSION, 0)
+	return hasPermission
+}
+
+// keysendReceipts selects the incoming transactions in the transactions table which were not paid to an invoice
+func keysendReceipts(query *gorm.DB) *gorm.DB {
+	return query.Where("type = ? AND (payment_request IS NULL OR payment_request = '')", "incoming")
+}
+
+// canSeeKeysendReceipt reports whether the payment is a keysend payment received by the node which the app may see
+func (svc *Service) canSeeKeysendReceipt(app *db.App, paymentHash string) bool {
+	if !svc.canSeeKeysendReceipts(app) {
+		return false
+	}
+	var count int64
+	err := keysendReceipts(svc.db.Model(&db.Transaction{})).Where("payment_hash = ?", paymentHash).Count(&count).Error
+	if err != nil {
+		svc.logger.WithField("paymentHash", paymentHash).WithError(err).Error("Failed to find keysend receipt")
+		return false
+	}
+	return count > 0
+}
+
+// invoiceToTransaction describes a payment to an app's invoice from its stored state
+func (svc *Service) invoiceToTransaction(invoice *db.Invoice) *nip47.Transaction {
+	transaction := &nip47.Transaction{
+		Type:        "incoming",
+		Invoice:     invoice.PaymentRequest,
+		PaymentHash: invoice.PaymentHash,
+		Amount:      int64(invoice.Amount) * 1000,
+		CreatedAt:   invoice.CreatedAt.Unix(),
+		State:       nip47.TRANSACTION_STATE_PENDING,
+	}
+
+	paymentRequest, err := decodepay.Decodepay(invoice.PaymentRequest)
+	if err == nil {
+		transaction.Description = paymentRequest.Description
+		transaction.DescriptionHash = paymentRequest.DescriptionHash
+		expiresAt := int64(paymentRequest.CreatedAt + paymentRequest.Expiry)
+		transaction.ExpiresAt = &expiresAt
+	}
+
+	if invoice.SettledAt != nil {
+		settledAt := invoice.SettledAt.Unix()
+		transaction.SettledAt = &settledAt
+		transaction.State = nip47.TRANSACTION_STATE_SETTLED
+	}
+
+	return transaction
+}
+
+// listAppTransactions lists the payments to the app's invoices and the payments it made, newest first.
+// Apps allowed to see keysend receipts also get the keysend payments received by the node.
+func (svc *Service) listAppTransactions(app *db.App, listParams *nip47.ListTransactionsParams, limit uint64) ([]nip47.Transaction, error) {
+	// enough of each to apply the offset to the merged list
+	fetchLimit := int(limit + listParams.Offset)
+	createdBetween := func(query *gorm.DB) *gorm.DB {
+		if listParams.From != 0 {
+			query = query.Where("created_at >= ?", time.Unix(int64(listParams.From), 0))
+		}
+		if listParams.Until != 0 {
+			query = query.Where("created_at <= ?", time.Unix(int64(listParams.Until), 0))
+		}
+		return query
+	}
+
+	transactions := []nip47.Transaction{}
+
+	if listParams.Type != "outgoing" {
+		invoices := []db.Invoice{}
+		query := createdBetween(svc.db.Where("app_id = ?", app.ID))
+		if !listParams.Unpaid {
+			query = query.Where("settled_at IS NOT NULL")
+		}
+		err := query.Order("created_at desc").Limit(fetchLimit).Find(&invoices).Error
+		if err != nil {
+			return nil, err
+		}
+		for i := range invoices {
+			transactions = append(transactions, *svc.invoiceToTransaction(&invoices[i]))
+		}
+
+		if svc.canSeeKeysendReceipts(app) {
+			dbTransactions := []db.Transaction{}
+			query := createdBetween(keysendReceipts(svc.db))
+			if !listParams.Unpaid {
+				query = query.Where("state = ?", nip47.TRANSACTION_STATE_SETTLED)
+			}
+			err := query.Order("created_at desc").Limit(fetchLimit).Find(&dbTransactions).Error
+			if err != nil {
+				return nil, err
+			}
+			for i := range dbTransactions {
+				transactions = append(transactions, *ledgerTransactionToNip47(&dbTransactions[i]))
+			}
+		}
+	}
+
+	if listParams.Type != "incoming" {
+		payments := []db.Payment{}
+		query := createdBetween(svc.db.Where("app_id = ?", app.ID))
+		if !listParams.Unpaid {
+			query = query.Where("state = ?", db.PAYMENT_STATE_SUCCEEDED)
+		}
+		err := query.Order("created_at desc").Limit(fetchLimit).Find(&payments).Error
+		if err != nil {
+			return nil, err
+		}
+		for i := range payments {
+			transactions = append(transactions, *svc.paymentToTransaction(&payments[i]))
+		}
+	}
+
+	sort.SliceStable(transactions, func(i, j int) bool {
+		return transactions[i].CreatedAt > transactions[j].CreatedAt
+	})
+
+	if listParams.Offset >= uint64(len(transactions)) {
+		return []nip47.Transaction{}, nil
+	}
+	transactions = transactions[listParams.Offset:]
+	if uint64(len(transactions)) > limit {
+		transactions = transactions[:limit]
+	}
+	return transactions, nil
+}
+
+// withLabels adds the labels the user gave the payments to the metadata of the transactions
+func (svc *Service) withLabels(transactions []nip47.Transaction) {
+	paymentHashes := []string{}
+	for _, transaction := range transactions {
+		paymentHashes = append(paymentHashes, transaction.PaymentHash)
+	}
+	if len(paymentHashes) == 0 {
+		return
+	}
+
+	labels := []db.Label{}
+	err := svc.db.Where("type = ? AND ref IN ?", api.LabelTypePayment, paymentHashes).Find(&labels).Error
+	if err != nil {
+		svc.logger.WithError(err).Error("Failed to find payment labels")
+		return
+	}
+	labelsByPaymentHash := map[string]string{}
+	for _, label := range labels {
+		labelsByPaymentHash[label.Ref] = label.Label
+	}
+
+	for i := range transactions {
+		label, ok := labelsByPaymentHash[transactions[i].PaymentHash]
+		if !ok {
+			continue
+		}
+		addMetadata(&transactions[i], "label", label)
+	}
+}
+
+// withContacts adds the contact that was paid to the metadata of the outgoing transactions
+func (svc *Service) withContacts(transactions []nip47.Transaction) {
+	paymentHashes := []string{}
+	for _, transaction := range transactions {
+		if transaction.Type == "outgoing" {
+			paymentHashes = append(paymentHashes, transaction.PaymentHash)
+		}
+	}
+	if len(paymentHashes) == 0 {
+		return
+	}
+
+	payments := []db.Payment{}
+	err := svc.db.Where("payment_hash IN ? AND destination IS NOT NULL AND destination != ''", paymentHashes).Find(&payments).Error
+	if err != nil {
+		svc.logger.WithError(err).Error("Failed to find payment destinations")
+		return
+	}
+	if len(payments) == 0 {
+		return
+	}
+	destinations := []string{}
+	for _, payment := range payments {
+		destinations = append(destinations, payment.Destination)
+	}
+
+	contacts := []db.Contact{}
+	err = svc.db.Where("lightning_address IN ? OR lnurl IN ? OR node_pubkey IN ?", destinations, destinations, destinations).Find(&contacts).Error
+	if err != nil {
+		svc.logger.WithError(err).Error("Failed to find contacts")
+		return
+	}
+	contactsByDestination := map[string]db.Contact{}
+	for _, contact := range contacts {
+		for _, destination := range []string{contact.LightningAddress, contact.Lnurl, contact.NodePubkey} {
+			if destination != "" {
+				contactsByDestination[destination] = contact
+			}
+		}
+	}
+	contactsByPaymentHash := map[string]db.Contact{}
+	for _, payment := range payments {
+		contact, ok := contactsByDestination[payment.Destination]
+		if ok {
+			contactsByPaymentHash[payment.PaymentHash] = contact
+		}
+	}
+
+	for i := range transactions {
+		if transactions[i].Type != "outgoing" {
+			continue
+		}
+		contact, ok := contactsByPaymentHash[transactions[i].PaymentHash]
+		if !ok {
+			continue
+		}
+		addMetadata(&transactions[i], "contact", map[string]interface{}{
+			"id":   contact.ID,
+			"name": contact.Name,
+		})
+	}
+}
+
+// addMetadata sets key in the metadata of the transaction, keeping the metadata the backend returned
+func addMetadata(transaction *nip47.Transaction, key string, value interface{}) {
+	metadata := map[string]interface{}{}
+	switch existingMetadata := transaction.Metadata.(type) {
+	case nil:
+	case map[string]interface{}:
+		for key, value := range existingMetadata {
+			metadata[key] = value
+		}
+	default:
+		// unknown metadata of the backend is kept as is
+		return
+	}
+	metadata[key] = value
+	transaction.Metadata = metadata
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/events"
+	"github.com/getAlby/nostr-wallet-connect/lnclient"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+)
+
+const (
+	// how often the transactions table is reconciled with the LN backend
+	transactionsReconcileInterval = 5 * time.Minute
+	// how many of the most recent transactions of the LN backend are compared per page
+	transactionsReconcilePageSize = 100
+	// config key set once every transaction of the LN backend was imported
+	transactionsBackfilledKey = "TransactionsBackfilled"
+)
+
+// TransactionsLedger records payments received by the node in the transactions table
+type TransactionsLedger struct {
+	svc *Service
+}
+
+func NewTransactionsLedger(svc *Service) *TransactionsLedger {
+	return &TransactionsLedger{
+		svc: svc,
+	}
+}
+
+func (ledger *TransactionsLedger) ConsumeEvent(ctx context.Context, event *events.Event, globalProperties map[string]interface{}) error {
+	if event.Event != "nwc_payment_received" {
+		return nil
+	}
+
+	paymentReceivedEventProperties, ok := event.Properties.(*events.PaymentReceivedEventProperties)
+	if !ok {
+		ledger.svc.logger.WithField("event", event).Error("Failed to cast event")
+		return errors.New("failed to cast event")
+	}
+
+	lnClient := ledger.svc.lnClient
+	if lnClient == nil {
+		return nil
+	}
+	transaction, err := lnClient.LookupInvoice(ctx, paymentReceivedEventProperties.PaymentHash)
+	if err != nil {
+		ledger.svc.logger.WithField("paymentHash", paymentReceivedEventProperties.PaymentHash).WithError(err).Error("Failed to lookup received payment")
+		return err
+	}
+	// internal payments are unknown to the node
+	transaction = ledger.svc.withInternalSettlement(transaction)
+	return ledger.svc.recordTransaction(transaction, ledger.svc.transactionAppId(transaction))
+}
+
+// startTransactionsReconciliation keeps the transactions table in sync with the LN backend until ctx is cancelled.
+// Transactions made before the table existed are imported on the first run, which is repeated until it completes.
+func (svc *Service) startTransactionsReconciliation(ctx context.Context) {
+	go func() {
+		svc.backfillTransactions(ctx)
+
+		ticker := time.NewTicker(transactionsReconcileInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				svc.reconcileTransactions(ctx, false)
+			}
+		}
+	}()
+}
+
+// backfillTransactions imports every transaction of the LN backend until that completed once, then only looks for changes
+func (svc *Service) backfillTransactions(ctx context.Context) {
+	backfilled, _ := svc.cfg.Get(transactionsBackfilledKey, "")
+	if backfilled == "true" {
+		svc.reconcileTransactions(ctx, false)
+		return
+	}
+	err := svc.reconcileTransactions(ctx, true)
+	if err == nil {
+		svc.cfg.SetUpdate(transactionsBackfilledKey, "true", "")
+	}
+}
+
+// reconcileTransactions records the transactions of the LN backend page by page, newest first.
+// With backfill set every page is recorded, otherwise paging stops at the first page without new or changed transactions
+// and the transactions still pending in the table are looked up, as they may have changed on a page which was not compared.
+func (svc *Service) reconcileTransactions(ctx context.Context, backfill bool) error {
+	lnClient := svc.lnClient
+	if lnClient == nil {
+		return errors.New("LNClient not started")
+	}
+
+	// some backends return the last page again past the end, so paging also stops once a page has nothing new
+	seen := map[string]bool{}
+	for offset := uint64(0); ; offset += transactionsReconcilePageSize {
+		transactions, err := lnClient.ListTransactions(ctx, 0, 0, transactionsReconcilePageSize, offset, true, "")
+		if err != nil {
+			svc.logger.WithError(err).Error("Failed to list transactions for reconciliation")
+			return err
+		}
+
+		found := false
+		changed := false
+		for i := range transactions {
+			key := transactions[i].Type + ":" + transactions[i].PaymentHash
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			found = true
+
+			transaction := svc.withInternalSettlement(&transactions[i])
+			transactionChanged, err := svc.recordTransactionChanges(transaction, svc.transactionAppId(transaction))
+			if err != nil {
+				svc.logger.WithField("paymentHash", transaction.PaymentHash).WithError(err).Error("Failed to record transaction")
+			}
+			changed = changed || transactionChanged
+		}
+
+		if !found || (!backfill && !changed) {
+			break
+		}
+	}
+
+	svc.reconcilePendingTransactions(ctx, lnClient, seen)
+	return nil
+}
+
+// reconcilePendingTransactions looks up the transactions which are pending in the table and were not seen in the pages
+// compared, e.g. a payment which was in flight for hours. Unpaid invoices are only looked up until they expire.
+func (svc *Service) reconcilePendingTransactions(ctx context.Context, lnClient lnclient.LNClient, seen map[string]bool) {
+	pendingTransactions := []db.Transaction{}
+	err := svc.db.Where("state = ? AND (type = ? OR expires_at IS NULL OR expires_at > ?)", nip47.TRANSACTION_STATE_PENDING, "outgoing", time.Now()).
+		Find(&pendingTransactions).Error
+	if err != nil {
+		svc.logger.WithError(err).Error("Failed to find pending transactions for reconciliation")
+		return
+	}
+
+	for _, pendingTransaction := range pendingTransactions {
+		if seen[pendingTransaction.Type+":"+pendingTransaction.PaymentHash] {
+			continue
+		}
+
+		var transaction *nip47.Transaction
+		if pendingTransaction.Type == "incoming" {
+			transaction, err = lnClient.LookupInvoice(ctx, pendingTransaction.PaymentHash)
+		} else {
+			transaction, err = lnClient.LookupPayment(ctx, pendingTransaction.PaymentHash)
+		}
+		if errors.Is(err, lnclient.ErrNotImplemented) || errors.Is(err, lnclient.ErrPaymentNotFound) {
+			continue
+		}
+		if err != nil {
+			svc.logger.WithField("paymentHash", pendingTransaction.PaymentHash).WithError(err).Error("Failed to look up pending transaction")
+			continue
+		}
+		if transaction.PaymentHash != pendingTransaction.PaymentHash {
+			continue
+		}
+
+		transaction = svc.withInternalSettlement(transaction)
+		_, err = svc.recordTransactionChanges(transaction, svc.transactionAppId(transaction))
+		if err != nil {
+			svc.logger.WithField("paymentHash", transaction.PaymentHash).WithError(err).Error("Failed to record transaction")
+		}
+	}
+}
+
+// transactionAppId returns the app which created the invoice or made the payment, if any
+func (svc *Service) transactionAppId(transaction *nip47.Transaction) *uint {
+	var appIds []uint
+	if transaction.Type == "incoming" {
+		svc.db.Model(&db.Invoice{}).Where("payment_hash = ?", transaction.PaymentHash).Limit(1).Pluck("app_id", &appIds)
+	} else {
+		svc.db.Model(&db.Payment{}).Where("payment_hash = ?", transaction.PaymentHash).Order("id desc").Limit(1).Pluck("app_id", &appIds)
+	}
+	if len(appIds) == 0 {
+		return nil
+	}
+	return &appIds[0]
+}
+
+// recordTransaction adds the transaction to the transactions table or updates the stored one with what is known now
+func (svc *Service) recordTransaction(transaction *nip47.Transaction, appId *uint) error {
+	_, err := svc.recordTransactionChanges(transaction, appId)
+	return err
+}
+
+// recordTransactionChanges records the transaction like recordTransaction,
+// and tells whether it was new or its state, preimage or fees changed
+func (svc *Service) recordTransactionChanges(transaction *nip47.Transaction, appId *uint) (bool, error) {
+	if transaction.PaymentHash == "" {
+		// cannot be matched with the transactions of the LN backend
+		return false, nil
+	}
+
+	dbTransaction := db.Transaction{}
+	result := svc.db.Where("type = ? AND payment_hash = ?", transaction.Type, transaction.PaymentHash).Limit(1).Find(&dbTransaction)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	previous := dbTransaction
+	if result.RowsAffected == 0 {
+		dbTransaction.Type = transaction.Type
+		dbTransaction.PaymentHash = transaction.PaymentHash
+		if transaction.CreatedAt != 0 {
+			dbTransaction.CreatedAt = time.Unix(transaction.CreatedAt, 0)
+		}
+	}
+
+	if appId != nil {
+		dbTransaction.AppId = appId
+	}
+
+	state := transaction.State
+	if state == "" {
+		state = nip47.TRANSACTION_STATE_PENDING
+		if transaction.SettledAt != nil {
+			state = nip47.TRANSACTION_STATE_SETTLED
+		}
+	}
+	// a settled or failed transaction is never reported as pending again,
+	// e.g. the node reports invoices which were settled internally as cancelled
+	if dbTransaction.State == "" || state != nip47.TRANSACTION_STATE_PENDING {
+		dbTransaction.State = state
+	}
+
+	if transaction.Invoice != "" {
+		dbTransaction.PaymentRequest = transaction.Invoice
+	}
+	if transaction.Preimage != "" {
+		dbTransaction.Preimage = transaction.Preimage
+	}
+	if transaction.Description != "" {
+		dbTransaction.Description = transaction.Description
+	}
+	if transaction.DescriptionHash != "" {
+		dbTransaction.DescriptionHash = transaction.DescriptionHash
+	}
+	if transaction.Amount != 0 {
+		dbTransaction.Amount = transaction.Amount
+	}
+	if transaction.FeesPaid != 0 {
+		dbTransaction.FeesPaid = transaction.FeesPaid
+	}
+	if transaction.Metadata != nil {
+		metadata, err := json.Marshal(transaction.Metadata)
+		if err == nil {
+			dbTransaction.Metadata = string(metadata)
+		}
+	}
+	if transaction.ExpiresAt != nil {
+		expiresAt := time.Unix(*transaction.ExpiresAt, 0)
+		dbTransaction.ExpiresAt = &expiresAt
+	}
+	if transaction.SettledAt != nil && dbTransaction.SettledAt == nil {
+		settledAt := time.Unix(*transaction.SettledAt, 0)
+		dbTransaction.SettledAt = &settledAt
+	}
+
+	changed := result.RowsAffected == 0 || dbTransaction.State != previous.State || dbTransaction.Preimage != previous.Preimage ||
+		dbTransaction.FeesPaid != previous.FeesPaid || (previous.SettledAt == nil && dbTransaction.SettledAt != nil)
+	return changed, svc.db.Save(&dbTransaction).Error
+}
+
+// listLedgerTransactions lists the transactions of the node from the transactions table, newest first
+func (svc *Service) listLedgerTransactions(listParams *nip47.ListTransactionsParams, limit uint64) ([]nip47.Transaction, error) {
+	query := svc.db.Model(&db.Transaction{})
+	if listParams.Type != "" {
+		query = query.Where("type = ?", listParams.Type)
+	}
+	if !listParams.Unpaid {
+		query = query.Where("state = ?", nip47.TRANSACTION_STATE_SETTLED)
+	}
+	if listParams.From != 0 {
+		query = query.Where("created_at >= ?", time.Unix(int64(listParams.From), 0))
+	}
+	if listParams.Until != 0 {
+		query = query.Where("created_at <= ?", time.Unix(int64(listParams.Until), 0))
+	}
+
+	dbTransactions := []db.Transaction{}
+	err := query.Order("created_at desc, id desc").Limit(int(limit)).Offset(int(listParams.Offset)).Find(&dbTransactions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := []nip47.Transaction{}
+	for i := range dbTransactions {
+		transactions = append(transactions, *ledgerTransactionToNip47(&dbTransactions[i]))
+	}
+	return transactions, nil
+}
+
+func ledgerTransactionToNip47(dbTransaction *db.Transaction) *nip47.Transaction {
+	transaction := &nip47.Transaction{
+		Type:            dbTransaction.Type,
+		State:           dbTransaction.State,
+		Invoice:         dbTransaction.PaymentRequest,
+		PaymentHash:     dbTransaction.PaymentHash,
+		Preimage:        dbTransaction.Preimage,
+		Description:     dbTransaction.Description,
+		DescriptionHash: dbTransaction.DescriptionHash,
+		Amount:          dbTransaction.Amount,
+		FeesPaid:        dbTransaction.FeesPaid,
+		CreatedAt:       dbTransaction.CreatedAt.Unix(),
+	}
+	if dbTransaction.Metadata != "" {
+		var metadata interface{}
+		if json.Unmarshal([]byte(dbTransaction.Metadata), &metadata) == nil {
+			transaction.Metadata = metadata
+		}
+	}
+	if dbTransaction.ExpiresAt != nil {
+		expiresAt := dbTransaction.ExpiresAt.Unix()
+		transaction.ExpiresAt = &expiresAt
+	}
+	if dbTransaction.SettledAt != nil {
+		settledAt := dbTransaction.SettledAt.Unix()
+		transaction.SettledAt = &settledAt
+	}
+	return transaction
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/sirupsen/logrus"
@@ -48,6 +50,94 @@ func (app *WailsApp) WailsRequestRouter(route string, method string, body string
 		return WailsRequestRouterResponse{Body: nil, Error: ""}
 	}
 
+	appPaymentsRegex := regexp.MustCompile(
+		`/api/apps/([0-9a-f]+)/payments`,
+	)
+
+	appPaymentsMatch := appPaymentsRegex.FindStringSubmatch(route)
+
+	switch {
+	case len(appPaymentsMatch) > 1 && method == "GET":
+		pubkey := appPaymentsMatch[1]
+
+		userApp := db.App{}
+		findResult := app.svc.db.Where("nostr_pubkey = ?", pubkey).First(&userApp)
+
+		if findResult.RowsAffected == 0 {
+			return WailsRequestRouterResponse{Body: nil, Error: "App does not exist"}
+		}
+
+		payments, err := app.api.ListAppPayments(&userApp)
+		if err != nil {
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		return WailsRequestRouterResponse{Body: payments, Error: ""}
+	}
+
+	appActivityRegex := regexp.MustCompile(
+		`/api/apps/([0-9a-f]+)/(events|stats)`,
+	)
+
+	appActivityMatch := appActivityRegex.FindStringSubmatch(route)
+
+	switch {
+	case len(appActivityMatch) > 2 && method == "GET":
+		pubkey := appActivityMatch[1]
+
+		userApp := db.App{}
+		findResult := app.svc.db.Where("nostr_pubkey = ?", pubkey).First(&userApp)
+
+		if findResult.RowsAffected == 0 {
+			return WailsRequestRouterResponse{Body: nil, Error: "App does not exist"}
+		}
+
+		routeUrl, err := url.Parse(route)
+		if err != nil {
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		query := routeUrl.Query()
+
+		if appActivityMatch[2] == "events" {
+			listAppEventsRequest := &api.ListAppEventsRequest{}
+			for key, value := range map[string]*uint64{
+				"limit":  &listAppEventsRequest.Limit,
+				"offset": &listAppEventsRequest.Offset,
+			} {
+				if query.Has(key) {
+					*value, err = strconv.ParseUint(query.Get(key), 10, 64)
+					if err != nil {
+						return WailsRequestRouterResponse{Body: nil, Error: fmt.Sprintf("Invalid %s parameter: %v", key, err)}
+					}
+				}
+			}
+			events, err := app.api.ListAppEvents(&userApp, listAppEventsRequest)
+			if err != nil {
+				return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+			}
+			return WailsRequestRouterResponse{Body: events, Error: ""}
+		}
+
+		getAppStatsRequest := &api.GetAppStatsRequest{
+			Period: query.Get("period"),
+		}
+		for key, value := range map[string]*int64{
+			"from":  &getAppStatsRequest.From,
+			"until": &getAppStatsRequest.Until,
+		} {
+			if query.Has(key) {
+				*value, err = strconv.ParseInt(query.Get(key), 10, 64)
+				if err != nil {
+					return WailsRequestRouterResponse{Body: nil, Error: fmt.Sprintf("Invalid %s parameter: %v", key, err)}
+				}
+			}
+		}
+		stats, err := app.api.GetAppStats(&userApp, getAppStatsRequest)
+		if err != nil {
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		return WailsRequestRouterResponse{Body: stats, Error: ""}
+	}
+
 	appRegex := regexp.MustCompile(
 		`/api/apps/([0-9a-f]+)`,
 	)
@@ -134,6 +224,123 @@ func (app *WailsApp) WailsRequestRouter(route string, method string, body string
 		}
 	}
 
+	invoiceRegex := regexp.MustCompile(
+		`/api/invoices/([0-9a-f]+)`,
+	)
+
+	invoiceMatch := invoiceRegex.FindStringSubmatch(route)
+
+	switch {
+	case len(invoiceMatch) == 2 && method == "GET":
+		invoice, err := app.api.LookupInvoice(ctx, invoiceMatch[1])
+		if err != nil {
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		return WailsRequestRouterResponse{Body: invoice, Error: ""}
+	}
+
+	labelRegex := regexp.MustCompile(
+		`/api/labels/([^/]+)/(.+)`,
+	)
+
+	labelMatch := labelRegex.FindStringSubmatch(route)
+
+	switch {
+	case len(labelMatch) == 3:
+		ref, err := url.PathUnescape(labelMatch[2])
+		if err != nil {
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		switch method {
+		case "GET":
+			label, err := app.api.GetLabel(labelMatch[1], ref)
+			if err != nil {
+				return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+			}
+			return WailsRequestRouterResponse{Body: label, Error: ""}
+		case "DELETE":
+			err = app.api.DeleteLabel(labelMatch[1], ref)
+			if err != nil {
+				return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+			}
+			return WailsRequestRouterResponse{Body: nil, Error: ""}
+		}
+	}
+
+	contactRegex := regexp.MustCompile(
+		`/api/contacts/([0-9]+)`,
+	)
+
+	contactMatch := contactRegex.FindStringSubmatch(route)
+
+	switch {
+	case len(contactMatch) == 2:
+		id, err := strconv.ParseUint(contactMatch[1], 10, 64)
+		if err != nil {
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		switch method {
+		case "PATCH":
+			contactRequest := &api.ContactRequest{}
+			err := json.Unmarshal([]byte(body), contactRequest)
+			if err != nil {
+				app.svc.logger.WithFields(logrus.Fields{
+					"route":  route,
+					"method": method,
+					"body":   body,
+				}).WithError(err).Error("Failed to decode request to wails router")
+				return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+			}
+			contact, err := app.api.UpdateContact(uint(id), contactRequest)
+			if err != nil {
+				return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+			}
+			return WailsRequestRouterResponse{Body: contact, Error: ""}
+		case "DELETE":
+			err := app.api.DeleteContact(uint(id))
+			if err != nil {
+				return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+			}
+			return WailsRequestRouterResponse{Body: nil, Error: ""}
+		}
+	}
+
+	connectionRequestRegex := regexp.MustCompile(
+		`/api/connection-requests/([0-9]+)/(approve|reject)`,
+	)
+
+	connectionRequestMatch := connectionRequestRegex.FindStringSubmatch(route)
+
+	switch {
+	case len(connectionRequestMatch) == 3 && method == "POST":
+		id, err := strconv.ParseUint(connectionRequestMatch[1], 10, 64)
+		if err != nil {
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		if connectionRequestMatch[2] == "reject" {
+			err = app.api.RejectConnectionRequest(ctx, uint(id))
+			if err != nil {
+				return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+			}
+			return WailsRequestRouterResponse{Body: nil, Error: ""}
+		}
+		approveConnectionRequestRequest := &api.ApproveConnectionRequestRequest{}
+		err = json.Unmarshal([]byte(body), approveConnectionRequestRequest)
+		if err != nil {
+			app.svc.logger.WithFields(logrus.Fields{
+				"route":  route,
+				"method": method,
+				"body":   body,
+			}).WithError(err).Error("Failed to decode request to wails router")
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		connectionRequest, err := app.api.ApproveConnectionRequest(ctx, uint(id), approveConnectionRequestRequest)
+		if err != nil {
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		return WailsRequestRouterResponse{Body: connectionRequest, Error: ""}
+	}
+
 	networkGraphRegex := regexp.MustCompile(
 		`/api/node/network-graph\?nodeIds=(.+)`,
 	)
@@ -207,6 +414,179 @@ func (app *WailsApp) WailsRequestRouter(route string, method string, body string
 			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
 		}
 		return WailsRequestRouterResponse{Body: nil, Error: ""}
+	case "/api/payments":
+		sendPaymentRequest := &api.SendPaymentRequest{}
+		err := json.Unmarshal([]byte(body), sendPaymentRequest)
+		if err != nil {
+			app.svc.logger.WithFields(logrus.Fields{
+				"route":  route,
+				"method": method,
+				"body":   body,
+			}).WithError(err).Error("Failed to decode request to wails router")
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		payResponse, err := app.api.SendPayment(ctx, sendPaymentRequest)
+		if err != nil {
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		return WailsRequestRouterResponse{Body: payResponse, Error: ""}
+	case "/api/invoices":
+		makeInvoiceRequest := &api.MakeInvoiceRequest{}
+		err := json.Unmarshal([]byte(body), makeInvoiceRequest)
+		if err != nil {
+			app.svc.logger.WithFields(logrus.Fields{
+				"route":  route,
+				"method": method,
+				"body":   body,
+			}).WithError(err).Error("Failed to decode request to wails router")
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		invoice, err := app.api.MakeInvoice(ctx, makeInvoiceRequest)
+		if err != nil {
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		return WailsRequestRouterResponse{Body: invoice, Error: ""}
+	case "/api/labels":
+		switch method {
+		case "GET":
+			labels, err := app.api.ListLabels()
+			if err != nil {
+				return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+			}
+			return WailsRequestRouterResponse{Body: labels, Error: ""}
+		case "PUT":
+			label := &api.Label{}
+			err := json.Unmarshal([]byte(body), label)
+			if err != nil {
+				app.svc.logger.WithFields(logrus.Fields{
+					"route":  route,
+					"method": method,
+					"body":   body,
+				}).WithError(err).Error("Failed to decode request to wails router")
+				return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+			}
+			err = app.api.SetLabel(label)
+			if err != nil {
+				return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+			}
+			return WailsRequestRouterResponse{Body: nil, Error: ""}
+		}
+	case "/api/connection-requests":
+		switch method {
+		case "GET":
+			connectionRequests, err := app.api.ListConnectionRequests()
+			if err != nil {
+				return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+			}
+			return WailsRequestRouterResponse{Body: connectionRequests, Error: ""}
+		case "POST":
+			createConnectionRequestRequest := &api.CreateConnectionRequestRequest{}
+			err := json.Unmarshal([]byte(body), createConnectionRequestRequest)
+			if err != nil {
+				app.svc.logger.WithFields(logrus.Fields{
+					"route":  route,
+					"method": method,
+					"body":   body,
+				}).WithError(err).Error("Failed to decode request to wails router")
+				return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+			}
+			connectionRequest, err := app.api.CreateConnectionRequest(createConnectionRequestRequest)
+			if err != nil {
+				return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+			}
+			return WailsRequestRouterResponse{Body: connectionRequest, Error: ""}
+		}
+	case "/api/contacts":
+		switch method {
+		case "GET":
+			contacts, err := app.api.ListContacts()
+			if err != nil {
+				return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+			}
+			return WailsRequestRouterResponse{Body: contacts, Error: ""}
+		case "POST":
+			contactRequest := &api.ContactRequest{}
+			err := json.Unmarshal([]byte(body), contactRequest)
+			if err != nil {
+				app.svc.logger.WithFields(logrus.Fields{
+					"route":  route,
+					"method": method,
+					"body":   body,
+				}).WithError(err).Error("Failed to decode request to wails router")
+				return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+			}
+			contact, err := app.api.CreateContact(contactRequest)
+			if err != nil {
+				return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+			}
+			return WailsRequestRouterResponse{Body: contact, Error: ""}
+		}
+	case "/api/labels/export":
+		saveFilePath, err := runtime.SaveFileDialog(ctx, runtime.SaveDialogOptions{
+			Title:           "Save Labels",
+			DefaultFilename: "labels.jsonl",
+		})
+		if err != nil {
+			app.svc.logger.WithFields(logrus.Fields{
+				"route":  route,
+				"method": method,
+			}).WithError(err).Error("Failed to open save file dialog")
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+
+		labelsFile, err := os.Create(saveFilePath)
+		if err != nil {
+			app.svc.logger.WithFields(logrus.Fields{
+				"route":  route,
+				"method": method,
+			}).WithError(err).Error("Failed to create labels file")
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+
+		defer labelsFile.Close()
+
+		err = app.api.ExportLabels(labelsFile)
+		if err != nil {
+			app.svc.logger.WithFields(logrus.Fields{
+				"route":  route,
+				"method": method,
+			}).WithError(err).Error("Failed to export labels")
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		return WailsRequestRouterResponse{Body: nil, Error: ""}
+	case "/api/labels/import":
+		labelsFilePath, err := runtime.OpenFileDialog(ctx, runtime.OpenDialogOptions{
+			Title:           "Select Labels File",
+			DefaultFilename: "labels.jsonl",
+		})
+		if err != nil {
+			app.svc.logger.WithFields(logrus.Fields{
+				"route":  route,
+				"method": method,
+			}).WithError(err).Error("Failed to open file dialog")
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+
+		labelsFile, err := os.Open(labelsFilePath)
+		if err != nil {
+			app.svc.logger.WithFields(logrus.Fields{
+				"route":  route,
+				"method": method,
+			}).WithError(err).Error("Failed to open labels file")
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+
+		defer labelsFile.Close()
+
+		importLabelsResponse, err := app.api.ImportLabels(labelsFile)
+		if err != nil {
+			app.svc.logger.WithFields(logrus.Fields{
+				"route":  route,
+				"method": method,
+			}).WithError(err).Error("Failed to import labels")
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		return WailsRequestRouterResponse{Body: importLabelsResponse, Error: ""}
 	case "/api/apps":
 		switch method {
 		case "GET":
@@ -512,6 +892,22 @@ func (app *WailsApp) WailsRequestRouter(route string, method string, body string
 			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
 		}
 		return WailsRequestRouterResponse{Body: sendPaymentProbesResponse, Error: ""}
+	case "/api/estimate-fee":
+		estimateFeeRequest := &api.EstimateFeeRequest{}
+		err := json.Unmarshal([]byte(body), estimateFeeRequest)
+		if err != nil {
+			app.svc.logger.WithFields(logrus.Fields{
+				"route":  route,
+				"method": method,
+				"body":   body,
+			}).WithError(err).Error("Failed to decode request to wails router")
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		estimateFeeResponse, err := app.api.EstimateFee(ctx, estimateFeeRequest)
+		if err != nil {
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		return WailsRequestRouterResponse{Body: estimateFeeResponse, Error: ""}
 	case "/api/send-spontaneous-payment-probes":
 		sendSpontaneousPaymentProbesRequest := &api.SendSpontaneousPaymentProbesRequest{}
 		err := json.Unmarshal([]byte(body), sendSpontaneousPaymentProbesRequest)
@@ -630,6 +1026,98 @@ func (app *WailsApp) WailsRequestRouter(route string, method string, body string
 		return WailsRequestRouterResponse{Body: nil, Error: ""}
 	}
 
+	if strings.HasPrefix(route, "/api/transactions/export") {
+		exportRequest := &api.ExportTransactionsRequest{
+			Format: api.ExportFormatCSV,
+		}
+		routeUrl, err := url.Parse(route)
+		if err != nil {
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		query := routeUrl.Query()
+		if query.Has("format") {
+			exportRequest.Format = query.Get("format")
+		}
+		if exportRequest.Format != api.ExportFormatCSV && exportRequest.Format != api.ExportFormatJSON {
+			return WailsRequestRouterResponse{Body: nil, Error: fmt.Sprintf("Invalid export format: '%s'", exportRequest.Format)}
+		}
+		if query.Has("from") {
+			exportRequest.From, err = strconv.ParseInt(query.Get("from"), 10, 64)
+			if err != nil {
+				return WailsRequestRouterResponse{Body: nil, Error: fmt.Sprintf("Invalid from parameter: %v", err)}
+			}
+		}
+		if query.Has("until") {
+			exportRequest.Until, err = strconv.ParseInt(query.Get("until"), 10, 64)
+			if err != nil {
+				return WailsRequestRouterResponse{Body: nil, Error: fmt.Sprintf("Invalid until parameter: %v", err)}
+			}
+		}
+
+		saveFilePath, err := runtime.SaveFileDialog(ctx, runtime.SaveDialogOptions{
+			Title:           "Save Transactions Export",
+			DefaultFilename: "transactions." + exportRequest.Format,
+		})
+		if err != nil {
+			app.svc.logger.WithFields(logrus.Fields{
+				"route":  route,
+				"method": method,
+			}).WithError(err).Error("Failed to open save file dialog")
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+
+		exportFile, err := os.Create(saveFilePath)
+		if err != nil {
+			app.svc.logger.WithFields(logrus.Fields{
+				"route":  route,
+				"method": method,
+			}).WithError(err).Error("Failed to create export file")
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+
+		defer exportFile.Close()
+
+		err = app.api.ExportTransactions(ctx, exportRequest, exportFile)
+		if err != nil {
+			app.svc.logger.WithFields(logrus.Fields{
+				"route":  route,
+				"method": method,
+			}).WithError(err).Error("Failed to export transactions")
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		return WailsRequestRouterResponse{Body: nil, Error: ""}
+	}
+
+	if route == "/api/transactions" || strings.HasPrefix(route, "/api/transactions?") {
+		listTransactionsRequest := &api.ListTransactionsRequest{}
+		routeUrl, err := url.Parse(route)
+		if err != nil {
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		query := routeUrl.Query()
+		for key, value := range map[string]*uint64{
+			"from":   &listTransactionsRequest.From,
+			"until":  &listTransactionsRequest.Until,
+			"limit":  &listTransactionsRequest.Limit,
+			"offset": &listTransactionsRequest.Offset,
+		} {
+			if query.Has(key) {
+				*value, err = strconv.ParseUint(query.Get(key), 10, 64)
+				if err != nil {
+					return WailsRequestRouterResponse{Body: nil, Error: fmt.Sprintf("Invalid %s parameter: %v", key, err)}
+				}
+			}
+		}
+		listTransactionsRequest.Unpaid = query.Get("unpaid") == "true"
+		listTransactionsRequest.Type = query.Get("type")
+
+		transactions, err := app.api.ListTransactions(ctx, listTransactionsRequest)
+		if err != nil {
+			return WailsRequestRouterResponse{Body: nil, Error: err.Error()}
+		}
+		return WailsRequestRouterResponse{Body: transactions, Error: ""}
+	}
+
 	if strings.HasPrefix(route, "/api/log/") {
 		logType := strings.TrimPrefix(route, "/api/log/")
 		if logType != api.LogTypeNode && logType != api.LogTypeApp {
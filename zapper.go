@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/events"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/sirupsen/logrus"
+)
+
+// NIP-57
+const (
+	ZAP_REQUEST_KIND = 9734
+	ZAP_RECEIPT_KIND = 9735
+)
+
+// parseZapRequest returns nil if the description is not a zap request,
+// and an error if it is one but not valid for an invoice of the given amount
+func parseZapRequest(description string, amountMsat int64) (*nostr.Event, error) {
+	zapRequest := &nostr.Event{}
+	err := json.Unmarshal([]byte(description), zapRequest)
+	if err != nil || zapRequest.Kind != ZAP_REQUEST_KIND {
+		return nil, nil
+	}
+
+	ok, err := zapRequest.CheckSignature()
+	if err != nil || !ok {
+		return nil, errors.New("zap request has an invalid signature")
+	}
+	if len(zapRequest.Tags.GetAll([]string{"p"})) != 1 {
+		return nil, errors.New("zap request must have exactly one p tag")
+	}
+	if len(zapRequest.Tags.GetAll([]string{"e"})) > 1 {
+		return nil, errors.New("zap request must not have more than one e tag")
+	}
+	relaysTag := zapRequest.Tags.GetFirst([]string{"relays"})
+	if relaysTag == nil || len(*relaysTag) < 2 {
+		return nil, errors.New("zap request has no relays")
+	}
+	amountTag := zapRequest.Tags.GetFirst([]string{"amount"})
+	if amountTag != nil {
+		zapAmount, err := strconv.ParseInt(amountTag.Value(), 10, 64)
+		if err != nil || zapAmount != amountMsat {
+			return nil, fmt.Errorf("zap request amount does not match invoice amount of %d msat", amountMsat)
+		}
+	}
+
+	return zapRequest, nil
+}
+
+type ZapReceiptPublisher struct {
+	svc *Service
+}
+
+func NewZapReceiptPublisher(svc *Service) *ZapReceiptPublisher {
+	return &ZapReceiptPublisher{
+		svc: svc,
+	}
+}
+
+func (zapper *ZapReceiptPublisher) ConsumeEvent(ctx context.Context, event *events.Event, globalProperties map[string]interface{}) error {
+	if event.Event != "nwc_payment_received" {
+		return nil
+	}
+
+	paymentReceivedEventProperties, ok := event.Properties.(*events.PaymentReceivedEventProperties)
+	if !ok {
+		zapper.svc.logger.WithField("event", event).Error("Failed to cast event")
+		return errors.New("failed to cast event")
+	}
+
+	zap := db.Zap{}
+	findResult := zapper.svc.db.Where("payment_hash = ? AND receipt_id IS NULL", paymentReceivedEventProperties.PaymentHash).Limit(1).Find(&zap)
+	if findResult.Error != nil {
+		return findResult.Error
+	}
+	if findResult.RowsAffected == 0 {
+		// not a zap
+		return nil
+	}
+
+	if zapper.svc.lnClient == nil {
+		return errors.New("LNClient not started")
+	}
+
+	transaction, err := zapper.svc.lnClient.LookupInvoice(ctx, zap.PaymentHash)
+	if err != nil {
+		zapper.svc.logger.WithField("paymentHash", zap.PaymentHash).WithError(err).Error("Failed to lookup zap invoice by payment hash")
+		return err
+	}
+
+	zapRequest := &nostr.Event{}
+	err = json.Unmarshal([]byte(zap.ZapRequest), zapRequest)
+	if err != nil {
+		return err
+	}
+
+	tags := nostr.Tags{}
+	for _, tagName := range []string{"p", "e", "a"} {
+		tag := zapRequest.Tags.GetFirst([]string{tagName})
+		if tag != nil {
+			tags = append(tags, *tag)
+		}
+	}
+	tags = append(tags,
+		nostr.Tag{"P", zapRequest.PubKey},
+		nostr.Tag{"bolt11", transaction.Invoice},
+		nostr.Tag{"description", zap.ZapRequest},
+	)
+	if transaction.Preimage != "" {
+		tags = append(tags, nostr.Tag{"preimage", transaction.Preimage})
+	}
+
+	createdAt := nostr.Now()
+	if transaction.SettledAt != nil {
+		createdAt = nostr.Timestamp(*transaction.SettledAt)
+	}
+
+	zapReceipt := nostr.Event{
+		CreatedAt: createdAt,
+		Kind:      ZAP_RECEIPT_KIND,
+		Tags:      tags,
+	}
+
+	zapperSecretKey := zapper.svc.cfg.GetEnv().ZapperSecretKey
+	if zapperSecretKey == "" {
+		zapperSecretKey = zapper.svc.cfg.GetNostrSecretKey()
+	}
+	err = zapReceipt.Sign(zapperSecretKey)
+	if err != nil {
+		zapper.svc.logger.WithField("paymentHash", zap.PaymentHash).WithError(err).Error("Failed to sign zap receipt")
+		return err
+	}
+
+	// the relays are chosen by the payer
+	relaysTag := zapRequest.Tags.GetFirst([]string{"relays"})
+	err = zapper.svc.publishToRelays(ctx, (*relaysTag)[1:], zapReceipt)
+	if err != nil {
+		zapper.svc.logger.WithField("paymentHash", zap.PaymentHash).WithError(err).Error("Failed to publish zap receipt")
+		return err
+	}
+
+	zapper.svc.logger.WithFields(logrus.Fields{
+		"paymentHash": zap.PaymentHash,
+		"receiptId":   zapReceipt.ID,
+		"appId":       zap.AppId,
+	}).Info("Published zap receipt")
+
+	return zapper.svc.db.Model(&zap).Update("receipt_id", zapReceipt.ID).Error
+}
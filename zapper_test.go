@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getAlby/nostr-wallet-connect/db"
+	"github.com/getAlby/nostr-wallet-connect/events"
+	"github.com/getAlby/nostr-wallet-connect/lnurl"
+	"github.com/getAlby/nostr-wallet-connect/nip47"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/stretchr/testify/assert"
+)
+
+func createZapRequest(t *testing.T, amountMsat int64) string {
+	zapRequest := &nostr.Event{
+		Kind:      ZAP_REQUEST_KIND,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"relays", "wss://relay.example.com"},
+			{"amount", fmt.Sprintf("%d", amountMsat)},
+			{"p", "32e1827635450ebb3c5a7d12c1f8e7b2b514439ac10a67eef3d9fd9c5c68e245"},
+			{"e", "3624762a1274dd9636e0c552b53086d70bc88c165bc4dc0f9e836a1eaf86c3b8"},
+		},
+	}
+	err := zapRequest.Sign(nostr.GeneratePrivateKey())
+	assert.NoError(t, err)
+	return zapRequest.String()
+}
+
+func TestHandleMakeInvoiceEvent_ZapRequest(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+
+	expiresAt := time.Now().Add(24 * time.Hour)
+	err = svc.db.Create(&db.AppPermission{
+		AppId:         app.ID,
+		App:           *app,
+		RequestMethod: nip47.MAKE_INVOICE_METHOD,
+		ExpiresAt:     &expiresAt,
+	}).Error
+	assert.NoError(t, err)
+
+	responses := []*nip47.Response{}
+	publishResponse := func(response *nip47.Response, tags nostr.Tags) {
+		responses = append(responses, response)
+	}
+
+	makeInvoice := func(amount int64, zapRequest string) {
+		params, err := json.Marshal(&nip47.MakeInvoiceParams{
+			Amount:      amount,
+			Description: zapRequest,
+		})
+		assert.NoError(t, err)
+		request := &nip47.Request{
+			Method: nip47.MAKE_INVOICE_METHOD,
+			Params: params,
+		}
+		requestEvent := &db.RequestEvent{
+			NostrId: "test_make_invoice_zap_request",
+		}
+		svc.HandleMakeInvoiceEvent(ctx, request, requestEvent, app, publishResponse)
+	}
+
+	// amount does not match the zap request
+	makeInvoice(2000, createZapRequest(t, 1000))
+	assert.Equal(t, nip47.ERROR_BAD_REQUEST, responses[0].Error.Code)
+
+	responses = []*nip47.Response{}
+	zapRequest := createZapRequest(t, 1000)
+	makeInvoice(1000, zapRequest)
+	assert.Nil(t, responses[0].Error)
+
+	zap := db.Zap{}
+	err = svc.db.First(&zap).Error
+	assert.NoError(t, err)
+	assert.Equal(t, app.ID, zap.AppId)
+	assert.Equal(t, mockTransaction.PaymentHash, zap.PaymentHash)
+	assert.Equal(t, zapRequest, zap.ZapRequest)
+	assert.Nil(t, zap.ReceiptId)
+}
+
+func TestPublishZapReceipt(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+	app, _, err := createApp(svc)
+	assert.NoError(t, err)
+
+	zapRequestJson := createZapRequest(t, 1000)
+	err = svc.db.Create(&db.Zap{
+		AppId:       app.ID,
+		PaymentHash: mockTransaction.PaymentHash,
+		ZapRequest:  zapRequestJson,
+	}).Error
+	assert.NoError(t, err)
+
+	publishedRelayUrls := []string{}
+	publishedEvents := []nostr.Event{}
+	svc.publishToRelay = func(ctx context.Context, relayUrl string, event nostr.Event) error {
+		publishedRelayUrls = append(publishedRelayUrls, relayUrl)
+		publishedEvents = append(publishedEvents, event)
+		return nil
+	}
+
+	zapper := NewZapReceiptPublisher(svc)
+
+	paymentReceivedEvent := &events.Event{
+		Event: "nwc_payment_received",
+		Properties: &events.PaymentReceivedEventProperties{
+			PaymentHash: mockTransaction.PaymentHash,
+		},
+	}
+
+	err = zapper.ConsumeEvent(ctx, paymentReceivedEvent, map[string]interface{}{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"wss://relay.example.com"}, publishedRelayUrls)
+	zapReceipt := publishedEvents[0]
+	assert.Equal(t, ZAP_RECEIPT_KIND, zapReceipt.Kind)
+	assert.Equal(t, svc.cfg.GetNostrPublicKey(), zapReceipt.PubKey)
+	assert.Equal(t, nostr.Timestamp(*mockTransaction.SettledAt), zapReceipt.CreatedAt)
+	ok, err := zapReceipt.CheckSignature()
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	zapRequest := &nostr.Event{}
+	err = json.Unmarshal([]byte(zapRequestJson), zapRequest)
+	assert.NoError(t, err)
+	assert.Equal(t, "32e1827635450ebb3c5a7d12c1f8e7b2b514439ac10a67eef3d9fd9c5c68e245", zapReceipt.Tags.GetFirst([]string{"p"}).Value())
+	assert.Equal(t, "3624762a1274dd9636e0c552b53086d70bc88c165bc4dc0f9e836a1eaf86c3b8", zapReceipt.Tags.GetFirst([]string{"e"}).Value())
+	assert.Equal(t, zapRequest.PubKey, zapReceipt.Tags.GetFirst([]string{"P"}).Value())
+	assert.Equal(t, mockTransaction.Invoice, zapReceipt.Tags.GetFirst([]string{"bolt11"}).Value())
+	assert.Equal(t, zapRequestJson, zapReceipt.Tags.GetFirst([]string{"description"}).Value())
+	assert.Equal(t, mockTransaction.Preimage, zapReceipt.Tags.GetFirst([]string{"preimage"}).Value())
+
+	zap := db.Zap{}
+	err = svc.db.First(&zap).Error
+	assert.NoError(t, err)
+	assert.Equal(t, zapReceipt.ID, *zap.ReceiptId)
+
+	// the receipt is only published once
+	err = zapper.ConsumeEvent(ctx, paymentReceivedEvent, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(publishedEvents))
+}
+
+func TestPublishToRelays(t *testing.T) {
+	ctx := context.TODO()
+	defer os.Remove(testDB)
+	mockLn, err := NewMockLn()
+	assert.NoError(t, err)
+	svc, err := createTestService(mockLn)
+	assert.NoError(t, err)
+
+	// only public websocket relays are dialed
+	for _, relayUrl := range []string{"https://relay.example.com", "ws://127.0.0.1:7777", "wss://[::1]", "ws://192.168.1.10", "ws://localhost:7777"} {
+		err = publishToRelay(ctx, relayUrl, nostr.Event{})
+		assert.Error(t, err, relayUrl)
+	}
+	err = publishToRelay(ctx, "ws://10.0.0.1", nostr.Event{})
+	assert.ErrorIs(t, err, lnurl.ErrNonPublicAddress)
+
+	var mu sync.Mutex
+	publishedRelayUrls := []string{}
+	svc.publishToRelay = func(ctx context.Context, relayUrl string, event nostr.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if relayUrl == "wss://failing.example.com" {
+			return errors.New("connection refused")
+		}
+		publishedRelayUrls = append(publishedRelayUrls, relayUrl)
+		return nil
+	}
+
+	// a payer cannot make the hub connect to any number of relays
+	relayUrls := []string{"wss://relay.example.com", "wss://relay.example.com"}
+	for i := 0; i < 20; i++ {
+		relayUrls = append(relayUrls, fmt.Sprintf("wss://relay%d.example.com", i))
+	}
+	err = svc.publishToRelays(ctx, relayUrls, nostr.Event{})
+	assert.NoError(t, err)
+	assert.Equal(t, maxPublishRelays, len(publishedRelayUrls))
+	assert.Contains(t, publishedRelayUrls, "wss://relay.example.com")
+	assert.NotContains(t, publishedRelayUrls, fmt.Sprintf("wss://relay%d.example.com", maxPublishRelays-1))
+
+	publishedRelayUrls = []string{}
+	err = svc.publishToRelays(ctx, []string{"wss://failing.example.com", "wss://relay.example.com"}, nostr.Event{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"wss://relay.example.com"}, publishedRelayUrls)
+	err = svc.publishToRelays(ctx, []string{"wss://failing.example.com"}, nostr.Event{})
+	assert.Error(t, err)
+}